@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -76,6 +77,8 @@ func createPIDFile(pidFilePath string) (string, error) {
 	return pidStr, nil
 }
 
+var errReloadConfig = errors.New("reload config error")
+
 func removePIDFile(config *pkgconfig.Config) {
 	if config.Global.PidFile != "" {
 		os.Remove(config.Global.PidFile)
@@ -181,6 +184,30 @@ func main() {
 		}
 	}
 
+	// reload the config, triggered by SIGHUP or the admin api
+	reload := func() error {
+		// read config, keep the current pipelines to compute the changes
+		previousPipelines := append([]pkgconfig.ConfigPipelines{}, config.Pipelines...)
+		if err := pkgconfig.ReloadConfig(configPath, config); err != nil {
+			return fmt.Errorf("%w: %v", errReloadConfig, err)
+		}
+
+		// reload logger and multiplexer
+		InitLogger(logger, config)
+		if pkginit.IsMuxEnabled(config) {
+			pkginit.ReloadMultiplexer(mapLoggers, mapCollectors, config, logger)
+		}
+		if pkginit.IsPipelinesEnabled(config) {
+			if err := pkginit.ReloadPipelines(mapLoggers, mapCollectors, previousPipelines, config, logger, metrics); err != nil {
+				return fmt.Errorf("reload pipelines error: %w", err)
+			}
+		}
+		return nil
+	}
+
+	// admin api
+	adminAPI := pkginit.InitAdminAPI(config, logger, mapLoggers, mapCollectors, metrics, reload)
+
 	// Handle Ctrl-C with SIG TERM and SIGHUP
 	sigTerm := make(chan os.Signal, 1)
 	sigHUP := make(chan os.Signal, 1)
@@ -198,35 +225,21 @@ func main() {
 
 			case <-sigHUP:
 				logger.Warning("main - SIGHUP received")
-
-				// read config
-				err := pkgconfig.ReloadConfig(configPath, config)
-				if err != nil {
-					logger.Error("main - reload config error:  %v", err)
-					removePIDFile(config)
-					os.Exit(1)
-				}
-
-				// reload logger and multiplexer
-				InitLogger(logger, config)
-				if pkginit.IsMuxEnabled(config) {
-					pkginit.ReloadMultiplexer(mapLoggers, mapCollectors, config, logger)
-				}
-				if pkginit.IsPipelinesEnabled(config) {
-					pkginit.ReloadPipelines(mapLoggers, mapCollectors, config, logger)
+				if err := adminAPI.Reload(); err != nil {
+					logger.Error("main - %v", err)
+					if errors.Is(err, errReloadConfig) {
+						removePIDFile(config)
+						os.Exit(1)
+					}
 				}
 
 			case <-sigTerm:
 				logger.Warning("main - exiting...")
 
-				// and stop all workers
-				for _, c := range mapCollectors {
-					c.Stop()
-				}
-
-				for _, l := range mapLoggers {
-					l.Stop()
-				}
+				// and stop all workers along the pipelines
+				adminAPI.Lock()
+				pkginit.StopWorkers(mapLoggers, mapCollectors, config, logger)
+				adminAPI.Unlock()
 
 				// gracefully shutdown the HTTP server
 				if config.Global.Telemetry.Enabled {
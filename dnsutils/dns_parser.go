@@ -1,11 +1,15 @@
 package dnsutils
 
 import (
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 )
@@ -79,6 +83,7 @@ var (
 		60:    "CDNSKEY",    // Child DNSKEY
 		61:    "OPENPGPKEY", // OpenPGP key
 		62:    "CSYNC",      // Child-to-parent synchronization
+		63:    "ZONEMD",     // Message digest for DNS zone
 		64:    "SVCB",       // Service binding
 		65:    "HTTPS",      // HTTPS binding
 		99:    "SPF",        // Sender policy framework (deprecated, use TXT)
@@ -134,6 +139,7 @@ var ErrDecodeQuestionQtypeTooShort = errors.New("malformed pkt, not enough data
 var ErrDecodeDNSAnswerTooShort = errors.New("malformed pkt, not enough data to decode answer")
 var ErrDecodeDNSAnswerRdataTooShort = errors.New("malformed pkt, not enough data to decode rdata answer")
 var ErrDecodeQuestionQclassTooShort = errors.New("malformed pkt, not enough data to decode qclass")
+var ErrDecodeDNSAnswerLocBadVersion = errors.New("malformed pkt, unsupported loc version")
 
 func RdatatypeToString(rrtype int) string {
 	if value, ok := Rdatatypes[rrtype]; ok {
@@ -599,6 +605,36 @@ func ParseRdata(rdatatype string, rdata []byte, payload []byte, rdataOffset int)
 		ret, err = ParseSOA(rdataOffset, payload)
 	case "HTTPS", "SVCB":
 		ret, err = ParseSVCB(rdata)
+	case "DS", "CDS":
+		ret, err = ParseDS(rdata)
+	case "DNSKEY", "CDNSKEY":
+		ret, err = ParseDNSKEY(rdata)
+	case "RRSIG":
+		ret, err = ParseRRSIG(rdataOffset, payload)
+	case "NSEC":
+		ret, err = ParseNSEC(rdataOffset, payload)
+	case "NSEC3":
+		ret, err = ParseNSEC3(rdata)
+	case "NSEC3PARAM":
+		ret, err = ParseNSEC3PARAM(rdata)
+	case "CAA":
+		ret, err = ParseCAA(rdata)
+	case "NAPTR":
+		ret, err = ParseNAPTR(rdataOffset, payload)
+	case "TLSA":
+		ret, err = ParseTLSA(rdata)
+	case "SSHFP":
+		ret, err = ParseSSHFP(rdata)
+	case "URI":
+		ret, err = ParseURI(rdata)
+	case "LOC":
+		ret, err = ParseLOC(rdata)
+	case "HINFO":
+		ret, err = ParseHINFO(rdata)
+	case "DNAME":
+		ret, err = ParseDNAME(rdataOffset, payload)
+	case "ZONEMD":
+		ret, err = ParseZONEMD(rdata)
 	default:
 		ret = "-"
 		err = nil
@@ -970,6 +1006,556 @@ func ParseSVCParam(svcParamKey uint16, paramData []byte) (string, error) {
 	}
 }
 
+/*
+DS and CDS
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|           Key Tag     | Algorithm |Digest Type|
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    Digest                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDS(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	keyTag := binary.BigEndian.Uint16(rdata[0:2])
+	algorithm := rdata[2]
+	digestType := rdata[3]
+	digest := strings.ToUpper(hex.EncodeToString(rdata[4:]))
+
+	ds := fmt.Sprintf("%d %d %d %s", keyTag, algorithm, digestType, digest)
+	return ds, nil
+}
+
+/*
+DNSKEY and CDNSKEY
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|         Flags         | Protocol  | Algorithm |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  Public Key                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNSKEY(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	flags := binary.BigEndian.Uint16(rdata[0:2])
+	protocol := rdata[2]
+	algorithm := rdata[3]
+	publicKey := base64.StdEncoding.EncodeToString(rdata[4:])
+
+	dnskey := fmt.Sprintf("%d %d %d %s", flags, protocol, algorithm, publicKey)
+	return dnskey, nil
+}
+
+/*
+RRSIG
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     Type Covered      | Algorithm |  Labels   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                 Original TTL                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             Signature Expiration              |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             Signature Inception               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        Key Tag        |                       /
++--+--+--+--+--+--+--+--+      Signer's Name    /
+/                                               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   Signature                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseRRSIG(rdataOffset int, payload []byte) (string, error) {
+	// fixed fields and at least one byte for the signer name
+	if len(payload) < rdataOffset+19 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	rdata := payload[rdataOffset:]
+
+	typeCovered := binary.BigEndian.Uint16(rdata[0:2])
+	algorithm := rdata[2]
+	labels := rdata[3]
+	originalTTL := binary.BigEndian.Uint32(rdata[4:8])
+	expiration := binary.BigEndian.Uint32(rdata[8:12])
+	inception := binary.BigEndian.Uint32(rdata[12:16])
+	keyTag := binary.BigEndian.Uint16(rdata[16:18])
+
+	signerName, offset, err := ParseLabels(rdataOffset+18, payload)
+	if err != nil {
+		return "", err
+	}
+	if signerName == "" {
+		signerName = "."
+	}
+	signature := base64.StdEncoding.EncodeToString(payload[offset:])
+
+	rrsig := fmt.Sprintf("%s %d %d %d %s %s %d %s %s", rrtypeToString(typeCovered), algorithm, labels, originalTTL,
+		signatureTimeToString(expiration), signatureTimeToString(inception), keyTag, signerName, signature)
+	return rrsig, nil
+}
+
+/*
+NSEC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/              Next Domain Name                 /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/               Type Bit Maps                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC(rdataOffset int, payload []byte) (string, error) {
+	nextDomain, offset, err := ParseLabels(rdataOffset, payload)
+	if err != nil {
+		return "", err
+	}
+	if nextDomain == "" {
+		nextDomain = "."
+	}
+
+	types, err := parseTypeBitMaps(payload[offset:])
+	if err != nil {
+		return "", err
+	}
+
+	nsec := nextDomain
+	if len(types) > 0 {
+		nsec += " " + strings.Join(types, " ")
+	}
+	return nsec, nil
+}
+
+/*
+NSEC3
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Hash Alg.   |     Flags     |  Iterations   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Salt Length  |             Salt              /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Hash Length  |     Next Hashed Owner Name    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                 Type Bit Maps                 /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3(rdata []byte) (string, error) {
+	nsec3, offset, err := parseNSEC3Params(rdata)
+	if err != nil {
+		return "", err
+	}
+
+	// next hashed owner name
+	if len(rdata) < offset+1 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	hashLength := int(rdata[offset])
+	offset++
+	if len(rdata) < offset+hashLength {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	nextHashed := base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(rdata[offset : offset+hashLength])
+	offset += hashLength
+
+	types, err := parseTypeBitMaps(rdata[offset:])
+	if err != nil {
+		return "", err
+	}
+
+	nsec3 += " " + nextHashed
+	if len(types) > 0 {
+		nsec3 += " " + strings.Join(types, " ")
+	}
+	return nsec3, nil
+}
+
+/*
+NSEC3PARAM
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Hash Alg.   |     Flags     |  Iterations   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Salt Length  |             Salt              /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3PARAM(rdata []byte) (string, error) {
+	nsec3param, _, err := parseNSEC3Params(rdata)
+	if err != nil {
+		return "", err
+	}
+	return nsec3param, nil
+}
+
+// parseNSEC3Params decodes the hash algorithm, flags, iterations and salt
+// shared by NSEC3 and NSEC3PARAM, the offset after the salt is returned
+func parseNSEC3Params(rdata []byte) (string, int, error) {
+	if len(rdata) < 5 {
+		return "", 0, ErrDecodeDNSAnswerRdataTooShort
+	}
+	hashAlgorithm := rdata[0]
+	flags := rdata[1]
+	iterations := binary.BigEndian.Uint16(rdata[2:4])
+	saltLength := int(rdata[4])
+	if len(rdata) < 5+saltLength {
+		return "", 0, ErrDecodeDNSAnswerRdataTooShort
+	}
+
+	// empty salt is represented by a dash
+	salt := "-"
+	if saltLength > 0 {
+		salt = strings.ToUpper(hex.EncodeToString(rdata[5 : 5+saltLength]))
+	}
+
+	params := fmt.Sprintf("%d %d %d %s", hashAlgorithm, flags, iterations, salt)
+	return params, 5 + saltLength, nil
+}
+
+// parseTypeBitMaps decodes the type bit maps of NSEC and NSEC3
+// each window is encoded with the window number, the length and the bitmap
+func parseTypeBitMaps(data []byte) ([]string, error) {
+	types := []string{}
+	offset := 0
+	for offset < len(data) {
+		if len(data) < offset+2 {
+			return nil, ErrDecodeDNSAnswerRdataTooShort
+		}
+		window := int(data[offset])
+		length := int(data[offset+1])
+		offset += 2
+		if length == 0 || length > 32 || len(data) < offset+length {
+			return nil, ErrDecodeDNSAnswerRdataTooShort
+		}
+		for i, b := range data[offset : offset+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, rrtypeToString(uint16(window*256+i*8+bit)))
+				}
+			}
+		}
+		offset += length
+	}
+	return types, nil
+}
+
+// rrtypeToString returns the name of the type or the generic TYPEnnn representation
+func rrtypeToString(rrtype uint16) string {
+	if value, ok := Rdatatypes[int(rrtype)]; ok {
+		return value
+	}
+	return fmt.Sprintf("TYPE%d", rrtype)
+}
+
+// signatureTimeToString returns the YYYYMMDDHHmmSS representation of the signature time
+func signatureTimeToString(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
+/*
+CAA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     Flags     |  Tag Length   |      Tag      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     Value                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseCAA(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	flags := rdata[0]
+	tagLength := int(rdata[1])
+	if len(rdata) < 2+tagLength {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	tag := string(rdata[2 : 2+tagLength])
+	value := quoteCharacterString(rdata[2+tagLength:])
+
+	caa := fmt.Sprintf("%d %s %s", flags, tag, value)
+	return caa, nil
+}
+
+/*
+NAPTR
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                     ORDER                     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   PREFERENCE                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     FLAGS                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   SERVICES                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    REGEXP                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  REPLACEMENT                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNAPTR(rdataOffset int, payload []byte) (string, error) {
+	if len(payload) < rdataOffset+4 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	order := binary.BigEndian.Uint16(payload[rdataOffset : rdataOffset+2])
+	preference := binary.BigEndian.Uint16(payload[rdataOffset+2 : rdataOffset+4])
+
+	// flags, services and regexp character strings
+	offset := rdataOffset + 4
+	strs := make([]string, 3)
+	for i := range strs {
+		str, next, err := parseCharacterString(payload, offset)
+		if err != nil {
+			return "", err
+		}
+		strs[i] = str
+		offset = next
+	}
+
+	replacement, _, err := ParseLabels(offset, payload)
+	if err != nil {
+		return "", err
+	}
+	if replacement == "" {
+		replacement = "."
+	}
+
+	naptr := fmt.Sprintf("%d %d %s %s %s %s", order, preference, strs[0], strs[1], strs[2], replacement)
+	return naptr, nil
+}
+
+/*
+TLSA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Cert. Usage  |   Selector    | Matching Type |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/      Certificate Association Data             /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseTLSA(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	usage := rdata[0]
+	selector := rdata[1]
+	matchingType := rdata[2]
+	certificate := strings.ToUpper(hex.EncodeToString(rdata[3:]))
+
+	tlsa := fmt.Sprintf("%d %d %d %s", usage, selector, matchingType, certificate)
+	return tlsa, nil
+}
+
+/*
+SSHFP
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Algorithm   |    FP Type    |               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  Fingerprint                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseSSHFP(rdata []byte) (string, error) {
+	if len(rdata) < 3 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	algorithm := rdata[0]
+	fpType := rdata[1]
+	fingerprint := strings.ToUpper(hex.EncodeToString(rdata[2:]))
+
+	sshfp := fmt.Sprintf("%d %d %s", algorithm, fpType, fingerprint)
+	return sshfp, nil
+}
+
+/*
+URI
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|           Priority    |          Weight       |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    Target                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseURI(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	priority := binary.BigEndian.Uint16(rdata[0:2])
+	weight := binary.BigEndian.Uint16(rdata[2:4])
+	target := quoteCharacterString(rdata[4:])
+
+	uri := fmt.Sprintf("%d %d %s", priority, weight, target)
+	return uri, nil
+}
+
+/*
+LOC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        VERSION        |         SIZE          |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|       HORIZ PRE       |       VERT PRE        |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LATITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LONGITUDE                   |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   ALTITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseLOC(rdata []byte) (string, error) {
+	if len(rdata) < 16 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	// only the version 0 is defined
+	if rdata[0] != 0 {
+		return "", ErrDecodeDNSAnswerLocBadVersion
+	}
+	size := rdata[1]
+	horizPre := rdata[2]
+	vertPre := rdata[3]
+	latitude := binary.BigEndian.Uint32(rdata[4:8])
+	longitude := binary.BigEndian.Uint32(rdata[8:12])
+	altitude := binary.BigEndian.Uint32(rdata[12:16])
+
+	// latitude and longitude in thousandths of a second of arc, from the equator and prime meridian
+	latitudeStr := locCoordinateToString(latitude, "N", "S")
+	longitudeStr := locCoordinateToString(longitude, "E", "W")
+
+	// altitude in centimeters from a base of 100000m below the reference
+	altitudeStr := fmt.Sprintf("%.0fm", float64(altitude)/100-100000)
+	if altitude%100 != 0 {
+		altitudeStr = fmt.Sprintf("%.2fm", float64(altitude)/100-100000)
+	}
+
+	loc := fmt.Sprintf("%s %s %s %sm %sm %sm", latitudeStr, longitudeStr, altitudeStr,
+		locPrecisionToString(size), locPrecisionToString(horizPre), locPrecisionToString(vertPre))
+	return loc, nil
+}
+
+/*
+HINFO
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                      CPU                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                       OS                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseHINFO(rdata []byte) (string, error) {
+	cpu, offset, err := parseCharacterString(rdata, 0)
+	if err != nil {
+		return "", err
+	}
+	os, _, err := parseCharacterString(rdata, offset)
+	if err != nil {
+		return "", err
+	}
+
+	hinfo := fmt.Sprintf("%s %s", cpu, os)
+	return hinfo, nil
+}
+
+/*
+DNAME
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    TARGET                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNAME(rdataOffset int, payload []byte) (string, error) {
+	dname, _, err := ParseLabels(rdataOffset, payload)
+	if err != nil {
+		return "", err
+	}
+	if dname == "" {
+		dname = "."
+	}
+	return dname, err
+}
+
+/*
+ZONEMD
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                     SERIAL                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|    Scheme     |Hash Algorithm |               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    Digest                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseZONEMD(rdata []byte) (string, error) {
+	if len(rdata) < 7 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	serial := binary.BigEndian.Uint32(rdata[0:4])
+	scheme := rdata[4]
+	hashAlgorithm := rdata[5]
+	digest := strings.ToUpper(hex.EncodeToString(rdata[6:]))
+
+	zonemd := fmt.Sprintf("%d %d %d %s", serial, scheme, hashAlgorithm, digest)
+	return zonemd, nil
+}
+
+// parseCharacterString decodes a length-prefixed character string,
+// the quoted string and the offset after it are returned
+func parseCharacterString(data []byte, offset int) (string, int, error) {
+	if len(data) < offset+1 {
+		return "", 0, ErrDecodeDNSAnswerRdataTooShort
+	}
+	length := int(data[offset])
+	offset++
+	if len(data) < offset+length {
+		return "", 0, ErrDecodeDNSAnswerRdataTooShort
+	}
+	return quoteCharacterString(data[offset : offset+length]), offset + length, nil
+}
+
+// quoteCharacterString returns the quoted and escaped presentation of the string
+func quoteCharacterString(s []byte) string {
+	var str strings.Builder
+	str.Grow(2 + len(s))
+	str.WriteByte('"')
+	for _, e := range s {
+		switch {
+		case e == '"' || e == '\\':
+			str.WriteByte('\\')
+			str.WriteByte(e)
+		case ' ' <= e && e <= '~':
+			str.WriteByte(e)
+		default:
+			str.WriteString(escapeByte(e))
+		}
+	}
+	str.WriteByte('"')
+	return str.String()
+}
+
+// locCoordinateToString returns the degrees, minutes and seconds of the LOC coordinate
+func locCoordinateToString(coordinate uint32, positive string, negative string) string {
+	const equator = 1 << 31
+	const minute = 60 * 1000
+	const degree = 60 * minute
+
+	hemisphere := positive
+	if coordinate > equator {
+		coordinate -= equator
+	} else {
+		hemisphere = negative
+		coordinate = equator - coordinate
+	}
+	degrees := coordinate / degree
+	coordinate %= degree
+	minutes := coordinate / minute
+	coordinate %= minute
+	return fmt.Sprintf("%02d %02d %0.3f %s", degrees, minutes, float64(coordinate)/1000, hemisphere)
+}
+
+// locPrecisionToString returns in meters the size or precision of the LOC record,
+// encoded in centimeters with a base and an exponent of ten
+func locPrecisionToString(precision uint8) string {
+	base := precision & 0xf0 >> 4
+	exponent := precision & 0x0f
+	if exponent < 2 {
+		if exponent == 1 {
+			base *= 10
+		}
+		return fmt.Sprintf("0.%02d", base)
+	}
+	return fmt.Sprintf("%d", base) + strings.Repeat("0", int(exponent)-2)
+}
+
 // These functions and consts have been taken from miekg/dns
 const (
 	escapedByteSmall = "" +
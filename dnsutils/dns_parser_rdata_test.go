@@ -659,3 +659,256 @@ func TestDecodeRdataSVCB_params(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeRdataDNSSEC(t *testing.T) {
+	fqdn := TestQName
+
+	testcases := []struct {
+		rrtype string
+		rdata  string
+		want   string
+	}{
+		{
+			rrtype: "DS",
+			rdata:  "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118",
+			want:   "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118",
+		},
+		{
+			rrtype: "CDS",
+			rdata:  "20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+			want:   "20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+		},
+		{
+			rrtype: "DNSKEY",
+			rdata:  "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+			want:   "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+		},
+		{
+			rrtype: "CDNSKEY",
+			rdata:  "256 3 8 AwEAAag=",
+			want:   "256 3 8 AwEAAag=",
+		},
+		{
+			rrtype: "RRSIG",
+			rdata:  "A 13 2 3600 20240131000000 20240101000000 12345 dnscollector.test. oJB1W6WNGv+ldvQ3WDG0MQkg5IEhjRip8WTrPYGv07h108dUKGMeDPKijVCHX3DDKdfb+v6oB9wfuh3DTJXUAfI/M0zmO/zz8bW0Rznl8O3tGNazPwQKkRN20XPXV6nwwfoXmJQbsLNrLfkGJ5D6fwFm8nN+6pBzeDQfsS3Ap3o=",
+			want:   "A 13 2 3600 20240131000000 20240101000000 12345 dnscollector.test oJB1W6WNGv+ldvQ3WDG0MQkg5IEhjRip8WTrPYGv07h108dUKGMeDPKijVCHX3DDKdfb+v6oB9wfuh3DTJXUAfI/M0zmO/zz8bW0Rznl8O3tGNazPwQKkRN20XPXV6nwwfoXmJQbsLNrLfkGJ5D6fwFm8nN+6pBzeDQfsS3Ap3o=",
+		},
+		{
+			rrtype: "NSEC",
+			rdata:  "host.dnscollector.test. A MX RRSIG NSEC TYPE1234",
+			want:   "host.dnscollector.test A MX RRSIG NSEC TYPE1234",
+		},
+		{
+			rrtype: "NSEC",
+			rdata:  ". NS SOA RRSIG NSEC DNSKEY",
+			want:   ". NS SOA RRSIG NSEC DNSKEY",
+		},
+		{
+			rrtype: "NSEC3",
+			rdata:  "1 1 12 AABBCCDD 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR A RRSIG",
+			want:   "1 1 12 AABBCCDD 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR A RRSIG",
+		},
+		{
+			rrtype: "NSEC3",
+			rdata:  "1 0 0 - 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR",
+			want:   "1 0 0 - 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR",
+		},
+		{
+			rrtype: "NSEC3PARAM",
+			rdata:  "1 0 10 AABBCCDD",
+			want:   "1 0 10 AABBCCDD",
+		},
+		{
+			rrtype: "NSEC3PARAM",
+			rdata:  "1 0 0 -",
+			want:   "1 0 0 -",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.rrtype, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion(fqdn, dns.TypeA)
+
+			rr1, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rrtype, tc.rdata))
+			if err != nil {
+				t.Fatalf("invalid rr: %v", err)
+			}
+			dm.Answer = append(dm.Answer, rr1)
+
+			payload, _ := dm.Pack()
+
+			_, _, _, offsetRR, _ := DecodeQuestion(1, payload)
+			answer, _, err := DecodeAnswer(len(dm.Answer), offsetRR, payload)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if answer[0].Rdata != tc.want {
+				t.Errorf("invalid decode for rdata %s, want %s, got: %s", tc.rrtype, tc.want, answer[0].Rdata)
+			}
+		})
+	}
+}
+
+func TestDecodeRdataDNSSEC_Short(t *testing.T) {
+	testcases := []struct {
+		name  string
+		parse func([]byte) (string, error)
+		rdata []byte
+	}{
+		{name: "DS", parse: ParseDS, rdata: []byte{0xec, 0x45, 0x05, 0x01}},
+		{name: "DNSKEY", parse: ParseDNSKEY, rdata: []byte{0x01, 0x01, 0x03}},
+		{name: "NSEC3PARAM", parse: ParseNSEC3PARAM, rdata: []byte{0x01, 0x00, 0x00, 0x0a, 0x04, 0xaa}},
+		{name: "NSEC3_Hash", parse: ParseNSEC3, rdata: []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x14, 0xaa}},
+		{name: "NSEC3_Bitmap", parse: ParseNSEC3, rdata: []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0xaa, 0x00, 0x06, 0x40}},
+		{name: "RRSIG", parse: func(rdata []byte) (string, error) { return ParseRRSIG(0, rdata) }, rdata: []byte{0x00, 0x01, 0x0d, 0x02}},
+		{name: "NSEC", parse: func(rdata []byte) (string, error) { return ParseNSEC(0, rdata) }, rdata: []byte{0x00, 0x00}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.parse(tc.rdata)
+			if !errors.Is(err, ErrDecodeDNSAnswerRdataTooShort) {
+				t.Errorf("bad error returned: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeRdataMisc(t *testing.T) {
+	fqdn := TestQName
+
+	testcases := []struct {
+		rrtype string
+		rdata  string
+		want   string
+	}{
+		{
+			rrtype: "CAA",
+			rdata:  "0 issue \"letsencrypt.org\"",
+			want:   "0 issue \"letsencrypt.org\"",
+		},
+		{
+			rrtype: "CAA",
+			rdata:  "128 iodef \"mailto:security@dnscollector.test\"",
+			want:   "128 iodef \"mailto:security@dnscollector.test\"",
+		},
+		{
+			rrtype: "NAPTR",
+			rdata:  "100 10 \"S\" \"SIP+D2U\" \"!^.*$!sip:info@dnscollector.test!\" _sip._udp.dnscollector.test.",
+			want:   "100 10 \"S\" \"SIP+D2U\" \"!^.*$!sip:info@dnscollector.test!\" _sip._udp.dnscollector.test",
+		},
+		{
+			rrtype: "NAPTR",
+			rdata:  "100 50 \"a\" \"z3950+N2L+N2C\" \"\" .",
+			want:   "100 50 \"a\" \"z3950+N2L+N2C\" \"\" .",
+		},
+		{
+			rrtype: "TLSA",
+			rdata:  "3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6",
+			want:   "3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6",
+		},
+		{
+			rrtype: "SSHFP",
+			rdata:  "4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789",
+			want:   "4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789",
+		},
+		{
+			rrtype: "URI",
+			rdata:  "10 1 \"https://dnscollector.test/\"",
+			want:   "10 1 \"https://dnscollector.test/\"",
+		},
+		{
+			rrtype: "LOC",
+			rdata:  "52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m",
+			want:   "52 22 23.000 N 04 53 32.000 E -2m 0.00m 10000m 10m",
+		},
+		{
+			rrtype: "LOC",
+			rdata:  "42 21 54.500 S 71 06 18.250 W 24.75m 30m 10m 10m",
+			want:   "42 21 54.500 S 71 06 18.250 W 24.75m 30m 10m 10m",
+		},
+		{
+			rrtype: "HINFO",
+			rdata:  "\"INTEL-386\" \"Unix \\\"BSD\\\"\"",
+			want:   "\"INTEL-386\" \"Unix \\\"BSD\\\"\"",
+		},
+		{
+			rrtype: "DNAME",
+			rdata:  "target.dnscollector.test.",
+			want:   "target.dnscollector.test",
+		},
+		{
+			rrtype: "DNAME",
+			rdata:  ".",
+			want:   ".",
+		},
+		{
+			rrtype: "ZONEMD",
+			rdata:  "2018031900 1 1 C68090D90A7AED716BC459F9340E3D7C1370D4D24B7E2FC3A1DDC0B9A87153B9A9713B3C9AE5CC27777F98B8E730044C",
+			want:   "2018031900 1 1 C68090D90A7AED716BC459F9340E3D7C1370D4D24B7E2FC3A1DDC0B9A87153B9A9713B3C9AE5CC27777F98B8E730044C",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.rrtype, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion(fqdn, dns.TypeA)
+
+			rr1, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rrtype, tc.rdata))
+			if err != nil {
+				t.Fatalf("invalid rr: %v", err)
+			}
+			dm.Answer = append(dm.Answer, rr1)
+
+			payload, _ := dm.Pack()
+
+			_, _, _, offsetRR, _ := DecodeQuestion(1, payload)
+			answer, _, err := DecodeAnswer(len(dm.Answer), offsetRR, payload)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if answer[0].Rdatatype != tc.rrtype {
+				t.Errorf("invalid rdatatype, want %s, got: %s", tc.rrtype, answer[0].Rdatatype)
+			}
+			if answer[0].Rdata != tc.want {
+				t.Errorf("invalid decode for rdata %s, want %s, got: %s", tc.rrtype, tc.want, answer[0].Rdata)
+			}
+		})
+	}
+}
+
+func TestDecodeRdataLOC_BadVersion(t *testing.T) {
+	rdata := []byte{0x01, 0x12, 0x16, 0x13, 0x89, 0x17, 0x2d, 0xd0, 0x80, 0x00, 0x00, 0x00, 0x00, 0x98, 0x96, 0x80}
+	if _, err := ParseLOC(rdata); !errors.Is(err, ErrDecodeDNSAnswerLocBadVersion) {
+		t.Errorf("bad error returned: %v", err)
+	}
+}
+
+func TestDecodeRdataMisc_Short(t *testing.T) {
+	testcases := []struct {
+		name  string
+		parse func([]byte) (string, error)
+		rdata []byte
+	}{
+		{name: "CAA", parse: ParseCAA, rdata: []byte{0x00, 0x05, 0x69, 0x73}},
+		{name: "TLSA", parse: ParseTLSA, rdata: []byte{0x03, 0x01, 0x01}},
+		{name: "SSHFP", parse: ParseSSHFP, rdata: []byte{0x04, 0x02}},
+		{name: "URI", parse: ParseURI, rdata: []byte{0x00, 0x0a, 0x00, 0x01}},
+		{name: "LOC", parse: ParseLOC, rdata: []byte{0x00, 0x12, 0x16, 0x13, 0x89, 0x17, 0x2d, 0xd0}},
+		{name: "HINFO", parse: ParseHINFO, rdata: []byte{0x03, 0x61, 0x62, 0x63, 0x05, 0x61}},
+		{name: "ZONEMD", parse: ParseZONEMD, rdata: []byte{0x78, 0x49, 0x7d, 0x2c, 0x01, 0x01}},
+		{name: "NAPTR", parse: func(rdata []byte) (string, error) { return ParseNAPTR(0, rdata) }, rdata: []byte{0x00, 0x64, 0x00, 0x0a, 0x01}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.parse(tc.rdata)
+			if !errors.Is(err, ErrDecodeDNSAnswerRdataTooShort) {
+				t.Errorf("bad error returned: %v", err)
+			}
+		})
+	}
+}
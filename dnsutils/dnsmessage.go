@@ -1,6 +1,8 @@
 package dnsutils
 
 import (
+	"bytes"
+	"encoding/gob"
 	"regexp"
 )
 
@@ -82,6 +84,16 @@ type DNSExtended struct {
 	Options       []DNSOption `json:"options"`
 }
 
+// GetOption returns the first EDNS option with the given name
+func (e *DNSExtended) GetOption(name string) (DNSOption, bool) {
+	for _, opt := range e.Options {
+		if opt.Name == name {
+			return opt, true
+		}
+	}
+	return DNSOption{}, false
+}
+
 type DNSTap struct {
 	Operation        string  `json:"operation"`
 	Identity         string  `json:"identity"`
@@ -143,12 +155,33 @@ type TransformSuspicious struct {
 	Domain                string  `json:"domain,omitempty"`
 }
 
+type TransformTyposquatting struct {
+	Detected  bool   `json:"detected"`
+	Brand     string `json:"brand"`
+	Technique string `json:"technique"`
+	Distance  int    `json:"distance"`
+}
+
+type TransformThreatIntel struct {
+	Matched  bool   `json:"matched"`
+	Feed     string `json:"feed"`
+	Category string `json:"category"`
+	Trigger  string `json:"trigger"`
+	Action   string `json:"action"`
+	Value    string `json:"value"`
+}
+
 type TransformPublicSuffix struct {
 	QnamePublicSuffix        string `json:"tld"`
 	QnameEffectiveTLDPlusOne string `json:"etld+1"`
 	ManagedByICANN           bool   `json:"managed-icann"`
 }
 
+type TransformIdna struct {
+	QnameUnicode string `json:"qname-unicode"`
+	MixedScript  bool   `json:"mixed-script"`
+}
+
 type TransformExtracted struct {
 	Base64Payload []byte `json:"dns_payload"`
 }
@@ -158,6 +191,14 @@ type TransformReducer struct {
 	CumulativeLength int `json:"cumulative-length"`
 }
 
+type TransformTransaction struct {
+	Status         string   `json:"status"`
+	QueryLength    int      `json:"query-length"`
+	ReplyLength    int      `json:"reply-length"`
+	ReplyTimestamp string   `json:"reply-timestamp-rfc3339ns"`
+	ReplyFlags     DNSFlags `json:"reply-flags"`
+}
+
 type TransformFiltering struct {
 	SampleRate int `json:"sample-rate"`
 }
@@ -198,22 +239,54 @@ type TransformRelabeling struct {
 	Rules []RelabelingRule
 }
 
+// GobEncode encodes the rules with the pattern of the regular expressions
+func (t *TransformRelabeling) GobEncode() ([]byte, error) {
+	rules := make([][3]string, 0, len(t.Rules))
+	for _, rule := range t.Rules {
+		rules = append(rules, [3]string{rule.Regex.String(), rule.Replacement, rule.Action})
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(rules)
+	return buf.Bytes(), err
+}
+
+// GobDecode decodes the rules and compiles the regular expressions
+func (t *TransformRelabeling) GobDecode(data []byte) error {
+	var rules [][3]string
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rules); err != nil {
+		return err
+	}
+	t.Rules = t.Rules[:0]
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule[0])
+		if err != nil {
+			return err
+		}
+		t.Rules = append(t.Rules, RelabelingRule{Regex: re, Replacement: rule[1], Action: rule[2]})
+	}
+	return nil
+}
+
 type DNSMessage struct {
-	NetworkInfo     DNSNetInfo             `json:"network"`
-	DNS             DNS                    `json:"dns"`
-	EDNS            DNSExtended            `json:"edns"`
-	DNSTap          DNSTap                 `json:"dnstap"`
-	PowerDNS        *CollectorPowerDNS     `json:"powerdns,omitempty"`
-	OpenTelemetry   *LoggerOpenTelemetry   `json:"opentelemetry,omitempty"`
-	Geo             *TransformDNSGeo       `json:"geoip,omitempty"`
-	Suspicious      *TransformSuspicious   `json:"suspicious,omitempty"`
-	PublicSuffix    *TransformPublicSuffix `json:"publicsuffix,omitempty"`
-	Extracted       *TransformExtracted    `json:"extracted,omitempty"`
-	Reducer         *TransformReducer      `json:"reducer,omitempty"`
-	MachineLearning *TransformML           `json:"ml,omitempty"`
-	Filtering       *TransformFiltering    `json:"filtering,omitempty"`
-	ATags           *TransformATags        `json:"atags,omitempty"`
-	Relabeling      *TransformRelabeling   `json:"-"`
+	NetworkInfo     DNSNetInfo              `json:"network"`
+	DNS             DNS                     `json:"dns"`
+	EDNS            DNSExtended             `json:"edns"`
+	DNSTap          DNSTap                  `json:"dnstap"`
+	PowerDNS        *CollectorPowerDNS      `json:"powerdns,omitempty"`
+	OpenTelemetry   *LoggerOpenTelemetry    `json:"opentelemetry,omitempty"`
+	Geo             *TransformDNSGeo        `json:"geoip,omitempty"`
+	Suspicious      *TransformSuspicious    `json:"suspicious,omitempty"`
+	Typosquatting   *TransformTyposquatting `json:"typosquatting,omitempty"`
+	ThreatIntel     *TransformThreatIntel   `json:"threatintel,omitempty"`
+	PublicSuffix    *TransformPublicSuffix  `json:"publicsuffix,omitempty"`
+	Idna            *TransformIdna          `json:"idna,omitempty"`
+	Extracted       *TransformExtracted     `json:"extracted,omitempty"`
+	Reducer         *TransformReducer       `json:"reducer,omitempty"`
+	MachineLearning *TransformML            `json:"ml,omitempty"`
+	Filtering       *TransformFiltering     `json:"filtering,omitempty"`
+	ATags           *TransformATags         `json:"atags,omitempty"`
+	Transaction     *TransformTransaction   `json:"transaction,omitempty"`
+	Relabeling      *TransformRelabeling    `json:"-"`
 }
 
 func (dm *DNSMessage) Init() {
@@ -266,8 +339,12 @@ func (dm *DNSMessage) InitTransforms() {
 	dm.Reducer = &TransformReducer{}
 	dm.Extracted = &TransformExtracted{}
 	dm.PublicSuffix = &TransformPublicSuffix{}
+	dm.Idna = &TransformIdna{}
 	dm.Suspicious = &TransformSuspicious{}
+	dm.Typosquatting = &TransformTyposquatting{}
+	dm.ThreatIntel = &TransformThreatIntel{}
 	dm.Geo = &TransformDNSGeo{}
+	dm.Transaction = &TransformTransaction{}
 	dm.Relabeling = &TransformRelabeling{}
 	// init collectors & loggers
 	dm.PowerDNS = &CollectorPowerDNS{}
@@ -0,0 +1,1279 @@
+package dnsutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-netutils"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/miekg/dns"
+)
+
+// Compacted-DNS (C-DNS) format, RFC 8618
+// A C-DNS file is a CBOR array of three items: the file type id, the file preamble
+// and an indefinite-length array of blocks. Each block contains deduplicated tables
+// (addresses, names, signatures, ...) and a list of query/response items
+// referencing these tables.
+
+const (
+	CDNSFileTypeID         = "C-DNS"
+	CDNSMajorFormatVersion = 1
+	CDNSMinorFormatVersion = 0
+	CDNSTicksPerSecond     = 1000000
+	CDNSDefaultBlockItems  = 5000
+
+	// delay during which a query without response is carried to the next block on flush
+	CDNSQueryTimeout = 5 * time.Second
+
+	// qr-sig-flags
+	cdnsQueryPresent          = 1 << 0
+	cdnsResponsePresent       = 1 << 1
+	cdnsQueryHasOpt           = 1 << 2
+	cdnsResponseHasOpt        = 1 << 3
+	cdnsQueryHasNoQuestion    = 1 << 4
+	cdnsResponseHasNoQuestion = 1 << 5
+
+	// qr-transport-flags
+	cdnsTransportIPv6 = 1 << 0
+
+	// dns-flags, query part from bit 0 to 7, response part from bit 8 to 14
+	cdnsFlagCD = 1 << 0
+	cdnsFlagAD = 1 << 1
+	cdnsFlagZ  = 1 << 2
+	cdnsFlagRA = 1 << 3
+	cdnsFlagRD = 1 << 4
+	cdnsFlagTC = 1 << 5
+	cdnsFlagAA = 1 << 6
+	cdnsFlagDO = 1 << 7
+
+	// cbor major types and special values used to frame the file
+	cborMajorArray = 4 << 5
+	cborIndefinite = 31
+	cborBreak      = 0xff
+)
+
+var (
+	// qr-type values
+	cdnsQRTypes = map[string]uint64{"STUB": 0, "CLIENT": 1, "RESOLVER": 2, "AUTH": 3, "FORWARDER": 4, "TOOL": 5}
+
+	// transport values, stored from bit 1 to 4 of qr-transport-flags
+	cdnsTransports = map[string]uint64{netutils.ProtoUDP: 0, netutils.ProtoTCP: 1, ProtoDoT: 2, "DTLS": 3, ProtoDoH: 4}
+
+	cdnsDecMode, _ = cbor.DecOptions{MaxArrayElements: 1 << 20, MaxMapPairs: 1 << 20}.DecMode()
+)
+
+type cdnsFilePreamble struct {
+	MajorFormatVersion uint64                `cbor:"0,keyasint"`
+	MinorFormatVersion uint64                `cbor:"1,keyasint"`
+	PrivateVersion     *uint64               `cbor:"2,keyasint,omitempty"`
+	BlockParameters    []cdnsBlockParameters `cbor:"3,keyasint"`
+}
+
+type cdnsBlockParameters struct {
+	StorageParameters    cdnsStorageParameters     `cbor:"0,keyasint"`
+	CollectionParameters *cdnsCollectionParameters `cbor:"1,keyasint,omitempty"`
+}
+
+type cdnsStorageParameters struct {
+	TicksPerSecond uint64           `cbor:"0,keyasint"`
+	MaxBlockItems  uint64           `cbor:"1,keyasint"`
+	StorageHints   cdnsStorageHints `cbor:"2,keyasint"`
+	Opcodes        []uint64         `cbor:"3,keyasint"`
+	RRTypes        []uint64         `cbor:"4,keyasint"`
+}
+
+type cdnsStorageHints struct {
+	QueryResponseHints          uint64 `cbor:"0,keyasint"`
+	QueryResponseSignatureHints uint64 `cbor:"1,keyasint"`
+	RRHints                     uint64 `cbor:"2,keyasint"`
+	OtherDataHints              uint64 `cbor:"3,keyasint"`
+}
+
+type cdnsCollectionParameters struct {
+	GeneratorID string `cbor:"8,keyasint,omitempty"`
+	HostID      string `cbor:"9,keyasint,omitempty"`
+}
+
+type cdnsBlock struct {
+	Preamble       cdnsBlockPreamble    `cbor:"0,keyasint"`
+	Statistics     *cdnsBlockStatistics `cbor:"1,keyasint,omitempty"`
+	Tables         *cdnsBlockTables     `cbor:"2,keyasint,omitempty"`
+	QueryResponses []cdnsQueryResponse  `cbor:"3,keyasint,omitempty"`
+}
+
+type cdnsBlockPreamble struct {
+	EarliestTime         *cdnsTimestamp `cbor:"0,keyasint,omitempty"`
+	BlockParametersIndex *uint64        `cbor:"1,keyasint,omitempty"`
+}
+
+type cdnsTimestamp struct {
+	_       struct{} `cbor:",toarray"`
+	Seconds uint64
+	Ticks   uint64
+}
+
+type cdnsBlockStatistics struct {
+	ProcessedMessages  *uint64 `cbor:"0,keyasint,omitempty"`
+	QRDataItems        *uint64 `cbor:"1,keyasint,omitempty"`
+	UnmatchedQueries   *uint64 `cbor:"2,keyasint,omitempty"`
+	UnmatchedResponses *uint64 `cbor:"3,keyasint,omitempty"`
+}
+
+type cdnsBlockTables struct {
+	IPAddress [][]byte                     `cbor:"0,keyasint,omitempty"`
+	ClassType []cdnsClassType              `cbor:"1,keyasint,omitempty"`
+	NameRdata [][]byte                     `cbor:"2,keyasint,omitempty"`
+	QRSig     []cdnsQueryResponseSignature `cbor:"3,keyasint,omitempty"`
+	QList     [][]uint64                   `cbor:"4,keyasint,omitempty"`
+	QRR       []cdnsQuestion               `cbor:"5,keyasint,omitempty"`
+	RRList    [][]uint64                   `cbor:"6,keyasint,omitempty"`
+	RR        []cdnsRR                     `cbor:"7,keyasint,omitempty"`
+}
+
+type cdnsClassType struct {
+	Type  uint64 `cbor:"0,keyasint"`
+	Class uint64 `cbor:"1,keyasint"`
+}
+
+type cdnsQueryResponseSignature struct {
+	ServerAddressIndex  *uint64 `cbor:"0,keyasint,omitempty"`
+	ServerPort          *uint64 `cbor:"1,keyasint,omitempty"`
+	QRTransportFlags    *uint64 `cbor:"2,keyasint,omitempty"`
+	QRType              *uint64 `cbor:"3,keyasint,omitempty"`
+	QRSigFlags          *uint64 `cbor:"4,keyasint,omitempty"`
+	QueryOpcode         *uint64 `cbor:"5,keyasint,omitempty"`
+	QRDNSFlags          *uint64 `cbor:"6,keyasint,omitempty"`
+	QueryRcode          *uint64 `cbor:"7,keyasint,omitempty"`
+	QueryClassTypeIndex *uint64 `cbor:"8,keyasint,omitempty"`
+	QueryQdCount        *uint64 `cbor:"9,keyasint,omitempty"`
+	QueryAnCount        *uint64 `cbor:"10,keyasint,omitempty"`
+	QueryNsCount        *uint64 `cbor:"11,keyasint,omitempty"`
+	QueryArCount        *uint64 `cbor:"12,keyasint,omitempty"`
+	QueryEDNSVersion    *uint64 `cbor:"13,keyasint,omitempty"`
+	QueryUDPSize        *uint64 `cbor:"14,keyasint,omitempty"`
+	QueryOptRdataIndex  *uint64 `cbor:"15,keyasint,omitempty"`
+	ResponseRcode       *uint64 `cbor:"16,keyasint,omitempty"`
+}
+
+type cdnsQuestion struct {
+	NameIndex      uint64 `cbor:"0,keyasint"`
+	ClassTypeIndex uint64 `cbor:"1,keyasint"`
+}
+
+type cdnsRR struct {
+	NameIndex      uint64  `cbor:"0,keyasint"`
+	ClassTypeIndex uint64  `cbor:"1,keyasint"`
+	TTL            *uint64 `cbor:"2,keyasint,omitempty"`
+	RdataIndex     *uint64 `cbor:"3,keyasint,omitempty"`
+}
+
+type cdnsQueryResponse struct {
+	TimeOffset         *int64                     `cbor:"0,keyasint,omitempty"`
+	ClientAddressIndex *uint64                    `cbor:"1,keyasint,omitempty"`
+	ClientPort         *uint64                    `cbor:"2,keyasint,omitempty"`
+	TransactionID      *uint64                    `cbor:"3,keyasint,omitempty"`
+	QRSignatureIndex   *uint64                    `cbor:"4,keyasint,omitempty"`
+	ResponseDelay      *int64                     `cbor:"6,keyasint,omitempty"`
+	QueryNameIndex     *uint64                    `cbor:"7,keyasint,omitempty"`
+	QuerySize          *uint64                    `cbor:"8,keyasint,omitempty"`
+	ResponseSize       *uint64                    `cbor:"9,keyasint,omitempty"`
+	QueryExtended      *cdnsQueryResponseExtended `cbor:"11,keyasint,omitempty"`
+	ResponseExtended   *cdnsQueryResponseExtended `cbor:"12,keyasint,omitempty"`
+}
+
+type cdnsQueryResponseExtended struct {
+	QuestionIndex   *uint64 `cbor:"0,keyasint,omitempty"`
+	AnswerIndex     *uint64 `cbor:"1,keyasint,omitempty"`
+	AuthorityIndex  *uint64 `cbor:"2,keyasint,omitempty"`
+	AdditionalIndex *uint64 `cbor:"3,keyasint,omitempty"`
+}
+
+func cdnsUint(v uint64) *uint64 { return &v }
+
+func cdnsInt(v int64) *int64 { return &v }
+
+func cdnsGet[T any](table []T, index *uint64) (T, error) {
+	var value T
+	if index == nil {
+		return value, errors.New("missing table index")
+	}
+	if *index >= uint64(len(table)) {
+		return value, fmt.Errorf("table index %d out of range", *index)
+	}
+	return table[*index], nil
+}
+
+// cdnsTransaction is a query and/or response waiting to be written in the current block
+type cdnsTransaction struct {
+	qrType                  uint64
+	family, protocol        string
+	clientIP, serverIP      net.IP
+	clientPort, serverPort  int
+	query, response         *dns.Msg
+	querySize, responseSize int
+	queryTime, responseTime time.Time
+}
+
+func (t *cdnsTransaction) time() time.Time {
+	if t.query != nil {
+		return t.queryTime
+	}
+	return t.responseTime
+}
+
+// cdnsTablesBuilder deduplicates the values stored in the block tables
+type cdnsTablesBuilder struct {
+	tables  cdnsBlockTables
+	indexes map[string]uint64
+}
+
+func cdnsAddToTable[T any](b *cdnsTablesBuilder, table *[]T, key string, value T) uint64 {
+	if idx, ok := b.indexes[key]; ok {
+		return idx
+	}
+	idx := uint64(len(*table))
+	*table = append(*table, value)
+	b.indexes[key] = idx
+	return idx
+}
+
+func (b *cdnsTablesBuilder) ip(ip net.IP) uint64 {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return cdnsAddToTable(b, &b.tables.IPAddress, "ip/"+string(ip), []byte(ip))
+}
+
+func (b *cdnsTablesBuilder) classType(rrtype, class uint16) uint64 {
+	ct := cdnsClassType{Type: uint64(rrtype), Class: uint64(class)}
+	return cdnsAddToTable(b, &b.tables.ClassType, fmt.Sprintf("ct/%d/%d", rrtype, class), ct)
+}
+
+func (b *cdnsTablesBuilder) nameRdata(data []byte) uint64 {
+	return cdnsAddToTable(b, &b.tables.NameRdata, "nr/"+string(data), data)
+}
+
+func (b *cdnsTablesBuilder) name(name string) (uint64, error) {
+	buf := make([]byte, 256)
+	off, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	return b.nameRdata(buf[:off]), nil
+}
+
+func (b *cdnsTablesBuilder) signature(sig cdnsQueryResponseSignature) (uint64, error) {
+	key, err := cbor.Marshal(sig)
+	if err != nil {
+		return 0, err
+	}
+	return cdnsAddToTable(b, &b.tables.QRSig, "sig/"+string(key), sig), nil
+}
+
+func (b *cdnsTablesBuilder) questions(questions []dns.Question) (uint64, error) {
+	list := []uint64{}
+	for _, q := range questions {
+		nameIdx, err := b.name(q.Name)
+		if err != nil {
+			return 0, err
+		}
+		qrr := cdnsQuestion{NameIndex: nameIdx, ClassTypeIndex: b.classType(q.Qtype, q.Qclass)}
+		list = append(list, cdnsAddToTable(b, &b.tables.QRR, fmt.Sprintf("qrr/%d/%d", qrr.NameIndex, qrr.ClassTypeIndex), qrr))
+	}
+	return cdnsAddToTable(b, &b.tables.QList, fmt.Sprintf("ql/%v", list), list), nil
+}
+
+func (b *cdnsTablesBuilder) records(rrs []dns.RR) (uint64, error) {
+	list := []uint64{}
+	for _, rr := range rrs {
+		rdata, err := cdnsRdata(rr)
+		if err != nil {
+			return 0, err
+		}
+		nameIdx, err := b.name(rr.Header().Name)
+		if err != nil {
+			return 0, err
+		}
+		r := cdnsRR{
+			NameIndex:      nameIdx,
+			ClassTypeIndex: b.classType(rr.Header().Rrtype, rr.Header().Class),
+			TTL:            cdnsUint(uint64(rr.Header().Ttl)),
+			RdataIndex:     cdnsUint(b.nameRdata(rdata)),
+		}
+		key := fmt.Sprintf("rr/%d/%d/%d/%d", r.NameIndex, r.ClassTypeIndex, *r.TTL, *r.RdataIndex)
+		list = append(list, cdnsAddToTable(b, &b.tables.RR, key, r))
+	}
+	return cdnsAddToTable(b, &b.tables.RRList, fmt.Sprintf("rl/%v", list), list), nil
+}
+
+// sections adds the additional questions and resource records of the message,
+// the query OPT record is excluded because already described by the signature
+func (b *cdnsTablesBuilder) sections(msg *dns.Msg, excludeOpt bool) (*cdnsQueryResponseExtended, error) {
+	ext := &cdnsQueryResponseExtended{}
+	if len(msg.Question) > 1 {
+		idx, err := b.questions(msg.Question[1:])
+		if err != nil {
+			return nil, err
+		}
+		ext.QuestionIndex = cdnsUint(idx)
+	}
+
+	extra := []dns.RR{}
+	for _, rr := range msg.Extra {
+		if excludeOpt && rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		extra = append(extra, rr)
+	}
+
+	for _, section := range []struct {
+		rrs   []dns.RR
+		index **uint64
+	}{
+		{msg.Answer, &ext.AnswerIndex},
+		{msg.Ns, &ext.AuthorityIndex},
+		{extra, &ext.AdditionalIndex},
+	} {
+		if len(section.rrs) == 0 {
+			continue
+		}
+		idx, err := b.records(section.rrs)
+		if err != nil {
+			return nil, err
+		}
+		*section.index = cdnsUint(idx)
+	}
+
+	if ext.QuestionIndex == nil && ext.AnswerIndex == nil && ext.AuthorityIndex == nil && ext.AdditionalIndex == nil {
+		return nil, nil
+	}
+	return ext, nil
+}
+
+// cdnsRdata returns the uncompressed wire format of the rdata
+func cdnsRdata(rr dns.RR) ([]byte, error) {
+	buf := make([]byte, dns.Len(rr))
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	nameLen, err := dns.PackDomainName(dns.Fqdn(rr.Header().Name), make([]byte, 256), 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	// the rdata follows the name, type, class, ttl and rdlength fields
+	return buf[nameLen+10 : off], nil
+}
+
+func cdnsDNSFlags(msg *dns.Msg) uint64 {
+	var flags uint64
+	for _, f := range []struct {
+		set  bool
+		flag uint64
+	}{
+		{msg.CheckingDisabled, cdnsFlagCD},
+		{msg.AuthenticatedData, cdnsFlagAD},
+		{msg.Zero, cdnsFlagZ},
+		{msg.RecursionAvailable, cdnsFlagRA},
+		{msg.RecursionDesired, cdnsFlagRD},
+		{msg.Truncated, cdnsFlagTC},
+		{msg.Authoritative, cdnsFlagAA},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+	return flags
+}
+
+// cdnsRRTypes returns the list of known rr types
+func cdnsRRTypes() []uint64 {
+	types := []uint64{}
+	for rrtype := range dns.TypeToString {
+		types = append(types, uint64(rrtype))
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func cdnsTicks(d time.Duration) int64 {
+	return d.Nanoseconds() / (int64(time.Second) / CDNSTicksPerSecond)
+}
+
+// CDNSWriter encodes DNS messages to a C-DNS stream, queries and responses
+// of the same transaction are matched together when they belong to the same block.
+type CDNSWriter struct {
+	w                  io.Writer
+	maxBlockItems      int
+	hostID             string
+	items              []*cdnsTransaction
+	pending            map[string]*cdnsTransaction
+	processed          uint64
+	lastTime           time.Time
+	headerDone, closed bool
+}
+
+func NewCDNSWriter(w io.Writer, maxBlockItems int, hostID string) *CDNSWriter {
+	if maxBlockItems <= 0 {
+		maxBlockItems = CDNSDefaultBlockItems
+	}
+	return &CDNSWriter{
+		w:             w,
+		maxBlockItems: maxBlockItems,
+		hostID:        hostID,
+		pending:       make(map[string]*cdnsTransaction),
+	}
+}
+
+// WriteHeader writes the file type id, the file preamble and opens the array of blocks
+func (cw *CDNSWriter) WriteHeader() (int, error) {
+	preamble := cdnsFilePreamble{
+		MajorFormatVersion: CDNSMajorFormatVersion,
+		MinorFormatVersion: CDNSMinorFormatVersion,
+		BlockParameters: []cdnsBlockParameters{{
+			StorageParameters: cdnsStorageParameters{
+				TicksPerSecond: CDNSTicksPerSecond,
+				MaxBlockItems:  uint64(cw.maxBlockItems),
+				StorageHints: cdnsStorageHints{
+					// all query/response fields except client-hoplimit and response-processing-data
+					QueryResponseHints: 0x3ffff &^ (1<<5 | 1<<10),
+					// all signature fields
+					QueryResponseSignatureHints: 0x1ffff,
+					// ttl and rdata
+					RRHints: 0x3,
+				},
+				Opcodes: []uint64{0, 1, 2, 4, 5, 6},
+				RRTypes: cdnsRRTypes(),
+			},
+			CollectionParameters: &cdnsCollectionParameters{GeneratorID: "dnscollector", HostID: cw.hostID},
+		}},
+	}
+
+	header := []byte{cborMajorArray | 3}
+	fileType, err := cbor.Marshal(CDNSFileTypeID)
+	if err != nil {
+		return 0, err
+	}
+	header = append(header, fileType...)
+	encoded, err := cbor.Marshal(preamble)
+	if err != nil {
+		return 0, err
+	}
+	header = append(header, encoded...)
+	header = append(header, cborMajorArray|cborIndefinite)
+
+	cw.headerDone = true
+	return cw.w.Write(header)
+}
+
+// Write adds the DNS message to the current block, the block is encoded
+// and written when the maximum number of items is reached.
+// Returns the number of bytes written to the underlying writer.
+func (cw *CDNSWriter) Write(dm *DNSMessage) (int, error) {
+	if cw.closed {
+		return 0, errors.New("cdns writer is closed")
+	}
+	if len(dm.DNS.Payload) == 0 {
+		return 0, errors.New("payload is empty")
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(dm.DNS.Payload); err != nil {
+		return 0, fmt.Errorf("unable to decode payload: %w", err)
+	}
+	ts := time.Unix(int64(dm.DNSTap.TimeSec), int64(dm.DNSTap.TimeNsec))
+	cw.processed++
+	if ts.After(cw.lastTime) {
+		cw.lastTime = ts
+	}
+
+	// the query ip is always the client side, for queries and replies
+	key := fmt.Sprintf("%s/%s/%d", dm.NetworkInfo.QueryIP, dm.NetworkInfo.QueryPort, msg.Id)
+	if len(msg.Question) > 0 {
+		key += "/" + msg.Question[0].String()
+	}
+
+	if msg.Response {
+		if t, ok := cw.pending[key]; ok {
+			t.response = msg
+			t.responseSize = len(dm.DNS.Payload)
+			t.responseTime = ts
+			delete(cw.pending, key)
+			return 0, nil
+		}
+	}
+
+	t := &cdnsTransaction{
+		qrType:   1,
+		family:   dm.NetworkInfo.Family,
+		protocol: dm.NetworkInfo.Protocol,
+		clientIP: net.ParseIP(dm.NetworkInfo.QueryIP),
+		serverIP: net.ParseIP(dm.NetworkInfo.ResponseIP),
+	}
+	t.clientPort, _ = strconv.Atoi(dm.NetworkInfo.QueryPort)
+	t.serverPort, _ = strconv.Atoi(dm.NetworkInfo.ResponsePort)
+	if qrType, ok := cdnsQRTypes[strings.Split(dm.DNSTap.Operation, "_")[0]]; ok {
+		t.qrType = qrType
+	}
+
+	if msg.Response {
+		t.response = msg
+		t.responseSize = len(dm.DNS.Payload)
+		t.responseTime = ts
+	} else {
+		t.query = msg
+		t.querySize = len(dm.DNS.Payload)
+		t.queryTime = ts
+		cw.pending[key] = t
+	}
+	cw.items = append(cw.items, t)
+
+	if len(cw.items) >= cw.maxBlockItems {
+		return cw.writeBlock()
+	}
+	return 0, nil
+}
+
+// Flush writes the pending block even if it is not full. The queries without
+// response are carried to the next block to be matched later, they are stored
+// as unmatched once older than CDNSQueryTimeout.
+func (cw *CDNSWriter) Flush() (int, error) {
+	if cw.closed {
+		return 0, nil
+	}
+
+	items := make([]*cdnsTransaction, 0, len(cw.items))
+	carried := []*cdnsTransaction{}
+	for _, t := range cw.items {
+		if t.response == nil && cw.lastTime.Sub(t.queryTime) < CDNSQueryTimeout {
+			carried = append(carried, t)
+		} else {
+			items = append(items, t)
+		}
+	}
+	pending := make(map[string]*cdnsTransaction)
+	for key, t := range cw.pending {
+		if cw.lastTime.Sub(t.queryTime) < CDNSQueryTimeout {
+			pending[key] = t
+		}
+	}
+
+	cw.items = items
+	n, err := cw.writeBlock()
+	cw.items = append(cw.items, carried...)
+	cw.pending = pending
+	return n, err
+}
+
+// Close writes the pending block and terminates the array of blocks,
+// the underlying writer is not closed.
+func (cw *CDNSWriter) Close() (int, error) {
+	if cw.closed {
+		return 0, nil
+	}
+	cw.closed = true
+
+	n, err := cw.writeBlock()
+	if err != nil {
+		return n, err
+	}
+	m, err := cw.w.Write([]byte{cborBreak})
+	return n + m, err
+}
+
+func (cw *CDNSWriter) writeBlock() (int, error) {
+	if !cw.headerDone {
+		return 0, errors.New("cdns header not written")
+	}
+	if len(cw.items) == 0 {
+		return 0, nil
+	}
+
+	block, err := cw.encodeBlock()
+	cw.items = cw.items[:0]
+	cw.pending = make(map[string]*cdnsTransaction)
+	cw.processed = 0
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := cbor.Marshal(block)
+	if err != nil {
+		return 0, err
+	}
+	return cw.w.Write(data)
+}
+
+func (cw *CDNSWriter) encodeBlock() (*cdnsBlock, error) {
+	earliest := cw.items[0].time()
+	for _, t := range cw.items {
+		if t.time().Before(earliest) {
+			earliest = t.time()
+		}
+	}
+
+	b := &cdnsTablesBuilder{indexes: make(map[string]uint64)}
+	var unmatchedQueries, unmatchedResponses uint64
+	responses := []cdnsQueryResponse{}
+
+	for _, t := range cw.items {
+		qr := cdnsQueryResponse{TimeOffset: cdnsInt(cdnsTicks(t.time().Sub(earliest)))}
+		sig := cdnsQueryResponseSignature{}
+
+		if t.clientIP != nil {
+			qr.ClientAddressIndex = cdnsUint(b.ip(t.clientIP))
+		}
+		if t.serverIP != nil {
+			sig.ServerAddressIndex = cdnsUint(b.ip(t.serverIP))
+		}
+		qr.ClientPort = cdnsUint(uint64(t.clientPort))
+		sig.ServerPort = cdnsUint(uint64(t.serverPort))
+
+		transport := cdnsTransports[t.protocol] << 1
+		if t.family == netutils.ProtoIPv6 || (t.clientIP != nil && t.clientIP.To4() == nil) {
+			transport |= cdnsTransportIPv6
+		}
+		sig.QRTransportFlags = cdnsUint(transport)
+		sig.QRType = cdnsUint(t.qrType)
+
+		// the question is taken from the query if present
+		first := t.query
+		if first == nil {
+			first = t.response
+		}
+		qr.TransactionID = cdnsUint(uint64(first.Id))
+		sig.QueryOpcode = cdnsUint(uint64(first.Opcode))
+		if len(first.Question) > 0 {
+			nameIdx, err := b.name(first.Question[0].Name)
+			if err != nil {
+				return nil, err
+			}
+			qr.QueryNameIndex = cdnsUint(nameIdx)
+			sig.QueryClassTypeIndex = cdnsUint(b.classType(first.Question[0].Qtype, first.Question[0].Qclass))
+		}
+
+		var sigFlags, dnsFlags uint64
+		if q := t.query; q != nil {
+			sigFlags |= cdnsQueryPresent
+			if len(q.Question) == 0 {
+				sigFlags |= cdnsQueryHasNoQuestion
+			}
+			dnsFlags |= cdnsDNSFlags(q)
+			sig.QueryRcode = cdnsUint(uint64(q.Rcode))
+			sig.QueryQdCount = cdnsUint(uint64(len(q.Question)))
+			sig.QueryAnCount = cdnsUint(uint64(len(q.Answer)))
+			sig.QueryNsCount = cdnsUint(uint64(len(q.Ns)))
+			sig.QueryArCount = cdnsUint(uint64(len(q.Extra)))
+
+			if opt := q.IsEdns0(); opt != nil {
+				sigFlags |= cdnsQueryHasOpt
+				if opt.Do() {
+					dnsFlags |= cdnsFlagDO
+				}
+				sig.QueryEDNSVersion = cdnsUint(uint64(opt.Version()))
+				sig.QueryUDPSize = cdnsUint(uint64(opt.UDPSize()))
+
+				rdata, err := cdnsRdata(opt)
+				if err != nil {
+					return nil, err
+				}
+				sig.QueryOptRdataIndex = cdnsUint(b.nameRdata(rdata))
+			}
+
+			qr.QuerySize = cdnsUint(uint64(t.querySize))
+			ext, err := b.sections(q, true)
+			if err != nil {
+				return nil, err
+			}
+			qr.QueryExtended = ext
+		}
+
+		if r := t.response; r != nil {
+			sigFlags |= cdnsResponsePresent
+			if len(r.Question) == 0 {
+				sigFlags |= cdnsResponseHasNoQuestion
+			}
+			if r.IsEdns0() != nil {
+				sigFlags |= cdnsResponseHasOpt
+			}
+			dnsFlags |= cdnsDNSFlags(r) << 8
+			sig.ResponseRcode = cdnsUint(uint64(r.Rcode))
+
+			qr.ResponseSize = cdnsUint(uint64(t.responseSize))
+			ext, err := b.sections(r, false)
+			if err != nil {
+				return nil, err
+			}
+			qr.ResponseExtended = ext
+		}
+
+		switch {
+		case t.query != nil && t.response != nil:
+			qr.ResponseDelay = cdnsInt(cdnsTicks(t.responseTime.Sub(t.queryTime)))
+		case t.query != nil:
+			unmatchedQueries++
+		default:
+			unmatchedResponses++
+		}
+
+		sig.QRSigFlags = cdnsUint(sigFlags)
+		sig.QRDNSFlags = cdnsUint(dnsFlags)
+		sigIdx, err := b.signature(sig)
+		if err != nil {
+			return nil, err
+		}
+		qr.QRSignatureIndex = cdnsUint(sigIdx)
+
+		responses = append(responses, qr)
+	}
+
+	ticks := earliest.Sub(time.Unix(earliest.Unix(), 0))
+	block := &cdnsBlock{
+		Preamble: cdnsBlockPreamble{
+			EarliestTime:         &cdnsTimestamp{Seconds: uint64(earliest.Unix()), Ticks: uint64(cdnsTicks(ticks))},
+			BlockParametersIndex: cdnsUint(0),
+		},
+		Statistics: &cdnsBlockStatistics{
+			ProcessedMessages:  cdnsUint(cw.processed),
+			QRDataItems:        cdnsUint(uint64(len(responses))),
+			UnmatchedQueries:   cdnsUint(unmatchedQueries),
+			UnmatchedResponses: cdnsUint(unmatchedResponses),
+		},
+		Tables:         &b.tables,
+		QueryResponses: responses,
+	}
+	return block, nil
+}
+
+// CDNSReader decodes a C-DNS stream block per block
+// and rebuilds the queries and responses as DNS messages
+type CDNSReader struct {
+	r               *bufio.Reader
+	preamble        cdnsFilePreamble
+	indefinite      bool
+	blocksRemaining uint64
+	block           *cdnsBlock
+	next            int
+}
+
+func NewCDNSReader(r io.Reader) (*CDNSReader, error) {
+	cr := &CDNSReader{r: bufio.NewReader(r)}
+
+	// file is an array of 3 items
+	ib, err := cr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if ib != cborMajorArray|3 && ib != cborMajorArray|cborIndefinite {
+		return nil, errors.New("not a cdns file")
+	}
+
+	// file type id
+	item, err := cborReadItem(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	var fileType string
+	if err := cdnsDecMode.Unmarshal(item, &fileType); err != nil || fileType != CDNSFileTypeID {
+		return nil, errors.New("not a cdns file")
+	}
+
+	// file preamble
+	item, err = cborReadItem(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	if err := cdnsDecMode.Unmarshal(item, &cr.preamble); err != nil {
+		return nil, fmt.Errorf("invalid cdns preamble: %w", err)
+	}
+	if cr.preamble.MajorFormatVersion != CDNSMajorFormatVersion {
+		return nil, fmt.Errorf("unsupported cdns version %d", cr.preamble.MajorFormatVersion)
+	}
+	if len(cr.preamble.BlockParameters) == 0 {
+		return nil, errors.New("cdns block parameters are missing")
+	}
+
+	// array of blocks
+	ib, err = cr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if ib>>5 != cborMajorArray>>5 {
+		return nil, errors.New("invalid cdns blocks array")
+	}
+	if ib&0x1f == cborIndefinite {
+		cr.indefinite = true
+	} else {
+		cr.blocksRemaining, err = cborReadArgument(cr.r, ib)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cr, nil
+}
+
+// GetHostID returns the host id provided in the collection parameters if any
+func (cr *CDNSReader) GetHostID() string {
+	if cp := cr.preamble.BlockParameters[0].CollectionParameters; cp != nil {
+		return cp.HostID
+	}
+	return ""
+}
+
+func (cr *CDNSReader) readBlock() error {
+	if cr.indefinite {
+		ib, err := cr.r.Peek(1)
+		if err != nil || ib[0] == cborBreak {
+			// a missing break is tolerated, the file may not have been properly closed
+			return io.EOF
+		}
+	} else {
+		if cr.blocksRemaining == 0 {
+			return io.EOF
+		}
+		cr.blocksRemaining--
+	}
+
+	item, err := cborReadItem(cr.r)
+	if err != nil {
+		return fmt.Errorf("unable to read cdns block: %w", err)
+	}
+	block := &cdnsBlock{}
+	if err := cdnsDecMode.Unmarshal(item, block); err != nil {
+		return fmt.Errorf("invalid cdns block: %w", err)
+	}
+	if block.Tables == nil {
+		block.Tables = &cdnsBlockTables{}
+	}
+	cr.block = block
+	cr.next = 0
+	return nil
+}
+
+// Next returns the query and/or the response of the next item,
+// io.EOF is returned when there is no more items.
+func (cr *CDNSReader) Next() ([]DNSMessage, error) {
+	for cr.block == nil || cr.next >= len(cr.block.QueryResponses) {
+		if err := cr.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	qr := cr.block.QueryResponses[cr.next]
+	cr.next++
+	return cr.decodeItem(&qr)
+}
+
+func (cr *CDNSReader) decodeItem(qr *cdnsQueryResponse) ([]DNSMessage, error) {
+	tables := cr.block.Tables
+
+	paramsIdx := cdnsUint(0)
+	if cr.block.Preamble.BlockParametersIndex != nil {
+		paramsIdx = cr.block.Preamble.BlockParametersIndex
+	}
+	params, err := cdnsGet(cr.preamble.BlockParameters, paramsIdx)
+	if err != nil {
+		return nil, fmt.Errorf("block parameters: %w", err)
+	}
+	ticksPerSecond := params.StorageParameters.TicksPerSecond
+	if ticksPerSecond == 0 {
+		ticksPerSecond = CDNSTicksPerSecond
+	}
+	toDuration := func(ticks int64) time.Duration {
+		return time.Duration(float64(ticks) * float64(time.Second) / float64(ticksPerSecond))
+	}
+
+	var ts time.Time
+	if et := cr.block.Preamble.EarliestTime; et != nil {
+		ts = time.Unix(int64(et.Seconds), 0).Add(toDuration(int64(et.Ticks)))
+	}
+	if qr.TimeOffset != nil {
+		ts = ts.Add(toDuration(*qr.TimeOffset))
+	}
+
+	sig := cdnsQueryResponseSignature{}
+	if qr.QRSignatureIndex != nil {
+		if sig, err = cdnsGet(tables.QRSig, qr.QRSignatureIndex); err != nil {
+			return nil, fmt.Errorf("signature: %w", err)
+		}
+	}
+	valueOf := func(v *uint64) uint64 {
+		if v == nil {
+			return 0
+		}
+		return *v
+	}
+	sigFlags := valueOf(sig.QRSigFlags)
+	dnsFlags := valueOf(sig.QRDNSFlags)
+	transport := valueOf(sig.QRTransportFlags)
+
+	// network informations
+	family, ipLen := netutils.ProtoIPv4, net.IPv4len
+	if transport&cdnsTransportIPv6 != 0 {
+		family, ipLen = netutils.ProtoIPv6, net.IPv6len
+	}
+	protocol := netutils.ProtoUDP
+	for proto, value := range cdnsTransports {
+		if value == (transport>>1)&0xf {
+			protocol = proto
+		}
+	}
+	address := func(idx *uint64) (string, error) {
+		if idx == nil {
+			return "-", nil
+		}
+		ip, err := cdnsGet(tables.IPAddress, idx)
+		if err != nil {
+			return "", fmt.Errorf("address: %w", err)
+		}
+		// addresses can be truncated to a prefix
+		full := make(net.IP, ipLen)
+		copy(full, ip)
+		return full.String(), nil
+	}
+	clientIP, err := address(qr.ClientAddressIndex)
+	if err != nil {
+		return nil, err
+	}
+	serverIP, err := address(sig.ServerAddressIndex)
+	if err != nil {
+		return nil, err
+	}
+	clientPort, serverPort := "-", "-"
+	if qr.ClientPort != nil {
+		clientPort = strconv.FormatUint(*qr.ClientPort, 10)
+	}
+	if sig.ServerPort != nil {
+		serverPort = strconv.FormatUint(*sig.ServerPort, 10)
+	}
+
+	// operation prefix
+	qrType := "CLIENT"
+	for name, value := range cdnsQRTypes {
+		if sig.QRType != nil && value == *sig.QRType {
+			qrType = name
+		}
+	}
+
+	// question
+	var question []byte
+	if qr.QueryNameIndex != nil && sig.QueryClassTypeIndex != nil {
+		name, err := cdnsGet(tables.NameRdata, qr.QueryNameIndex)
+		if err != nil {
+			return nil, fmt.Errorf("query name: %w", err)
+		}
+		ct, err := cdnsGet(tables.ClassType, sig.QueryClassTypeIndex)
+		if err != nil {
+			return nil, fmt.Errorf("query classtype: %w", err)
+		}
+		question = cdnsWireQuestion(name, ct)
+	}
+
+	msgs := []DNSMessage{}
+	newMessage := func(payload []byte, ts time.Time, operation, dnsType string) DNSMessage {
+		dm := DNSMessage{}
+		dm.Init()
+		dm.NetworkInfo.Family = family
+		dm.NetworkInfo.Protocol = protocol
+		dm.NetworkInfo.QueryIP = clientIP
+		dm.NetworkInfo.QueryPort = clientPort
+		dm.NetworkInfo.ResponseIP = serverIP
+		dm.NetworkInfo.ResponsePort = serverPort
+		dm.DNS.Type = dnsType
+		dm.DNS.Payload = payload
+		dm.DNS.Length = len(payload)
+		dm.DNSTap.Operation = qrType + operation
+		dm.DNSTap.TimeSec = int(ts.Unix())
+		dm.DNSTap.TimeNsec = ts.Nanosecond()
+		if hostID := cr.GetHostID(); hostID != "" {
+			dm.DNSTap.Identity = hostID
+		}
+		return dm
+	}
+
+	header := cdnsWireHeader{id: uint16(valueOf(qr.TransactionID)), opcode: valueOf(sig.QueryOpcode)}
+
+	if sigFlags&cdnsQueryPresent != 0 {
+		h := header
+		h.flags = dnsFlags & 0x7f
+		h.rcode = valueOf(sig.QueryRcode)
+		if sigFlags&cdnsQueryHasNoQuestion == 0 {
+			h.question = question
+		}
+		if sigFlags&cdnsQueryHasOpt != 0 {
+			rdata := []byte{}
+			if sig.QueryOptRdataIndex != nil {
+				if rdata, err = cdnsGet(tables.NameRdata, sig.QueryOptRdataIndex); err != nil {
+					return nil, fmt.Errorf("query opt: %w", err)
+				}
+			}
+			ttl := valueOf(sig.QueryEDNSVersion) << 16
+			if dnsFlags&cdnsFlagDO != 0 {
+				ttl |= 1 << 15
+			}
+			h.opt = cdnsWireRR([]byte{0}, cdnsClassType{Type: uint64(dns.TypeOPT), Class: valueOf(sig.QueryUDPSize)}, ttl, rdata)
+		}
+		payload, err := h.pack(tables, qr.QueryExtended)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, newMessage(payload, ts, "_QUERY", DNSQuery))
+	}
+
+	if sigFlags&cdnsResponsePresent != 0 {
+		h := header
+		h.response = true
+		h.flags = (dnsFlags >> 8) & 0x7f
+		h.rcode = valueOf(sig.ResponseRcode)
+		if sigFlags&cdnsResponseHasNoQuestion == 0 {
+			h.question = question
+		}
+		payload, err := h.pack(tables, qr.ResponseExtended)
+		if err != nil {
+			return nil, err
+		}
+		rts := ts
+		if qr.ResponseDelay != nil && sigFlags&cdnsQueryPresent != 0 {
+			rts = ts.Add(toDuration(*qr.ResponseDelay))
+		}
+		msgs = append(msgs, newMessage(payload, rts, "_RESPONSE", DNSReply))
+	}
+
+	return msgs, nil
+}
+
+// cdnsWireHeader is used to rebuild the wire format of a message
+type cdnsWireHeader struct {
+	id            uint16
+	response      bool
+	opcode, rcode uint64
+	flags         uint64
+	question, opt []byte
+}
+
+func cdnsWireQuestion(name []byte, ct cdnsClassType) []byte {
+	buf := append([]byte{}, name...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(ct.Type))
+	return binary.BigEndian.AppendUint16(buf, uint16(ct.Class))
+}
+
+func cdnsWireRR(name []byte, ct cdnsClassType, ttl uint64, rdata []byte) []byte {
+	buf := append([]byte{}, name...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(ct.Type))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(ct.Class))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(ttl))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(rdata)))
+	return append(buf, rdata...)
+}
+
+func (h *cdnsWireHeader) pack(tables *cdnsBlockTables, ext *cdnsQueryResponseExtended) ([]byte, error) {
+	questions := [][]byte{}
+	if h.question != nil {
+		questions = append(questions, h.question)
+	}
+	sections := [3][][]byte{}
+
+	if ext != nil {
+		if ext.QuestionIndex != nil {
+			list, err := cdnsGet(tables.QList, ext.QuestionIndex)
+			if err != nil {
+				return nil, fmt.Errorf("question list: %w", err)
+			}
+			for i := range list {
+				q, err := cdnsGet(tables.QRR, &list[i])
+				if err != nil {
+					return nil, fmt.Errorf("question: %w", err)
+				}
+				name, err := cdnsGet(tables.NameRdata, &q.NameIndex)
+				if err != nil {
+					return nil, fmt.Errorf("question name: %w", err)
+				}
+				ct, err := cdnsGet(tables.ClassType, &q.ClassTypeIndex)
+				if err != nil {
+					return nil, fmt.Errorf("question classtype: %w", err)
+				}
+				questions = append(questions, cdnsWireQuestion(name, ct))
+			}
+		}
+
+		for i, index := range []*uint64{ext.AnswerIndex, ext.AuthorityIndex, ext.AdditionalIndex} {
+			if index == nil {
+				continue
+			}
+			list, err := cdnsGet(tables.RRList, index)
+			if err != nil {
+				return nil, fmt.Errorf("rr list: %w", err)
+			}
+			for j := range list {
+				rr, err := cdnsGet(tables.RR, &list[j])
+				if err != nil {
+					return nil, fmt.Errorf("rr: %w", err)
+				}
+				name, err := cdnsGet(tables.NameRdata, &rr.NameIndex)
+				if err != nil {
+					return nil, fmt.Errorf("rr name: %w", err)
+				}
+				ct, err := cdnsGet(tables.ClassType, &rr.ClassTypeIndex)
+				if err != nil {
+					return nil, fmt.Errorf("rr classtype: %w", err)
+				}
+				rdata := []byte{}
+				if rr.RdataIndex != nil {
+					if rdata, err = cdnsGet(tables.NameRdata, rr.RdataIndex); err != nil {
+						return nil, fmt.Errorf("rr rdata: %w", err)
+					}
+				}
+				ttl := uint64(0)
+				if rr.TTL != nil {
+					ttl = *rr.TTL
+				}
+				sections[i] = append(sections[i], cdnsWireRR(name, ct, ttl, rdata))
+			}
+		}
+	}
+	if h.opt != nil {
+		sections[2] = append(sections[2], h.opt)
+	}
+
+	// header flags: QR, opcode, AA, TC, RD, RA, Z, AD, CD, rcode
+	var flags uint16
+	if h.response {
+		flags |= 1 << 15
+	}
+	flags |= uint16(h.opcode&0xf) << 11
+	for _, f := range []struct {
+		flag uint64
+		bit  uint16
+	}{
+		{cdnsFlagAA, 1 << 10}, {cdnsFlagTC, 1 << 9}, {cdnsFlagRD, 1 << 8}, {cdnsFlagRA, 1 << 7},
+		{cdnsFlagZ, 1 << 6}, {cdnsFlagAD, 1 << 5}, {cdnsFlagCD, 1 << 4},
+	} {
+		if h.flags&f.flag != 0 {
+			flags |= f.bit
+		}
+	}
+	flags |= uint16(h.rcode & 0xf)
+
+	buf := binary.BigEndian.AppendUint16(nil, h.id)
+	buf = binary.BigEndian.AppendUint16(buf, flags)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(questions)))
+	for _, section := range sections {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(section)))
+	}
+	for _, q := range questions {
+		buf = append(buf, q...)
+	}
+	for _, section := range sections {
+		for _, rr := range section {
+			buf = append(buf, rr...)
+		}
+	}
+	return buf, nil
+}
+
+// cborReadArgument returns the argument value of the initial byte
+func cborReadArgument(r *bufio.Reader, ib byte) (uint64, error) {
+	ai := ib & 0x1f
+	switch {
+	case ai < 24:
+		return uint64(ai), nil
+	case ai <= 27:
+		buf := make([]byte, 1<<(ai-24))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+		var v uint64
+		for _, b := range buf {
+			v = v<<8 | uint64(b)
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("cbor: invalid additional information %d", ai)
+}
+
+// cborReadItem reads the raw bytes of the next complete cbor data item
+func cborReadItem(r *bufio.Reader) ([]byte, error) {
+	var item []byte
+	if err := cborAppendItem(r, &item, 0); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func cborAppendItem(r *bufio.Reader, item *[]byte, depth int) error {
+	if depth > 64 {
+		return errors.New("cbor: too many nested levels")
+	}
+	ib, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	*item = append(*item, ib)
+	major := ib >> 5
+
+	if ib&0x1f == cborIndefinite {
+		switch major {
+		case 2, 3, 4, 5:
+			for {
+				next, err := r.Peek(1)
+				if err != nil {
+					return err
+				}
+				if next[0] == cborBreak {
+					r.ReadByte()
+					*item = append(*item, cborBreak)
+					return nil
+				}
+				if err := cborAppendItem(r, item, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return errors.New("cbor: unexpected indefinite length")
+	}
+
+	arg, err := cborReadArgument(r, ib)
+	if err != nil {
+		return err
+	}
+	// keep the argument bytes
+	if ai := ib & 0x1f; ai >= 24 {
+		size := 1 << (ai - 24)
+		for i := size - 1; i >= 0; i-- {
+			*item = append(*item, byte(arg>>(8*uint(i))))
+		}
+	}
+
+	switch major {
+	case 2, 3:
+		if arg > math.MaxInt32 {
+			return errors.New("cbor: string too long")
+		}
+		buf := make([]byte, arg)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		*item = append(*item, buf...)
+	case 4, 5:
+		count := arg
+		if major == 5 {
+			count *= 2
+		}
+		for i := uint64(0); i < count; i++ {
+			if err := cborAppendItem(r, item, depth+1); err != nil {
+				return err
+			}
+		}
+	case 6:
+		return cborAppendItem(r, item, depth+1)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package dnsutils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/dmachard/go-netutils"
+	"github.com/miekg/dns"
+)
+
+func getCdnsTestMessages(t *testing.T) (DNSMessage, DNSMessage) {
+	query := new(dns.Msg)
+	query.SetQuestion("dnscollector.dev.", dns.TypeA)
+	query.SetEdns0(1232, true)
+	queryPayload, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	rr, _ := dns.NewRR("dnscollector.dev. 3600 IN A 192.168.1.1")
+	reply.Answer = append(reply.Answer, rr)
+	replyPayload, err := reply.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dmQuery := GetFakeDNSMessage()
+	dmQuery.NetworkInfo.Family = netutils.ProtoIPv4
+	dmQuery.NetworkInfo.Protocol = netutils.ProtoUDP
+	dmQuery.DNS.Payload = queryPayload
+	dmQuery.DNS.Length = len(queryPayload)
+	dmQuery.DNSTap.TimeSec = 1700000000
+	dmQuery.DNSTap.TimeNsec = 100000
+
+	dmReply := GetFakeDNSMessage()
+	dmReply.DNSTap.Operation = DNSTapClientResponse
+	dmReply.DNS.Type = DNSReply
+	dmReply.NetworkInfo.Family = netutils.ProtoIPv4
+	dmReply.NetworkInfo.Protocol = netutils.ProtoUDP
+	dmReply.DNS.Payload = replyPayload
+	dmReply.DNS.Length = len(replyPayload)
+	dmReply.DNSTap.TimeSec = 1700000000
+	dmReply.DNSTap.TimeNsec = 2100000
+
+	return dmQuery, dmReply
+}
+
+func TestDnsMessage_Cdns_WriteRead(t *testing.T) {
+	dmQuery, dmReply := getCdnsTestMessages(t)
+
+	// encode
+	buf := new(bytes.Buffer)
+	cw := NewCDNSWriter(buf, 10, "collector")
+	if _, err := cw.WriteHeader(); err != nil {
+		t.Fatalf("unable to write header: %v", err)
+	}
+	for _, dm := range []DNSMessage{dmQuery, dmReply} {
+		if _, err := cw.Write(&dm); err != nil {
+			t.Fatalf("unable to write dns message: %v", err)
+		}
+	}
+	if _, err := cw.Close(); err != nil {
+		t.Fatalf("unable to close writer: %v", err)
+	}
+
+	// decode
+	cr, err := NewCDNSReader(buf)
+	if err != nil {
+		t.Fatalf("unable to read header: %v", err)
+	}
+	if cr.GetHostID() != "collector" {
+		t.Errorf("invalid host id: %s", cr.GetHostID())
+	}
+
+	// query and reply must be matched in the same item
+	msgs, err := cr.Next()
+	if err != nil {
+		t.Fatalf("unable to read item: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("query and reply expected, got %d message(s)", len(msgs))
+	}
+
+	if msgs[0].DNSTap.Operation != DNSTapClientQuery || msgs[1].DNSTap.Operation != DNSTapClientResponse {
+		t.Errorf("invalid operations: %s %s", msgs[0].DNSTap.Operation, msgs[1].DNSTap.Operation)
+	}
+	for _, dm := range msgs {
+		if dm.NetworkInfo.QueryIP != "1.2.3.4" || dm.NetworkInfo.QueryPort != "1234" {
+			t.Errorf("invalid client: %s:%s", dm.NetworkInfo.QueryIP, dm.NetworkInfo.QueryPort)
+		}
+		if dm.NetworkInfo.ResponseIP != "4.3.2.1" || dm.NetworkInfo.ResponsePort != "4321" {
+			t.Errorf("invalid server: %s:%s", dm.NetworkInfo.ResponseIP, dm.NetworkInfo.ResponsePort)
+		}
+	}
+	if msgs[0].DNSTap.TimeSec != 1700000000 || msgs[0].DNSTap.TimeNsec != 100000 {
+		t.Errorf("invalid query timestamp: %d %d", msgs[0].DNSTap.TimeSec, msgs[0].DNSTap.TimeNsec)
+	}
+	if msgs[1].DNSTap.TimeSec != 1700000000 || msgs[1].DNSTap.TimeNsec != 2100000 {
+		t.Errorf("invalid reply timestamp: %d %d", msgs[1].DNSTap.TimeSec, msgs[1].DNSTap.TimeNsec)
+	}
+
+	// check rebuilt payloads
+	query := new(dns.Msg)
+	if err := query.Unpack(msgs[0].DNS.Payload); err != nil {
+		t.Fatalf("invalid query payload: %v", err)
+	}
+	if query.Question[0].Name != "dnscollector.dev." || query.Question[0].Qtype != dns.TypeA {
+		t.Errorf("invalid question: %v", query.Question[0])
+	}
+	if opt := query.IsEdns0(); opt == nil || opt.UDPSize() != 1232 || !opt.Do() {
+		t.Errorf("invalid edns: %v", opt)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(msgs[1].DNS.Payload); err != nil {
+		t.Fatalf("invalid reply payload: %v", err)
+	}
+	if !reply.Response || reply.Id != query.Id {
+		t.Errorf("invalid reply header: %v", reply.MsgHdr)
+	}
+	if len(reply.Answer) != 1 || reply.Answer[0].String() != "dnscollector.dev.\t3600\tIN\tA\t192.168.1.1" {
+		t.Errorf("invalid answers: %v", reply.Answer)
+	}
+
+	if _, err := cr.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("end of file expected: %v", err)
+	}
+}
+
+func TestDnsMessage_Cdns_UnmatchedReply(t *testing.T) {
+	_, dmReply := getCdnsTestMessages(t)
+
+	buf := new(bytes.Buffer)
+	cw := NewCDNSWriter(buf, 1, "")
+	cw.WriteHeader()
+
+	// the block is written as soon as the max number of items is reached
+	n, err := cw.Write(&dmReply)
+	if err != nil {
+		t.Fatalf("unable to write dns message: %v", err)
+	}
+	if n == 0 {
+		t.Errorf("block not written")
+	}
+
+	// file without the final break is accepted
+	cr, err := NewCDNSReader(buf)
+	if err != nil {
+		t.Fatalf("unable to read header: %v", err)
+	}
+	msgs, err := cr.Next()
+	if err != nil {
+		t.Fatalf("unable to read item: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].DNS.Type != DNSReply {
+		t.Fatalf("reply only expected, got %v", msgs)
+	}
+	if _, err := cr.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("end of file expected: %v", err)
+	}
+}
+
+func TestDnsMessage_Cdns_FlushBetweenQueryAndReply(t *testing.T) {
+	dmQuery, dmReply := getCdnsTestMessages(t)
+
+	buf := new(bytes.Buffer)
+	cw := NewCDNSWriter(buf, 10, "")
+	cw.WriteHeader()
+
+	// the query without response is carried to the next block on flush
+	cw.Write(&dmQuery)
+	if n, err := cw.Flush(); err != nil || n != 0 {
+		t.Errorf("no block expected on flush: %d %v", n, err)
+	}
+	cw.Write(&dmReply)
+	if n, err := cw.Flush(); err != nil || n == 0 {
+		t.Errorf("block expected on flush: %d %v", n, err)
+	}
+
+	// query without response older than the timeout is stored as unmatched
+	dmLate := dmQuery
+	dmLate.DNSTap.TimeSec += 1
+	cw.Write(&dmLate)
+	dmOther := dmReply
+	dmOther.NetworkInfo.QueryPort = "5353"
+	dmOther.DNSTap.TimeSec += 1 + int(CDNSQueryTimeout.Seconds())
+	cw.Write(&dmOther)
+	cw.Flush()
+	if len(cw.items) != 0 || len(cw.pending) != 0 {
+		t.Errorf("expired query not written on flush")
+	}
+	cw.Close()
+
+	cr, err := NewCDNSReader(buf)
+	if err != nil {
+		t.Fatalf("unable to read header: %v", err)
+	}
+	msgs, err := cr.Next()
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("query and reply expected in the same item, got %d message(s): %v", len(msgs), err)
+	}
+	for i := 0; i < 2; i++ {
+		if msgs, err = cr.Next(); err != nil || len(msgs) != 1 {
+			t.Fatalf("unmatched message expected, got %d message(s): %v", len(msgs), err)
+		}
+	}
+	if _, err := cr.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("end of file expected: %v", err)
+	}
+}
+
+func TestDnsMessage_Cdns_EmptyPayload(t *testing.T) {
+	dm := GetFakeDNSMessage()
+	cw := NewCDNSWriter(new(bytes.Buffer), 0, "")
+	if _, err := cw.Write(&dm); err == nil {
+		t.Errorf("error expected with empty payload")
+	}
+}
+
+func TestDnsMessage_Cdns_InvalidFile(t *testing.T) {
+	if _, err := NewCDNSReader(bytes.NewReader([]byte("invalid"))); err == nil {
+		t.Errorf("error expected with invalid file")
+	}
+}
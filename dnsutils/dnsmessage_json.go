@@ -118,6 +118,9 @@ func (dm *DNSMessage) Flatten() (map[string]interface{}, error) {
 		dnsFields[prefixOpt+".data"] = opt.Data
 		dnsFields[prefixOpt+".name"] = opt.Name
 	}
+	for field, value := range EdnsOptionsFields(&dm.EDNS) {
+		dnsFields["edns."+field] = value
+	}
 
 	// Add TransformDNSGeo fields
 	if dm.Geo != nil {
@@ -159,6 +162,45 @@ func (dm *DNSMessage) Flatten() (map[string]interface{}, error) {
 		dnsFields["reducer.cumulative-length"] = dm.Reducer.CumulativeLength
 	}
 
+	// Add TransformTyposquatting fields
+	if dm.Typosquatting != nil {
+		dnsFields["typosquatting.detected"] = dm.Typosquatting.Detected
+		dnsFields["typosquatting.brand"] = dm.Typosquatting.Brand
+		dnsFields["typosquatting.technique"] = dm.Typosquatting.Technique
+		dnsFields["typosquatting.distance"] = dm.Typosquatting.Distance
+	}
+
+	// Add TransformThreatIntel fields
+	if dm.ThreatIntel != nil {
+		dnsFields["threatintel.matched"] = dm.ThreatIntel.Matched
+		dnsFields["threatintel.feed"] = dm.ThreatIntel.Feed
+		dnsFields["threatintel.category"] = dm.ThreatIntel.Category
+		dnsFields["threatintel.trigger"] = dm.ThreatIntel.Trigger
+		dnsFields["threatintel.action"] = dm.ThreatIntel.Action
+		dnsFields["threatintel.value"] = dm.ThreatIntel.Value
+	}
+
+	// Add TransformIdna fields
+	if dm.Idna != nil {
+		dnsFields["idna.qname-unicode"] = dm.Idna.QnameUnicode
+		dnsFields["idna.mixed-script"] = dm.Idna.MixedScript
+	}
+
+	// Add TransformTransaction fields
+	if dm.Transaction != nil {
+		dnsFields["transaction.status"] = dm.Transaction.Status
+		dnsFields["transaction.query-length"] = dm.Transaction.QueryLength
+		dnsFields["transaction.reply-length"] = dm.Transaction.ReplyLength
+		dnsFields["transaction.reply-timestamp-rfc3339ns"] = dm.Transaction.ReplyTimestamp
+		dnsFields["transaction.reply-flags.qr"] = dm.Transaction.ReplyFlags.QR
+		dnsFields["transaction.reply-flags.tc"] = dm.Transaction.ReplyFlags.TC
+		dnsFields["transaction.reply-flags.aa"] = dm.Transaction.ReplyFlags.AA
+		dnsFields["transaction.reply-flags.ra"] = dm.Transaction.ReplyFlags.RA
+		dnsFields["transaction.reply-flags.ad"] = dm.Transaction.ReplyFlags.AD
+		dnsFields["transaction.reply-flags.rd"] = dm.Transaction.ReplyFlags.RD
+		dnsFields["transaction.reply-flags.cd"] = dm.Transaction.ReplyFlags.CD
+	}
+
 	// Add TransformFiltering fields
 	if dm.Filtering != nil {
 		dnsFields["filtering.sample-rate"] = dm.Filtering.SampleRate
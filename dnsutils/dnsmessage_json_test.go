@@ -233,6 +233,19 @@ func TestDnsMessage_Json_Transforms_Reference(t *testing.T) {
 						}
 					}`,
 		},
+		{
+			transform: "transaction",
+			dmRef:     DNSMessage{Transaction: &TransformTransaction{Status: "ANSWERED", QueryLength: 30, ReplyLength: 46}},
+			jsonRef: `{
+						"transaction": {
+							"status": "ANSWERED",
+							"query-length": 30,
+							"reply-length": 46,
+							"reply-timestamp-rfc3339ns": "",
+							"reply-flags": {"qr": false, "tc": false, "aa": false, "ra": false, "ad": false, "rd": false, "cd": false}
+						}
+					}`,
+		},
 		{
 			transform: "normalize",
 			dmRef: DNSMessage{
@@ -371,6 +384,8 @@ func TestDnsMessage_JsonFlatten_Reference(t *testing.T) {
 					"edns.options.0.code": 10,
 					"edns.options.0.data": "aaaabbbbcccc",
 					"edns.options.0.name": "COOKIE",
+					"edns.cookie.client": "aaaabbbbcccc",
+					"edns.cookie.server": "-",
 					"edns.rcode": 0,
 					"edns.udp-size": 0,
 					"edns.version": 0,
@@ -441,6 +456,45 @@ func TestDnsMessage_JsonFlatten_Transforms_Reference(t *testing.T) {
 						"reducer.cumulative-length": 47
 					  }`,
 		},
+		{
+			transform: "typosquatting",
+			dm:        DNSMessage{Typosquatting: &TransformTyposquatting{Detected: true, Brand: "paypal.com", Technique: "tld-swap"}},
+			jsonRef: `{
+						"typosquatting.detected": true,
+						"typosquatting.brand": "paypal.com",
+						"typosquatting.technique": "tld-swap",
+						"typosquatting.distance": 0
+					  }`,
+		},
+		{
+			transform: "threatintel",
+			dm:        DNSMessage{ThreatIntel: &TransformThreatIntel{Matched: true, Feed: "rpz", Category: "malware", Trigger: "QNAME", Action: "NXDOMAIN", Value: "bad.example.com"}},
+			jsonRef: `{
+						"threatintel.matched": true,
+						"threatintel.feed": "rpz",
+						"threatintel.category": "malware",
+						"threatintel.trigger": "QNAME",
+						"threatintel.action": "NXDOMAIN",
+						"threatintel.value": "bad.example.com"
+					  }`,
+		},
+		{
+			transform: "idna",
+			dm:        DNSMessage{Idna: &TransformIdna{QnameUnicode: "аpple.com", MixedScript: true}},
+			jsonRef: `{
+						"idna.qname-unicode": "аpple.com",
+						"idna.mixed-script": true
+					  }`,
+		},
+		{
+			transform: "transaction",
+			dm:        DNSMessage{Transaction: &TransformTransaction{Status: "TIMEOUT", QueryLength: 30}},
+			jsonRef: `{
+						"transaction.status": "TIMEOUT",
+						"transaction.query-length": 30,
+						"transaction.reply-length": 0
+					  }`,
+		},
 		{
 			transform: "publixsuffix",
 			dm: DNSMessage{
@@ -0,0 +1,419 @@
+package dnsutils
+
+import (
+	"strconv"
+)
+
+// DNSMessageParquet is the row of the parquet output, a typed columnar schema derived from the DNSMessage.
+// The sections of the collectors and transformers are optional groups, null when not populated.
+type DNSMessageParquet struct {
+	Network       ParquetNetInfo        `parquet:"network"`
+	DNS           ParquetDNS            `parquet:"dns"`
+	EDNS          ParquetEDNS           `parquet:"edns"`
+	DNSTap        ParquetDNSTap         `parquet:"dnstap"`
+	PowerDNS      *ParquetPowerDNS      `parquet:"powerdns,optional"`
+	OpenTelemetry *ParquetOpenTelemetry `parquet:"opentelemetry,optional"`
+	Geo           *ParquetGeo           `parquet:"geoip,optional"`
+	Suspicious    *ParquetSuspicious    `parquet:"suspicious,optional"`
+	Typosquatting *ParquetTyposquatting `parquet:"typosquatting,optional"`
+	ThreatIntel   *ParquetThreatIntel   `parquet:"threatintel,optional"`
+	PublicSuffix  *ParquetPublicSuffix  `parquet:"publicsuffix,optional"`
+	Idna          *ParquetIdna          `parquet:"idna,optional"`
+	Extracted     *ParquetExtracted     `parquet:"extracted,optional"`
+	Reducer       *ParquetReducer       `parquet:"reducer,optional"`
+	ML            *ParquetML            `parquet:"ml,optional"`
+	Filtering     *ParquetFiltering     `parquet:"filtering,optional"`
+	ATags         *ParquetATags         `parquet:"atags,optional"`
+	Transaction   *ParquetTransaction   `parquet:"transaction,optional"`
+}
+
+type ParquetNetInfo struct {
+	Family         string `parquet:"family,dict"`
+	Protocol       string `parquet:"protocol,dict"`
+	QueryIP        string `parquet:"query_ip"`
+	QueryPort      int32  `parquet:"query_port"`
+	ResponseIP     string `parquet:"response_ip"`
+	ResponsePort   int32  `parquet:"response_port"`
+	IPDefragmented bool   `parquet:"ip_defragmented"`
+	TCPReassembled bool   `parquet:"tcp_reassembled"`
+}
+
+type ParquetFlags struct {
+	QR bool `parquet:"qr"`
+	TC bool `parquet:"tc"`
+	AA bool `parquet:"aa"`
+	RA bool `parquet:"ra"`
+	AD bool `parquet:"ad"`
+	RD bool `parquet:"rd"`
+	CD bool `parquet:"cd"`
+}
+
+type ParquetRR struct {
+	Name      string `parquet:"name"`
+	Rdatatype string `parquet:"rdatatype,dict"`
+	Class     string `parquet:"class,dict"`
+	TTL       int32  `parquet:"ttl"`
+	Rdata     string `parquet:"rdata"`
+}
+
+type ParquetDNS struct {
+	Length          int32        `parquet:"length"`
+	ID              int32        `parquet:"id"`
+	Opcode          int32        `parquet:"opcode"`
+	Rcode           string       `parquet:"rcode,dict"`
+	Qname           string       `parquet:"qname"`
+	Qclass          string       `parquet:"qclass,dict"`
+	Qtype           string       `parquet:"qtype,dict"`
+	QdCount         int32        `parquet:"qdcount"`
+	AnCount         int32        `parquet:"ancount"`
+	NsCount         int32        `parquet:"nscount"`
+	ArCount         int32        `parquet:"arcount"`
+	Flags           ParquetFlags `parquet:"flags"`
+	Answers         []ParquetRR  `parquet:"answers,list"`
+	Nameservers     []ParquetRR  `parquet:"nameservers,list"`
+	Records         []ParquetRR  `parquet:"records,list"`
+	MalformedPacket bool         `parquet:"malformed_packet"`
+}
+
+type ParquetOption struct {
+	Code int32  `parquet:"code"`
+	Name string `parquet:"name,dict"`
+	Data string `parquet:"data"`
+}
+
+type ParquetEDNS struct {
+	UDPSize       int32           `parquet:"udp_size"`
+	ExtendedRcode int32           `parquet:"rcode"`
+	Version       int32           `parquet:"version"`
+	DnssecOk      bool            `parquet:"dnssec_ok"`
+	Options       []ParquetOption `parquet:"options,list"`
+}
+
+type ParquetDNSTap struct {
+	Operation    string  `parquet:"operation,dict"`
+	Identity     string  `parquet:"identity,dict"`
+	Version      string  `parquet:"version,dict"`
+	Timestamp    int64   `parquet:"timestamp,timestamp(nanosecond)"`
+	Latency      float64 `parquet:"latency"`
+	Extra        string  `parquet:"extra"`
+	PolicyRule   string  `parquet:"policy_rule"`
+	PolicyType   string  `parquet:"policy_type"`
+	PolicyMatch  string  `parquet:"policy_match"`
+	PolicyAction string  `parquet:"policy_action"`
+	PolicyValue  string  `parquet:"policy_value"`
+	PeerName     string  `parquet:"peer_name"`
+	QueryZone    string  `parquet:"query_zone"`
+}
+
+type ParquetPowerDNS struct {
+	Tags                  []string          `parquet:"tags,list"`
+	OriginalRequestSubnet string            `parquet:"original_request_subnet"`
+	AppliedPolicy         string            `parquet:"applied_policy"`
+	AppliedPolicyHit      string            `parquet:"applied_policy_hit"`
+	AppliedPolicyKind     string            `parquet:"applied_policy_kind"`
+	AppliedPolicyTrigger  string            `parquet:"applied_policy_trigger"`
+	AppliedPolicyType     string            `parquet:"applied_policy_type"`
+	Metadata              map[string]string `parquet:"metadata"`
+	HTTPVersion           string            `parquet:"http_version"`
+	MessageID             string            `parquet:"message_id"`
+	InitialRequestorID    string            `parquet:"initial_requestor_id"`
+	RequestorID           string            `parquet:"requestor_id"`
+	DeviceName            string            `parquet:"device_name"`
+	DeviceID              string            `parquet:"device_id"`
+}
+
+type ParquetOpenTelemetry struct {
+	TraceID string `parquet:"trace_id"`
+}
+
+type ParquetGeo struct {
+	City                   string `parquet:"city,dict"`
+	Continent              string `parquet:"continent,dict"`
+	CountryIsoCode         string `parquet:"country_isocode,dict"`
+	AutonomousSystemNumber string `parquet:"as_number,dict"`
+	AutonomousSystemOrg    string `parquet:"as_owner,dict"`
+}
+
+type ParquetSuspicious struct {
+	Score                 float64 `parquet:"score"`
+	MalformedPacket       bool    `parquet:"malformed_pkt"`
+	LargePacket           bool    `parquet:"large_pkt"`
+	LongDomain            bool    `parquet:"long_domain"`
+	SlowDomain            bool    `parquet:"slow_domain"`
+	UnallowedChars        bool    `parquet:"unallowed_chars"`
+	UncommonQtypes        bool    `parquet:"uncommon_qtypes"`
+	ExcessiveNumberLabels bool    `parquet:"excessive_number_labels"`
+	Domain                string  `parquet:"domain"`
+}
+
+type ParquetTyposquatting struct {
+	Detected  bool   `parquet:"detected"`
+	Brand     string `parquet:"brand,dict"`
+	Technique string `parquet:"technique,dict"`
+	Distance  int32  `parquet:"distance"`
+}
+
+type ParquetThreatIntel struct {
+	Matched  bool   `parquet:"matched"`
+	Feed     string `parquet:"feed,dict"`
+	Category string `parquet:"category,dict"`
+	Trigger  string `parquet:"trigger,dict"`
+	Action   string `parquet:"action,dict"`
+	Value    string `parquet:"value"`
+}
+
+type ParquetPublicSuffix struct {
+	QnamePublicSuffix        string `parquet:"tld,dict"`
+	QnameEffectiveTLDPlusOne string `parquet:"etld_plus_one"`
+	ManagedByICANN           bool   `parquet:"managed_icann"`
+}
+
+type ParquetIdna struct {
+	QnameUnicode string `parquet:"qname_unicode"`
+	MixedScript  bool   `parquet:"mixed_script"`
+}
+
+type ParquetExtracted struct {
+	DNSPayload []byte `parquet:"dns_payload"`
+}
+
+type ParquetReducer struct {
+	Occurrences      int32 `parquet:"occurrences"`
+	CumulativeLength int32 `parquet:"cumulative_length"`
+}
+
+type ParquetML struct {
+	Entropy               float64 `parquet:"entropy"`
+	Length                int32   `parquet:"length"`
+	Labels                int32   `parquet:"labels"`
+	Digits                int32   `parquet:"digits"`
+	Lowers                int32   `parquet:"lowers"`
+	Uppers                int32   `parquet:"uppers"`
+	Specials              int32   `parquet:"specials"`
+	Others                int32   `parquet:"others"`
+	RatioDigits           float64 `parquet:"ratio_digits"`
+	RatioLetters          float64 `parquet:"ratio_letters"`
+	RatioSpecials         float64 `parquet:"ratio_specials"`
+	RatioOthers           float64 `parquet:"ratio_others"`
+	ConsecutiveChars      int32   `parquet:"consecutive_chars"`
+	ConsecutiveVowels     int32   `parquet:"consecutive_vowels"`
+	ConsecutiveDigits     int32   `parquet:"consecutive_digits"`
+	ConsecutiveConsonants int32   `parquet:"consecutive_consonants"`
+	Size                  int32   `parquet:"size"`
+	Occurrences           int32   `parquet:"occurrences"`
+	UncommonQtypes        int32   `parquet:"uncommon_qtypes"`
+}
+
+type ParquetFiltering struct {
+	SampleRate int32 `parquet:"sample_rate"`
+}
+
+type ParquetATags struct {
+	Tags []string `parquet:"tags,list"`
+}
+
+type ParquetTransaction struct {
+	Status         string       `parquet:"status,dict"`
+	QueryLength    int32        `parquet:"query_length"`
+	ReplyLength    int32        `parquet:"reply_length"`
+	ReplyTimestamp string       `parquet:"reply_timestamp"`
+	ReplyFlags     ParquetFlags `parquet:"reply_flags"`
+}
+
+func toParquetFlags(flags DNSFlags) ParquetFlags {
+	return ParquetFlags{QR: flags.QR, TC: flags.TC, AA: flags.AA, RA: flags.RA, AD: flags.AD, RD: flags.RD, CD: flags.CD}
+}
+
+func toParquetRRs(rrs []DNSAnswer) []ParquetRR {
+	ret := make([]ParquetRR, 0, len(rrs))
+	for _, rr := range rrs {
+		ret = append(ret, ParquetRR{Name: rr.Name, Rdatatype: rr.Rdatatype, Class: rr.Class, TTL: int32(rr.TTL), Rdata: rr.Rdata})
+	}
+	return ret
+}
+
+// toParquetPort converts the port to integer, zero if unknown
+func toParquetPort(port string) int32 {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return 0
+	}
+	return int32(p)
+}
+
+// ToParquet converts the dns message to a parquet row
+func (dm *DNSMessage) ToParquet() DNSMessageParquet {
+	row := DNSMessageParquet{
+		Network: ParquetNetInfo{
+			Family:         dm.NetworkInfo.Family,
+			Protocol:       dm.NetworkInfo.Protocol,
+			QueryIP:        dm.NetworkInfo.QueryIP,
+			QueryPort:      toParquetPort(dm.NetworkInfo.QueryPort),
+			ResponseIP:     dm.NetworkInfo.ResponseIP,
+			ResponsePort:   toParquetPort(dm.NetworkInfo.ResponsePort),
+			IPDefragmented: dm.NetworkInfo.IPDefragmented,
+			TCPReassembled: dm.NetworkInfo.TCPReassembled,
+		},
+		DNS: ParquetDNS{
+			Length:          int32(dm.DNS.Length),
+			ID:              int32(dm.DNS.ID),
+			Opcode:          int32(dm.DNS.Opcode),
+			Rcode:           dm.DNS.Rcode,
+			Qname:           dm.DNS.Qname,
+			Qclass:          dm.DNS.Qclass,
+			Qtype:           dm.DNS.Qtype,
+			QdCount:         int32(dm.DNS.QdCount),
+			AnCount:         int32(dm.DNS.AnCount),
+			NsCount:         int32(dm.DNS.NsCount),
+			ArCount:         int32(dm.DNS.ArCount),
+			Flags:           toParquetFlags(dm.DNS.Flags),
+			Answers:         toParquetRRs(dm.DNS.DNSRRs.Answers),
+			Nameservers:     toParquetRRs(dm.DNS.DNSRRs.Nameservers),
+			Records:         toParquetRRs(dm.DNS.DNSRRs.Records),
+			MalformedPacket: dm.DNS.MalformedPacket,
+		},
+		EDNS: ParquetEDNS{
+			UDPSize:       int32(dm.EDNS.UDPSize),
+			ExtendedRcode: int32(dm.EDNS.ExtendedRcode),
+			Version:       int32(dm.EDNS.Version),
+			DnssecOk:      dm.EDNS.Do == 1,
+			Options:       make([]ParquetOption, 0, len(dm.EDNS.Options)),
+		},
+		DNSTap: ParquetDNSTap{
+			Operation:    dm.DNSTap.Operation,
+			Identity:     dm.DNSTap.Identity,
+			Version:      dm.DNSTap.Version,
+			Timestamp:    int64(dm.DNSTap.TimeSec)*1e9 + int64(dm.DNSTap.TimeNsec),
+			Latency:      dm.DNSTap.Latency,
+			Extra:        dm.DNSTap.Extra,
+			PolicyRule:   dm.DNSTap.PolicyRule,
+			PolicyType:   dm.DNSTap.PolicyType,
+			PolicyMatch:  dm.DNSTap.PolicyMatch,
+			PolicyAction: dm.DNSTap.PolicyAction,
+			PolicyValue:  dm.DNSTap.PolicyValue,
+			PeerName:     dm.DNSTap.PeerName,
+			QueryZone:    dm.DNSTap.QueryZone,
+		},
+	}
+	for _, opt := range dm.EDNS.Options {
+		row.EDNS.Options = append(row.EDNS.Options, ParquetOption{Code: int32(opt.Code), Name: opt.Name, Data: opt.Data})
+	}
+
+	if dm.PowerDNS != nil {
+		row.PowerDNS = &ParquetPowerDNS{
+			Tags:                  dm.PowerDNS.Tags,
+			OriginalRequestSubnet: dm.PowerDNS.OriginalRequestSubnet,
+			AppliedPolicy:         dm.PowerDNS.AppliedPolicy,
+			AppliedPolicyHit:      dm.PowerDNS.AppliedPolicyHit,
+			AppliedPolicyKind:     dm.PowerDNS.AppliedPolicyKind,
+			AppliedPolicyTrigger:  dm.PowerDNS.AppliedPolicyTrigger,
+			AppliedPolicyType:     dm.PowerDNS.AppliedPolicyType,
+			Metadata:              dm.PowerDNS.Metadata,
+			HTTPVersion:           dm.PowerDNS.HTTPVersion,
+			MessageID:             dm.PowerDNS.MessageID,
+			InitialRequestorID:    dm.PowerDNS.InitialRequestorID,
+			RequestorID:           dm.PowerDNS.RequestorID,
+			DeviceName:            dm.PowerDNS.DeviceName,
+			DeviceID:              dm.PowerDNS.DeviceID,
+		}
+	}
+	if dm.OpenTelemetry != nil {
+		row.OpenTelemetry = &ParquetOpenTelemetry{TraceID: dm.OpenTelemetry.TraceID}
+	}
+	if dm.Geo != nil {
+		row.Geo = &ParquetGeo{
+			City:                   dm.Geo.City,
+			Continent:              dm.Geo.Continent,
+			CountryIsoCode:         dm.Geo.CountryIsoCode,
+			AutonomousSystemNumber: dm.Geo.AutonomousSystemNumber,
+			AutonomousSystemOrg:    dm.Geo.AutonomousSystemOrg,
+		}
+	}
+	if dm.Suspicious != nil {
+		row.Suspicious = &ParquetSuspicious{
+			Score:                 dm.Suspicious.Score,
+			MalformedPacket:       dm.Suspicious.MalformedPacket,
+			LargePacket:           dm.Suspicious.LargePacket,
+			LongDomain:            dm.Suspicious.LongDomain,
+			SlowDomain:            dm.Suspicious.SlowDomain,
+			UnallowedChars:        dm.Suspicious.UnallowedChars,
+			UncommonQtypes:        dm.Suspicious.UncommonQtypes,
+			ExcessiveNumberLabels: dm.Suspicious.ExcessiveNumberLabels,
+			Domain:                dm.Suspicious.Domain,
+		}
+	}
+	if dm.Typosquatting != nil {
+		row.Typosquatting = &ParquetTyposquatting{
+			Detected:  dm.Typosquatting.Detected,
+			Brand:     dm.Typosquatting.Brand,
+			Technique: dm.Typosquatting.Technique,
+			Distance:  int32(dm.Typosquatting.Distance),
+		}
+	}
+	if dm.ThreatIntel != nil {
+		row.ThreatIntel = &ParquetThreatIntel{
+			Matched:  dm.ThreatIntel.Matched,
+			Feed:     dm.ThreatIntel.Feed,
+			Category: dm.ThreatIntel.Category,
+			Trigger:  dm.ThreatIntel.Trigger,
+			Action:   dm.ThreatIntel.Action,
+			Value:    dm.ThreatIntel.Value,
+		}
+	}
+	if dm.PublicSuffix != nil {
+		row.PublicSuffix = &ParquetPublicSuffix{
+			QnamePublicSuffix:        dm.PublicSuffix.QnamePublicSuffix,
+			QnameEffectiveTLDPlusOne: dm.PublicSuffix.QnameEffectiveTLDPlusOne,
+			ManagedByICANN:           dm.PublicSuffix.ManagedByICANN,
+		}
+	}
+	if dm.Idna != nil {
+		row.Idna = &ParquetIdna{QnameUnicode: dm.Idna.QnameUnicode, MixedScript: dm.Idna.MixedScript}
+	}
+	if dm.Extracted != nil {
+		row.Extracted = &ParquetExtracted{DNSPayload: dm.Extracted.Base64Payload}
+	}
+	if dm.Reducer != nil {
+		row.Reducer = &ParquetReducer{Occurrences: int32(dm.Reducer.Occurrences), CumulativeLength: int32(dm.Reducer.CumulativeLength)}
+	}
+	if dm.MachineLearning != nil {
+		ml := dm.MachineLearning
+		row.ML = &ParquetML{
+			Entropy:               ml.Entropy,
+			Length:                int32(ml.Length),
+			Labels:                int32(ml.Labels),
+			Digits:                int32(ml.Digits),
+			Lowers:                int32(ml.Lowers),
+			Uppers:                int32(ml.Uppers),
+			Specials:              int32(ml.Specials),
+			Others:                int32(ml.Others),
+			RatioDigits:           ml.RatioDigits,
+			RatioLetters:          ml.RatioLetters,
+			RatioSpecials:         ml.RatioSpecials,
+			RatioOthers:           ml.RatioOthers,
+			ConsecutiveChars:      int32(ml.ConsecutiveChars),
+			ConsecutiveVowels:     int32(ml.ConsecutiveVowels),
+			ConsecutiveDigits:     int32(ml.ConsecutiveDigits),
+			ConsecutiveConsonants: int32(ml.ConsecutiveConsonants),
+			Size:                  int32(ml.Size),
+			Occurrences:           int32(ml.Occurrences),
+			UncommonQtypes:        int32(ml.UncommonQtypes),
+		}
+	}
+	if dm.Filtering != nil {
+		row.Filtering = &ParquetFiltering{SampleRate: int32(dm.Filtering.SampleRate)}
+	}
+	if dm.ATags != nil {
+		row.ATags = &ParquetATags{Tags: dm.ATags.Tags}
+	}
+	if dm.Transaction != nil {
+		row.Transaction = &ParquetTransaction{
+			Status:         dm.Transaction.Status,
+			QueryLength:    int32(dm.Transaction.QueryLength),
+			ReplyLength:    int32(dm.Transaction.ReplyLength),
+			ReplyTimestamp: dm.Transaction.ReplyTimestamp,
+			ReplyFlags:     toParquetFlags(dm.Transaction.ReplyFlags),
+		}
+	}
+	return row
+}
@@ -0,0 +1,67 @@
+package dnsutils
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestDnsMessage_ToParquet(t *testing.T) {
+	dm := GetFakeDNSMessage()
+	dm.NetworkInfo.QueryPort = "53000"
+	dm.DNSTap.TimeSec = 1700000000
+	dm.DNSTap.TimeNsec = 100
+	dm.DNS.DNSRRs.Answers = []DNSAnswer{{Name: "dns.collector", Rdatatype: "A", Class: "IN", TTL: 3600, Rdata: "1.2.3.4"}}
+	dm.EDNS.Do = 1
+
+	row := dm.ToParquet()
+	if row.DNS.Qname != dm.DNS.Qname || row.Network.QueryPort != 53000 {
+		t.Errorf("invalid dns or network section: %+v %+v", row.DNS, row.Network)
+	}
+	if row.DNSTap.Timestamp != 1700000000000000100 {
+		t.Errorf("invalid timestamp: %d", row.DNSTap.Timestamp)
+	}
+	if len(row.DNS.Answers) != 1 || row.DNS.Answers[0].Rdata != "1.2.3.4" || !row.EDNS.DnssecOk {
+		t.Errorf("invalid answers or edns: %+v %+v", row.DNS.Answers, row.EDNS)
+	}
+
+	// transformers sections are null when not populated
+	if row.ATags != nil || row.Geo != nil || row.PowerDNS != nil {
+		t.Errorf("optional sections should be nil")
+	}
+	dm.ATags = &TransformATags{Tags: []string{"tag1"}}
+	if row = dm.ToParquet(); row.ATags == nil || row.ATags.Tags[0] != "tag1" {
+		t.Errorf("invalid atags section: %+v", row.ATags)
+	}
+}
+
+func TestDnsMessage_Parquet_WriteRead(t *testing.T) {
+	dm := GetFakeDNSMessage()
+	dm.InitTransforms()
+	dm.ATags.Tags = []string{"tag1", "tag2"}
+	dmNoTransforms := GetFakeDNSMessage()
+
+	buf := new(bytes.Buffer)
+	pw := parquet.NewGenericWriter[DNSMessageParquet](buf)
+	if _, err := pw.Write([]DNSMessageParquet{dm.ToParquet(), dmNoTransforms.ToParquet()}); err != nil {
+		t.Fatalf("unable to write rows: %v", err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("unable to close writer: %v", err)
+	}
+
+	rows, err := parquet.Read[DNSMessageParquet](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("unable to read rows: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("want 2 rows, got %d", len(rows))
+	}
+	if rows[0].DNS.Qname != dm.DNS.Qname || rows[0].ATags == nil || len(rows[0].ATags.Tags) != 2 {
+		t.Errorf("invalid first row: %+v", rows[0])
+	}
+	if rows[1].ATags != nil {
+		t.Errorf("atags of the second row should be null")
+	}
+}
@@ -16,11 +16,16 @@ var (
 	PdnsDirectives            = regexp.MustCompile(`^powerdns-*`)
 	GeoIPDirectives           = regexp.MustCompile(`^geoip-*`)
 	SuspiciousDirectives      = regexp.MustCompile(`^suspicious-*`)
+	TyposquattingDirectives   = regexp.MustCompile(`^typosquatting-*`)
+	ThreatIntelDirectives     = regexp.MustCompile(`^threatintel-*`)
 	PublicSuffixDirectives    = regexp.MustCompile(`^publixsuffix-*`)
+	IdnaDirectives            = regexp.MustCompile(`^idna-*`)
 	ExtractedDirectives       = regexp.MustCompile(`^extracted-*`)
 	ReducerDirectives         = regexp.MustCompile(`^reducer-*`)
 	MachineLearningDirectives = regexp.MustCompile(`^ml-*`)
 	FilteringDirectives       = regexp.MustCompile(`^filtering-*`)
+	TransactionDirectives     = regexp.MustCompile(`^transaction-*`)
+	EdnsDirectives            = regexp.MustCompile(`^edns-*`)
 	RawTextDirective          = regexp.MustCompile(`^ *\{.*\}`)
 	ATagsDirectives           = regexp.MustCompile(`^atags*`)
 )
@@ -255,6 +260,46 @@ func (dm *DNSMessage) handleSuspiciousDirectives(directive string, s *strings.Bu
 	return nil
 }
 
+func (dm *DNSMessage) handleTyposquattingDirectives(directive string, s *strings.Builder) error {
+	if dm.Typosquatting == nil {
+		s.WriteString("-")
+	} else {
+		switch {
+		case directive == "typosquatting-brand":
+			s.WriteString(dm.Typosquatting.Brand)
+		case directive == "typosquatting-technique":
+			s.WriteString(dm.Typosquatting.Technique)
+		case directive == "typosquatting-distance":
+			s.WriteString(strconv.Itoa(dm.Typosquatting.Distance))
+		default:
+			return errors.New(ErrorUnexpectedDirective + directive)
+		}
+	}
+	return nil
+}
+
+func (dm *DNSMessage) handleThreatIntelDirectives(directive string, s *strings.Builder) error {
+	if dm.ThreatIntel == nil {
+		s.WriteString("-")
+	} else {
+		switch {
+		case directive == "threatintel-feed":
+			s.WriteString(dm.ThreatIntel.Feed)
+		case directive == "threatintel-category":
+			s.WriteString(dm.ThreatIntel.Category)
+		case directive == "threatintel-trigger":
+			s.WriteString(dm.ThreatIntel.Trigger)
+		case directive == "threatintel-action":
+			s.WriteString(dm.ThreatIntel.Action)
+		case directive == "threatintel-value":
+			s.WriteString(dm.ThreatIntel.Value)
+		default:
+			return errors.New(ErrorUnexpectedDirective + directive)
+		}
+	}
+	return nil
+}
+
 func (dm *DNSMessage) handlePublicSuffixDirectives(directive string, s *strings.Builder) error {
 	if dm.PublicSuffix == nil {
 		s.WriteString("-")
@@ -277,6 +322,26 @@ func (dm *DNSMessage) handlePublicSuffixDirectives(directive string, s *strings.
 	return nil
 }
 
+func (dm *DNSMessage) handleIdnaDirectives(directive string, s *strings.Builder) error {
+	if dm.Idna == nil {
+		s.WriteString("-")
+	} else {
+		switch {
+		case directive == "idna-qname-unicode":
+			s.WriteString(dm.Idna.QnameUnicode)
+		case directive == "idna-mixed-script":
+			if dm.Idna.MixedScript {
+				s.WriteString("mixed")
+			} else {
+				s.WriteString("-")
+			}
+		default:
+			return errors.New(ErrorUnexpectedDirective + directive)
+		}
+	}
+	return nil
+}
+
 func (dm *DNSMessage) handleExtractedDirectives(directive string, s *strings.Builder) error {
 	if dm.Extracted == nil {
 		s.WriteString("-")
@@ -327,6 +392,51 @@ func (dm *DNSMessage) handleReducerDirectives(directive string, s *strings.Build
 	return nil
 }
 
+func (dm *DNSMessage) handleEdnsDirectives(directive string, s *strings.Builder) error {
+	switch directive {
+	case "edns-csubnet":
+		if opt, found := dm.EDNS.GetOption("CSUBNET"); found {
+			s.WriteString(opt.Data)
+		} else {
+			s.WriteByte('-')
+		}
+	case "edns-nsid", "edns-cookie-client", "edns-cookie-server", "edns-keepalive", "edns-padding",
+		"edns-expire", "edns-chain", "edns-key-tag", "edns-dau", "edns-dhu", "edns-n3u",
+		"edns-zoneversion", "edns-report-channel":
+		value := "-"
+		for field, data := range EdnsOptionsFields(&dm.EDNS) {
+			if directive == "edns-"+strings.ReplaceAll(field, ".", "-") {
+				value = data
+				break
+			}
+		}
+		s.WriteString(value)
+	default:
+		return errors.New(ErrorUnexpectedDirective + directive)
+	}
+	return nil
+}
+
+func (dm *DNSMessage) handleTransactionDirectives(directive string, s *strings.Builder) error {
+	if dm.Transaction == nil {
+		s.WriteString("-")
+	} else {
+		switch {
+		case directive == "transaction-status":
+			s.WriteString(dm.Transaction.Status)
+		case directive == "transaction-query-length":
+			s.WriteString(strconv.Itoa(dm.Transaction.QueryLength))
+		case directive == "transaction-reply-length":
+			s.WriteString(strconv.Itoa(dm.Transaction.ReplyLength))
+		case directive == "transaction-reply-timestamp":
+			s.WriteString(dm.Transaction.ReplyTimestamp)
+		default:
+			return errors.New(ErrorUnexpectedDirective + directive)
+		}
+	}
+	return nil
+}
+
 func (dm *DNSMessage) handleMachineLearningDirectives(directive string, s *strings.Builder) error {
 	if dm.MachineLearning == nil {
 		s.WriteString("-")
@@ -549,16 +659,10 @@ func (dm *DNSMessage) ToTextLine(format []string, fieldDelimiter string, fieldBo
 		case directive == "arcount":
 			s.WriteString(strconv.Itoa(dm.DNS.ArCount))
 
-		case directive == "edns-csubnet":
-			if len(dm.EDNS.Options) > 0 {
-				for _, opt := range dm.EDNS.Options {
-					if opt.Name == "CSUBNET" {
-						s.WriteString(opt.Data)
-						break
-					}
-				}
-			} else {
-				s.WriteByte('-')
+		case EdnsDirectives.MatchString(directive):
+			err := dm.handleEdnsDirectives(directive, &s)
+			if err != nil {
+				return nil, err
 			}
 
 		// more directives from loggers
@@ -591,11 +695,26 @@ func (dm *DNSMessage) ToTextLine(format []string, fieldDelimiter string, fieldBo
 			if err != nil {
 				return nil, err
 			}
+		case TyposquattingDirectives.MatchString(directive):
+			err := dm.handleTyposquattingDirectives(directive, &s)
+			if err != nil {
+				return nil, err
+			}
+		case ThreatIntelDirectives.MatchString(directive):
+			err := dm.handleThreatIntelDirectives(directive, &s)
+			if err != nil {
+				return nil, err
+			}
 		case PublicSuffixDirectives.MatchString(directive):
 			err := dm.handlePublicSuffixDirectives(directive, &s)
 			if err != nil {
 				return nil, err
 			}
+		case IdnaDirectives.MatchString(directive):
+			err := dm.handleIdnaDirectives(directive, &s)
+			if err != nil {
+				return nil, err
+			}
 		case ExtractedDirectives.MatchString(directive):
 			err := dm.handleExtractedDirectives(directive, &s)
 			if err != nil {
@@ -616,6 +735,11 @@ func (dm *DNSMessage) ToTextLine(format []string, fieldDelimiter string, fieldBo
 			if err != nil {
 				return nil, err
 			}
+		case TransactionDirectives.MatchString(directive):
+			err := dm.handleTransactionDirectives(directive, &s)
+			if err != nil {
+				return nil, err
+			}
 		case RawTextDirective.MatchString(directive):
 			directive = strings.ReplaceAll(directive, "{", "")
 			directive = strings.ReplaceAll(directive, "}", "")
@@ -307,6 +307,11 @@ func TestDnsMessage_TextFormat_InvalidDirectives(t *testing.T) {
 			dm:     DNSMessage{MachineLearning: &TransformML{}},
 			format: "ml-invalid",
 		},
+		{
+			name:   "transaction",
+			dm:     DNSMessage{Transaction: &TransformTransaction{}},
+			format: "transaction-invalid",
+		},
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -676,6 +681,218 @@ func TestDnsMessage_TextFormat_Directives_Reducer(t *testing.T) {
 	}
 }
 
+func TestDnsMessage_TextFormat_Directives_Transaction(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "transaction-status",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "transaction-status transaction-query-length transaction-reply-length",
+			dm:       DNSMessage{Transaction: &TransformTransaction{Status: "ANSWERED", QueryLength: 30, ReplyLength: 46}},
+			expected: "ANSWERED 30 46",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Typosquatting(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "typosquatting-brand",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "typosquatting-brand typosquatting-technique typosquatting-distance",
+			dm:       DNSMessage{Typosquatting: &TransformTyposquatting{Detected: true, Brand: "paypal.com", Technique: "homoglyph", Distance: 1}},
+			expected: "paypal.com homoglyph 1",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_ThreatIntel(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "threatintel-feed",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "threatintel-feed threatintel-category threatintel-trigger threatintel-action threatintel-value",
+			dm:       DNSMessage{ThreatIntel: &TransformThreatIntel{Matched: true, Feed: "rpz", Category: "malware", Trigger: "IP", Action: "DROP", Value: "10.0.0.1"}},
+			expected: "rpz malware IP DROP 10.0.0.1",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Idna(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "idna-qname-unicode",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "idna-qname-unicode idna-mixed-script",
+			dm:       DNSMessage{Idna: &TransformIdna{QnameUnicode: "münchen.de"}},
+			expected: "münchen.de -",
+		},
+		{
+			name:     "mixed",
+			format:   "idna-qname-unicode idna-mixed-script",
+			dm:       DNSMessage{Idna: &TransformIdna{QnameUnicode: "аpple.com", MixedScript: true}},
+			expected: "аpple.com mixed",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Edns(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "edns-csubnet edns-nsid edns-cookie-client edns-cookie-server",
+			dm:       DNSMessage{},
+			expected: "- - - -",
+		},
+		{
+			name:   "default",
+			format: "edns-csubnet edns-nsid edns-cookie-client edns-cookie-server edns-keepalive edns-padding",
+			dm: DNSMessage{EDNS: DNSExtended{Options: []DNSOption{
+				{Code: 8, Name: "CSUBNET", Data: "1.2.3.0/24"},
+				{Code: 3, Name: "NSID", Data: "ns1.lon"},
+				{Code: 10, Name: "COOKIE", Data: "0102030405060708 0102030405060708090a0b0c0d0e0f10"},
+				{Code: 11, Name: "KEEPALIVE", Data: "12000"},
+				{Code: 12, Name: "PADDING", Data: "394"},
+			}}},
+			expected: "1.2.3.0/24 ns1.lon 0102030405060708 0102030405060708090a0b0c0d0e0f10 12000 394",
+		},
+		{
+			name:   "others",
+			format: "edns-expire edns-chain edns-key-tag edns-dau edns-dhu edns-n3u edns-zoneversion edns-report-channel",
+			dm: DNSMessage{EDNS: DNSExtended{Options: []DNSOption{
+				{Code: 9, Name: "EXPIRE", Data: "604800"},
+				{Code: 13, Name: "CHAIN", Data: "."},
+				{Code: 14, Name: "KEY-TAG", Data: "20326"},
+				{Code: 5, Name: "DAU", Data: "8 13"},
+				{Code: 6, Name: "DHU", Data: "2"},
+				{Code: 7, Name: "N3U", Data: "1"},
+				{Code: 19, Name: "ZONEVERSION", Data: "2 0 2024010101"},
+				{Code: 18, Name: "REPORT-CHANNEL", Data: "agent.dnscollector.test"},
+			}}},
+			expected: "604800 . 20326 8 13 2 1 2 0 2024010101 agent.dnscollector.test",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
 func TestDnsMessage_TextFormat_Directives_Extracted(t *testing.T) {
 	config := pkgconfig.GetDefaultConfig()
 
@@ -2,9 +2,12 @@ package dnsutils
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 )
@@ -17,7 +20,8 @@ var ErrDecodeEdnsTooManyOpts = errors.New("edns, packet contained too many OPT R
 
 var (
 	OptCodes = map[int]string{
-		3: "NSID", 8: "CSUBNET", 9: "EXPIRE", 10: "COOKIE", 11: "KEEPALIVE", 12: "PADDING", 15: "ERRORS",
+		3: "NSID", 5: "DAU", 6: "DHU", 7: "N3U", 8: "CSUBNET", 9: "EXPIRE", 10: "COOKIE", 11: "KEEPALIVE", 12: "PADDING",
+		13: "CHAIN", 14: "KEY-TAG", 15: "ERRORS", 18: "REPORT-CHANNEL", 19: "ZONEVERSION",
 	}
 	ErrorCodeToString = map[int]string{
 		0:  "Other",
@@ -48,6 +52,27 @@ var (
 	}
 )
 
+// EdnsOptionsFields returns the decoded values of the EDNS options by field name,
+// the cookie is split in client and server parts
+func EdnsOptionsFields(e *DNSExtended) map[string]string {
+	fields := make(map[string]string)
+	for _, opt := range e.Options {
+		switch opt.Name {
+		case "COOKIE":
+			client, server, found := strings.Cut(opt.Data, " ")
+			if !found {
+				server = "-"
+			}
+			fields["cookie.client"] = client
+			fields["cookie.server"] = server
+		case "NSID", "KEEPALIVE", "PADDING", "EXPIRE", "CHAIN", "KEY-TAG",
+			"DAU", "DHU", "N3U", "ZONEVERSION", "REPORT-CHANNEL":
+			fields[strings.ToLower(opt.Name)] = opt.Data
+		}
+	}
+	return fields
+}
+
 func OptCodeToString(rcode int) string {
 	if value, ok := OptCodes[rcode]; ok {
 		return value
@@ -179,6 +204,26 @@ func ParseOption(optName string, optData []byte) (string, error) {
 		ret, err = ParseErrors(optData)
 	case "CSUBNET":
 		ret, err = ParseCsubnet(optData)
+	case "NSID":
+		ret, err = ParseNsid(optData)
+	case "COOKIE":
+		ret, err = ParseCookie(optData)
+	case "KEEPALIVE":
+		ret, err = ParseKeepalive(optData)
+	case "PADDING":
+		ret, err = ParsePadding(optData)
+	case "EXPIRE":
+		ret, err = ParseExpire(optData)
+	case "CHAIN":
+		ret, err = ParseChain(optData)
+	case "KEY-TAG":
+		ret, err = ParseKeyTag(optData)
+	case "DAU", "DHU", "N3U":
+		ret, err = ParseAlgorithmsList(optData)
+	case "ZONEVERSION":
+		ret, err = ParseZoneVersion(optData)
+	case "REPORT-CHANNEL":
+		ret, err = ParseReportChannel(optData)
 	default:
 		ret = "-"
 		err = nil
@@ -256,3 +301,219 @@ func ParseCsubnet(d []byte) (string, error) {
 		return "-", ErrDecodeEdnsOptionCsubnetBadFamily
 	}
 }
+
+/*
+https://datatracker.ietf.org/doc/html/rfc5001
+
+NSID EDNS0 option format, the identifier is displayed as text
+when printable, otherwise in hexadecimal
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                          NSID                                 /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseNsid(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	for _, c := range d {
+		if c < ' ' || c > '~' {
+			return hex.EncodeToString(d), nil
+		}
+	}
+	return string(d), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7873
+
+Cookie EDNS0 option format, displayed as "<client> <server>" in hexadecimal
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                                                               |
++-+-                      Client Cookie                      -+-+
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                Server Cookie, 8 to 32 bytes                   /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseCookie(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	// malformed cookies are kept as is to help to spot spoofing attempts
+	if len(d) <= 8 {
+		return fmt.Sprintf("%s -", hex.EncodeToString(d)), nil
+	}
+	return fmt.Sprintf("%s %s", hex.EncodeToString(d[:8]), hex.EncodeToString(d[8:])), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7828
+
+Keepalive EDNS0 option format, the timeout in units of 100 milliseconds
+is displayed in milliseconds, the option is empty in queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                           TIMEOUT                             |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseKeepalive(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 2:
+		timeout := int(binary.BigEndian.Uint16(d)) * 100
+		return strconv.Itoa(timeout), nil
+	default:
+		return parseOptionRaw(d), nil
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7830
+
+Padding EDNS0 option format, the length of the padding is displayed
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                      PADDING OCTETS                           /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParsePadding(d []byte) (string, error) {
+	return strconv.Itoa(len(d)), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7314
+
+Expire EDNS0 option format, the option is empty in queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                            EXPIRE                             |
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseExpire(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 4:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(d)), 10), nil
+	default:
+		return parseOptionRaw(d), nil
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7901
+
+Chain EDNS0 option format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                   Closest trust point                         /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseChain(d []byte) (string, error) {
+	return parseOptionDomainName(d)
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc8145
+
+Key tag EDNS0 option format, displayed as a space separated list
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                           KEY-TAG                             |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                             ...                               /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseKeyTag(d []byte) (string, error) {
+	if len(d) < 2 || len(d)%2 != 0 {
+		return parseOptionRaw(d), nil
+	}
+	tags := make([]string, 0, len(d)/2)
+	for i := 0; i < len(d); i += 2 {
+		tags = append(tags, strconv.Itoa(int(binary.BigEndian.Uint16(d[i:i+2]))))
+	}
+	return strings.Join(tags, " "), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc6975
+
+DAU, DHU and N3U EDNS0 options format, displayed as a space separated list
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|    ALG-CODE   |                     ...                       /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseAlgorithmsList(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	algs := make([]string, 0, len(d))
+	for _, alg := range d {
+		algs = append(algs, strconv.Itoa(int(alg)))
+	}
+	return strings.Join(algs, " "), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc9660
+
+Zone version EDNS0 option format, displayed as "<label-count> <type> <version>",
+the SOA serial type (0) is displayed in decimal, otherwise in hexadecimal.
+The option is empty in queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|  LABELCOUNT   |     TYPE      |                               /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                           VERSION                             /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseZoneVersion(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	if len(d) < 2 {
+		return parseOptionRaw(d), nil
+	}
+	labelCount := d[0]
+	versionType := d[1]
+	version := hex.EncodeToString(d[2:])
+	if versionType == 0 {
+		if len(d) != 6 {
+			return parseOptionRaw(d), nil
+		}
+		version = strconv.FormatUint(uint64(binary.BigEndian.Uint32(d[2:6])), 10)
+	}
+	return fmt.Sprintf("%d %d %s", labelCount, versionType, version), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc9567
+
+Report channel EDNS0 option format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                      AGENT DOMAIN                             /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseReportChannel(d []byte) (string, error) {
+	return parseOptionDomainName(d)
+}
+
+// parseOptionDomainName decodes an uncompressed domain name, the root is displayed as "."
+func parseOptionDomainName(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	name, offset, err := ParseLabels(0, d)
+	if err != nil || offset != len(d) {
+		return parseOptionRaw(d), nil
+	}
+	if name == "" {
+		name = "."
+	}
+	return name, nil
+}
+
+// parseOptionRaw displays an option with an unexpected length in hexadecimal,
+// a malformed option must not mark the whole packet as malformed
+func parseOptionRaw(d []byte) string {
+	if len(d) == 0 {
+		return "-"
+	}
+	return hex.EncodeToString(d)
+}
@@ -0,0 +1,216 @@
+package dnsutils
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDecodeEdns_Options(t *testing.T) {
+	testcases := []struct {
+		name   string
+		option dns.EDNS0
+		want   DNSOption
+	}{
+		{
+			name:   "NSID",
+			option: &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e73312e6c6f6e"},
+			want:   DNSOption{Code: 3, Name: "NSID", Data: "ns1.lon"},
+		},
+		{
+			name:   "NSID_Binary",
+			option: &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "00ff"},
+			want:   DNSOption{Code: 3, Name: "NSID", Data: "00ff"},
+		},
+		{
+			name:   "NSID_Query",
+			option: &dns.EDNS0_NSID{Code: dns.EDNS0NSID},
+			want:   DNSOption{Code: 3, Name: "NSID", Data: "-"},
+		},
+		{
+			name:   "COOKIE_Client",
+			option: &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+			want:   DNSOption{Code: 10, Name: "COOKIE", Data: "0102030405060708 -"},
+		},
+		{
+			name:   "COOKIE_Server",
+			option: &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708a1a2a3a4a5a6a7a8b1b2b3b4b5b6b7b8"},
+			want:   DNSOption{Code: 10, Name: "COOKIE", Data: "0102030405060708 a1a2a3a4a5a6a7a8b1b2b3b4b5b6b7b8"},
+		},
+		{
+			name:   "KEEPALIVE",
+			option: &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: 120},
+			want:   DNSOption{Code: 11, Name: "KEEPALIVE", Data: "12000"},
+		},
+		{
+			name:   "KEEPALIVE_Query",
+			option: &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE},
+			want:   DNSOption{Code: 11, Name: "KEEPALIVE", Data: "-"},
+		},
+		{
+			name:   "PADDING",
+			option: &dns.EDNS0_PADDING{Padding: make([]byte, 394)},
+			want:   DNSOption{Code: 12, Name: "PADDING", Data: "394"},
+		},
+		{
+			name:   "EXPIRE",
+			option: &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Expire: 604800},
+			want:   DNSOption{Code: 9, Name: "EXPIRE", Data: "604800"},
+		},
+		{
+			name:   "EXPIRE_Query",
+			option: &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Empty: true},
+			want:   DNSOption{Code: 9, Name: "EXPIRE", Data: "-"},
+		},
+		{
+			name:   "CHAIN",
+			option: &dns.EDNS0_LOCAL{Code: 13, Data: []byte{0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x00}},
+			want:   DNSOption{Code: 13, Name: "CHAIN", Data: "example"},
+		},
+		{
+			name:   "CHAIN_Root",
+			option: &dns.EDNS0_LOCAL{Code: 13, Data: []byte{0x00}},
+			want:   DNSOption{Code: 13, Name: "CHAIN", Data: "."},
+		},
+		{
+			name:   "KEY-TAG",
+			option: &dns.EDNS0_LOCAL{Code: 14, Data: []byte{0x4f, 0x66, 0x95, 0x0f}},
+			want:   DNSOption{Code: 14, Name: "KEY-TAG", Data: "20326 38159"},
+		},
+		{
+			name:   "DAU",
+			option: &dns.EDNS0_DAU{Code: dns.EDNS0DAU, AlgCode: []uint8{8, 13, 15}},
+			want:   DNSOption{Code: 5, Name: "DAU", Data: "8 13 15"},
+		},
+		{
+			name:   "DHU",
+			option: &dns.EDNS0_DHU{Code: dns.EDNS0DHU, AlgCode: []uint8{2}},
+			want:   DNSOption{Code: 6, Name: "DHU", Data: "2"},
+		},
+		{
+			name:   "N3U",
+			option: &dns.EDNS0_N3U{Code: dns.EDNS0N3U, AlgCode: []uint8{1}},
+			want:   DNSOption{Code: 7, Name: "N3U", Data: "1"},
+		},
+		{
+			name:   "ZONEVERSION",
+			option: &dns.EDNS0_LOCAL{Code: 19, Data: []byte{0x02, 0x00, 0x78, 0x49, 0x7d, 0x2c}},
+			want:   DNSOption{Code: 19, Name: "ZONEVERSION", Data: "2 0 2018082092"},
+		},
+		{
+			name:   "ZONEVERSION_Query",
+			option: &dns.EDNS0_LOCAL{Code: 19, Data: []byte{}},
+			want:   DNSOption{Code: 19, Name: "ZONEVERSION", Data: "-"},
+		},
+		{
+			name:   "REPORT-CHANNEL",
+			option: &dns.EDNS0_LOCAL{Code: 18, Data: []byte{0x05, 'a', 'g', 'e', 'n', 't', 0x04, 't', 'e', 's', 't', 0x00}},
+			want:   DNSOption{Code: 18, Name: "REPORT-CHANNEL", Data: "agent.test"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion("dnscollector.test.", dns.TypeA)
+
+			e := &dns.OPT{}
+			e.Hdr.Name = "."
+			e.Hdr.Rrtype = dns.TypeOPT
+			e.SetUDPSize(1232)
+			e.Option = append(e.Option, tc.option)
+			dm.Extra = append(dm.Extra, e)
+
+			payload, err := dm.Pack()
+			if err != nil {
+				t.Fatalf("unable to pack message: %v", err)
+			}
+
+			_, _, _, offset, _ := DecodeQuestion(1, payload)
+			edns, _, err := DecodeEDNS(len(dm.Extra), offset, payload)
+			if err != nil {
+				t.Fatalf("unexpected error while decoding edns: %v", err)
+			}
+			if len(edns.Options) != 1 {
+				t.Fatalf("expected one edns option to be parsed, got %d", len(edns.Options))
+			}
+			if edns.Options[0] != tc.want {
+				t.Errorf("bad edns option, expected %v, got %v", tc.want, edns.Options[0])
+			}
+		})
+	}
+}
+
+func TestDecodeEdns_Options_Invalid(t *testing.T) {
+	testcases := []struct {
+		name  string
+		parse func([]byte) (string, error)
+		data  []byte
+		want  string
+	}{
+		{name: "COOKIE", parse: ParseCookie, data: []byte{}, want: "-"},
+		{name: "KEEPALIVE", parse: ParseKeepalive, data: []byte{0x00}, want: "00"},
+		{name: "EXPIRE", parse: ParseExpire, data: []byte{0x00, 0x01}, want: "0001"},
+		{name: "CHAIN", parse: ParseChain, data: []byte{}, want: "-"},
+		{name: "CHAIN_Trailing", parse: ParseChain, data: []byte{0x00, 0x01}, want: "0001"},
+		{name: "CHAIN_BadLabel", parse: ParseChain, data: []byte{0x05, 'a'}, want: "0561"},
+		{name: "KEY-TAG", parse: ParseKeyTag, data: []byte{0x4f, 0x66, 0x95}, want: "4f6695"},
+		{name: "ZONEVERSION", parse: ParseZoneVersion, data: []byte{0x02}, want: "02"},
+		{name: "ZONEVERSION_Serial", parse: ParseZoneVersion, data: []byte{0x02, 0x00, 0x78}, want: "020078"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := tc.parse(tc.data)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if data != tc.want {
+				t.Errorf("bad data, expected %s, got %s", tc.want, data)
+			}
+		})
+	}
+}
+
+func TestDecodeEdns_Options_InvalidNotMalformed(t *testing.T) {
+	dm := new(dns.Msg)
+	dm.SetQuestion("dnscollector.test.", dns.TypeA)
+
+	e := &dns.OPT{}
+	e.Hdr.Name = "."
+	e.Hdr.Rrtype = dns.TypeOPT
+	e.SetUDPSize(1232)
+	e.Option = append(e.Option, &dns.EDNS0_LOCAL{Code: 11, Data: []byte{0x00}}, &dns.EDNS0_LOCAL{Code: 3, Data: []byte("ns1")})
+	dm.Extra = append(dm.Extra, e)
+
+	payload, err := dm.Pack()
+	if err != nil {
+		t.Fatalf("unable to pack message: %v", err)
+	}
+
+	// the other options are still decoded
+	_, _, _, offset, _ := DecodeQuestion(1, payload)
+	edns, _, err := DecodeEDNS(len(dm.Extra), offset, payload)
+	if err != nil {
+		t.Fatalf("unexpected error while decoding edns: %v", err)
+	}
+	if len(edns.Options) != 2 || edns.Options[0].Data != "00" || edns.Options[1].Data != "ns1" {
+		t.Errorf("bad edns options: %v", edns.Options)
+	}
+}
+
+func TestEdnsOptionsFields(t *testing.T) {
+	edns := DNSExtended{Options: []DNSOption{
+		{Code: 3, Name: "NSID", Data: "ns1.lon"},
+		{Code: 10, Name: "COOKIE", Data: "0102030405060708 -"},
+		{Code: 8, Name: "CSUBNET", Data: "1.2.3.0/24"},
+	}}
+
+	fields := EdnsOptionsFields(&edns)
+	if len(fields) != 3 {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if fields["nsid"] != "ns1.lon" || fields["cookie.client"] != "0102030405060708" || fields["cookie.server"] != "-" {
+		t.Errorf("invalid fields: %v", fields)
+	}
+}
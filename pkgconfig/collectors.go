@@ -8,12 +8,9 @@ import (
 
 type ConfigCollectors struct {
 	DNSMessage struct {
-		Enable            bool `yaml:"enable" default:"false"`
-		ChannelBufferSize int  `yaml:"chan-buffer-size" default:"0"`
-		Matching          struct {
-			Include map[string]interface{} `yaml:"include"`
-			Exclude map[string]interface{} `yaml:"exclude"`
-		} `yaml:"matching"`
+		Enable            bool           `yaml:"enable" default:"false"`
+		ChannelBufferSize int            `yaml:"chan-buffer-size" default:"0"`
+		Matching          ConfigMatching `yaml:"matching"`
 	} `yaml:"dnsmessage"`
 	Tail struct {
 		Enable            bool   `yaml:"enable" default:"false"`
@@ -24,6 +24,8 @@ const (
 	ModeFlatJSON = "flat-json"
 	ModePCAP     = "pcap"
 	ModeDNSTap   = "dnstap"
+	ModeCDNS     = "cdns"
+	ModeParquet  = "parquet"
 
 	SASLMechanismPlain = "PLAIN"
 	SASLMechanismScram = "SCRAM-SHA-512"
@@ -33,6 +35,19 @@ const (
 	CompressLz4    = "lz4"
 	CompressZstd   = "ztd"
 	CompressNone   = "none"
+
+	ParquetCompressZstd = "zstd"
+
+	BackpressureDrop             = "drop"
+	BackpressureBlock            = "block"
+	BackpressureBlockWithTimeout = "block-with-timeout"
+
+	MatchModeFirst = "first-match"
+	MatchModeAll   = "all-matches"
+
+	LoadBalanceRoundRobin     = "round-robin"
+	LoadBalanceLeastLoaded    = "least-loaded"
+	LoadBalanceConsistentHash = "consistent-hash"
 )
 
 var (
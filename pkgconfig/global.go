@@ -23,6 +23,7 @@ type ConfigGlobal struct {
 	Worker         struct {
 		InternalMonitor   int `yaml:"interval-monitor" default:"10"`
 		ChannelBufferSize int `yaml:"buffer-size" default:"8192"`
+		DrainTimeout      int `yaml:"drain-timeout" default:"30"`
 	} `yaml:"worker"`
 	Telemetry struct {
 		Enabled         bool   `yaml:"enabled" default:"false"`
@@ -36,6 +37,8 @@ type ConfigGlobal struct {
 		BasicAuthEnable bool   `yaml:"basic-auth-enable" default:"false"`
 		BasicAuthLogin  string `yaml:"basic-auth-login" default:"admin"`
 		BasicAuthPwd    string `yaml:"basic-auth-pwd" default:"changeme"`
+		AdminAPI        bool   `yaml:"admin-api" default:"false"`
+		AdminWebPath    string `yaml:"admin-web-path" default:"/api/v1"`
 	} `yaml:"telemetry"`
 }
 
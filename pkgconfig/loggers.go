@@ -7,6 +7,30 @@ import (
 	"github.com/prometheus/prometheus/model/relabel"
 )
 
+// disk-backed spool, used by the loggers to persist the messages while the remote is unavailable
+type ConfigSpool struct {
+	Enable         bool   `yaml:"enable" default:"false"`
+	Directory      string `yaml:"directory" default:"/var/lib/dnscollector/spool"`
+	MaxSegmentSize int    `yaml:"max-segment-size" default:"10485760"`
+	MaxSize        int    `yaml:"max-size" default:"1073741824"`
+	MaxAge         int    `yaml:"max-age" default:"86400"`
+	RetryInterval  int    `yaml:"retry-interval" default:"10"`
+}
+
+// column of the clickhouse table, filled with the value of a flat json field of the dns message
+type ConfigClickhouseColumn struct {
+	Name  string `yaml:"name"`
+	Field string `yaml:"field"`
+	Type  string `yaml:"type"`
+}
+
+// column of the postgresql table, filled with the value of a flat json field of the dns message
+type ConfigPostgreSQLColumn struct {
+	Name  string `yaml:"name"`
+	Field string `yaml:"field"`
+	Type  string `yaml:"type"`
+}
+
 type ConfigLoggers struct {
 	DevNull struct {
 		Enable            bool `yaml:"enable" default:"false"`
@@ -69,20 +93,23 @@ type ConfigLoggers struct {
 		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
 	} `yaml:"restapi"`
 	LogFile struct {
-		Enable            bool   `yaml:"enable" default:"false"`
-		FilePath          string `yaml:"file-path" default:""`
-		MaxSize           int    `yaml:"max-size" default:"100"`
-		MaxFiles          int    `yaml:"max-files" default:"10"`
-		MaxBatchSize      int    `yaml:"max-batch-size" default:"65536"`
-		FlushInterval     int    `yaml:"flush-interval" default:"1"`
-		Compress          bool   `yaml:"compress" default:"false"`
-		Mode              string `yaml:"mode" default:"text"`
-		PostRotateCommand string `yaml:"postrotate-command" default:""`
-		PostRotateDelete  bool   `yaml:"postrotate-delete-success" default:"false"`
-		TextFormat        string `yaml:"text-format" default:""`
-		JinjaFormat       string `yaml:"jinja-format" default:""`
-		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
-		ExtendedSupport   bool   `yaml:"extended-support" default:"false"`
+		Enable              bool   `yaml:"enable" default:"false"`
+		FilePath            string `yaml:"file-path" default:""`
+		MaxSize             int    `yaml:"max-size" default:"100"`
+		MaxFiles            int    `yaml:"max-files" default:"10"`
+		MaxBatchSize        int    `yaml:"max-batch-size" default:"65536"`
+		FlushInterval       int    `yaml:"flush-interval" default:"1"`
+		Compress            bool   `yaml:"compress" default:"false"`
+		Mode                string `yaml:"mode" default:"text"`
+		PostRotateCommand   string `yaml:"postrotate-command" default:""`
+		PostRotateDelete    bool   `yaml:"postrotate-delete-success" default:"false"`
+		TextFormat          string `yaml:"text-format" default:""`
+		JinjaFormat         string `yaml:"jinja-format" default:""`
+		ChannelBufferSize   int    `yaml:"chan-buffer-size" default:"0"`
+		ExtendedSupport     bool   `yaml:"extended-support" default:"false"`
+		CdnsMaxBlockItems   int    `yaml:"cdns-max-block-items" default:"5000"`
+		ParquetCompression  string `yaml:"parquet-compression" default:"snappy"`
+		ParquetRowGroupSize int    `yaml:"parquet-row-group-size" default:"100000"`
 	} `yaml:"logfile"`
 	DNSTap struct {
 		Enable            bool   `yaml:"enable" default:"false"`
@@ -205,6 +232,7 @@ type ConfigLoggers struct {
 		TenantID          string            `yaml:"tenant-id" default:""`
 		RelabelConfigs    []*relabel.Config `yaml:"relabel-configs" default:"[]"`
 		ChannelBufferSize int               `yaml:"chan-buffer-size" default:"0"`
+		Spool             ConfigSpool       `yaml:"spool"`
 	} `yaml:"lokiclient"`
 	Statsd struct {
 		Enable            bool   `yaml:"enable" default:"false"`
@@ -223,24 +251,43 @@ type ConfigLoggers struct {
 		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
 	} `yaml:"statsd"`
 	ElasticSearchClient struct {
-		Enable            bool   `yaml:"enable" default:"false"`
-		Index             string `yaml:"index" default:"dnscollector"`
-		Server            string `yaml:"server" default:"http://127.0.0.1:9200/"`
-		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
-		BulkSize          int    `yaml:"bulk-size" default:"5242880"`
-		BulkChannelSize   int    `yaml:"bulk-channel-size" default:"10"`
-		FlushInterval     int    `yaml:"flush-interval" default:"10"`
-		Compression       string `yaml:"compression" default:"none"`
-		BasicAuthEnabled  bool   `yaml:"basic-auth-enable" default:"false"`
-		BasicAuthLogin    string `yaml:"basic-auth-login" default:""`
-		BasicAuthPwd      string `yaml:"basic-auth-pwd" default:""`
+		Enable            bool        `yaml:"enable" default:"false"`
+		Index             string      `yaml:"index" default:"dnscollector"`
+		Server            string      `yaml:"server" default:"http://127.0.0.1:9200/"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size" default:"0"`
+		BulkSize          int         `yaml:"bulk-size" default:"5242880"`
+		BulkChannelSize   int         `yaml:"bulk-channel-size" default:"10"`
+		FlushInterval     int         `yaml:"flush-interval" default:"10"`
+		Compression       string      `yaml:"compression" default:"none"`
+		BasicAuthEnabled  bool        `yaml:"basic-auth-enable" default:"false"`
+		BasicAuthLogin    string      `yaml:"basic-auth-login" default:""`
+		BasicAuthPwd      string      `yaml:"basic-auth-pwd" default:""`
+		Spool             ConfigSpool `yaml:"spool"`
 	} `yaml:"elasticsearch"`
 	OpenTelemetryClient struct {
-		Enable               bool   `yaml:"enable" default:"false"`
-		ChannelBufferSize    int    `yaml:"chan-buffer-size" default:"0"`
-		CleanupSpansInterval int    `yaml:"cleanup-spans-interval" default:"30"`
-		MaxSpanTime          int    `yaml:"max-span-time" default:"120"`
-		OtelEndpoint         string `yaml:"otel-endpoint" default:""`
+		Enable               bool              `yaml:"enable" default:"false"`
+		ChannelBufferSize    int               `yaml:"chan-buffer-size" default:"0"`
+		CleanupSpansInterval int               `yaml:"cleanup-spans-interval" default:"30"`
+		MaxSpanTime          int               `yaml:"max-span-time" default:"120"`
+		OtelEndpoint         string            `yaml:"otel-endpoint" default:""`
+		TracesEnabled        bool              `yaml:"traces-enabled" default:"true"`
+		LogsEnabled          bool              `yaml:"logs-enabled" default:"false"`
+		MetricsEnabled       bool              `yaml:"metrics-enabled" default:"false"`
+		Protocol             string            `yaml:"protocol" default:"grpc"`
+		Headers              map[string]string `yaml:"headers"`
+		BatchSize            int               `yaml:"batch-size" default:"512"`
+		FlushInterval        int               `yaml:"flush-interval" default:"5"`
+		MetricsInterval      int               `yaml:"metrics-interval" default:"60"`
+		MetricsPrefix        string            `yaml:"metrics-prefix" default:"dnscollector"`
+		ExportTimeout        int               `yaml:"export-timeout" default:"10"`
+		MaxRetries           int               `yaml:"max-retries" default:"3"`
+		TextFormat           string            `yaml:"text-format" default:""`
+		TLSSupport           bool              `yaml:"tls-support" default:"false"`
+		TLSInsecure          bool              `yaml:"tls-insecure" default:"false"`
+		TLSMinVersion        string            `yaml:"tls-min-version" default:"1.2"`
+		CAFile               string            `yaml:"ca-file" default:""`
+		CertFile             string            `yaml:"cert-file" default:""`
+		KeyFile              string            `yaml:"key-file" default:""`
 	} `yaml:"opentelemetry"`
 	ScalyrClient struct {
 		Enable            bool                   `yaml:"enable" default:"false"`
@@ -283,29 +330,30 @@ type ConfigLoggers struct {
 		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
 	} `yaml:"redispub"`
 	KafkaProducer struct {
-		Enable            bool   `yaml:"enable" default:"false"`
-		RemoteAddress     string `yaml:"remote-address" default:"127.0.0.1"`
-		RemotePort        int    `yaml:"remote-port" default:"9092"`
-		RetryInterval     int    `yaml:"retry-interval" default:"10"`
-		TLSSupport        bool   `yaml:"tls-support" default:"false"`
-		TLSInsecure       bool   `yaml:"tls-insecure" default:"false"`
-		TLSMinVersion     string `yaml:"tls-min-version" default:"1.2"`
-		CAFile            string `yaml:"ca-file" default:""`
-		CertFile          string `yaml:"cert-file" default:""`
-		KeyFile           string `yaml:"key-file" default:""`
-		SaslSupport       bool   `yaml:"sasl-support" default:"false"`
-		SaslUsername      string `yaml:"sasl-username" default:""`
-		SaslPassword      string `yaml:"sasl-password" default:""`
-		SaslMechanism     string `yaml:"sasl-mechanism" default:"PLAIN"`
-		Mode              string `yaml:"mode" default:"flat-json"`
-		TextFormat        string `yaml:"text-format" default:""`
-		BufferSize        int    `yaml:"buffer-size" default:"100"`
-		FlushInterval     int    `yaml:"flush-interval" default:"10"`
-		ConnectTimeout    int    `yaml:"connect-timeout" default:"5"`
-		Topic             string `yaml:"topic" default:"dnscollector"`
-		Partition         *int   `yaml:"partition" default:"nil"`
-		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
-		Compression       string `yaml:"compression" default:"none"`
+		Enable            bool        `yaml:"enable" default:"false"`
+		RemoteAddress     string      `yaml:"remote-address" default:"127.0.0.1"`
+		RemotePort        int         `yaml:"remote-port" default:"9092"`
+		RetryInterval     int         `yaml:"retry-interval" default:"10"`
+		TLSSupport        bool        `yaml:"tls-support" default:"false"`
+		TLSInsecure       bool        `yaml:"tls-insecure" default:"false"`
+		TLSMinVersion     string      `yaml:"tls-min-version" default:"1.2"`
+		CAFile            string      `yaml:"ca-file" default:""`
+		CertFile          string      `yaml:"cert-file" default:""`
+		KeyFile           string      `yaml:"key-file" default:""`
+		SaslSupport       bool        `yaml:"sasl-support" default:"false"`
+		SaslUsername      string      `yaml:"sasl-username" default:""`
+		SaslPassword      string      `yaml:"sasl-password" default:""`
+		SaslMechanism     string      `yaml:"sasl-mechanism" default:"PLAIN"`
+		Mode              string      `yaml:"mode" default:"flat-json"`
+		TextFormat        string      `yaml:"text-format" default:""`
+		BufferSize        int         `yaml:"buffer-size" default:"100"`
+		FlushInterval     int         `yaml:"flush-interval" default:"10"`
+		ConnectTimeout    int         `yaml:"connect-timeout" default:"5"`
+		Topic             string      `yaml:"topic" default:"dnscollector"`
+		Partition         *int        `yaml:"partition" default:"nil"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size" default:"0"`
+		Compression       string      `yaml:"compression" default:"none"`
+		Spool             ConfigSpool `yaml:"spool"`
 	} `yaml:"kafkaproducer"`
 	FalcoClient struct {
 		Enable            bool   `yaml:"enable" default:"false"`
@@ -313,14 +361,66 @@ type ConfigLoggers struct {
 		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
 	} `yaml:"falco"`
 	ClickhouseClient struct {
+		Enable            bool                     `yaml:"enable" default:"false"`
+		URL               string                   `yaml:"url" default:"http://localhost:8123"`
+		User              string                   `yaml:"user" default:"default"`
+		Password          string                   `yaml:"password" default:"password"`
+		Database          string                   `yaml:"database" default:"dnscollector"`
+		Table             string                   `yaml:"table" default:"records"`
+		Columns           []ConfigClickhouseColumn `yaml:"columns,flow"`
+		CreateTable       bool                     `yaml:"create-table" default:"false"`
+		TableEngine       string                   `yaml:"table-engine" default:"MergeTree ORDER BY tuple()"`
+		BatchSize         int                      `yaml:"batch-size" default:"10000"`
+		FlushInterval     int                      `yaml:"flush-interval" default:"10"`
+		MaxRetries        int                      `yaml:"max-retries" default:"5"`
+		ChannelBufferSize int                      `yaml:"chan-buffer-size" default:"0"`
+	} `yaml:"clickhouse"`
+	S3Client struct {
 		Enable            bool   `yaml:"enable" default:"false"`
-		URL               string `yaml:"url" default:"http://localhost:8123"`
-		User              string `yaml:"user" default:"default"`
-		Password          string `yaml:"password" default:"password"`
-		Database          string `yaml:"database" default:"dnscollector"`
-		Table             string `yaml:"table" default:"records"`
+		Endpoint          string `yaml:"endpoint" default:"s3.amazonaws.com"`
+		Region            string `yaml:"region" default:"us-east-1"`
+		Bucket            string `yaml:"bucket" default:"dnscollector"`
+		AccessKey         string `yaml:"access-key" default:""`
+		SecretKey         string `yaml:"secret-key" default:""`
+		PathStyle         bool   `yaml:"path-style" default:"false"`
+		KeyTemplate       string `yaml:"key-template" default:"{identity}/{yyyy}/{mm}/{dd}/{hh}/{uuid}{ext}"`
+		Mode              string `yaml:"mode" default:"json"`
+		ExtendedSupport   bool   `yaml:"extended-support" default:"false"`
+		Compression       string `yaml:"compression" default:"gzip"`
+		MaxSize           int    `yaml:"max-size" default:"100"`
+		FlushInterval     int    `yaml:"flush-interval" default:"300"`
+		PartSize          int    `yaml:"part-size" default:"16"`
+		UploadQueueSize   int    `yaml:"upload-queue-size" default:"4"`
+		MaxRetries        int    `yaml:"max-retries" default:"10"`
+		TLSSupport        bool   `yaml:"tls-support" default:"true"`
+		TLSInsecure       bool   `yaml:"tls-insecure" default:"false"`
+		TLSMinVersion     string `yaml:"tls-min-version" default:"1.2"`
+		CAFile            string `yaml:"ca-file" default:""`
 		ChannelBufferSize int    `yaml:"chan-buffer-size" default:"0"`
-	} `yaml:"clickhouse"`
+	} `yaml:"s3"`
+	PostgreSQL struct {
+		Enable            bool                     `yaml:"enable" default:"false"`
+		URL               string                   `yaml:"url" default:"postgres://127.0.0.1:5432/dnscollector"`
+		User              string                   `yaml:"user" default:"postgres"`
+		Password          string                   `yaml:"password" default:""`
+		Table             string                   `yaml:"table" default:"dns_messages"`
+		Columns           []ConfigPostgreSQLColumn `yaml:"columns,flow"`
+		ExtraColumn       string                   `yaml:"extra-column" default:"extra"`
+		CreateTable       bool                     `yaml:"create-table" default:"false"`
+		HypertableColumn  string                   `yaml:"hypertable-column" default:""`
+		BufferSize        int                      `yaml:"buffer-size" default:"1000"`
+		FlushInterval     int                      `yaml:"flush-interval" default:"10"`
+		RetryInterval     int                      `yaml:"retry-interval" default:"10"`
+		ConnectTimeout    int                      `yaml:"connect-timeout" default:"5"`
+		MaxConns          int                      `yaml:"max-conns" default:"4"`
+		TLSSupport        bool                     `yaml:"tls-support" default:"false"`
+		TLSInsecure       bool                     `yaml:"tls-insecure" default:"false"`
+		TLSMinVersion     string                   `yaml:"tls-min-version" default:"1.2"`
+		CAFile            string                   `yaml:"ca-file" default:""`
+		CertFile          string                   `yaml:"cert-file" default:""`
+		KeyFile           string                   `yaml:"key-file" default:""`
+		ChannelBufferSize int                      `yaml:"chan-buffer-size" default:"0"`
+	} `yaml:"postgresql"`
 }
 
 func (c *ConfigLoggers) SetDefault() {
@@ -2,6 +2,7 @@ package pkgconfig
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -60,16 +61,120 @@ func (c *ConfigPipelines) IsValid(userCfg map[string]interface{}) error {
 	return nil
 }
 
+type ConfigMatching struct {
+	Include map[string]interface{} `yaml:"include"`
+	Exclude map[string]interface{} `yaml:"exclude"`
+}
+
+type PipelinesMatchRoute struct {
+	Targets  []string       `yaml:"targets,flow"`
+	Matching ConfigMatching `yaml:"matching"`
+}
+
 type PipelinesRouting struct {
-	Forward []string `yaml:"forward,flow"`
-	Dropped []string `yaml:"dropped,flow"`
+	Forward             []string              `yaml:"forward,flow"`
+	Dropped             []string              `yaml:"dropped,flow"`
+	Backpressure        string                `yaml:"backpressure"`
+	BackpressureTimeout int                   `yaml:"backpressure-timeout"`
+	MatchMode           string                `yaml:"match-mode"`
+	Routes              []PipelinesMatchRoute `yaml:"routes"`
+	LoadBalance         string                `yaml:"load-balance"`
+	HashKey             string                `yaml:"hash-key"`
+}
+
+// GetHashKey returns the field used by the consistent hashing, the query ip by default
+func (c *PipelinesRouting) GetHashKey() string {
+	if len(c.HashKey) == 0 {
+		return "network.query-ip"
+	}
+	return c.HashKey
+}
+
+// GetMatchMode returns how the conditional routes are evaluated, first-match by default
+func (c *PipelinesRouting) GetMatchMode() string {
+	if len(c.MatchMode) == 0 {
+		return MatchModeFirst
+	}
+	return c.MatchMode
+}
+
+// GetBackpressure returns the policy applied when the next stanza is busy, drop by default
+func (c *PipelinesRouting) GetBackpressure() string {
+	if len(c.Backpressure) == 0 {
+		return BackpressureDrop
+	}
+	return c.Backpressure
+}
+
+// GetBackpressureTimeout returns the maximum time to wait for the next stanza, 1s by default
+func (c *PipelinesRouting) GetBackpressureTimeout() time.Duration {
+	if c.BackpressureTimeout <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.BackpressureTimeout) * time.Millisecond
 }
 
 func (c *PipelinesRouting) IsValid(userCfg map[string]interface{}) error {
-	for k := range userCfg {
-		if k != "forward" && k != "dropped" {
+	for k, v := range userCfg {
+		switch k {
+		case "forward", "dropped", "backpressure-timeout", "hash-key":
+		case "load-balance":
+			switch v {
+			case LoadBalanceRoundRobin, LoadBalanceLeastLoaded, LoadBalanceConsistentHash:
+			default:
+				return fmt.Errorf("invalid load-balance '%v'", v)
+			}
+		case "match-mode":
+			if v != MatchModeFirst && v != MatchModeAll {
+				return fmt.Errorf("invalid match-mode '%v'", v)
+			}
+		case "routes":
+			if err := c.isValidRoutes(v); err != nil {
+				return err
+			}
+		case "backpressure":
+			switch v {
+			case BackpressureDrop, BackpressureBlock, BackpressureBlockWithTimeout:
+			default:
+				return fmt.Errorf("invalid backpressure '%v'", v)
+			}
+		default:
 			return fmt.Errorf("invalid key '%s'", k)
 		}
 	}
 	return nil
 }
+
+func (c *PipelinesRouting) isValidRoutes(routes interface{}) error {
+	items, ok := routes.([]interface{})
+	if !ok {
+		return fmt.Errorf("routes - list expected")
+	}
+	for _, item := range items {
+		route, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("routes - invalid route '%v'", item)
+		}
+		if _, ok := route["targets"]; !ok {
+			return fmt.Errorf("routes - targets key is required")
+		}
+		for k, v := range route {
+			switch k {
+			case "targets":
+			case "matching":
+				matching, ok := v.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("routes - invalid matching '%v'", v)
+				}
+				for m := range matching {
+					if m != "include" && m != "exclude" {
+						return fmt.Errorf("routes - invalid matching key '%s'", m)
+					}
+				}
+			default:
+				return fmt.Errorf("routes - invalid key '%s'", k)
+			}
+		}
+	}
+	return nil
+}
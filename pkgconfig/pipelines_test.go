@@ -38,6 +38,79 @@ func TestConfigPipelines_IsValid(t *testing.T) {
 			expectErr: true,
 			errorMsg:  "routing-policy - invalid key 'invalid'",
 		},
+		{
+			name: "Valid Backpressure Policy",
+			config: map[string]interface{}{
+				"name":           "testPipeline",
+				"routing-policy": map[string]interface{}{"forward": []string{"route1"}, "backpressure": "block-with-timeout", "backpressure-timeout": 500},
+			},
+			expectErr: false,
+		},
+		{
+			name: "Invalid Backpressure Policy",
+			config: map[string]interface{}{
+				"name":           "testPipeline",
+				"routing-policy": map[string]interface{}{"forward": []string{"route1"}, "backpressure": "wait"},
+			},
+			expectErr: true,
+			errorMsg:  "routing-policy - invalid backpressure 'wait'",
+		},
+		{
+			name: "Valid Conditional Routes",
+			config: map[string]interface{}{
+				"name": "testPipeline",
+				"routing-policy": map[string]interface{}{
+					"match-mode": "all-matches",
+					"routes": []interface{}{
+						map[string]interface{}{
+							"targets":  []interface{}{"route1"},
+							"matching": map[string]interface{}{"include": map[string]interface{}{"dns.rcode": "NXDOMAIN"}},
+						},
+						map[string]interface{}{"targets": []interface{}{"route2"}},
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "Invalid Conditional Route Key",
+			config: map[string]interface{}{
+				"name": "testPipeline",
+				"routing-policy": map[string]interface{}{
+					"routes": []interface{}{
+						map[string]interface{}{"targets": []interface{}{"route1"}, "filter": map[string]interface{}{}},
+					},
+				},
+			},
+			expectErr: true,
+			errorMsg:  "routing-policy - routes - invalid key 'filter'",
+		},
+		{
+			name: "Invalid Match Mode",
+			config: map[string]interface{}{
+				"name":           "testPipeline",
+				"routing-policy": map[string]interface{}{"match-mode": "any"},
+			},
+			expectErr: true,
+			errorMsg:  "routing-policy - invalid match-mode 'any'",
+		},
+		{
+			name: "Valid Load Balance",
+			config: map[string]interface{}{
+				"name":           "testPipeline",
+				"routing-policy": map[string]interface{}{"forward": []string{"route1", "route2"}, "load-balance": "consistent-hash", "hash-key": "network.query-ip"},
+			},
+			expectErr: false,
+		},
+		{
+			name: "Invalid Load Balance",
+			config: map[string]interface{}{
+				"name":           "testPipeline",
+				"routing-policy": map[string]interface{}{"forward": []string{"route1", "route2"}, "load-balance": "random"},
+			},
+			expectErr: true,
+			errorMsg:  "routing-policy - invalid load-balance 'random'",
+		},
 		{
 			name: "Invalid Transforms",
 			config: map[string]interface{}{
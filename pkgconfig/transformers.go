@@ -4,6 +4,8 @@ import (
 	"reflect"
 
 	"github.com/creasty/defaults"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 )
 
 type RelabelingConfig struct {
@@ -11,8 +13,17 @@ type RelabelingConfig struct {
 	Replacement string `yaml:"replacement"`
 }
 
+type ThreatIntelFeed struct {
+	Name     string `yaml:"name"`
+	Category string `yaml:"category"`
+	Format   string `yaml:"format"`
+	File     string `yaml:"file"`
+}
+
 type ConfigTransformers struct {
-	UserPrivacy struct {
+	Workers         int    `yaml:"workers" default:"1"`
+	WorkersShardKey string `yaml:"workers-shard-key" default:""`
+	UserPrivacy     struct {
 		Enable            bool   `yaml:"enable" default:"false"`
 		AnonymizeIP       bool   `yaml:"anonymize-ip" default:"false"`
 		AnonymizeIPV4Bits string `yaml:"anonymize-v4bits" default:"0.0.0.0/16"`
@@ -21,6 +32,17 @@ type ConfigTransformers struct {
 		HashQueryIP       bool   `yaml:"hash-query-ip" default:"false"`
 		HashReplyIP       bool   `yaml:"hash-reply-ip" default:"false"`
 		HashIPAlgo        string `yaml:"hash-ip-algo" default:"sha1"`
+		HashQname         bool   `yaml:"hash-qname" default:"false"`
+		HashEcs           bool   `yaml:"hash-ecs" default:"false"`
+		HashPdnsRequestor bool   `yaml:"hash-pdns-requestor-id" default:"false"`
+		HashPdnsDevice    bool   `yaml:"hash-pdns-device-id" default:"false"`
+		HashSecretFile    string `yaml:"hash-secret-file" default:""`
+		HashSecretEnv     string `yaml:"hash-secret-env" default:""`
+		HashDailyRotation bool   `yaml:"hash-daily-rotation" default:"false"`
+		CryptoPanQueryIP  bool   `yaml:"cryptopan-query-ip" default:"false"`
+		CryptoPanReplyIP  bool   `yaml:"cryptopan-reply-ip" default:"false"`
+		CryptoPanEcs      bool   `yaml:"cryptopan-ecs" default:"false"`
+		CryptoPanKeyFile  string `yaml:"cryptopan-key-file" default:""`
 	} `yaml:"user-privacy"`
 	Normalize struct {
 		Enable              bool `yaml:"enable" default:"false"`
@@ -30,6 +52,8 @@ type ConfigTransformers struct {
 		AddTld              bool `yaml:"add-tld" default:"false"`
 		AddTldPlusOne       bool `yaml:"add-tld-plus-one" default:"false"`
 		ReplaceNonPrintable bool `yaml:"qname-replace-nonprintable" default:"false"`
+		QnameIdnaDecode     bool `yaml:"qname-idna-decode" default:"false"`
+		RRIdnaDecode        bool `yaml:"rr-idna-decode" default:"false"`
 	} `yaml:"normalize"`
 	Latency struct {
 		Enable            bool `yaml:"enable" default:"false"`
@@ -37,6 +61,10 @@ type ConfigTransformers struct {
 		UnansweredQueries bool `yaml:"unanswered-queries" default:"false"`
 		QueriesTimeout    int  `yaml:"queries-timeout" default:"2"`
 	} `yaml:"latency"`
+	Transaction struct {
+		Enable         bool `yaml:"enable" default:"false"`
+		QueriesTimeout int  `yaml:"queries-timeout" default:"2"`
+	} `yaml:"transaction"`
 	Reducer struct {
 		Enable                    bool     `yaml:"enable" default:"false"`
 		RepetitiveTrafficDetector bool     `yaml:"repetitive-traffic-detector" default:"false"`
@@ -75,6 +103,18 @@ type ConfigTransformers struct {
 		ThresholdMaxLabels int      `yaml:"threshold-max-labels" default:"10"`
 		WhitelistDomains   []string `yaml:"whitelist-domains,flow" default:"[\"\\\\.ip6\\\\.arpa\"]"`
 	} `yaml:"suspicious"`
+	Typosquatting struct {
+		Enable               bool     `yaml:"enable" default:"false"`
+		ProtectedDomains     []string `yaml:"protected-domains,flow" default:"[]"`
+		ProtectedDomainsFile string   `yaml:"protected-domains-file" default:""`
+		MaxEditDistance      int      `yaml:"max-edit-distance" default:"1"`
+		DropLookalikes       bool     `yaml:"drop-lookalikes" default:"false"`
+	} `yaml:"typosquatting"`
+	ThreatIntel struct {
+		Enable        bool              `yaml:"enable" default:"false"`
+		Feeds         []ThreatIntelFeed `yaml:"feeds,flow"`
+		WatchInterval int               `yaml:"watch-interval" default:"60"`
+	} `yaml:"threat-intel"`
 	Extract struct {
 		Enable     bool `yaml:"enable" default:"false"`
 		AddPayload bool `yaml:"add-payload" default:"false"`
@@ -115,7 +155,58 @@ func (c *ConfigTransformers) SetDefault() {
 }
 
 func (c *ConfigTransformers) IsValid(userCfg map[string]interface{}) error {
-	return CheckConfigWithTags(reflect.ValueOf(*c), userCfg)
+	if err := CheckConfigWithTags(reflect.ValueOf(*c), userCfg); err != nil {
+		return err
+	}
+
+	// check the values of the user privacy settings
+	if userPrivacy, ok := userCfg["user-privacy"]; ok {
+		data, err := yaml.Marshal(userPrivacy)
+		if err != nil {
+			return err
+		}
+		cfg := GetFakeConfigTransformers()
+		if err := yaml.Unmarshal(data, &cfg.UserPrivacy); err != nil {
+			return errors.Errorf("user-privacy - %s", err)
+		}
+		if err := cfg.CheckUserPrivacy(); err != nil {
+			return errors.Errorf("user-privacy - %s", err)
+		}
+	}
+	return nil
+}
+
+// CheckUserPrivacy rejects the incompatible user privacy settings
+func (c *ConfigTransformers) CheckUserPrivacy() error {
+	cfg := c.UserPrivacy
+
+	// an ip can't be hashed and anonymized with crypto-pan
+	if cfg.HashQueryIP && cfg.CryptoPanQueryIP {
+		return errors.New("hash-query-ip and cryptopan-query-ip can't be enabled together")
+	}
+	if cfg.HashReplyIP && cfg.CryptoPanReplyIP {
+		return errors.New("hash-reply-ip and cryptopan-reply-ip can't be enabled together")
+	}
+	if cfg.HashEcs && cfg.CryptoPanEcs {
+		return errors.New("hash-ecs and cryptopan-ecs can't be enabled together")
+	}
+	if (cfg.CryptoPanQueryIP || cfg.CryptoPanReplyIP || cfg.CryptoPanEcs) && len(cfg.CryptoPanKeyFile) == 0 {
+		return errors.New("cryptopan-key-file is required")
+	}
+	if c.IsHashEnabled() {
+		switch cfg.HashIPAlgo {
+		case "sha1", "sha256", "sha512":
+		default:
+			return errors.Errorf("hash-ip-algo - invalid algorithm '%s'", cfg.HashIPAlgo)
+		}
+	}
+	return nil
+}
+
+// IsHashEnabled returns true if one of the user privacy hash options is enabled
+func (c *ConfigTransformers) IsHashEnabled() bool {
+	cfg := c.UserPrivacy
+	return cfg.HashQueryIP || cfg.HashReplyIP || cfg.HashQname || cfg.HashEcs || cfg.HashPdnsRequestor || cfg.HashPdnsDevice
 }
 
 func GetFakeConfigTransformers() *ConfigTransformers {
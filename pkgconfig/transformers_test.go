@@ -19,3 +19,51 @@ func TestConfigTransformersSetDefault(t *testing.T) {
 		t.Errorf("geo should be disabled")
 	}
 }
+
+func TestConfigTransformers_IsValid_UserPrivacy(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{
+			name:      "Hash query ip",
+			config:    map[string]interface{}{"user-privacy": map[string]interface{}{"hash-query-ip": true}},
+			expectErr: false,
+		},
+		{
+			name: "Hash and cryptopan query ip",
+			config: map[string]interface{}{"user-privacy": map[string]interface{}{
+				"hash-query-ip": true, "cryptopan-query-ip": true, "cryptopan-key-file": "/etc/dnscollector/cryptopan.key"}},
+			expectErr: true,
+		},
+		{
+			name:      "Hash with invalid algorithm",
+			config:    map[string]interface{}{"user-privacy": map[string]interface{}{"hash-qname": true, "hash-ip-algo": "md5"}},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid algorithm without hash",
+			config:    map[string]interface{}{"user-privacy": map[string]interface{}{"hash-ip-algo": "md5"}},
+			expectErr: false,
+		},
+		{
+			name:      "Cryptopan without key file",
+			config:    map[string]interface{}{"user-privacy": map[string]interface{}{"cryptopan-query-ip": true}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := ConfigTransformers{}
+			err := config.IsValid(tc.config)
+			if tc.expectErr && err == nil {
+				t.Errorf("Expected error, got nil")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
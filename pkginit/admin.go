@@ -0,0 +1,236 @@
+package pkginit
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/telemetry"
+	"github.com/dmachard/go-dnscollector/workers"
+	"github.com/dmachard/go-logger"
+)
+
+type ChannelState struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+type StanzaCounters struct {
+	Ingress   int `json:"ingress"`
+	Egress    int `json:"egress"`
+	Forwarded int `json:"forwarded"`
+	Dropped   int `json:"dropped"`
+	Discarded int `json:"discarded"`
+}
+
+// StanzaState describes a running stanza
+type StanzaState struct {
+	Name       string               `json:"name"`
+	Kind       string               `json:"kind"`
+	Type       string               `json:"type"`
+	Paused     bool                 `json:"paused"`
+	Transforms []string             `json:"transforms"`
+	Input      ChannelState         `json:"input"`
+	Output     *ChannelState        `json:"output,omitempty"`
+	Routes     []workers.RouteState `json:"routes"`
+	Counters   *StanzaCounters      `json:"counters,omitempty"`
+}
+
+// AdminAPI exposes the running stanzas on the telemetry server, to list them with
+// their routes and counters, to pause or resume a stanza and to reload the configuration.
+// The lock protects the maps of workers updated by the reload.
+type AdminAPI struct {
+	sync.Mutex
+	config        *pkgconfig.Config
+	logger        *logger.Logger
+	mapLoggers    map[string]workers.Worker
+	mapCollectors map[string]workers.Worker
+	metrics       *telemetry.PrometheusCollector
+	reload        func() error
+	mux           *http.ServeMux
+}
+
+func NewAdminAPI(config *pkgconfig.Config, logger *logger.Logger, mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker,
+	metrics *telemetry.PrometheusCollector, reload func() error) *AdminAPI {
+	a := &AdminAPI{
+		config:        config,
+		logger:        logger,
+		mapLoggers:    mapLoggers,
+		mapCollectors: mapCollectors,
+		metrics:       metrics,
+		reload:        reload,
+		mux:           http.NewServeMux(),
+	}
+
+	a.mux.HandleFunc("GET /stanzas", a.listStanzas)
+	a.mux.HandleFunc("GET /stanzas/{name}", a.getStanza)
+	a.mux.HandleFunc("POST /stanzas/{name}/pause", a.pauseStanza)
+	a.mux.HandleFunc("POST /stanzas/{name}/resume", a.resumeStanza)
+	a.mux.HandleFunc("POST /reload", a.reloadConfig)
+	return a
+}
+
+// InitAdminAPI creates the admin api and adds it to the telemetry server if enabled
+func InitAdminAPI(config *pkgconfig.Config, logger *logger.Logger, mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker,
+	metrics *telemetry.PrometheusCollector, reload func() error) *AdminAPI {
+	a := NewAdminAPI(config, logger, mapLoggers, mapCollectors, metrics, reload)
+	if config.Global.Telemetry.Enabled && config.Global.Telemetry.AdminAPI {
+		path := strings.TrimSuffix(config.Global.Telemetry.AdminWebPath, "/")
+		http.Handle(path+"/", http.StripPrefix(path, a))
+		logger.Info("main - admin api enabled on path: %s", path)
+	}
+	return a
+}
+
+func (a *AdminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// Reload reloads the configuration, the admin api is locked during the reload
+func (a *AdminAPI) Reload() error {
+	a.Lock()
+	defer a.Unlock()
+	return a.reload()
+}
+
+// GetStanzaState returns the state of the running stanza
+func (a *AdminAPI) GetStanzaState(name string) (StanzaState, bool) {
+	wrk, ok := getStanzaWorker(name, a.mapCollectors, a.mapLoggers)
+	if !ok {
+		return StanzaState{}, false
+	}
+
+	state := StanzaState{
+		Name:       name,
+		Kind:       "collector",
+		Type:       a.getStanzaType(name),
+		Paused:     wrk.GetControl().IsPaused(),
+		Transforms: wrk.GetControl().GetTransforms(),
+		Input:      ChannelState{Length: len(wrk.GetInputChannel()), Capacity: cap(wrk.GetInputChannel())},
+		Routes:     wrk.GetRoutesState(),
+	}
+	if _, isLogger := a.mapLoggers[name]; isLogger {
+		state.Kind = "logger"
+		state.Output = &ChannelState{Length: len(wrk.GetOutputChannel()), Capacity: cap(wrk.GetOutputChannel())}
+	}
+
+	// counters are available with the telemetry
+	if a.metrics != nil {
+		if stats, ok := a.metrics.GetWorkerStats(name); ok {
+			state.Counters = &StanzaCounters{
+				Ingress:   stats.TotalIngress,
+				Egress:    stats.TotalEgress,
+				Forwarded: stats.TotalForwardedPolicy,
+				Dropped:   stats.TotalDroppedPolicy,
+				Discarded: stats.TotalDiscarded,
+			}
+		}
+	}
+	return state, true
+}
+
+// getStanzaType returns the type of collector or logger of the stanza
+func (a *AdminAPI) getStanzaType(name string) string {
+	for _, stanza := range a.config.Pipelines {
+		if stanza.Name == name {
+			return GetStanzaKind(stanza)
+		}
+	}
+	for _, item := range append(append([]pkgconfig.MultiplexInOut{}, a.config.Multiplexer.Collectors...), a.config.Multiplexer.Loggers...) {
+		if item.Name == name {
+			for k := range item.Params {
+				return k
+			}
+		}
+	}
+	return ""
+}
+
+func (a *AdminAPI) listStanzas(w http.ResponseWriter, _ *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+
+	names := []string{}
+	for name := range a.mapCollectors {
+		names = append(names, name)
+	}
+	for name := range a.mapLoggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stanzas := []StanzaState{}
+	for _, name := range names {
+		if state, ok := a.GetStanzaState(name); ok {
+			stanzas = append(stanzas, state)
+		}
+	}
+	a.writeJSON(w, http.StatusOK, stanzas)
+}
+
+func (a *AdminAPI) getStanza(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+
+	state, ok := a.GetStanzaState(r.PathValue("name"))
+	if !ok {
+		a.writeError(w, http.StatusNotFound, "stanza not found")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, state)
+}
+
+func (a *AdminAPI) pauseStanza(w http.ResponseWriter, r *http.Request) {
+	a.setPaused(w, r.PathValue("name"), true)
+}
+
+func (a *AdminAPI) resumeStanza(w http.ResponseWriter, r *http.Request) {
+	a.setPaused(w, r.PathValue("name"), false)
+}
+
+func (a *AdminAPI) setPaused(w http.ResponseWriter, name string, paused bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	wrk, ok := getStanzaWorker(name, a.mapCollectors, a.mapLoggers)
+	if !ok {
+		a.writeError(w, http.StatusNotFound, "stanza not found")
+		return
+	}
+
+	if paused {
+		if wrk.GetControl().Pause() {
+			a.logger.Info("main - admin api - stanza=%s paused", name)
+		}
+	} else if wrk.GetControl().Resume() {
+		a.logger.Info("main - admin api - stanza=%s resumed", name)
+	}
+
+	state, _ := a.GetStanzaState(name)
+	a.writeJSON(w, http.StatusOK, state)
+}
+
+func (a *AdminAPI) reloadConfig(w http.ResponseWriter, _ *http.Request) {
+	a.logger.Warning("main - admin api - reload requested")
+	if err := a.Reload(); err != nil {
+		a.logger.Error("main - admin api - %v", err)
+		a.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+func (a *AdminAPI) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		a.logger.Error("main - admin api - %v", err)
+	}
+}
+
+func (a *AdminAPI) writeError(w http.ResponseWriter, status int, msg string) {
+	a.writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,143 @@
+package pkginit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/workers"
+	"github.com/dmachard/go-logger"
+)
+
+func getAdminAPIForTest(t *testing.T, reload func() error) (*AdminAPI, map[string]workers.Worker) {
+	config := pkgconfig.GetDefaultConfig()
+	config.Pipelines = getPipelinesForTest()
+
+	mapLoggers := make(map[string]workers.Worker)
+	mapCollectors := make(map[string]workers.Worker)
+	if err := InitPipelines(mapLoggers, mapCollectors, config, logger.New(false), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, wrk := range mapLoggers {
+		go wrk.StartCollect()
+	}
+	for _, wrk := range mapCollectors {
+		go wrk.StartCollect()
+	}
+	t.Cleanup(func() { StopWorkers(mapLoggers, mapCollectors, config, logger.New(false)) })
+
+	return NewAdminAPI(config, logger.New(false), mapLoggers, mapCollectors, nil, reload), mapCollectors
+}
+
+func doAdminRequest(t *testing.T, api *AdminAPI, method, path string, data interface{}) int {
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+	if data != nil && rr.Code == http.StatusOK {
+		if err := json.NewDecoder(rr.Body).Decode(data); err != nil {
+			t.Fatalf("invalid json response: %v", err)
+		}
+	}
+	return rr.Code
+}
+
+func TestAdminAPI_ListStanzas(t *testing.T) {
+	api, _ := getAdminAPIForTest(t, nil)
+
+	var stanzas []StanzaState
+	if code := doAdminRequest(t, api, http.MethodGet, "/stanzas", &stanzas); code != http.StatusOK {
+		t.Fatalf("want status 200, got %d", code)
+	}
+	if len(stanzas) != 3 {
+		t.Fatalf("want 3 stanzas, got %d", len(stanzas))
+	}
+
+	// sorted by name
+	out, relay, tap := stanzas[0], stanzas[1], stanzas[2]
+	if out.Name != "out" || out.Kind != "logger" || out.Type != "devnull" || out.Output == nil {
+		t.Errorf("invalid logger stanza: %+v", out)
+	}
+	if relay.Name != "relay" || relay.Kind != "collector" || relay.Type != "dnsmessage" {
+		t.Errorf("invalid collector stanza: %+v", relay)
+	}
+	if relay.Input.Capacity != api.config.Global.Worker.ChannelBufferSize {
+		t.Errorf("invalid input channel: %+v", relay.Input)
+	}
+	if len(tap.Routes) != 2 || tap.Routes[0].Type != "forward" || tap.Routes[0].Target != "relay" ||
+		tap.Routes[1].Type != "dropped" || tap.Routes[1].Target != "out" {
+		t.Errorf("invalid routes: %+v", tap.Routes)
+	}
+}
+
+func TestAdminAPI_GetStanza(t *testing.T) {
+	api, _ := getAdminAPIForTest(t, nil)
+
+	var stanza StanzaState
+	if code := doAdminRequest(t, api, http.MethodGet, "/stanzas/relay", &stanza); code != http.StatusOK {
+		t.Fatalf("want status 200, got %d", code)
+	}
+	if stanza.Name != "relay" || stanza.Paused {
+		t.Errorf("invalid stanza: %+v", stanza)
+	}
+
+	if code := doAdminRequest(t, api, http.MethodGet, "/stanzas/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("want status 404, got %d", code)
+	}
+	if code := doAdminRequest(t, api, http.MethodPost, "/stanzas/unknown/pause", nil); code != http.StatusNotFound {
+		t.Errorf("want status 404, got %d", code)
+	}
+}
+
+func TestAdminAPI_PauseResume(t *testing.T) {
+	api, mapCollectors := getAdminAPIForTest(t, nil)
+
+	var stanza StanzaState
+	if code := doAdminRequest(t, api, http.MethodPost, "/stanzas/relay/pause", &stanza); code != http.StatusOK || !stanza.Paused {
+		t.Fatalf("stanza not paused: %d %+v", code, stanza)
+	}
+
+	// the messages are kept in the input channel of the paused stanza
+	for i := 0; i < 10; i++ {
+		mapCollectors["tap"].GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	}
+	time.Sleep(500 * time.Millisecond)
+	if length := len(mapCollectors["relay"].GetInputChannel()); length < 9 {
+		t.Errorf("messages should be queued, got %d", length)
+	}
+
+	if code := doAdminRequest(t, api, http.MethodPost, "/stanzas/relay/resume", &stanza); code != http.StatusOK || stanza.Paused {
+		t.Fatalf("stanza not resumed: %d %+v", code, stanza)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if length := len(mapCollectors["relay"].GetInputChannel()); length != 0 {
+		t.Errorf("messages should be consumed, got %d", length)
+	}
+}
+
+func TestAdminAPI_Reload(t *testing.T) {
+	reloaded := 0
+	api, _ := getAdminAPIForTest(t, func() error {
+		reloaded++
+		if reloaded > 1 {
+			return errors.New("invalid config")
+		}
+		return nil
+	})
+
+	if code := doAdminRequest(t, api, http.MethodPost, "/reload", nil); code != http.StatusOK {
+		t.Errorf("want status 200, got %d", code)
+	}
+	if code := doAdminRequest(t, api, http.MethodPost, "/reload", nil); code != http.StatusInternalServerError {
+		t.Errorf("want status 500, got %d", code)
+	}
+	if code := doAdminRequest(t, api, http.MethodGet, "/reload", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("want status 405, got %d", code)
+	}
+	if reloaded != 2 {
+		t.Errorf("want 2 reloads, got %d", reloaded)
+	}
+}
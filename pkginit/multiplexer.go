@@ -67,11 +67,13 @@ func GetItemConfig(section string, config *pkgconfig.Config, item pkgconfig.Mult
 
 	// add transformer
 	for k, v := range item.Transforms {
-		if _, ok := v.(map[string]interface{}); !ok {
+		transform, ok := v.(map[string]interface{})
+		if !ok && k != "workers" && k != "workers-shard-key" {
 			panic("main - yaml transform config error - map expected")
 		}
-
-		v.(map[string]interface{})["enable"] = true
+		if ok {
+			transform["enable"] = true
+		}
 		cfg[section+Transformers].(map[string]interface{})[k] = v
 	}
 
@@ -152,6 +154,12 @@ func InitMultiplexer(mapLoggers map[string]workers.Worker, mapCollectors map[str
 		if subcfg.Loggers.ClickhouseClient.Enable && IsLoggerRouted(config, output.Name) {
 			mapLoggers[output.Name] = workers.NewClickhouseClient(subcfg, logger, output.Name)
 		}
+		if subcfg.Loggers.S3Client.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = workers.NewS3Client(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.PostgreSQL.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = workers.NewPostgreSQL(subcfg, logger, output.Name)
+		}
 	}
 
 	// load collectors
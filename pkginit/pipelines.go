@@ -2,6 +2,7 @@ package pkginit
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/telemetry"
@@ -47,7 +48,10 @@ func GetStanzaConfig(config *pkgconfig.Config, item pkgconfig.ConfigPipelines) *
 
 	// add transformers
 	for k, v := range item.Transforms {
-		v.(map[string]interface{})["enable"] = true
+		// options of the transforms like the number of workers are not maps
+		if transform, ok := v.(map[string]interface{}); ok {
+			transform["enable"] = true
+		}
 		cfg[section+"-transformers"].(map[string]interface{})[k] = v
 	}
 
@@ -94,6 +98,12 @@ func CreateRouting(stanza pkgconfig.ConfigPipelines, mapCollectors map[string]wo
 		currentStanza = logger
 	}
 
+	// backpressure policy when the next stanzas are busy
+	currentStanza.SetRoutingPolicy(stanza.RoutingPolicy)
+	if policy := stanza.RoutingPolicy.GetBackpressure(); policy != pkgconfig.BackpressureDrop {
+		logger.Info("main - routing stanza=[%s] with backpressure=%s", stanza.Name, policy)
+	}
+
 	// forward routing
 	for _, route := range stanza.RoutingPolicy.Forward {
 		if route == stanza.Name {
@@ -122,6 +132,25 @@ func CreateRouting(stanza pkgconfig.ConfigPipelines, mapCollectors map[string]wo
 			return fmt.Errorf("main - routing error with dropped messages from stanza=%s to stanza=%s doest not exist", stanza.Name, route)
 		}
 	}
+
+	// conditional routing
+	for _, matchRoute := range stanza.RoutingPolicy.Routes {
+		targets := []workers.Worker{}
+		for _, route := range matchRoute.Targets {
+			if route == stanza.Name {
+				return fmt.Errorf("main - routing error loop with stanza=%s to stanza=%s", stanza.Name, route)
+			}
+			if _, ok := mapCollectors[route]; ok {
+				targets = append(targets, mapCollectors[route])
+			} else if _, ok := mapLoggers[route]; ok {
+				targets = append(targets, mapLoggers[route])
+			} else {
+				return fmt.Errorf("main - conditional routing error from stanza=%s to stanza=%s doest not exist", stanza.Name, route)
+			}
+			logger.Info("main - routing (policy=%s) stanza=[%s] to stanza=[%s]", stanza.RoutingPolicy.GetMatchMode(), stanza.Name, route)
+		}
+		currentStanza.AddMatchRoute(matchRoute.Matching, targets)
+	}
 	return nil
 }
 
@@ -195,6 +224,14 @@ func CreateStanza(stanzaName string, config *pkgconfig.Config, mapCollectors map
 		mapLoggers[stanzaName] = workers.NewClickhouseClient(config, logger, stanzaName)
 		mapLoggers[stanzaName].SetMetrics(metrics)
 	}
+	if config.Loggers.S3Client.Enable {
+		mapLoggers[stanzaName] = workers.NewS3Client(config, logger, stanzaName)
+		mapLoggers[stanzaName].SetMetrics(metrics)
+	}
+	if config.Loggers.PostgreSQL.Enable {
+		mapLoggers[stanzaName] = workers.NewPostgreSQL(config, logger, stanzaName)
+		mapLoggers[stanzaName].SetMetrics(metrics)
+	}
 	if config.Loggers.DevNull.Enable {
 		mapLoggers[stanzaName] = workers.NewDevNull(config, logger, stanzaName)
 		mapLoggers[stanzaName].SetMetrics(metrics)
@@ -243,14 +280,15 @@ func CreateStanza(stanzaName string, config *pkgconfig.Config, mapCollectors map
 	}
 }
 
-func InitPipelines(mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker, config *pkgconfig.Config, logger *logger.Logger, telemetry *telemetry.PrometheusCollector) error {
+// CheckPipelines checks the name of each stanza and the routes
+func CheckPipelines(config *pkgconfig.Config) error {
 	// check if the name of each stanza is uniq
 	routesDefined := false
 	for _, stanza := range config.Pipelines {
 		if err := StanzaNameIsUniq(stanza.Name, config); err != nil {
 			return errors.Errorf("stanza with name=[%s] is duplicated", stanza.Name)
 		}
-		if len(stanza.RoutingPolicy.Forward) > 0 || len(stanza.RoutingPolicy.Dropped) > 0 {
+		if len(stanza.RoutingPolicy.Forward) > 0 || len(stanza.RoutingPolicy.Dropped) > 0 || len(stanza.RoutingPolicy.Routes) > 0 {
 			routesDefined = true
 		}
 	}
@@ -259,9 +297,12 @@ func InitPipelines(mapLoggers map[string]workers.Worker, mapCollectors map[strin
 		return errors.Errorf("no routes are defined")
 	}
 
-	// check if all routes exists before continue
+	// check if all routes exists and don't loop before continue
 	for _, stanza := range config.Pipelines {
 		for _, route := range stanza.RoutingPolicy.Forward {
+			if route == stanza.Name {
+				return errors.Errorf("stanza=[%s] routing error loop with forward route=[%s]", stanza.Name, route)
+			}
 			if err := IsRouteExist(route, config); err != nil {
 				return errors.Errorf("stanza=[%s] forward route=[%s] doest not exist", stanza.Name, route)
 			}
@@ -271,6 +312,23 @@ func InitPipelines(mapLoggers map[string]workers.Worker, mapCollectors map[strin
 				return errors.Errorf("stanza=[%s] dropped route=[%s] doest not exist", stanza.Name, route)
 			}
 		}
+		for _, matchRoute := range stanza.RoutingPolicy.Routes {
+			for _, route := range matchRoute.Targets {
+				if route == stanza.Name {
+					return errors.Errorf("stanza=[%s] routing error loop with conditional route=[%s]", stanza.Name, route)
+				}
+				if err := IsRouteExist(route, config); err != nil {
+					return errors.Errorf("stanza=[%s] conditional route=[%s] doest not exist", stanza.Name, route)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func InitPipelines(mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker, config *pkgconfig.Config, logger *logger.Logger, telemetry *telemetry.PrometheusCollector) error {
+	if err := CheckPipelines(config); err != nil {
+		return err
 	}
 
 	// read each stanza and init
@@ -294,15 +352,155 @@ func InitPipelines(mapLoggers map[string]workers.Worker, mapCollectors map[strin
 	return nil
 }
 
-func ReloadPipelines(mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker, config *pkgconfig.Config, logger *logger.Logger) {
+// GetStanzaKind returns the collector or logger type of the stanza
+func GetStanzaKind(stanza pkgconfig.ConfigPipelines) string {
+	for k := range stanza.Params {
+		return k
+	}
+	return ""
+}
+
+func getStanzaWorker(name string, mapCollectors map[string]workers.Worker, mapLoggers map[string]workers.Worker) (workers.Worker, bool) {
+	if collector, ok := mapCollectors[name]; ok {
+		return collector, true
+	}
+	if logger, ok := mapLoggers[name]; ok {
+		return logger, true
+	}
+	return nil, false
+}
+
+// RewireRouting replaces the routes of a running stanza
+func RewireRouting(stanza pkgconfig.ConfigPipelines, mapCollectors map[string]workers.Worker, mapLoggers map[string]workers.Worker) error {
+	currentStanza, ok := getStanzaWorker(stanza.Name, mapCollectors, mapLoggers)
+	if !ok {
+		return fmt.Errorf("main - routing error stanza=%s doest not exist", stanza.Name)
+	}
+
+	getWorkers := func(routes []string, loop bool) ([]workers.Worker, error) {
+		wrks := []workers.Worker{}
+		for _, route := range routes {
+			if loop && route == stanza.Name {
+				return nil, fmt.Errorf("main - routing error loop with stanza=%s to stanza=%s", stanza.Name, route)
+			}
+			wrk, ok := getStanzaWorker(route, mapCollectors, mapLoggers)
+			if !ok {
+				return nil, fmt.Errorf("main - routing error from stanza=%s to stanza=%s doest not exist", stanza.Name, route)
+			}
+			wrks = append(wrks, wrk)
+		}
+		return wrks, nil
+	}
+
+	forward, err := getWorkers(stanza.RoutingPolicy.Forward, true)
+	if err != nil {
+		return err
+	}
+	dropped, err := getWorkers(stanza.RoutingPolicy.Dropped, false)
+	if err != nil {
+		return err
+	}
+	matchTargets := [][]workers.Worker{}
+	for _, matchRoute := range stanza.RoutingPolicy.Routes {
+		targets, err := getWorkers(matchRoute.Targets, true)
+		if err != nil {
+			return err
+		}
+		matchTargets = append(matchTargets, targets)
+	}
+
+	currentStanza.RewireRoutes(stanza.RoutingPolicy, forward, dropped, matchTargets)
+	return nil
+}
+
+// ReloadPipelines applies the new pipelines without restarting the unchanged stanzas.
+// The new stanzas are started, the routes of all stanzas are rewired, then the
+// removed stanzas are stopped after draining. The running stanzas are not changed
+// when the new pipelines are invalid.
+func ReloadPipelines(mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker, previous []pkgconfig.ConfigPipelines,
+	config *pkgconfig.Config, logger *logger.Logger, metrics *telemetry.PrometheusCollector) error {
+	if err := CheckPipelines(config); err != nil {
+		return err
+	}
+
+	newKinds := make(map[string]string)
+	for _, stanza := range config.Pipelines {
+		newKinds[stanza.Name] = GetStanzaKind(stanza)
+	}
+
+	// stanzas removed or with a new collector or logger type
+	removed := make(map[string]workers.Worker)
+	for _, stanza := range previous {
+		if kind, ok := newKinds[stanza.Name]; ok && kind == GetStanzaKind(stanza) {
+			continue
+		}
+		if wrk, ok := getStanzaWorker(stanza.Name, mapCollectors, mapLoggers); ok {
+			removed[stanza.Name] = wrk
+		}
+	}
+
+	// create the new stanzas, the existing ones are reloaded once all the stanzas are created
+	newLoggers := make(map[string]workers.Worker)
+	newCollectors := make(map[string]workers.Worker)
+	reloaded := make(map[string]*pkgconfig.Config)
 	for _, stanza := range config.Pipelines {
-		newCfg := GetStanzaConfig(config, stanza)
-		if _, ok := mapLoggers[stanza.Name]; ok {
-			mapLoggers[stanza.Name].ReloadConfig(newCfg)
-		} else if _, ok := mapCollectors[stanza.Name]; ok {
-			mapCollectors[stanza.Name].ReloadConfig(newCfg)
+		stanzaConfig := GetStanzaConfig(config, stanza)
+		if _, ok := getStanzaWorker(stanza.Name, mapCollectors, mapLoggers); ok && removed[stanza.Name] == nil {
+			reloaded[stanza.Name] = stanzaConfig
+			continue
+		}
+		CreateStanza(stanza.Name, stanzaConfig, newCollectors, newLoggers, logger, metrics)
+		if _, ok := getStanzaWorker(stanza.Name, newCollectors, newLoggers); !ok {
+			return errors.Errorf("routing - stanza=[%v] doest not exist", stanza.Name)
+		}
+	}
+
+	for name := range removed {
+		logger.Info("main - reload, stanza=[%s] removed", name)
+		delete(mapCollectors, name)
+		delete(mapLoggers, name)
+	}
+	for name, stanzaConfig := range reloaded {
+		wrk, _ := getStanzaWorker(name, mapCollectors, mapLoggers)
+		wrk.ReloadConfig(stanzaConfig)
+	}
+	for name, wrk := range newLoggers {
+		logger.Info("main - reload, stanza=[%s] added", name)
+		mapLoggers[name] = wrk
+	}
+	for name, wrk := range newCollectors {
+		logger.Info("main - reload, stanza=[%s] added", name)
+		mapCollectors[name] = wrk
+	}
+
+	// routing of the new stanzas and rewiring of the running ones
+	for _, stanza := range config.Pipelines {
+		_, isNewLogger := newLoggers[stanza.Name]
+		_, isNewCollector := newCollectors[stanza.Name]
+		var err error
+		if isNewLogger || isNewCollector {
+			err = CreateRouting(stanza, mapCollectors, mapLoggers, logger)
 		} else {
-			logger.Info("main - reload config stanza=%v doest not exist", stanza.Name)
+			err = RewireRouting(stanza, mapCollectors, mapLoggers)
 		}
+		if err != nil {
+			return errors.Wrap(err, "routing")
+		}
+	}
+
+	// start the new stanzas, loggers first
+	for _, wrk := range newLoggers {
+		go wrk.StartCollect()
+	}
+	for _, wrk := range newCollectors {
+		go wrk.StartCollect()
 	}
+
+	// stop the removed stanzas
+	for _, wrk := range removed {
+		if lost := DrainWorker(wrk, time.Duration(config.Global.Worker.DrainTimeout)*time.Second, logger); lost > 0 {
+			logger.Warning("main - reload, stanza=[%s] stopped with %d message(s) lost", wrk.GetName(), lost)
+		}
+	}
+	return nil
 }
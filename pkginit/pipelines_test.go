@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/telemetry"
 	"github.com/dmachard/go-dnscollector/workers"
@@ -111,3 +112,151 @@ func TestPipelines_RoutingLoop(t *testing.T) {
 	}
 
 }
+
+func TestPipelines_StanzaConfigTransformsWorkers(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	stanza := pkgconfig.ConfigPipelines{
+		Name:   "console",
+		Params: map[string]interface{}{"stdout": map[string]interface{}{}},
+		Transforms: map[string]interface{}{
+			"workers":           4,
+			"workers-shard-key": "network.query-ip",
+			"normalize":         map[string]interface{}{"qname-lowercase": true},
+		},
+	}
+
+	subcfg := GetStanzaConfig(config, stanza)
+	transforms := subcfg.OutgoingTransformers
+	if transforms.Workers != 4 || transforms.WorkersShardKey != "network.query-ip" {
+		t.Errorf("invalid workers options: %d %s", transforms.Workers, transforms.WorkersShardKey)
+	}
+	if !transforms.Normalize.Enable || !transforms.Normalize.QnameLowerCase {
+		t.Errorf("normalize transform not enabled")
+	}
+}
+
+func TestPipelines_RewireRouting(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	mapLoggers := make(map[string]workers.Worker)
+	mapCollectors := make(map[string]workers.Worker)
+
+	for _, name := range []string{"tap", "out1", "out2"} {
+		config.Collectors.DNSMessage.Enable = true
+		CreateStanza(name, config, mapCollectors, mapLoggers, logger.New(false), nil)
+	}
+
+	// route to out1 then to out2
+	stanza := pkgconfig.ConfigPipelines{Name: "tap", RoutingPolicy: pkgconfig.PipelinesRouting{Forward: []string{"out1"}}}
+	if err := CreateRouting(stanza, mapCollectors, mapLoggers, logger.New(false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stanza.RoutingPolicy.Forward = []string{"out2"}
+	if err := RewireRouting(stanza, mapCollectors, mapLoggers); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the running worker uses the new routes
+	tap := mapCollectors["tap"].(*workers.DNSMessage)
+	defaultRoutes, defaultNames := workers.GetRoutes(tap.GetDefaultRoutes())
+	tap.SendForwardedTo(defaultRoutes, defaultNames, dnsutils.GetFakeDNSMessage())
+
+	if len(mapCollectors["out1"].GetInputChannel()) != 0 {
+		t.Errorf("no message expected on the old route")
+	}
+	if len(mapCollectors["out2"].GetInputChannel()) != 1 {
+		t.Errorf("message expected on the new route")
+	}
+}
+
+func TestPipelines_Reload(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	config.Pipelines = []pkgconfig.ConfigPipelines{
+		{Name: "tap", Params: map[string]interface{}{"dnsmessage": map[string]interface{}{}}, RoutingPolicy: pkgconfig.PipelinesRouting{Forward: []string{"out1"}}},
+		{Name: "out1", Params: map[string]interface{}{"devnull": map[string]interface{}{}}},
+	}
+
+	mapLoggers := make(map[string]workers.Worker)
+	mapCollectors := make(map[string]workers.Worker)
+	if err := InitPipelines(mapLoggers, mapCollectors, config, logger.New(false), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, wrk := range mapLoggers {
+		go wrk.StartCollect()
+	}
+	for _, wrk := range mapCollectors {
+		go wrk.StartCollect()
+	}
+	tap := mapCollectors["tap"]
+
+	// replace out1 by out2
+	previous := append([]pkgconfig.ConfigPipelines{}, config.Pipelines...)
+	config.Pipelines = []pkgconfig.ConfigPipelines{
+		{Name: "tap", Params: map[string]interface{}{"dnsmessage": map[string]interface{}{}}, RoutingPolicy: pkgconfig.PipelinesRouting{Forward: []string{"out2"}}},
+		{Name: "out2", Params: map[string]interface{}{"devnull": map[string]interface{}{}}},
+	}
+	if err := ReloadPipelines(mapLoggers, mapCollectors, previous, config, logger.New(false), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := mapLoggers["out1"]; ok {
+		t.Errorf("out1 should be removed")
+	}
+	if _, ok := mapLoggers["out2"]; !ok {
+		t.Errorf("out2 should be added")
+	}
+	if mapCollectors["tap"] != tap {
+		t.Errorf("tap should not be restarted")
+	}
+
+	// an invalid topology is rejected
+	previous = append([]pkgconfig.ConfigPipelines{}, config.Pipelines...)
+	config.Pipelines[0].RoutingPolicy.Forward = []string{"unknown"}
+	if err := ReloadPipelines(mapLoggers, mapCollectors, previous, config, logger.New(false), nil); err == nil {
+		t.Errorf("error expected with an unknown route")
+	}
+
+	for _, wrk := range mapCollectors {
+		wrk.Stop()
+	}
+	for _, wrk := range mapLoggers {
+		wrk.Stop()
+	}
+}
+
+func TestPipelines_ReloadInvalid(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	config.Pipelines = []pkgconfig.ConfigPipelines{
+		{Name: "tap", Params: map[string]interface{}{"dnsmessage": map[string]interface{}{}}, RoutingPolicy: pkgconfig.PipelinesRouting{Forward: []string{"out1"}}},
+		{Name: "out1", Params: map[string]interface{}{"devnull": map[string]interface{}{}}},
+	}
+
+	mapLoggers := make(map[string]workers.Worker)
+	mapCollectors := make(map[string]workers.Worker)
+	if err := InitPipelines(mapLoggers, mapCollectors, config, logger.New(false), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tap, out1 := mapCollectors["tap"], mapLoggers["out1"]
+
+	// out1 is replaced by out2 and tap loops on itself
+	previous := append([]pkgconfig.ConfigPipelines{}, config.Pipelines...)
+	config.Pipelines = []pkgconfig.ConfigPipelines{
+		{Name: "tap", Params: map[string]interface{}{"dnsmessage": map[string]interface{}{}}, RoutingPolicy: pkgconfig.PipelinesRouting{Forward: []string{"out2", "tap"}}},
+		{Name: "out2", Params: map[string]interface{}{"devnull": map[string]interface{}{}}},
+	}
+	err := ReloadPipelines(mapLoggers, mapCollectors, previous, config, logger.New(false), nil)
+	if err == nil || !strings.Contains(err.Error(), "routing error loop") {
+		t.Fatalf("routing loop error expected: %v", err)
+	}
+
+	// the running stanzas and their routes are unchanged
+	if len(mapCollectors) != 1 || mapCollectors["tap"] != tap {
+		t.Errorf("tap should not be changed: %v", mapCollectors)
+	}
+	if len(mapLoggers) != 1 || mapLoggers["out1"] != out1 {
+		t.Errorf("out1 should not be removed: %v", mapLoggers)
+	}
+	_, names := workers.GetRoutes(tap.(*workers.DNSMessage).GetDefaultRoutes())
+	if len(names) != 1 || names[0] != "out1" {
+		t.Errorf("tap should be routed to out1: %v", names)
+	}
+}
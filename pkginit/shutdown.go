@@ -0,0 +1,136 @@
+package pkginit
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/workers"
+	"github.com/dmachard/go-logger"
+)
+
+// GetStopOrder returns the workers ordered along the pipelines, from the collectors
+// to the loggers, so each stage is stopped after the stages sending messages to it
+func GetStopOrder(mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker, config *pkgconfig.Config) []workers.Worker {
+	order := []workers.Worker{}
+	added := make(map[string]bool)
+	add := func(name string) {
+		if added[name] {
+			return
+		}
+		if wrk, ok := getStanzaWorker(name, mapCollectors, mapLoggers); ok {
+			order = append(order, wrk)
+			added[name] = true
+		}
+	}
+
+	if IsPipelinesEnabled(config) {
+		// count the routes to each stanza
+		inDegree := make(map[string]int)
+		nextStanzas := make(map[string][]string)
+		for _, stanza := range config.Pipelines {
+			next := append(append([]string{}, stanza.RoutingPolicy.Forward...), stanza.RoutingPolicy.Dropped...)
+			for _, matchRoute := range stanza.RoutingPolicy.Routes {
+				next = append(next, matchRoute.Targets...)
+			}
+			for _, route := range next {
+				if route != stanza.Name {
+					inDegree[route]++
+					nextStanzas[stanza.Name] = append(nextStanzas[stanza.Name], route)
+				}
+			}
+		}
+
+		// stanzas without incoming routes first, then the next ones
+		queue := []string{}
+		for _, stanza := range config.Pipelines {
+			if inDegree[stanza.Name] == 0 {
+				queue = append(queue, stanza.Name)
+			}
+		}
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			add(name)
+			for _, route := range nextStanzas[name] {
+				inDegree[route]--
+				if inDegree[route] == 0 {
+					queue = append(queue, route)
+				}
+			}
+		}
+
+		// stanzas in a loop
+		for _, stanza := range config.Pipelines {
+			add(stanza.Name)
+		}
+	}
+
+	// collectors then loggers
+	names := []string{}
+	for name := range mapCollectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name)
+	}
+	names = names[:0]
+	for name := range mapLoggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name)
+	}
+	return order
+}
+
+// stopWorker waits until the input channel of the worker is empty then stops it,
+// the number of messages still buffered is returned if the deadline is reached.
+// A worker still blocked at the deadline can't be cancelled, it is logged as leaked.
+func stopWorker(wrk workers.Worker, deadline time.Time, logger *logger.Logger) int {
+	for len(wrk.GetInputChannel()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan bool)
+	go func() {
+		wrk.Stop()
+		close(done)
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return len(wrk.GetInputChannel())
+	case <-timer.C:
+		logger.Error("main - worker=[%s] not stopped before the deadline, leaked in background", wrk.GetName())
+		return len(wrk.GetInputChannel()) + len(wrk.GetOutputChannel())
+	}
+}
+
+// DrainWorker stops the worker after processing its buffered messages,
+// the number of lost messages is returned when the timeout is reached
+func DrainWorker(wrk workers.Worker, timeout time.Duration, logger *logger.Logger) int {
+	return stopWorker(wrk, time.Now().Add(timeout), logger)
+}
+
+// StopWorkers stops all workers along the pipelines within the drain timeout
+// and returns the number of lost messages
+func StopWorkers(mapLoggers map[string]workers.Worker, mapCollectors map[string]workers.Worker, config *pkgconfig.Config, logger *logger.Logger) int {
+	deadline := time.Now().Add(time.Duration(config.Global.Worker.DrainTimeout) * time.Second)
+
+	lost := 0
+	for _, wrk := range GetStopOrder(mapLoggers, mapCollectors, config) {
+		if n := stopWorker(wrk, deadline, logger); n > 0 {
+			logger.Warning("main - worker=[%s] stopped with %d message(s) lost", wrk.GetName(), n)
+			lost += n
+		}
+	}
+	if lost > 0 {
+		logger.Warning("main - drain timeout reached, %d message(s) lost", lost)
+	}
+	return lost
+}
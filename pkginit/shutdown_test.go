@@ -0,0 +1,108 @@
+package pkginit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/workers"
+	"github.com/dmachard/go-logger"
+)
+
+func getPipelinesForTest() []pkgconfig.ConfigPipelines {
+	return []pkgconfig.ConfigPipelines{
+		{Name: "out", Params: map[string]interface{}{"devnull": map[string]interface{}{}}},
+		{Name: "relay", Params: map[string]interface{}{"dnsmessage": map[string]interface{}{}}, RoutingPolicy: pkgconfig.PipelinesRouting{Forward: []string{"out"}}},
+		{Name: "tap", Params: map[string]interface{}{"dnsmessage": map[string]interface{}{}}, RoutingPolicy: pkgconfig.PipelinesRouting{Forward: []string{"relay"}, Dropped: []string{"out"}}},
+	}
+}
+
+func TestShutdown_GetStopOrder(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	config.Pipelines = getPipelinesForTest()
+
+	mapLoggers := make(map[string]workers.Worker)
+	mapCollectors := make(map[string]workers.Worker)
+	if err := InitPipelines(mapLoggers, mapCollectors, config, logger.New(false), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	order := GetStopOrder(mapLoggers, mapCollectors, config)
+	expected := []string{"tap", "relay", "out"}
+	if len(order) != len(expected) {
+		t.Fatalf("want %d workers, got %d", len(expected), len(order))
+	}
+	for i, wrk := range order {
+		if wrk.GetName() != expected[i] {
+			t.Errorf("position %d: want %s, got %s", i, expected[i], wrk.GetName())
+		}
+	}
+}
+
+func TestShutdown_StopWorkers(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	config.Global.Worker.DrainTimeout = 5
+	config.Pipelines = getPipelinesForTest()
+
+	mapLoggers := make(map[string]workers.Worker)
+	mapCollectors := make(map[string]workers.Worker)
+	if err := InitPipelines(mapLoggers, mapCollectors, config, logger.New(false), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, wrk := range mapLoggers {
+		go wrk.StartCollect()
+	}
+	for _, wrk := range mapCollectors {
+		go wrk.StartCollect()
+	}
+
+	// messages buffered in the first stage
+	for i := 0; i < 100; i++ {
+		mapCollectors["tap"].GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	}
+
+	if lost := StopWorkers(mapLoggers, mapCollectors, config, logger.New(false)); lost != 0 {
+		t.Errorf("no message should be lost, got %d", lost)
+	}
+	for name, wrk := range mapCollectors {
+		if len(wrk.GetInputChannel()) != 0 {
+			t.Errorf("worker %s not drained", name)
+		}
+	}
+}
+
+// blockedWorker is a worker never stopped
+type blockedWorker struct {
+	*workers.GenericWorker
+	release chan bool
+}
+
+func (w *blockedWorker) Stop() { <-w.release }
+
+func TestShutdown_DrainWorkerLeaked(t *testing.T) {
+	wrk := &blockedWorker{
+		GenericWorker: workers.NewGenericWorker(pkgconfig.GetDefaultConfig(), logger.New(false), "blocked", "", pkgconfig.DefaultBufferSize, pkgconfig.WorkerMonitorDisabled),
+		release:       make(chan bool),
+	}
+	defer close(wrk.release)
+	wrk.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+
+	logs := make(chan logger.LogEntry, 10)
+	lg := logger.New(false)
+	lg.SetOutputChannel(logs)
+
+	// the worker is not stopped before the timeout, the buffered message is lost
+	if lost := DrainWorker(wrk, 100*time.Millisecond, lg); lost != 1 {
+		t.Errorf("1 message lost expected, got %d", lost)
+	}
+	select {
+	case entry := <-logs:
+		if !strings.Contains(entry.Message, "worker=[blocked] not stopped") {
+			t.Errorf("unexpected log: %s", entry.Message)
+		}
+	default:
+		t.Errorf("leaked worker not logged")
+	}
+}
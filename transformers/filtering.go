@@ -6,6 +6,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
@@ -20,6 +21,7 @@ type FilteringTransform struct {
 	listFqdns, listKeepFqdns               map[string]bool
 	listDomainsRegex, listKeepDomainsRegex map[string]*regexp.Regexp
 	downsample, downsampleCount            int
+	downsampleMutex                        sync.Mutex
 }
 
 func NewFilteringTransform(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, instance int, nextWorkers []chan dnsutils.DNSMessage) *FilteringTransform {
@@ -377,6 +379,8 @@ func (t *FilteringTransform) downsampleFilter(dm *dnsutils.DNSMessage) (int, err
 	}
 
 	// Increment the downsampleCount for each processed DNS message.
+	t.downsampleMutex.Lock()
+	defer t.downsampleMutex.Unlock()
 	t.downsampleCount += 1
 
 	// Calculate the remainder once and add sampling rate to DNS message
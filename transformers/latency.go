@@ -100,6 +100,21 @@ func (mp *HashQueries) Delete(key uint64) {
 	delete(mp.kv, key)
 }
 
+// HashQuery computes the hash of the query ip, query port and dns id
+// used to match replies with queries
+func HashQuery(dm *dnsutils.DNSMessage) (uint64, bool) {
+	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
+	if len(dm.NetworkInfo.QueryIP) == 0 || queryport <= 0 || dm.DNS.MalformedPacket {
+		return 0, false
+	}
+
+	hashData := []string{dm.NetworkInfo.QueryIP, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.ID)}
+
+	hashfnv := fnv.New64a()
+	hashfnv.Write([]byte(strings.Join(hashData, "+")))
+	return hashfnv.Sum64(), true
+}
+
 // latency transformer
 type LatencyTransform struct {
 	GenericTransformer
@@ -129,45 +144,36 @@ func (t *LatencyTransform) GetTransforms() ([]Subtransform, error) {
 }
 
 func (t *LatencyTransform) measureLatency(dm *dnsutils.DNSMessage) (int, error) {
-	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
-	if len(dm.NetworkInfo.QueryIP) > 0 && queryport > 0 && !dm.DNS.MalformedPacket {
-		// compute the hash of the query
-		hashData := []string{dm.NetworkInfo.QueryIP, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.ID)}
-
-		hashfnv := fnv.New64a()
-		hashfnv.Write([]byte(strings.Join(hashData, "+")))
-
-		if dm.DNS.Type == dnsutils.DNSQuery || dm.DNS.Type == dnsutils.DNSQueryQuiet {
-			t.hashQueries.Set(hashfnv.Sum64(), dm.DNSTap.Timestamp)
-		} else {
-			key := hashfnv.Sum64()
-			value, ok := t.hashQueries.Get(key)
-			if ok {
-				t.hashQueries.Delete(key)
-				latency := float64(dm.DNSTap.Timestamp-value) / float64(1000000000)
-				dm.DNSTap.Latency = latency
-			}
+	// compute the hash of the query
+	key, ok := HashQuery(dm)
+	if !ok {
+		return ReturnKeep, nil
+	}
+
+	if dm.DNS.Type == dnsutils.DNSQuery || dm.DNS.Type == dnsutils.DNSQueryQuiet {
+		t.hashQueries.Set(key, dm.DNSTap.Timestamp)
+	} else {
+		value, ok := t.hashQueries.Get(key)
+		if ok {
+			t.hashQueries.Delete(key)
+			latency := float64(dm.DNSTap.Timestamp-value) / float64(1000000000)
+			dm.DNSTap.Latency = latency
 		}
 	}
 	return ReturnKeep, nil
 }
 
 func (t *LatencyTransform) detectEvictedTimeout(dm *dnsutils.DNSMessage) (int, error) {
+	// compute the hash of the query
+	key, ok := HashQuery(dm)
+	if !ok {
+		return ReturnKeep, nil
+	}
 
-	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
-	if len(dm.NetworkInfo.QueryIP) > 0 && queryport > 0 && !dm.DNS.MalformedPacket {
-		// compute the hash of the query
-		hashData := []string{dm.NetworkInfo.QueryIP, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.ID)}
-
-		hashfnv := fnv.New64a()
-		hashfnv.Write([]byte(strings.Join(hashData, "+")))
-		key := hashfnv.Sum64()
-
-		if dm.DNS.Type == dnsutils.DNSQuery || dm.DNS.Type == dnsutils.DNSQueryQuiet {
-			t.mapQueries.Set(key, *dm)
-		} else if t.mapQueries.Exists(key) {
-			t.mapQueries.Delete(key)
-		}
+	if dm.DNS.Type == dnsutils.DNSQuery || dm.DNS.Type == dnsutils.DNSQueryQuiet {
+		t.mapQueries.Set(key, *dm)
+	} else if t.mapQueries.Exists(key) {
+		t.mapQueries.Delete(key)
 	}
 	return ReturnKeep, nil
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -17,6 +18,7 @@ import (
 )
 
 type NewDomainTracker struct {
+	sync.Mutex
 	ttl             time.Duration                    // Time window to consider a domain as "new"
 	cache           *expirable.LRU[string, struct{}] // Expirable LRU Cache
 	whitelist       map[string]*regexp.Regexp        // Whitelisted domains
@@ -66,7 +68,10 @@ func (ndt *NewDomainTracker) IsNewDomain(domain string) bool {
 		return false
 	}
 
-	// Check if the domain exists in the cache
+	// Check if the domain exists in the cache, then add it in the same step
+	// to detect the domain only once when the messages are processed in parallel
+	ndt.Lock()
+	defer ndt.Unlock()
 	if _, exists := ndt.cache.Get(domain); exists {
 		// Domain was recently seen, not new
 		return false
@@ -8,6 +8,7 @@ import (
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-logger"
+	"golang.org/x/net/idna"
 	publicsuffixlist "golang.org/x/net/publicsuffix"
 )
 
@@ -47,6 +48,119 @@ func processRecords(records []dnsutils.DNSAnswer) {
 	}
 }
 
+// IdnaToUnicode returns the Unicode form of the domain name,
+// the name is returned as is if it does not contain any punycode label
+func IdnaToUnicode(name string) string {
+	if !strings.Contains(strings.ToLower(name), "xn--") {
+		return name
+	}
+	unicodeName, err := idna.Display.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return unicodeName
+}
+
+// scripts which can be mixed in a label, according to the
+// highly restrictive level of the Unicode security mechanisms (UTS #39)
+var allowedScriptsSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// commonScripts are checked first, before the full list of scripts
+var commonScripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Greek", unicode.Greek},
+	{"Han", unicode.Han},
+	{"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+	{"Arabic", unicode.Arabic},
+	{"Hebrew", unicode.Hebrew},
+	{"Thai", unicode.Thai},
+	{"Devanagari", unicode.Devanagari},
+	{"Armenian", unicode.Armenian},
+	{"Georgian", unicode.Georgian},
+}
+
+// runeScript returns the script of the character, Common and Inherited are ignored
+func runeScript(r rune) string {
+	if r < unicode.MaxASCII {
+		if unicode.IsLetter(r) {
+			return "Latin"
+		}
+		return ""
+	}
+	if unicode.In(r, unicode.Common, unicode.Inherited) {
+		return ""
+	}
+	for _, script := range commonScripts {
+		if unicode.Is(script.table, r) {
+			return script.name
+		}
+	}
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// IsMixedScript returns true if one label of the domain name
+// mixes characters from several scripts, like Latin and Cyrillic
+func IsMixedScript(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		scripts := make(map[string]bool)
+		for _, r := range label {
+			if script := runeScript(r); script != "" {
+				scripts[script] = true
+			}
+		}
+		if len(scripts) <= 1 {
+			continue
+		}
+
+		allowed := false
+		for _, set := range allowedScriptsSets {
+			matched := 0
+			for _, script := range set {
+				if scripts[script] {
+					matched++
+				}
+			}
+			if matched == len(scripts) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func processRecordsIdna(records []dnsutils.DNSAnswer) {
+	for i := range records {
+		records[i].Name = IdnaToUnicode(records[i].Name)
+		switch records[i].Rdatatype {
+		case "CNAME", "DNAME", "SOA", "NS", "MX", "PTR", "SRV":
+			fields := strings.Split(records[i].Rdata, " ")
+			for j := range fields {
+				fields[j] = IdnaToUnicode(fields[j])
+			}
+			records[i].Rdata = strings.Join(fields, " ")
+		}
+	}
+}
+
 type NormalizeTransform struct {
 	GenericTransformer
 }
@@ -67,6 +181,12 @@ func (t *NormalizeTransform) GetTransforms() ([]Subtransform, error) {
 	if t.config.Normalize.Enable && t.config.Normalize.QnameLowerCase {
 		subprocessors = append(subprocessors, Subtransform{name: "normalize:qname-lowercase", processFunc: t.QnameLowercase})
 	}
+	if t.config.Normalize.Enable && t.config.Normalize.QnameIdnaDecode {
+		subprocessors = append(subprocessors, Subtransform{name: "normalize:qname-idna-decode", processFunc: t.QnameIdnaDecode})
+	}
+	if t.config.Normalize.Enable && t.config.Normalize.RRIdnaDecode {
+		subprocessors = append(subprocessors, Subtransform{name: "normalize:rr-idna-decode", processFunc: t.RRIdnaDecode})
+	}
 	if t.config.Normalize.Enable && t.config.Normalize.QuietText {
 		subprocessors = append(subprocessors, Subtransform{name: "normalize:quiet", processFunc: t.QuietText})
 	}
@@ -91,6 +211,23 @@ func (t *NormalizeTransform) RRLowercase(dm *dnsutils.DNSMessage) (int, error) {
 	return ReturnKeep, nil
 }
 
+func (t *NormalizeTransform) QnameIdnaDecode(dm *dnsutils.DNSMessage) (int, error) {
+	// the wire form of the qname is kept intact
+	qnameUnicode := IdnaToUnicode(dm.DNS.Qname)
+	dm.Idna = &dnsutils.TransformIdna{
+		QnameUnicode: qnameUnicode,
+		MixedScript:  IsMixedScript(qnameUnicode),
+	}
+	return ReturnKeep, nil
+}
+
+func (t *NormalizeTransform) RRIdnaDecode(dm *dnsutils.DNSMessage) (int, error) {
+	processRecordsIdna(dm.DNS.DNSRRs.Answers)
+	processRecordsIdna(dm.DNS.DNSRRs.Nameservers)
+	processRecordsIdna(dm.DNS.DNSRRs.Records)
+	return ReturnKeep, nil
+}
+
 func (t *NormalizeTransform) ReplaceNonprintable(dm *dnsutils.DNSMessage) (int, error) {
 
 	var builder strings.Builder
@@ -323,3 +323,107 @@ func BenchmarkNormalize_QuietText(b *testing.B) {
 		subprocessor.QuietText(&dm)
 	}
 }
+
+func BenchmarkNormalize_IsMixedScript(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		IsMixedScript("аpple.日本語テスト.münchen.com")
+	}
+}
+
+func TestNormalize_RuneScript(t *testing.T) {
+	tests := map[rune]string{'a': "Latin", 'ü': "Latin", 'а': "Cyrillic", '日': "Han", 'テ': "Katakana", 'ᚠ': "Runic", '-': ""}
+	for r, want := range tests {
+		if got := runeScript(r); got != want {
+			t.Errorf("script of %q want %s, got %s", r, want, got)
+		}
+	}
+}
+
+func TestNormalize_QnameIdnaDecode(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Normalize.Enable = true
+	config.Normalize.QnameIdnaDecode = true
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init the processor
+	normTransformer := NewNormalizeTransform(config, logger.New(false), "test", 0, outChans)
+
+	testcases := []struct {
+		qname        string
+		qnameUnicode string
+		mixedScript  bool
+	}{
+		{qname: "www.google.com", qnameUnicode: "www.google.com", mixedScript: false},
+		{qname: "xn--mnchen-3ya.de", qnameUnicode: "münchen.de", mixedScript: false},
+		{qname: "xn--80ak6aa92e.com", qnameUnicode: "аррӏе.com", mixedScript: false},
+		{qname: "xn--pple-43d.com", qnameUnicode: "аpple.com", mixedScript: true},
+		{qname: "xn--zckzah9945czlbtz6h.jp", qnameUnicode: "日本語テスト.jp", mixedScript: false},
+		{qname: "xn--999999999.com", qnameUnicode: "xn--999999999.com", mixedScript: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.qname, func(t *testing.T) {
+			dm := dnsutils.GetFakeDNSMessage()
+			dm.DNS.Qname = tc.qname
+
+			returnCode, err := normTransformer.QnameIdnaDecode(&dm)
+			if err != nil {
+				t.Errorf("process transform err %s", err.Error())
+			}
+			if returnCode != ReturnKeep {
+				t.Errorf("Return code is %v and not RETURN_KEEP (%v)", returnCode, ReturnKeep)
+			}
+
+			if dm.DNS.Qname != tc.qname {
+				t.Errorf("Qname should be kept intact, got %s", dm.DNS.Qname)
+			}
+			if dm.Idna.QnameUnicode != tc.qnameUnicode {
+				t.Errorf("Want %s, got %s", tc.qnameUnicode, dm.Idna.QnameUnicode)
+			}
+			if dm.Idna.MixedScript != tc.mixedScript {
+				t.Errorf("Mixed script want %v, got %v", tc.mixedScript, dm.Idna.MixedScript)
+			}
+		})
+	}
+}
+
+func TestNormalize_RRIdnaDecode(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Normalize.Enable = true
+	config.Normalize.RRIdnaDecode = true
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init the processor
+	normTransformer := NewNormalizeTransform(config, logger.New(false), "test", 0, outChans)
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.DNSRRs.Answers = append(dm.DNS.DNSRRs.Answers,
+		dnsutils.DNSAnswer{Name: "xn--mnchen-3ya.de", Rdatatype: "CNAME", Rdata: "www.xn--mnchen-3ya.de"},
+		dnsutils.DNSAnswer{Name: "xn--mnchen-3ya.de", Rdatatype: "MX", Rdata: "10 mx.xn--mnchen-3ya.de"},
+		dnsutils.DNSAnswer{Name: "xn--mnchen-3ya.de", Rdatatype: "TXT", Rdata: "xn--mnchen-3ya"},
+	)
+
+	returnCode, err := normTransformer.RRIdnaDecode(&dm)
+	if err != nil {
+		t.Errorf("process transform err %s", err.Error())
+	}
+	if returnCode != ReturnKeep {
+		t.Errorf("Return code is %v and not RETURN_KEEP (%v)", returnCode, ReturnKeep)
+	}
+
+	for _, an := range dm.DNS.DNSRRs.Answers {
+		if an.Name != "münchen.de" {
+			t.Errorf("invalid name, got %s", an.Name)
+		}
+	}
+	if dm.DNS.DNSRRs.Answers[0].Rdata != "www.münchen.de" || dm.DNS.DNSRRs.Answers[1].Rdata != "10 mx.münchen.de" {
+		t.Errorf("invalid rdata, got %v", dm.DNS.DNSRRs.Answers)
+	}
+	if dm.DNS.DNSRRs.Answers[2].Rdata != "xn--mnchen-3ya" {
+		t.Errorf("TXT rdata should be kept intact, got %s", dm.DNS.DNSRRs.Answers[2].Rdata)
+	}
+}
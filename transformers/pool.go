@@ -0,0 +1,71 @@
+package transformers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+)
+
+// TransformsPool runs the transforms with several goroutines.
+// Without shard key, the workers read a shared queue and the messages are processed
+// by the first available worker. With a shard key, each worker has its own queue
+// and the messages with the same key value are processed in order by the same worker.
+type TransformsPool struct {
+	queues   []chan dnsutils.DNSMessage
+	shardKey string
+	wg       sync.WaitGroup
+}
+
+func NewTransformsPool(workers int, shardKey string, process func(dm dnsutils.DNSMessage)) *TransformsPool {
+	pool := &TransformsPool{shardKey: shardKey}
+
+	nbQueues := 1
+	if len(shardKey) > 0 {
+		nbQueues = workers
+	}
+	for i := 0; i < nbQueues; i++ {
+		pool.queues = append(pool.queues, make(chan dnsutils.DNSMessage, pkgconfig.DefaultBufferSize))
+	}
+
+	for i := 0; i < workers; i++ {
+		queue := pool.queues[i%nbQueues]
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for dm := range queue {
+				process(dm)
+			}
+		}()
+	}
+	return pool
+}
+
+// Dispatch queues the message to the worker, blocks while the queue is full
+func (pool *TransformsPool) Dispatch(dm dnsutils.DNSMessage) {
+	queue := pool.queues[0]
+	if len(pool.queues) > 1 {
+		queue = pool.queues[ShardIndex(&dm, pool.shardKey, len(pool.queues))]
+	}
+	queue <- dm
+}
+
+// Stop waits for the messages already queued to be processed then stops the workers
+func (pool *TransformsPool) Stop() {
+	for _, queue := range pool.queues {
+		close(queue)
+	}
+	pool.wg.Wait()
+}
+
+// ShardIndex returns the index of the worker for the message from the hash of the key value
+func ShardIndex(dm *dnsutils.DNSMessage, shardKey string, workers int) int {
+	hash := fnv.New64a()
+	if value, found := dnsutils.GetFieldByJSONTag(reflect.ValueOf(dm).Elem(), shardKey); found {
+		hash.Write([]byte(fmt.Sprint(value.Interface())))
+	}
+	return int(hash.Sum64() % uint64(workers))
+}
@@ -0,0 +1,112 @@
+package transformers
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func TestTransformsPool_ShardIndex(t *testing.T) {
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.NetworkInfo.QueryIP = "192.168.1.1"
+
+	// the same key value is always processed by the same worker
+	index := ShardIndex(&dm, "network.query-ip", 4)
+	for i := 0; i < 10; i++ {
+		dm.DNS.Qname = strconv.Itoa(i) + ".dns.collector"
+		if ShardIndex(&dm, "network.query-ip", 4) != index {
+			t.Fatalf("message %d sent to another worker", i)
+		}
+	}
+
+	// the key values are spread on all workers
+	workers := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		dm.NetworkInfo.QueryIP = "10.0.0." + strconv.Itoa(i)
+		workers[ShardIndex(&dm, "network.query-ip", 4)] = true
+	}
+	if len(workers) != 4 {
+		t.Errorf("messages sent to %d workers, want 4", len(workers))
+	}
+}
+
+func TestTransforms_ProcessWorkers(t *testing.T) {
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Workers = 4
+	config.WorkersShardKey = "network.query-ip"
+	config.Normalize.Enable = true
+	config.Normalize.QnameLowerCase = true
+
+	subprocessors := NewTransforms(config, logger.New(false), "test", []chan dnsutils.DNSMessage{}, 0)
+
+	// the handler is called by the workers
+	var mu sync.Mutex
+	processed := make(map[string][]int)
+	subprocessors.Start(func(dm *dnsutils.DNSMessage, result int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if dm.DNS.Qname != "www.google.com" {
+			t.Errorf("message not transformed: %s", dm.DNS.Qname)
+		}
+		processed[dm.NetworkInfo.QueryIP] = append(processed[dm.NetworkInfo.QueryIP], dm.DNS.ID)
+	})
+
+	for i := 0; i < 1000; i++ {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = CapsAddress
+		dm.DNS.ID = i
+		dm.NetworkInfo.QueryIP = "10.0.0." + strconv.Itoa(i%10)
+		subprocessors.Process(dm)
+	}
+
+	// the queued messages are processed before to stop
+	subprocessors.Reset()
+
+	total := 0
+	for queryIP, ids := range processed {
+		total += len(ids)
+		// messages of a client are processed in order
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Fatalf("messages of %s not processed in order", queryIP)
+			}
+		}
+	}
+	if total != 1000 {
+		t.Errorf("%d messages processed, want 1000", total)
+	}
+}
+
+func TestTransforms_ProcessWorkersSharedState(t *testing.T) {
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Workers = 8
+	config.NewDomainTracker.Enable = true
+
+	subprocessors := NewTransforms(config, logger.New(false), "test", []chan dnsutils.DNSMessage{}, 0)
+
+	// a new domain is detected once whatever the worker
+	var mu sync.Mutex
+	kept := 0
+	subprocessors.Start(func(dm *dnsutils.DNSMessage, result int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if result == ReturnKeep {
+			kept++
+		}
+	})
+
+	for i := 0; i < 1000; i++ {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = strconv.Itoa(i%10) + ".dns.collector"
+		subprocessors.Process(dm)
+	}
+	subprocessors.Reset()
+
+	if kept != 10 {
+		t.Errorf("%d new domains detected, want 10", kept)
+	}
+}
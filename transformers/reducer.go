@@ -105,7 +105,6 @@ func (mp *MapTraffic) ProcessExpiredKeys() {
 type ReducerTransform struct {
 	GenericTransformer
 	mapTraffic MapTraffic
-	strBuilder strings.Builder
 }
 
 func NewReducerTransform(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, instance int, nextWorkers []chan dnsutils.DNSMessage) *ReducerTransform {
@@ -134,7 +133,7 @@ func (t *ReducerTransform) repetitiveTrafficDetector(dm *dnsutils.DNSMessage) (i
 		dm.Reducer = &dnsutils.TransformReducer{}
 	}
 
-	t.strBuilder.Reset()
+	var strBuilder strings.Builder
 
 	// update qname ?
 	if t.config.Reducer.QnamePlusOne {
@@ -151,7 +150,7 @@ func (t *ReducerTransform) repetitiveTrafficDetector(dm *dnsutils.DNSMessage) (i
 			// Check if the field's kind is either int or string
 			switch value.Kind() {
 			case reflect.Int, reflect.String:
-				t.strBuilder.WriteString(fmt.Sprintf("%v", value.Interface())) // Append field value
+				strBuilder.WriteString(fmt.Sprintf("%v", value.Interface())) // Append field value
 			default:
 				// Skip unsupported types
 				continue
@@ -159,7 +158,7 @@ func (t *ReducerTransform) repetitiveTrafficDetector(dm *dnsutils.DNSMessage) (i
 		}
 	}
 
-	dmTag := t.strBuilder.String()
+	dmTag := strBuilder.String()
 
 	dmCopy := *dm
 	t.mapTraffic.Set(dmTag, &dmCopy)
@@ -51,9 +51,10 @@ func (t *ReorderingTransform) ReorderLogs(dm *dnsutils.DNSMessage) (int, error)
 	// Add the log to the buffer.
 	t.mutex.Lock()
 	t.buffer = append(t.buffer, *dm)
+	full := len(t.buffer) >= t.config.Reordering.MaxBufferSize
 	t.mutex.Unlock()
 	// If the buffer exceeds a certain size, flush it.
-	if len(t.buffer) >= t.config.Reordering.MaxBufferSize {
+	if full {
 		select {
 		case t.flushSignal <- struct{}{}:
 		default:
@@ -0,0 +1,491 @@
+package transformers
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+var (
+	ThreatIntelFormatRPZ       = "rpz"
+	ThreatIntelFormatDomains   = "domains"
+	ThreatIntelFormatIPs       = "ips"
+	ThreatIntelFormatClientIPs = "client-ips"
+
+	ThreatIntelTriggerQname    = "QNAME"
+	ThreatIntelTriggerIP       = "IP"
+	ThreatIntelTriggerNsdname  = "NSDNAME"
+	ThreatIntelTriggerClientIP = "CLIENT-IP"
+
+	RpzActionNxdomain  = "NXDOMAIN"
+	RpzActionNodata    = "NODATA"
+	RpzActionPassthru  = "PASSTHRU"
+	RpzActionDrop      = "DROP"
+	RpzActionTCPOnly   = "TCP-ONLY"
+	RpzActionLocalData = "LOCAL-DATA"
+
+	// default origin used to parse RPZ zones without $ORIGIN
+	rpzDefaultOrigin = "rpz.dnscollector."
+)
+
+// set of domains, exact names and names matching all subdomains
+type threatIntelDomains struct {
+	names     map[string]string
+	wildcards map[string]string
+}
+
+func newThreatIntelDomains() threatIntelDomains {
+	return threatIntelDomains{names: make(map[string]string), wildcards: make(map[string]string)}
+}
+
+// lookup returns the action of the domain, the exact name first then the closest wildcard
+func (d *threatIntelDomains) lookup(name string) (string, bool) {
+	if action, found := d.names[name]; found {
+		return action, true
+	}
+	for i := strings.IndexByte(name, '.'); i != -1; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		if action, found := d.wildcards[name]; found {
+			return action, true
+		}
+	}
+	return "", false
+}
+
+// set of ip prefixes, the most specific prefix is matched first
+type threatIntelPrefixes struct {
+	prefixes map[netip.Prefix]string
+	bits     []int
+}
+
+func newThreatIntelPrefixes() threatIntelPrefixes {
+	return threatIntelPrefixes{prefixes: make(map[netip.Prefix]string)}
+}
+
+func (p *threatIntelPrefixes) add(prefix netip.Prefix, action string) {
+	prefix = prefix.Masked()
+	if !slices.Contains(p.bits, prefix.Bits()) {
+		p.bits = append(p.bits, prefix.Bits())
+		slices.Sort(p.bits)
+		slices.Reverse(p.bits)
+	}
+	p.prefixes[prefix] = action
+}
+
+func (p *threatIntelPrefixes) lookup(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	for _, bits := range p.bits {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if action, found := p.prefixes[prefix]; found {
+			return action, true
+		}
+	}
+	return "", false
+}
+
+// threat intelligence feed, loaded from a RPZ zone or a list
+type ThreatIntelFeed struct {
+	config    pkgconfig.ThreatIntelFeed
+	modTime   time.Time
+	qnames    threatIntelDomains
+	nsdnames  threatIntelDomains
+	ips       threatIntelPrefixes
+	clientIPs threatIntelPrefixes
+}
+
+func NewThreatIntelFeed(config pkgconfig.ThreatIntelFeed) *ThreatIntelFeed {
+	return &ThreatIntelFeed{
+		config:    config,
+		qnames:    newThreatIntelDomains(),
+		nsdnames:  newThreatIntelDomains(),
+		ips:       newThreatIntelPrefixes(),
+		clientIPs: newThreatIntelPrefixes(),
+	}
+}
+
+// Load reads the file of the feed, the number of entries is returned
+func (f *ThreatIntelFeed) Load() (int, error) {
+	info, err := os.Stat(f.config.File)
+	if err != nil {
+		return 0, err
+	}
+	f.modTime = info.ModTime()
+
+	file, err := os.Open(f.config.File)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	switch f.config.Format {
+	case ThreatIntelFormatRPZ:
+		return f.loadRPZ(file)
+	case ThreatIntelFormatDomains, ThreatIntelFormatIPs, ThreatIntelFormatClientIPs:
+		return f.loadList(file)
+	default:
+		return 0, fmt.Errorf("unsupported format: %s", f.config.Format)
+	}
+}
+
+func (f *ThreatIntelFeed) loadList(file *os.File) (int, error) {
+	entries := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch f.config.Format {
+		case ThreatIntelFormatDomains:
+			// the domain and all its subdomains
+			domain := strings.TrimSuffix(strings.ToLower(line), ".")
+			domain = strings.TrimPrefix(domain, "*.")
+			f.qnames.names[domain] = "-"
+			f.qnames.wildcards[domain] = "-"
+		default:
+			prefix, err := ParseIPOrPrefix(line)
+			if err != nil {
+				return entries, err
+			}
+			if f.config.Format == ThreatIntelFormatIPs {
+				f.ips.add(prefix, "-")
+			} else {
+				f.clientIPs.add(prefix, "-")
+			}
+		}
+		entries++
+	}
+	return entries, scanner.Err()
+}
+
+func (f *ThreatIntelFeed) loadRPZ(file *os.File) (int, error) {
+	entries := 0
+	apex := ""
+
+	zp := dns.NewZoneParser(file, rpzDefaultOrigin, f.config.File)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		// the zone apex is the owner of the SOA record
+		if rr.Header().Rrtype == dns.TypeSOA {
+			if len(apex) == 0 {
+				apex = strings.ToLower(rr.Header().Name)
+			}
+			continue
+		}
+		if rr.Header().Rrtype == dns.TypeNS {
+			continue
+		}
+		if len(apex) == 0 {
+			apex = rpzDefaultOrigin
+		}
+
+		owner := strings.ToLower(rr.Header().Name)
+		if !strings.HasSuffix(owner, "."+apex) {
+			continue
+		}
+		owner = strings.TrimSuffix(owner, "."+apex)
+		action := RpzAction(rr)
+
+		if err := f.addRPZTrigger(owner, action); err != nil {
+			return entries, fmt.Errorf("invalid trigger %s: %w", owner, err)
+		}
+		entries++
+	}
+	return entries, zp.Err()
+}
+
+func (f *ThreatIntelFeed) addRPZTrigger(owner string, action string) error {
+	switch {
+	case strings.HasSuffix(owner, ".rpz-client-ip"):
+		prefix, err := RpzIPTriggerToPrefix(strings.TrimSuffix(owner, ".rpz-client-ip"))
+		if err != nil {
+			return err
+		}
+		f.clientIPs.add(prefix, action)
+	case strings.HasSuffix(owner, ".rpz-ip"):
+		prefix, err := RpzIPTriggerToPrefix(strings.TrimSuffix(owner, ".rpz-ip"))
+		if err != nil {
+			return err
+		}
+		f.ips.add(prefix, action)
+	case strings.HasSuffix(owner, ".rpz-nsdname"):
+		addRPZDomain(&f.nsdnames, strings.TrimSuffix(owner, ".rpz-nsdname"), action)
+	case strings.HasSuffix(owner, ".rpz-nsip"):
+		// not supported, the ip of the name servers are not available
+		return nil
+	default:
+		addRPZDomain(&f.qnames, owner, action)
+	}
+	return nil
+}
+
+func addRPZDomain(domains *threatIntelDomains, name string, action string) {
+	if strings.HasPrefix(name, "*.") {
+		domains.wildcards[strings.TrimPrefix(name, "*.")] = action
+	} else {
+		domains.names[name] = action
+	}
+}
+
+// RpzAction returns the policy action of the RPZ record
+func RpzAction(rr dns.RR) string {
+	cname, ok := rr.(*dns.CNAME)
+	if !ok {
+		return RpzActionLocalData
+	}
+	switch strings.ToLower(cname.Target) {
+	case ".":
+		return RpzActionNxdomain
+	case "*.":
+		return RpzActionNodata
+	case "rpz-passthru.":
+		return RpzActionPassthru
+	case "rpz-drop.":
+		return RpzActionDrop
+	case "rpz-tcp-only.":
+		return RpzActionTCPOnly
+	default:
+		return RpzActionLocalData
+	}
+}
+
+// RpzIPTriggerToPrefix converts the RPZ encoding of an ip prefix,
+// for example 24.0.2.0.192 or 48.zz.db8.2001, to a prefix
+func RpzIPTriggerToPrefix(trigger string) (netip.Prefix, error) {
+	labels := strings.Split(trigger, ".")
+	if len(labels) < 2 {
+		return netip.Prefix{}, fmt.Errorf("too short")
+	}
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	parts := labels[1:]
+	slices.Reverse(parts)
+
+	var addr string
+	if len(parts) == 4 && !slices.Contains(parts, "zz") {
+		addr = strings.Join(parts, ".")
+	} else {
+		for i := range parts {
+			if parts[i] == "zz" {
+				parts[i] = ""
+			}
+		}
+		addr = strings.Join(parts, ":")
+		if strings.HasPrefix(addr, ":") {
+			addr = ":" + addr
+		}
+		if strings.HasSuffix(addr, ":") {
+			addr += ":"
+		}
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return ip.Prefix(bits)
+}
+
+// ParseIPOrPrefix parses an ip address or a prefix
+func ParseIPOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+type ThreatIntelTransform struct {
+	GenericTransformer
+	sync.RWMutex
+	feeds     []*ThreatIntelFeed
+	stopWatch chan bool
+}
+
+func NewThreatIntelTransform(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, instance int, nextWorkers []chan dnsutils.DNSMessage) *ThreatIntelTransform {
+	t := &ThreatIntelTransform{GenericTransformer: NewTransformer(config, logger, "threat-intel", name, instance, nextWorkers)}
+	return t
+}
+
+func (t *ThreatIntelTransform) GetTransforms() ([]Subtransform, error) {
+	subtransforms := []Subtransform{}
+
+	// stop the previous watcher on reload
+	t.stopWatcher()
+
+	if t.config.ThreatIntel.Enable {
+		if err := t.LoadFeeds(); err != nil {
+			return nil, err
+		}
+		if t.config.ThreatIntel.WatchInterval > 0 {
+			t.stopWatch = make(chan bool)
+			go t.watchFeeds(t.stopWatch, time.Duration(t.config.ThreatIntel.WatchInterval)*time.Second)
+		}
+		subtransforms = append(subtransforms, Subtransform{name: "threat-intel:match", processFunc: t.matchFeeds})
+	}
+	return subtransforms, nil
+}
+
+func (t *ThreatIntelTransform) Reset() {
+	t.stopWatcher()
+}
+
+func (t *ThreatIntelTransform) stopWatcher() {
+	if t.stopWatch != nil {
+		close(t.stopWatch)
+		t.stopWatch = nil
+	}
+}
+
+func (t *ThreatIntelTransform) LoadFeeds() error {
+	feeds := []*ThreatIntelFeed{}
+	for _, feedConfig := range t.config.ThreatIntel.Feeds {
+		feed := NewThreatIntelFeed(feedConfig)
+		entries, err := feed.Load()
+		if err != nil {
+			return fmt.Errorf("unable to load feed %s: %w", feedConfig.Name, err)
+		}
+		t.LogInfo("feed %s loaded with %d entries", feedConfig.Name, entries)
+		feeds = append(feeds, feed)
+	}
+
+	t.Lock()
+	t.feeds = feeds
+	t.Unlock()
+	return nil
+}
+
+// watchFeeds reloads the feeds when the files are modified
+func (t *ThreatIntelTransform) watchFeeds(stop chan bool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t.RLock()
+			feeds := slices.Clone(t.feeds)
+			t.RUnlock()
+
+			for i, feed := range feeds {
+				info, err := os.Stat(feed.config.File)
+				if err != nil || info.ModTime().Equal(feed.modTime) {
+					continue
+				}
+
+				newFeed := NewThreatIntelFeed(feed.config)
+				entries, err := newFeed.Load()
+				if err != nil {
+					t.LogError("unable to reload feed %s: %v", feed.config.Name, err)
+					continue
+				}
+				t.LogInfo("feed %s reloaded with %d entries", feed.config.Name, entries)
+
+				t.Lock()
+				if i < len(t.feeds) && t.feeds[i] == feed {
+					t.feeds[i] = newFeed
+				}
+				t.Unlock()
+			}
+		}
+	}
+}
+
+// match returns the trigger, the action and the value matched in the feed
+func (f *ThreatIntelFeed) match(dm *dnsutils.DNSMessage, qname string) (string, string, string) {
+	// client ip
+	if len(f.clientIPs.bits) > 0 {
+		if addr, err := netip.ParseAddr(dm.NetworkInfo.QueryIP); err == nil {
+			if action, found := f.clientIPs.lookup(addr); found {
+				return ThreatIntelTriggerClientIP, action, dm.NetworkInfo.QueryIP
+			}
+		}
+	}
+
+	// query name
+	if action, found := f.qnames.lookup(qname); found {
+		return ThreatIntelTriggerQname, action, qname
+	}
+
+	// ip addresses in the answers
+	if len(f.ips.bits) > 0 {
+		for _, rr := range dm.DNS.DNSRRs.Answers {
+			if rr.Rdatatype != "A" && rr.Rdatatype != "AAAA" {
+				continue
+			}
+			if addr, err := netip.ParseAddr(rr.Rdata); err == nil {
+				if action, found := f.ips.lookup(addr); found {
+					return ThreatIntelTriggerIP, action, rr.Rdata
+				}
+			}
+		}
+	}
+
+	// name servers
+	if len(f.nsdnames.names) > 0 || len(f.nsdnames.wildcards) > 0 {
+		for _, records := range [][]dnsutils.DNSAnswer{dm.DNS.DNSRRs.Answers, dm.DNS.DNSRRs.Nameservers} {
+			for _, rr := range records {
+				if rr.Rdatatype != "NS" {
+					continue
+				}
+				nsdname := strings.TrimSuffix(strings.ToLower(rr.Rdata), ".")
+				if action, found := f.nsdnames.lookup(nsdname); found {
+					return ThreatIntelTriggerNsdname, action, nsdname
+				}
+			}
+		}
+	}
+	return "", "", ""
+}
+
+func (t *ThreatIntelTransform) matchFeeds(dm *dnsutils.DNSMessage) (int, error) {
+	dm.ThreatIntel = &dnsutils.TransformThreatIntel{Feed: "-", Category: "-", Trigger: "-", Action: "-", Value: "-"}
+
+	qname := strings.TrimSuffix(strings.ToLower(dm.DNS.Qname), ".")
+
+	t.RLock()
+	defer t.RUnlock()
+
+	// the first matching feed wins
+	for _, feed := range t.feeds {
+		trigger, action, value := feed.match(dm, qname)
+		if len(trigger) == 0 {
+			continue
+		}
+		// passthru rules are used to exclude some triggers
+		if action == RpzActionPassthru {
+			break
+		}
+
+		dm.ThreatIntel.Matched = true
+		dm.ThreatIntel.Feed = feed.config.Name
+		dm.ThreatIntel.Category = feed.config.Category
+		dm.ThreatIntel.Trigger = trigger
+		dm.ThreatIntel.Action = action
+		dm.ThreatIntel.Value = value
+		break
+	}
+	return ReturnKeep, nil
+}
@@ -0,0 +1,189 @@
+package transformers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+const testRpzZone = `$TTL 300
+@ SOA localhost. root.localhost. 1 3600 600 86400 300
+  NS  localhost.
+malware.example.com         CNAME .
+*.phishing.example.com      CNAME *.
+allowed.phishing.example.com CNAME rpz-passthru.
+sinkhole.example.com        A 127.0.0.1
+32.1.0.0.10.rpz-ip          CNAME rpz-drop.
+24.0.2.0.192.rpz-client-ip  CNAME rpz-tcp-only.
+48.zz.db8.2001.rpz-ip       CNAME .
+ns.evil.example.rpz-nsdname CNAME .
+`
+
+func writeThreatIntelFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+	return path
+}
+
+func TestThreatIntel_RpzIPTriggerToPrefix(t *testing.T) {
+	testcases := []struct {
+		trigger string
+		prefix  string
+	}{
+		{trigger: "32.1.0.0.10", prefix: "10.0.0.1/32"},
+		{trigger: "24.0.2.0.192", prefix: "192.0.2.0/24"},
+		{trigger: "48.zz.db8.2001", prefix: "2001:db8::/48"},
+		{trigger: "128.1.zz.db8.2001", prefix: "2001:db8::1/128"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.trigger, func(t *testing.T) {
+			prefix, err := RpzIPTriggerToPrefix(tc.trigger)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if prefix.String() != tc.prefix {
+				t.Errorf("want %s, got %s", tc.prefix, prefix.String())
+			}
+		})
+	}
+
+	if _, err := RpzIPTriggerToPrefix("invalid"); err == nil {
+		t.Errorf("error expected for invalid trigger")
+	}
+}
+
+func TestThreatIntel_Match(t *testing.T) {
+	dir := t.TempDir()
+
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.ThreatIntel.Enable = true
+	config.ThreatIntel.WatchInterval = 0
+	config.ThreatIntel.Feeds = []pkgconfig.ThreatIntelFeed{
+		{Name: "rpz", Category: "malware", Format: ThreatIntelFormatRPZ, File: writeThreatIntelFile(t, dir, "db.rpz", testRpzZone)},
+		{Name: "blocklist", Category: "ads", Format: ThreatIntelFormatDomains, File: writeThreatIntelFile(t, dir, "domains.txt", "# ads\nads.example.org\n")},
+		{Name: "scanners", Category: "scanner", Format: ThreatIntelFormatClientIPs, File: writeThreatIntelFile(t, dir, "clients.txt", "1.2.3.0/24\n")},
+	}
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init the transformer
+	threatintel := NewThreatIntelTransform(config, logger.New(false), "test", 0, outChans)
+	if _, err := threatintel.GetTransforms(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer threatintel.Reset()
+
+	testcases := []struct {
+		name    string
+		qname   string
+		queryIP string
+		answers []dnsutils.DNSAnswer
+		matched bool
+		feed    string
+		trigger string
+		action  string
+		value   string
+	}{
+		{name: "no_match", qname: "www.example.net", queryIP: "8.8.8.8", feed: "-", trigger: "-", action: "-", value: "-"},
+		{name: "qname", qname: "malware.example.com.", queryIP: "8.8.8.8", matched: true, feed: "rpz", trigger: ThreatIntelTriggerQname, action: RpzActionNxdomain, value: "malware.example.com"},
+		{name: "qname_subdomain", qname: "www.malware.example.com", queryIP: "8.8.8.8", feed: "-", trigger: "-", action: "-", value: "-"},
+		{name: "qname_wildcard", qname: "login.phishing.example.com", queryIP: "8.8.8.8", matched: true, feed: "rpz", trigger: ThreatIntelTriggerQname, action: RpzActionNodata, value: "login.phishing.example.com"},
+		{name: "qname_wildcard_apex", qname: "phishing.example.com", queryIP: "8.8.8.8", feed: "-", trigger: "-", action: "-", value: "-"},
+		{name: "qname_passthru", qname: "allowed.phishing.example.com", queryIP: "8.8.8.8", feed: "-", trigger: "-", action: "-", value: "-"},
+		{name: "qname_local_data", qname: "sinkhole.example.com", queryIP: "8.8.8.8", matched: true, feed: "rpz", trigger: ThreatIntelTriggerQname, action: RpzActionLocalData, value: "sinkhole.example.com"},
+		{name: "client_ip", qname: "www.example.net", queryIP: "192.0.2.10", matched: true, feed: "rpz", trigger: ThreatIntelTriggerClientIP, action: RpzActionTCPOnly, value: "192.0.2.10"},
+		{
+			name: "answer_ipv4", qname: "www.example.net", queryIP: "8.8.8.8",
+			answers: []dnsutils.DNSAnswer{{Name: "www.example.net", Rdatatype: "A", Rdata: "10.0.0.1"}},
+			matched: true, feed: "rpz", trigger: ThreatIntelTriggerIP, action: RpzActionDrop, value: "10.0.0.1",
+		},
+		{
+			name: "answer_ipv6", qname: "www.example.net", queryIP: "8.8.8.8",
+			answers: []dnsutils.DNSAnswer{{Name: "www.example.net", Rdatatype: "AAAA", Rdata: "2001:db8::53"}},
+			matched: true, feed: "rpz", trigger: ThreatIntelTriggerIP, action: RpzActionNxdomain, value: "2001:db8::53",
+		},
+		{
+			name: "nsdname", qname: "www.example.net", queryIP: "8.8.8.8",
+			answers: []dnsutils.DNSAnswer{{Name: "example.net", Rdatatype: "NS", Rdata: "ns.evil.example"}},
+			matched: true, feed: "rpz", trigger: ThreatIntelTriggerNsdname, action: RpzActionNxdomain, value: "ns.evil.example",
+		},
+		{name: "list_domain", qname: "tracker.ads.example.org", queryIP: "8.8.8.8", matched: true, feed: "blocklist", trigger: ThreatIntelTriggerQname, action: "-", value: "tracker.ads.example.org"},
+		{name: "list_client_ip", qname: "www.example.net", queryIP: "1.2.3.4", matched: true, feed: "scanners", trigger: ThreatIntelTriggerClientIP, action: "-", value: "1.2.3.4"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := dnsutils.GetFakeDNSMessage()
+			dm.DNS.Qname = tc.qname
+			dm.NetworkInfo.QueryIP = tc.queryIP
+			dm.DNS.DNSRRs.Answers = tc.answers
+
+			returnCode, err := threatintel.matchFeeds(&dm)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if returnCode != ReturnKeep {
+				t.Errorf("Return code is %v, want keep(%v)", returnCode, ReturnKeep)
+			}
+			if dm.ThreatIntel.Matched != tc.matched || dm.ThreatIntel.Feed != tc.feed || dm.ThreatIntel.Trigger != tc.trigger ||
+				dm.ThreatIntel.Action != tc.action || dm.ThreatIntel.Value != tc.value {
+				t.Errorf("invalid threat-intel section: %v", dm.ThreatIntel)
+			}
+		})
+	}
+}
+
+func TestThreatIntel_ReloadOnChange(t *testing.T) {
+	dir := t.TempDir()
+	listFile := writeThreatIntelFile(t, dir, "domains.txt", "bad.example.com\n")
+
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.ThreatIntel.Enable = true
+	config.ThreatIntel.WatchInterval = 1
+	config.ThreatIntel.Feeds = []pkgconfig.ThreatIntelFeed{
+		{Name: "blocklist", Category: "malware", Format: ThreatIntelFormatDomains, File: listFile},
+	}
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init the transformer
+	threatintel := NewThreatIntelTransform(config, logger.New(false), "test", 0, outChans)
+	if _, err := threatintel.GetTransforms(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer threatintel.Reset()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Qname = "new.example.com"
+	threatintel.matchFeeds(&dm)
+	if dm.ThreatIntel.Matched {
+		t.Fatalf("unexpected match before reload")
+	}
+
+	// update the file and force a new modification time
+	writeThreatIntelFile(t, dir, "domains.txt", "bad.example.com\nnew.example.com\n")
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(listFile, future, future); err != nil {
+		t.Fatalf("unable to change file time: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		threatintel.matchFeeds(&dm)
+		if dm.ThreatIntel.Matched {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("feed not reloaded after change")
+}
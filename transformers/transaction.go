@@ -0,0 +1,154 @@
+package transformers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+var (
+	TransactionAnswered = "ANSWERED"
+	TransactionTimeout  = "TIMEOUT"
+	TransactionNoQuery  = "NO_QUERY"
+)
+
+// pending query waiting for its reply
+type transactionQuery struct {
+	dm    dnsutils.DNSMessage
+	timer *time.Timer
+	done  bool
+}
+
+// transaction transformer
+type TransactionTransform struct {
+	GenericTransformer
+	mutex   sync.Mutex
+	timeout time.Duration
+	queries map[uint64]*transactionQuery
+}
+
+func NewTransactionTransform(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, instance int, nextWorkers []chan dnsutils.DNSMessage) *TransactionTransform {
+	t := &TransactionTransform{GenericTransformer: NewTransformer(config, logger, "transaction", name, instance, nextWorkers)}
+	t.queries = make(map[uint64]*transactionQuery)
+	return t
+}
+
+func (t *TransactionTransform) GetTransforms() ([]Subtransform, error) {
+	t.timeout = time.Duration(t.config.Transaction.QueriesTimeout) * time.Second
+
+	subtransforms := []Subtransform{}
+	if t.config.Transaction.Enable {
+		subtransforms = append(subtransforms, Subtransform{name: "transaction:join", processFunc: t.joinQueryReply})
+	}
+	return subtransforms, nil
+}
+
+// Reset drops the pending queries
+func (t *TransactionTransform) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, query := range t.queries {
+		query.timer.Stop()
+		query.done = true
+		delete(t.queries, key)
+	}
+}
+
+func (t *TransactionTransform) joinQueryReply(dm *dnsutils.DNSMessage) (int, error) {
+	// compute the hash of the query
+	key, ok := HashQuery(dm)
+	if !ok {
+		return ReturnKeep, nil
+	}
+
+	// queries are kept until the reply or the timeout
+	if dm.DNS.Type == dnsutils.DNSQuery || dm.DNS.Type == dnsutils.DNSQueryQuiet {
+		t.addQuery(key, *dm)
+		return ReturnDrop, nil
+	}
+
+	t.mutex.Lock()
+	query, found := t.queries[key]
+	if found {
+		query.timer.Stop()
+		query.done = true
+		delete(t.queries, key)
+	}
+	t.mutex.Unlock()
+
+	// reply without query, kept as is
+	if !found {
+		dm.Transaction = &dnsutils.TransformTransaction{
+			Status:         TransactionNoQuery,
+			ReplyLength:    dm.DNS.Length,
+			ReplyTimestamp: dm.DNSTap.TimestampRFC3339,
+			ReplyFlags:     dm.DNS.Flags,
+		}
+		return ReturnKeep, nil
+	}
+
+	// merge the reply in the query
+	reply := *dm
+	*dm = query.dm
+	dm.DNS.Rcode = reply.DNS.Rcode
+	dm.DNS.AnCount = reply.DNS.AnCount
+	dm.DNS.NsCount = reply.DNS.NsCount
+	dm.DNS.ArCount = reply.DNS.ArCount
+	dm.DNS.DNSRRs = reply.DNS.DNSRRs
+	dm.DNS.MalformedPacket = dm.DNS.MalformedPacket || reply.DNS.MalformedPacket
+
+	dm.DNSTap.Latency = reply.DNSTap.Latency
+	if dm.DNSTap.Latency == 0 {
+		dm.DNSTap.Latency = float64(reply.DNSTap.Timestamp-dm.DNSTap.Timestamp) / float64(1000000000)
+	}
+
+	dm.Transaction = &dnsutils.TransformTransaction{
+		Status:         TransactionAnswered,
+		QueryLength:    dm.DNS.Length,
+		ReplyLength:    reply.DNS.Length,
+		ReplyTimestamp: reply.DNSTap.TimestampRFC3339,
+		ReplyFlags:     reply.DNS.Flags,
+	}
+	return ReturnKeep, nil
+}
+
+func (t *TransactionTransform) addQuery(key uint64, dm dnsutils.DNSMessage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// a query with the same key is replaced,
+	// the previous one is emitted on its own timeout
+	query := &transactionQuery{dm: dm}
+	query.timer = time.AfterFunc(t.timeout, func() {
+		t.expireQuery(key, query)
+	})
+	t.queries[key] = query
+}
+
+func (t *TransactionTransform) expireQuery(key uint64, query *transactionQuery) {
+	t.mutex.Lock()
+	if query.done {
+		t.mutex.Unlock()
+		return
+	}
+	query.done = true
+	if t.queries[key] == query {
+		delete(t.queries, key)
+	}
+	t.mutex.Unlock()
+
+	// emit the unanswered query
+	dm := query.dm
+	dm.DNS.Rcode = TransactionTimeout
+	dm.Transaction = &dnsutils.TransformTransaction{
+		Status:      TransactionTimeout,
+		QueryLength: dm.DNS.Length,
+	}
+	for i := range t.nextWorkers {
+		t.nextWorkers[i] <- dm
+	}
+}
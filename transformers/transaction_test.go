@@ -0,0 +1,117 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func TestTransaction_JoinQueryReply(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Transaction.Enable = true
+
+	outChannels := []chan dnsutils.DNSMessage{}
+
+	// init transformer
+	transaction := NewTransactionTransform(config, logger.New(true), "test", 0, outChannels)
+	transaction.GetTransforms()
+
+	// the query is kept
+	CQ := dnsutils.GetFakeDNSMessage()
+	CQ.DNS.Length = 30
+	CQ.DNS.Flags.RD = true
+	CQ.EDNS.UDPSize = 1232
+	CQ.DNSTap.Timestamp = 1704486841216166066
+	if result, _ := transaction.joinQueryReply(&CQ); result != ReturnDrop {
+		t.Errorf("query should be dropped")
+	}
+
+	// the reply is merged with the query
+	CR := dnsutils.GetFakeDNSMessage()
+	CR.DNS.Type = dnsutils.DNSReply
+	CR.DNS.Length = 46
+	CR.DNS.Rcode = "NXDOMAIN"
+	CR.DNS.Flags.QR = true
+	CR.DNS.Flags.RA = true
+	CR.DNS.DNSRRs.Answers = append(CR.DNS.DNSRRs.Answers, dnsutils.DNSAnswer{Name: "dns.collector", Rdatatype: "A", Rdata: "127.0.0.1"})
+	CR.DNSTap.Timestamp = 1704486841227961611
+	CR.DNSTap.TimestampRFC3339 = "2024-01-05T20:34:01.227961611Z"
+	if result, _ := transaction.joinQueryReply(&CR); result != ReturnKeep {
+		t.Errorf("transaction should be kept")
+	}
+
+	if CR.DNS.Type != dnsutils.DNSQuery || CR.DNS.Rcode != "NXDOMAIN" || len(CR.DNS.DNSRRs.Answers) != 1 {
+		t.Errorf("invalid dns transaction: %v", CR.DNS)
+	}
+	if !CR.DNS.Flags.RD || CR.DNS.Flags.QR || CR.EDNS.UDPSize != 1232 {
+		t.Errorf("query flags and edns expected: %v %v", CR.DNS.Flags, CR.EDNS)
+	}
+	if CR.DNSTap.Latency == 0.0 {
+		t.Errorf("incorrect latency, got 0.0")
+	}
+	if CR.Transaction.Status != TransactionAnswered || CR.Transaction.QueryLength != 30 || CR.Transaction.ReplyLength != 46 {
+		t.Errorf("invalid transaction section: %v", CR.Transaction)
+	}
+	if !CR.Transaction.ReplyFlags.QR || !CR.Transaction.ReplyFlags.RA || CR.Transaction.ReplyTimestamp != "2024-01-05T20:34:01.227961611Z" {
+		t.Errorf("invalid reply in transaction section: %v", CR.Transaction)
+	}
+}
+
+func TestTransaction_ReplyWithoutQuery(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Transaction.Enable = true
+
+	outChannels := []chan dnsutils.DNSMessage{}
+
+	// init transformer
+	transaction := NewTransactionTransform(config, logger.New(true), "test", 0, outChannels)
+	transaction.GetTransforms()
+
+	CR := dnsutils.GetFakeDNSMessage()
+	CR.DNS.Type = dnsutils.DNSReply
+	if result, _ := transaction.joinQueryReply(&CR); result != ReturnKeep {
+		t.Errorf("reply should be kept")
+	}
+	if CR.Transaction.Status != TransactionNoQuery {
+		t.Errorf("invalid transaction status: %s", CR.Transaction.Status)
+	}
+}
+
+func TestTransaction_Timeout(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Transaction.Enable = true
+	config.Transaction.QueriesTimeout = 1
+
+	outChannels := []chan dnsutils.DNSMessage{}
+	outChannels = append(outChannels, make(chan dnsutils.DNSMessage, 1))
+
+	// init transformer
+	transaction := NewTransactionTransform(config, logger.New(true), "test", 0, outChannels)
+	transaction.GetTransforms()
+
+	CQ := dnsutils.GetFakeDNSMessage()
+	transaction.joinQueryReply(&CQ)
+
+	select {
+	case dmTimeout := <-outChannels[0]:
+		if dmTimeout.DNS.Rcode != TransactionTimeout || dmTimeout.Transaction.Status != TransactionTimeout {
+			t.Errorf("timeout expected, got rcode=%s", dmTimeout.DNS.Rcode)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no timeout emitted")
+	}
+
+	// the reply after the timeout does not match anymore
+	CR := dnsutils.GetFakeDNSMessage()
+	CR.DNS.Type = dnsutils.DNSReply
+	transaction.joinQueryReply(&CR)
+	if CR.Transaction.Status != TransactionNoQuery {
+		t.Errorf("invalid transaction status: %s", CR.Transaction.Status)
+	}
+}
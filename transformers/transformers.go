@@ -2,6 +2,7 @@ package transformers
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
@@ -67,11 +68,18 @@ type Transforms struct {
 	availableTransforms     []TransformEntry
 	activeTransforms        []TransformEntry
 	activeProcessTransforms []func(dm *dnsutils.DNSMessage) (int, error)
+
+	// called with each transformed message
+	handler func(dm *dnsutils.DNSMessage, result int)
+	pool    *TransformsPool
+
+	// names of the active subtransforms, read by the admin api
+	names *atomic.Pointer[[]string]
 }
 
 func NewTransforms(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, nextWorkers []chan dnsutils.DNSMessage, instance int) Transforms {
 
-	d := Transforms{config: config, logger: logger, name: name, instance: instance}
+	d := Transforms{config: config, logger: logger, name: name, instance: instance, names: &atomic.Pointer[[]string]{}}
 
 	// order definition important
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewNormalizeTransform(config, logger, name, instance, nextWorkers)})
@@ -82,8 +90,11 @@ func NewTransforms(config *pkgconfig.ConfigTransformers, logger *logger.Logger,
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewUserPrivacyTransform(config, logger, name, instance, nextWorkers)})
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewExtractTransform(config, logger, name, instance, nextWorkers)})
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewSuspiciousTransform(config, logger, name, instance, nextWorkers)})
+	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewTyposquattingTransform(config, logger, name, instance, nextWorkers)})
+	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewThreatIntelTransform(config, logger, name, instance, nextWorkers)})
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewMachineLearningTransform(config, logger, name, instance, nextWorkers)})
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewLatencyTransform(config, logger, name, instance, nextWorkers)})
+	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewTransactionTransform(config, logger, name, instance, nextWorkers)})
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewDNSGeoIPTransform(config, logger, name, instance, nextWorkers)})
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewRewriteTransform(config, logger, name, instance, nextWorkers)})
 	d.availableTransforms = append(d.availableTransforms, TransformEntry{NewNewDomainTrackerTransform(config, logger, name, instance, nextWorkers)})
@@ -94,6 +105,9 @@ func NewTransforms(config *pkgconfig.ConfigTransformers, logger *logger.Logger,
 }
 
 func (p *Transforms) ReloadConfig(config *pkgconfig.ConfigTransformers) {
+	// the workers are stopped while the transforms are updated
+	p.stopWorkers()
+
 	p.config = config
 
 	for _, transform := range p.availableTransforms {
@@ -101,6 +115,33 @@ func (p *Transforms) ReloadConfig(config *pkgconfig.ConfigTransformers) {
 	}
 
 	p.Prepare()
+	p.startWorkers()
+}
+
+// Start defines the function called with the transformed messages and
+// starts the workers if the transforms are processed in parallel
+func (p *Transforms) Start(handler func(dm *dnsutils.DNSMessage, result int)) {
+	p.handler = handler
+	p.startWorkers()
+}
+
+func (p *Transforms) startWorkers() {
+	if p.handler == nil || p.config.Workers <= 1 {
+		return
+	}
+	p.pool = NewTransformsPool(p.config.Workers, p.config.WorkersShardKey, p.process)
+	if len(p.config.WorkersShardKey) > 0 {
+		p.LogInfo("transforms processed by %d workers, sharded by %s", p.config.Workers, p.config.WorkersShardKey)
+	} else {
+		p.LogInfo("transforms processed by %d workers", p.config.Workers)
+	}
+}
+
+func (p *Transforms) stopWorkers() {
+	if p.pool != nil {
+		p.pool.Stop()
+		p.pool = nil
+	}
 }
 
 func (p *Transforms) Prepare() error {
@@ -128,10 +169,20 @@ func (p *Transforms) Prepare() error {
 	if len(tranformsList) > 0 {
 		p.LogInfo("transformers applied: %v", tranformsList)
 	}
+	p.names.Store(&tranformsList)
 	return nil
 }
 
+// GetNames returns the names of the active subtransforms
+func (p *Transforms) GetNames() []string {
+	if names := p.names.Load(); names != nil {
+		return *names
+	}
+	return []string{}
+}
+
 func (p *Transforms) Reset() {
+	p.stopWorkers()
 	for _, transform := range p.activeTransforms {
 		transform.Reset()
 	}
@@ -156,3 +207,21 @@ func (p *Transforms) ProcessMessage(dm *dnsutils.DNSMessage) (int, error) {
 	}
 	return ReturnKeep, nil
 }
+
+// Process applies the transforms then calls the handler, the message is
+// processed by one of the workers when several workers are configured
+func (p *Transforms) Process(dm dnsutils.DNSMessage) {
+	if p.pool != nil {
+		p.pool.Dispatch(dm)
+		return
+	}
+	p.process(dm)
+}
+
+func (p *Transforms) process(dm dnsutils.DNSMessage) {
+	result, err := p.ProcessMessage(&dm)
+	if err != nil {
+		p.LogError(err.Error())
+	}
+	p.handler(&dm, result)
+}
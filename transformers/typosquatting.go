@@ -0,0 +1,249 @@
+package transformers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	publicsuffixlist "golang.org/x/net/publicsuffix"
+)
+
+var (
+	TyposquattingHomoglyph    = "homoglyph"
+	TyposquattingBitsquatting = "bitsquatting"
+	TyposquattingHyphenation  = "hyphenation"
+	TyposquattingTldSwap      = "tld-swap"
+	TyposquattingEditDistance = "edit-distance"
+
+	// confusable characters, replaced by their ascii lookalike
+	Homoglyphs = map[rune]string{
+		// cyrillic
+		'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'к': "k", 'м': "m", 'н': "h", 'о': "o", 'р': "p", 'с': "c",
+		'т': "t", 'у': "y", 'х': "x", 'ѕ': "s", 'і': "i", 'ї': "i", 'ј': "j", 'ԁ': "d", 'ӏ': "l", 'ԛ': "q", 'ԝ': "w",
+		'ո': "n", 'ս': "u", 'օ': "o",
+		// greek
+		'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o", 'ρ': "p", 'τ': "t",
+		'υ': "u", 'χ': "x", 'ω': "w",
+		// latin with diacritics
+		'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+		'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+		'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+		'ğ': "g", 'ġ': "g", 'ɡ': "g",
+		'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ı': "i",
+		'ł': "l", 'ľ': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+		'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+		'ŕ': "r", 'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ť': "t", 'ţ': "t",
+		'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+		'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+		// digits
+		'0': "o", '1': "l",
+	}
+
+	// sequences of ascii characters looking like another one
+	HomoglyphsSequences = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+)
+
+// HomoglyphSkeleton returns the domain name where the confusable characters
+// are replaced by their ascii lookalike
+func HomoglyphSkeleton(name string) string {
+	var skeleton strings.Builder
+	for _, r := range strings.ToLower(IdnaToUnicode(name)) {
+		if v, found := Homoglyphs[r]; found {
+			skeleton.WriteString(v)
+		} else {
+			skeleton.WriteRune(r)
+		}
+	}
+	return HomoglyphsSequences.Replace(skeleton.String())
+}
+
+// EditDistance returns the optimal string alignment distance between two strings,
+// the number of insertions, deletions, substitutions or transpositions of adjacent characters
+func EditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+// IsBitsquatting returns true if the strings differ by one bit flip in a single character
+func IsBitsquatting(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	diff := -1
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			if diff != -1 {
+				return false
+			}
+			diff = i
+		}
+	}
+	if diff == -1 {
+		return false
+	}
+	xor := a[diff] ^ b[diff]
+	return xor&(xor-1) == 0
+}
+
+// protected registered domain
+type protectedDomain struct {
+	domain   string
+	name     string
+	suffix   string
+	skeleton string
+}
+
+func newProtectedDomain(domain string) (protectedDomain, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	etldPlusOne, err := publicsuffixlist.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return protectedDomain{}, err
+	}
+	suffix, _ := publicsuffixlist.PublicSuffix(etldPlusOne)
+	return protectedDomain{
+		domain:   etldPlusOne,
+		name:     strings.TrimSuffix(etldPlusOne, "."+suffix),
+		suffix:   suffix,
+		skeleton: HomoglyphSkeleton(etldPlusOne),
+	}, nil
+}
+
+type TyposquattingTransform struct {
+	GenericTransformer
+	protectedDomains []protectedDomain
+}
+
+func NewTyposquattingTransform(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, instance int, nextWorkers []chan dnsutils.DNSMessage) *TyposquattingTransform {
+	t := &TyposquattingTransform{GenericTransformer: NewTransformer(config, logger, "typosquatting", name, instance, nextWorkers)}
+	return t
+}
+
+func (t *TyposquattingTransform) GetTransforms() ([]Subtransform, error) {
+	subtransforms := []Subtransform{}
+	if t.config.Typosquatting.Enable {
+		if err := t.LoadProtectedDomains(); err != nil {
+			return nil, err
+		}
+		subtransforms = append(subtransforms, Subtransform{name: "typosquatting:detect", processFunc: t.detectLookalike})
+	}
+	return subtransforms, nil
+}
+
+func (t *TyposquattingTransform) LoadProtectedDomains() error {
+	// before to start, reset the list
+	t.protectedDomains = t.protectedDomains[:0]
+
+	domains := append([]string{}, t.config.Typosquatting.ProtectedDomains...)
+	if len(t.config.Typosquatting.ProtectedDomainsFile) > 0 {
+		file, err := os.Open(t.config.Typosquatting.ProtectedDomainsFile)
+		if err != nil {
+			return fmt.Errorf("unable to open protected domains file: %w", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			domains = append(domains, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("unable to read protected domains file: %w", err)
+		}
+	}
+
+	for _, domain := range domains {
+		protected, err := newProtectedDomain(domain)
+		if err != nil {
+			t.LogError("invalid protected domain %s: %v", domain, err)
+			continue
+		}
+		t.protectedDomains = append(t.protectedDomains, protected)
+	}
+	t.LogInfo("loaded with %d protected domains", len(t.protectedDomains))
+	return nil
+}
+
+// checkLookalike returns the technique used if the domain is a lookalike of the protected one
+func (t *TyposquattingTransform) checkLookalike(domain, name, suffix, skeleton string, protected protectedDomain) (string, int) {
+	distance := EditDistance(domain, protected.domain)
+
+	switch {
+	case skeleton == protected.skeleton:
+		return TyposquattingHomoglyph, distance
+	case IsBitsquatting(domain, protected.domain):
+		return TyposquattingBitsquatting, distance
+	case suffix == protected.suffix && strings.Contains(name, "-") && strings.ReplaceAll(name, "-", "") == protected.name:
+		return TyposquattingHyphenation, distance
+	case name == protected.name && suffix != protected.suffix:
+		return TyposquattingTldSwap, distance
+	case distance <= t.config.Typosquatting.MaxEditDistance:
+		return TyposquattingEditDistance, distance
+	}
+	return "", distance
+}
+
+func (t *TyposquattingTransform) detectLookalike(dm *dnsutils.DNSMessage) (int, error) {
+	dm.Typosquatting = &dnsutils.TransformTyposquatting{Brand: "-", Technique: "-"}
+
+	// search the registered domain of the qname
+	qname := strings.TrimSuffix(strings.ToLower(dm.DNS.Qname), ".")
+	domain, err := publicsuffixlist.EffectiveTLDPlusOne(qname)
+	if err != nil {
+		return ReturnKeep, nil
+	}
+	suffix, _ := publicsuffixlist.PublicSuffix(domain)
+	name := strings.TrimSuffix(domain, "."+suffix)
+	skeleton := HomoglyphSkeleton(domain)
+
+	for _, protected := range t.protectedDomains {
+		// the protected domain itself
+		if domain == protected.domain {
+			return ReturnKeep, nil
+		}
+	}
+
+	for _, protected := range t.protectedDomains {
+		technique, distance := t.checkLookalike(domain, name, suffix, skeleton, protected)
+		if len(technique) == 0 {
+			continue
+		}
+
+		dm.Typosquatting.Detected = true
+		dm.Typosquatting.Brand = protected.domain
+		dm.Typosquatting.Technique = technique
+		dm.Typosquatting.Distance = distance
+
+		if t.config.Typosquatting.DropLookalikes {
+			return ReturnDrop, nil
+		}
+		break
+	}
+	return ReturnKeep, nil
+}
@@ -0,0 +1,134 @@
+package transformers
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func TestTyposquatting_Detect(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Typosquatting.Enable = true
+	config.Typosquatting.ProtectedDomains = []string{"paypal.com", "microsoft.com", "dnscollector.dev"}
+	config.Typosquatting.MaxEditDistance = 1
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init the transformer
+	typosquatting := NewTyposquattingTransform(config, logger.New(false), "test", 0, outChans)
+	if _, err := typosquatting.GetTransforms(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testcases := []struct {
+		qname     string
+		detected  bool
+		brand     string
+		technique string
+	}{
+		{qname: "www.paypal.com", detected: false, brand: "-", technique: "-"},
+		{qname: "www.google.com", detected: false, brand: "-", technique: "-"},
+		{qname: "xn--pypal-4ve.com", detected: true, brand: "paypal.com", technique: TyposquattingHomoglyph},
+		{qname: "login.paypa1.com", detected: true, brand: "paypal.com", technique: TyposquattingHomoglyph},
+		{qname: "rnicrosoft.com", detected: true, brand: "microsoft.com", technique: TyposquattingHomoglyph},
+		{qname: "microsnft.com", detected: true, brand: "microsoft.com", technique: TyposquattingBitsquatting},
+		{qname: "dns-collector.dev", detected: true, brand: "dnscollector.dev", technique: TyposquattingHyphenation},
+		{qname: "www.paypal.co.uk", detected: true, brand: "paypal.com", technique: TyposquattingTldSwap},
+		{qname: "paypall.com", detected: true, brand: "paypal.com", technique: TyposquattingEditDistance},
+		{qname: "pyapal.com", detected: true, brand: "paypal.com", technique: TyposquattingEditDistance},
+		{qname: "paypaaal.com", detected: false, brand: "-", technique: "-"},
+		{qname: "com", detected: false, brand: "-", technique: "-"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.qname, func(t *testing.T) {
+			dm := dnsutils.GetFakeDNSMessage()
+			dm.DNS.Qname = tc.qname
+
+			returnCode, err := typosquatting.detectLookalike(&dm)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if returnCode != ReturnKeep {
+				t.Errorf("Return code is %v, want keep(%v)", returnCode, ReturnKeep)
+			}
+			if dm.Typosquatting.Detected != tc.detected || dm.Typosquatting.Brand != tc.brand || dm.Typosquatting.Technique != tc.technique {
+				t.Errorf("invalid typosquatting section: %v", dm.Typosquatting)
+			}
+		})
+	}
+}
+
+func TestTyposquatting_DropLookalikes(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Typosquatting.Enable = true
+	config.Typosquatting.DropLookalikes = true
+
+	// protected domains from file
+	f, err := os.CreateTemp("", "protected_domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# protected brands\npaypal.com\n\ninvalid\n")
+	f.Close()
+	config.Typosquatting.ProtectedDomainsFile = f.Name()
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init the transformer
+	typosquatting := NewTyposquattingTransform(config, logger.New(false), "test", 0, outChans)
+	if _, err := typosquatting.GetTransforms(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(typosquatting.protectedDomains) != 1 {
+		t.Fatalf("one protected domain expected, got %d", len(typosquatting.protectedDomains))
+	}
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Qname = "paypa1.com"
+	if returnCode, _ := typosquatting.detectLookalike(&dm); returnCode != ReturnDrop {
+		t.Errorf("Return code is %v, want drop(%v)", returnCode, ReturnDrop)
+	}
+
+	dm = dnsutils.GetFakeDNSMessage()
+	dm.DNS.Qname = "paypal.com"
+	if returnCode, _ := typosquatting.detectLookalike(&dm); returnCode != ReturnKeep {
+		t.Errorf("Return code is %v, want keep(%v)", returnCode, ReturnKeep)
+	}
+}
+
+func TestTyposquatting_MissingFile(t *testing.T) {
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Typosquatting.Enable = true
+	config.Typosquatting.ProtectedDomainsFile = "/tmp/notexist"
+
+	typosquatting := NewTyposquattingTransform(config, logger.New(false), "test", 0, []chan dnsutils.DNSMessage{})
+	if _, err := typosquatting.GetTransforms(); err == nil {
+		t.Errorf("error expected with missing file")
+	}
+}
+
+func TestTyposquatting_EditDistance(t *testing.T) {
+	testcases := []struct {
+		a, b     string
+		distance int
+	}{
+		{a: "paypal", b: "paypal", distance: 0},
+		{a: "paypal", b: "paypall", distance: 1},
+		{a: "paypal", b: "pyapal", distance: 1},
+		{a: "paypal", b: "paypl", distance: 1},
+		{a: "paypal", b: "pavpa1", distance: 2},
+		{a: "", b: "abc", distance: 3},
+	}
+	for _, tc := range testcases {
+		if d := EditDistance(tc.a, tc.b); d != tc.distance {
+			t.Errorf("distance between %s and %s, want %d, got %d", tc.a, tc.b, tc.distance, d)
+		}
+	}
+}
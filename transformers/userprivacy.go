@@ -1,12 +1,23 @@
 package transformers
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"net"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
@@ -15,28 +26,168 @@ import (
 	"golang.org/x/net/publicsuffix"
 )
 
-func HashIP(ip string, algo string) string {
+func hashFunc(algo string) func() hash.Hash {
 	switch algo {
 	case "sha1":
-		hash := sha1.New()
-		hash.Write([]byte(ip))
-		return fmt.Sprintf("%x", hash.Sum(nil))
+		return sha1.New
 	case "sha256":
-		hash := sha256.New()
-		hash.Write([]byte(ip))
-		return fmt.Sprintf("%x", hash.Sum(nil))
-	case "sha512": // nolint
-		hash := sha512.New()
-		hash.Write([]byte(ip))
-		return fmt.Sprintf("%x", hash.Sum(nil))
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+// HashIP returns the hash of the IP, an empty string is returned with an unsupported algorithm
+func HashIP(ip string, algo string) string {
+	h := hashFunc(algo)
+	if h == nil {
+		return ""
+	}
+	hasher := h()
+	hasher.Write([]byte(ip))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// HmacHash returns the keyed hash of the value, an empty string is returned with an unsupported algorithm
+func HmacHash(value string, algo string, key []byte) string {
+	h := hashFunc(algo)
+	if h == nil {
+		return ""
+	}
+	mac := hmac.New(h, key)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// LoadHashSecret reads the secret from a file or from an environment variable
+func LoadHashSecret(secretFile, secretEnv string) ([]byte, error) {
+	switch {
+	case len(secretFile) > 0:
+		data, err := os.ReadFile(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("empty secret in file %s", secretFile)
+		}
+		return secret, nil
+	case len(secretEnv) > 0:
+		secret := strings.TrimSpace(os.Getenv(secretEnv))
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("empty secret in environment variable %s", secretEnv)
+		}
+		return []byte(secret), nil
 	default:
+		return nil, nil
+	}
+}
+
+// CryptoPan implements the prefix-preserving IP anonymization scheme
+// described by Xu, Fan, Ammar and Moon, extended to IPv6 addresses
+type CryptoPan struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+}
+
+// NewCryptoPan creates the anonymizer from a 32 bytes key, the first half is
+// used as AES key and the second half to generate the pad
+func NewCryptoPan(key []byte) (*CryptoPan, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key size %d, expect 32 bytes", len(key))
+	}
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	cp := &CryptoPan{block: block}
+	block.Encrypt(cp.pad[:], key[16:])
+	return cp, nil
+}
+
+// LoadCryptoPanKey reads the key from a file, 32 raw bytes or 64 hexadecimal characters
+func LoadCryptoPanKey(keyFile string) ([]byte, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, errors.New("invalid key, expect 32 bytes or 64 hexadecimal characters")
+	}
+	return key, nil
+}
+
+// Anonymize returns the anonymized address, two addresses sharing
+// a prefix of n bits are anonymized to addresses sharing a prefix of n bits
+func (cp *CryptoPan) Anonymize(ip net.IP) net.IP {
+	orig := ip.To4()
+	if orig == nil {
+		orig = ip.To16()
+	}
+	if orig == nil {
 		return ip
 	}
+
+	var input, output [aes.BlockSize]byte
+	result := make(net.IP, len(orig))
+	copy(input[:], cp.pad[:])
+
+	for pos := 0; pos < len(orig)*8; pos++ {
+		// the first bits are taken from the original address, the rest from the pad
+		if pos > 0 {
+			idx, mask := (pos-1)/8, byte(0x80)>>((pos-1)%8)
+			input[idx] = input[idx]&^mask | orig[idx]&mask
+		}
+		cp.block.Encrypt(output[:], input[:])
+		result[pos/8] |= (output[0] >> 7) << (7 - pos%8)
+	}
+
+	for i := range result {
+		result[i] ^= orig[i]
+	}
+	return result
+}
+
+// AnonymizeString anonymizes an IP address or a subnet with the format ip/mask or [ip]/mask
+func (cp *CryptoPan) AnonymizeString(value string) (string, error) {
+	addr, mask, isSubnet := strings.Cut(value, "/")
+	bracketed := strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]")
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return value, fmt.Errorf("not a valid ip: %v", value)
+	}
+
+	anonymized := cp.Anonymize(ip)
+	if !isSubnet {
+		return anonymized.String(), nil
+	}
+
+	// keep the subnet, the host part is set to zero
+	bits, err := strconv.Atoi(mask)
+	if err != nil {
+		return value, fmt.Errorf("not a valid subnet: %v", value)
+	}
+	anonymized = anonymized.Mask(net.CIDRMask(bits, len(anonymized)*8))
+	if bracketed {
+		return fmt.Sprintf("[%s]/%d", anonymized.String(), bits), nil
+	}
+	return fmt.Sprintf("%s/%d", anonymized.String(), bits), nil
 }
 
 type UserPrivacyTransform struct {
 	GenericTransformer
 	v4Mask, v6Mask net.IPMask
+	cryptoPan      *CryptoPan
+	hashSecret     []byte
+	hashDayMutex   sync.Mutex
+	hashDay        string
+	hashDayKey     []byte
 }
 
 func NewUserPrivacyTransform(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, instance int, nextWorkers []chan dnsutils.DNSMessage) *UserPrivacyTransform {
@@ -44,7 +195,25 @@ func NewUserPrivacyTransform(config *pkgconfig.ConfigTransformers, logger *logge
 	return t
 }
 
+// GetTransforms returns the privacy subtransforms, the messages are dropped when they can't be
+// initialized, so the data are never sent unprotected
 func (t *UserPrivacyTransform) GetTransforms() ([]Subtransform, error) {
+	subprocessors, err := t.getTransforms()
+	if err != nil {
+		t.LogError("%s, all messages are dropped", err)
+		return []Subtransform{{name: "userprivacy:drop-all", processFunc: t.dropAll}}, nil
+	}
+	return subprocessors, nil
+}
+
+func (t *UserPrivacyTransform) dropAll(dm *dnsutils.DNSMessage) (int, error) {
+	return ReturnDrop, nil
+}
+
+func (t *UserPrivacyTransform) getTransforms() ([]Subtransform, error) {
+	if err := t.config.CheckUserPrivacy(); err != nil {
+		return nil, err
+	}
 	subprocessors := []Subtransform{}
 
 	var err error
@@ -69,12 +238,57 @@ func (t *UserPrivacyTransform) GetTransforms() ([]Subtransform, error) {
 		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:minimaze-qname", processFunc: t.minimazeQname})
 	}
 
+	// the secret is loaded again on reload, to support the rotation
+	t.hashSecret, t.hashDay, t.hashDayKey = nil, "", nil
+	if t.config.IsHashEnabled() {
+		t.hashSecret, err = LoadHashSecret(t.config.UserPrivacy.HashSecretFile, t.config.UserPrivacy.HashSecretEnv)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load hash secret: %w", err)
+		}
+		if t.config.UserPrivacy.HashDailyRotation && t.hashSecret == nil {
+			return nil, fmt.Errorf("hash-daily-rotation requires a secret")
+		}
+	}
+
 	if t.config.UserPrivacy.HashQueryIP {
 		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:hash-query-ip", processFunc: t.hashQueryIP})
 	}
 	if t.config.UserPrivacy.HashReplyIP {
 		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:hash-reply-ip", processFunc: t.hashReplyIP})
 	}
+	if t.config.UserPrivacy.HashQname {
+		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:hash-qname", processFunc: t.hashQname})
+	}
+	if t.config.UserPrivacy.HashEcs {
+		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:hash-ecs", processFunc: t.hashEcs})
+	}
+	if t.config.UserPrivacy.HashPdnsRequestor {
+		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:hash-pdns-requestor-id", processFunc: t.hashPdnsRequestorID})
+	}
+	if t.config.UserPrivacy.HashPdnsDevice {
+		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:hash-pdns-device-id", processFunc: t.hashPdnsDeviceID})
+	}
+
+	if t.config.UserPrivacy.CryptoPanQueryIP || t.config.UserPrivacy.CryptoPanReplyIP || t.config.UserPrivacy.CryptoPanEcs {
+		// the key is loaded again on reload, to support the rotation
+		key, err := LoadCryptoPanKey(t.config.UserPrivacy.CryptoPanKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load cryptopan key: %w", err)
+		}
+		t.cryptoPan, err = NewCryptoPan(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to init cryptopan: %w", err)
+		}
+	}
+	if t.config.UserPrivacy.CryptoPanQueryIP {
+		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:cryptopan-query-ip", processFunc: t.cryptoPanQueryIP})
+	}
+	if t.config.UserPrivacy.CryptoPanReplyIP {
+		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:cryptopan-reply-ip", processFunc: t.cryptoPanReplyIP})
+	}
+	if t.config.UserPrivacy.CryptoPanEcs {
+		subprocessors = append(subprocessors, Subtransform{name: "userprivacy:cryptopan-ecs", processFunc: t.cryptoPanEcs})
+	}
 
 	return subprocessors, nil
 }
@@ -95,13 +309,110 @@ func (t *UserPrivacyTransform) anonymizeQueryIP(dm *dnsutils.DNSMessage) (int, e
 	return ReturnKeep, nil
 }
 
+// hashKey returns the key to use for the message, the secret or the key of the day
+func (t *UserPrivacyTransform) hashKey(dm *dnsutils.DNSMessage) []byte {
+	if !t.config.UserPrivacy.HashDailyRotation {
+		return t.hashSecret
+	}
+
+	ts := time.Now()
+	if dm.DNSTap.TimeSec > 0 {
+		ts = time.Unix(int64(dm.DNSTap.TimeSec), 0)
+	}
+	day := ts.UTC().Format(time.DateOnly)
+
+	t.hashDayMutex.Lock()
+	defer t.hashDayMutex.Unlock()
+	if day != t.hashDay {
+		// the key of the day is derived from the secret
+		mac := hmac.New(sha256.New, t.hashSecret)
+		mac.Write([]byte(day))
+		t.hashDay, t.hashDayKey = day, mac.Sum(nil)
+	}
+	return t.hashDayKey
+}
+
+// hashValue uses a keyed hash when a secret is configured
+func (t *UserPrivacyTransform) hashValue(dm *dnsutils.DNSMessage, value string) string {
+	if t.hashSecret == nil {
+		return HashIP(value, t.config.UserPrivacy.HashIPAlgo)
+	}
+	return HmacHash(value, t.config.UserPrivacy.HashIPAlgo, t.hashKey(dm))
+}
+
 func (t *UserPrivacyTransform) hashQueryIP(dm *dnsutils.DNSMessage) (int, error) {
-	dm.NetworkInfo.QueryIP = HashIP(dm.NetworkInfo.QueryIP, t.config.UserPrivacy.HashIPAlgo)
+	dm.NetworkInfo.QueryIP = t.hashValue(dm, dm.NetworkInfo.QueryIP)
 	return ReturnKeep, nil
 }
 
 func (t *UserPrivacyTransform) hashReplyIP(dm *dnsutils.DNSMessage) (int, error) {
-	dm.NetworkInfo.ResponseIP = HashIP(dm.NetworkInfo.ResponseIP, t.config.UserPrivacy.HashIPAlgo)
+	dm.NetworkInfo.ResponseIP = t.hashValue(dm, dm.NetworkInfo.ResponseIP)
+	return ReturnKeep, nil
+}
+
+func (t *UserPrivacyTransform) hashQname(dm *dnsutils.DNSMessage) (int, error) {
+	dm.DNS.Qname = t.hashValue(dm, dm.DNS.Qname)
+	return ReturnKeep, nil
+}
+
+func (t *UserPrivacyTransform) hashEcs(dm *dnsutils.DNSMessage) (int, error) {
+	for i := range dm.EDNS.Options {
+		if dm.EDNS.Options[i].Name == "CSUBNET" {
+			dm.EDNS.Options[i].Data = t.hashValue(dm, dm.EDNS.Options[i].Data)
+		}
+	}
+	return ReturnKeep, nil
+}
+
+func (t *UserPrivacyTransform) hashPdnsRequestorID(dm *dnsutils.DNSMessage) (int, error) {
+	if dm.PowerDNS == nil {
+		return ReturnKeep, nil
+	}
+	if len(dm.PowerDNS.RequestorID) > 0 {
+		dm.PowerDNS.RequestorID = t.hashValue(dm, dm.PowerDNS.RequestorID)
+	}
+	if len(dm.PowerDNS.InitialRequestorID) > 0 {
+		dm.PowerDNS.InitialRequestorID = t.hashValue(dm, dm.PowerDNS.InitialRequestorID)
+	}
+	return ReturnKeep, nil
+}
+
+func (t *UserPrivacyTransform) hashPdnsDeviceID(dm *dnsutils.DNSMessage) (int, error) {
+	if dm.PowerDNS != nil && len(dm.PowerDNS.DeviceID) > 0 {
+		dm.PowerDNS.DeviceID = t.hashValue(dm, dm.PowerDNS.DeviceID)
+	}
+	return ReturnKeep, nil
+}
+
+func (t *UserPrivacyTransform) cryptoPanQueryIP(dm *dnsutils.DNSMessage) (int, error) {
+	queryIP, err := t.cryptoPan.AnonymizeString(dm.NetworkInfo.QueryIP)
+	if err != nil {
+		return ReturnKeep, err
+	}
+	dm.NetworkInfo.QueryIP = queryIP
+	return ReturnKeep, nil
+}
+
+func (t *UserPrivacyTransform) cryptoPanReplyIP(dm *dnsutils.DNSMessage) (int, error) {
+	responseIP, err := t.cryptoPan.AnonymizeString(dm.NetworkInfo.ResponseIP)
+	if err != nil {
+		return ReturnKeep, err
+	}
+	dm.NetworkInfo.ResponseIP = responseIP
+	return ReturnKeep, nil
+}
+
+func (t *UserPrivacyTransform) cryptoPanEcs(dm *dnsutils.DNSMessage) (int, error) {
+	for i := range dm.EDNS.Options {
+		if dm.EDNS.Options[i].Name != "CSUBNET" {
+			continue
+		}
+		ecs, err := t.cryptoPan.AnonymizeString(dm.EDNS.Options[i].Data)
+		if err != nil {
+			return ReturnKeep, err
+		}
+		dm.EDNS.Options[i].Data = ecs
+	}
 	return ReturnKeep, nil
 }
 
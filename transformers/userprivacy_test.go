@@ -1,6 +1,11 @@
 package transformers
 
 import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -268,3 +273,341 @@ func TestUserPrivacy_AnonymizeIPRemove(t *testing.T) {
 		})
 	}
 }
+
+// key from the reference implementation of Crypto-PAn
+var testCryptoPanKey = []byte{21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16,
+	216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2}
+
+func TestUserPrivacy_CryptoPan(t *testing.T) {
+	cp, err := NewCryptoPan(testCryptoPanKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// reference values
+	testCases := []struct {
+		inputIP    string
+		expectedIP string
+	}{
+		{"128.11.68.132", "135.242.180.132"},
+		{"129.118.74.4", "134.136.186.123"},
+		{"130.132.252.244", "133.68.164.234"},
+		{"141.223.7.43", "141.167.8.160"},
+		{"141.233.145.108", "141.129.237.235"},
+		{"152.163.225.39", "151.140.114.167"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.inputIP, func(t *testing.T) {
+			anonymized := cp.Anonymize(net.ParseIP(tc.inputIP)).String()
+			if anonymized != tc.expectedIP {
+				t.Errorf("got %s, want %s", anonymized, tc.expectedIP)
+			}
+		})
+	}
+}
+
+func TestUserPrivacy_CryptoPan_PrefixPreserving(t *testing.T) {
+	cp, err := NewCryptoPan(testCryptoPanKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		ip1, ip2 string
+		bits     int
+	}{
+		{"192.168.1.2", "192.168.1.200", 24},
+		{"10.0.0.1", "10.255.0.1", 8},
+		{"2001:db8:1:2::1", "2001:db8:1:2:8000::1", 64},
+		{"2001:db8::1", "2001:db9::1", 31},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ip1, func(t *testing.T) {
+			ip1, ip2 := cp.Anonymize(net.ParseIP(tc.ip1)), cp.Anonymize(net.ParseIP(tc.ip2))
+			mask := net.CIDRMask(tc.bits, len(ip1)*8)
+			if !ip1.Mask(mask).Equal(ip2.Mask(mask)) {
+				t.Errorf("prefix not preserved: %s and %s", ip1, ip2)
+			}
+			// the next bit is different
+			next := net.CIDRMask(tc.bits+1, len(ip1)*8)
+			if ip1.Mask(next).Equal(ip2.Mask(next)) {
+				t.Errorf("prefix too long: %s and %s", ip1, ip2)
+			}
+		})
+	}
+}
+
+func TestUserPrivacy_CryptoPanTransform(t *testing.T) {
+	// write the key file in hexadecimal
+	keyFile := filepath.Join(t.TempDir(), "cryptopan.key")
+	if err := os.WriteFile(keyFile, []byte(fmt.Sprintf("%x\n", testCryptoPanKey)), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.CryptoPanQueryIP = true
+	config.UserPrivacy.CryptoPanReplyIP = true
+	config.UserPrivacy.CryptoPanEcs = true
+	config.UserPrivacy.CryptoPanKeyFile = keyFile
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init the processor
+	userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+	if _, err := userPrivacy.GetTransforms(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.NetworkInfo.QueryIP = "128.11.68.132"
+	dm.NetworkInfo.ResponseIP = "129.118.74.4"
+	dm.EDNS.Options = []dnsutils.DNSOption{
+		{Code: 8, Name: "CSUBNET", Data: "130.132.252.0/24"},
+		{Code: 8, Name: "CSUBNET", Data: "[2001:db8::]/48"},
+	}
+
+	userPrivacy.cryptoPanQueryIP(&dm)
+	userPrivacy.cryptoPanReplyIP(&dm)
+	if _, err := userPrivacy.cryptoPanEcs(&dm); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if dm.NetworkInfo.QueryIP != "135.242.180.132" {
+		t.Errorf("invalid query ip: %s", dm.NetworkInfo.QueryIP)
+	}
+	if dm.NetworkInfo.ResponseIP != "134.136.186.123" {
+		t.Errorf("invalid response ip: %s", dm.NetworkInfo.ResponseIP)
+	}
+	if dm.EDNS.Options[0].Data != "133.68.164.0/24" {
+		t.Errorf("invalid ecs: %s", dm.EDNS.Options[0].Data)
+	}
+	if !strings.HasPrefix(dm.EDNS.Options[1].Data, "[") || !strings.HasSuffix(dm.EDNS.Options[1].Data, "::]/48") {
+		t.Errorf("invalid ecs: %s", dm.EDNS.Options[1].Data)
+	}
+
+	// rotate the key on reload
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	userPrivacy.ReloadConfig(config)
+	if _, err := userPrivacy.GetTransforms(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dm.NetworkInfo.QueryIP = "128.11.68.132"
+	userPrivacy.cryptoPanQueryIP(&dm)
+	if dm.NetworkInfo.QueryIP == "135.242.180.132" {
+		t.Errorf("key not rotated")
+	}
+}
+
+func TestUserPrivacy_CryptoPanInvalidKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "cryptopan.key")
+	if err := os.WriteFile(keyFile, []byte("tooshort"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.CryptoPanQueryIP = true
+	config.UserPrivacy.CryptoPanKeyFile = keyFile
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+	checkUserPrivacyDropAll(t, userPrivacy)
+}
+
+// checkUserPrivacyDropAll checks the transform fails closed, all messages are dropped
+func checkUserPrivacyDropAll(t *testing.T, userPrivacy *UserPrivacyTransform) {
+	subtransforms, err := userPrivacy.GetTransforms()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(subtransforms) != 1 || subtransforms[0].name != "userprivacy:drop-all" {
+		t.Fatalf("drop-all subtransform expected, got %v", subtransforms)
+	}
+	dm := dnsutils.GetFakeDNSMessage()
+	if result, _ := subtransforms[0].processFunc(&dm); result != ReturnDrop {
+		t.Errorf("message not dropped")
+	}
+}
+
+func TestUserPrivacy_HashAndCryptoPanQueryIP(t *testing.T) {
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.HashQueryIP = true
+	config.UserPrivacy.CryptoPanQueryIP = true
+	config.UserPrivacy.CryptoPanKeyFile = "/nonexistent"
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+	checkUserPrivacyDropAll(t, userPrivacy)
+}
+
+func TestUserPrivacy_HmacHash(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("mysecret\n"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Setenv("DNSCOLLECTOR_TEST_SECRET", "mysecret")
+
+	testCases := []struct {
+		name          string
+		secretFile    string
+		secretEnv     string
+		dailyRotation bool
+		expectedIP    string
+	}{
+		{"Secret File", secretFile, "", false, "0b890e0047af724b963ecd7e875796736ab3c5fb9dc2f2d8e6ebb76b0de967be"},
+		{"Secret Env", "", "DNSCOLLECTOR_TEST_SECRET", false, "0b890e0047af724b963ecd7e875796736ab3c5fb9dc2f2d8e6ebb76b0de967be"},
+		{"Daily Rotation", secretFile, "", true, "62742dbf4b220c7973f38af7e6281553bbc1ef852d275620e6ca4ba1764f0a4c"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := pkgconfig.GetFakeConfigTransformers()
+			config.UserPrivacy.Enable = true
+			config.UserPrivacy.HashQueryIP = true
+			config.UserPrivacy.HashIPAlgo = "sha256"
+			config.UserPrivacy.HashSecretFile = tc.secretFile
+			config.UserPrivacy.HashSecretEnv = tc.secretEnv
+			config.UserPrivacy.HashDailyRotation = tc.dailyRotation
+
+			outChans := []chan dnsutils.DNSMessage{}
+
+			userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+			if _, err := userPrivacy.GetTransforms(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			dm := dnsutils.GetFakeDNSMessage()
+			dm.NetworkInfo.QueryIP = TestIP4
+			dm.DNSTap.TimeSec = 1704196800 // 2024-01-02 12:00:00 UTC
+
+			userPrivacy.hashQueryIP(&dm)
+			if dm.NetworkInfo.QueryIP != tc.expectedIP {
+				t.Errorf("IP hashing failed, got %s, want %s", dm.NetworkInfo.QueryIP, tc.expectedIP)
+			}
+
+			// the pseudonym changes the next day with the rotation
+			if tc.dailyRotation {
+				dm.NetworkInfo.QueryIP = TestIP4
+				dm.DNSTap.TimeSec += 86400
+				userPrivacy.hashQueryIP(&dm)
+				if dm.NetworkInfo.QueryIP == tc.expectedIP {
+					t.Errorf("the key of the day is not rotated")
+				}
+			}
+		})
+	}
+}
+
+func TestUserPrivacy_HashFields(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("mysecret"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.HashQname = true
+	config.UserPrivacy.HashEcs = true
+	config.UserPrivacy.HashPdnsRequestor = true
+	config.UserPrivacy.HashPdnsDevice = true
+	config.UserPrivacy.HashSecretFile = secretFile
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+	subtransforms, err := userPrivacy.GetTransforms()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(subtransforms) != 4 {
+		t.Fatalf("invalid number of subtransforms: %d", len(subtransforms))
+	}
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.EDNS.Options = []dnsutils.DNSOption{{Code: 8, Name: "CSUBNET", Data: "1.2.3.0/24"}}
+	dm.PowerDNS = &dnsutils.CollectorPowerDNS{RequestorID: "alice", DeviceID: "phone"}
+
+	for _, subtransform := range subtransforms {
+		if _, err := subtransform.processFunc(&dm); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if dm.DNS.Qname != "3e0bdfc64a8d05a8b40222fea5376aebd5dd2c62" {
+		t.Errorf("invalid qname: %s", dm.DNS.Qname)
+	}
+	if dm.EDNS.Options[0].Data != HmacHash("1.2.3.0/24", "sha1", []byte("mysecret")) {
+		t.Errorf("invalid ecs: %s", dm.EDNS.Options[0].Data)
+	}
+	if dm.PowerDNS.RequestorID != HmacHash("alice", "sha1", []byte("mysecret")) {
+		t.Errorf("invalid requestor-id: %s", dm.PowerDNS.RequestorID)
+	}
+	if dm.PowerDNS.DeviceID != HmacHash("phone", "sha1", []byte("mysecret")) {
+		t.Errorf("invalid device-id: %s", dm.PowerDNS.DeviceID)
+	}
+}
+
+func TestUserPrivacy_HashDailyRotationWithoutSecret(t *testing.T) {
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.HashQueryIP = true
+	config.UserPrivacy.HashDailyRotation = true
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+	checkUserPrivacyDropAll(t, userPrivacy)
+}
+
+func TestUserPrivacy_HashInvalidAlgo(t *testing.T) {
+	if HashIP(TestIP4, "md5") != "" {
+		t.Errorf("hash expected to be empty with unsupported algorithm")
+	}
+	if HmacHash(TestIP4, "md5", []byte("mysecret")) != "" {
+		t.Errorf("hmac expected to be empty with unsupported algorithm")
+	}
+
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.HashQueryIP = true
+	config.UserPrivacy.HashIPAlgo = "md5"
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+	checkUserPrivacyDropAll(t, userPrivacy)
+}
+
+func TestUserPrivacy_HashSecretNotLoadedWithoutHash(t *testing.T) {
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.HashSecretFile = "/nonexistent"
+
+	outChans := []chan dnsutils.DNSMessage{}
+
+	userPrivacy := NewUserPrivacyTransform(config, logger.New(false), "test", 0, outChans)
+	subtransforms, err := userPrivacy.GetTransforms()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(subtransforms) != 1 || subtransforms[0].name != "userprivacy:ip-anonymization" {
+		t.Errorf("unexpected subtransforms: %v", subtransforms)
+	}
+
+	// the secret is required once the hashing is enabled
+	config.UserPrivacy.HashQueryIP = true
+	userPrivacy.ReloadConfig(config)
+	checkUserPrivacyDropAll(t, userPrivacy)
+}
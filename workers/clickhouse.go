@@ -1,34 +1,60 @@
 package workers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
+	"github.com/grafana/dskit/backoff"
 )
 
-var (
-	separator = "','"
+// columns of the table when none are configured, same as the legacy table
+var ClickhouseDefaultColumns = []pkgconfig.ConfigClickhouseColumn{
+	{Name: "identity", Field: "dnstap.identity", Type: "String"},
+	{Name: "queryip", Field: "network.query-ip", Type: "String"},
+	{Name: "qname", Field: "dns.qname", Type: "String"},
+	{Name: "operation", Field: "dnstap.operation", Type: "String"},
+	{Name: "family", Field: "network.family", Type: "String"},
+	{Name: "protocol", Field: "network.protocol", Type: "String"},
+	{Name: "qtype", Field: "dns.qtype", Type: "String"},
+	{Name: "rcode", Field: "dns.rcode", Type: "String"},
+	{Name: "timensec", Field: ClickhouseFieldTimestampUnixNs, Type: "UInt64"},
+	{Name: "timestamp", Field: ClickhouseFieldTimestampUnix, Type: "DateTime"},
+}
+
+// fields added to the flat json, with the epoch time of the legacy table
+const (
+	ClickhouseFieldTimestampUnix   = "dnstap.timestamp-unix"
+	ClickhouseFieldTimestampUnixNs = "dnstap.timestamp-unixns"
 )
 
-type ClickhouseData struct {
-	Identity  string `json:"identity"`
-	QueryIP   string `json:"query_ip"`
-	QName     string `json:"q_name"`
-	Operation string `json:"operation"`
-	Family    string `json:"family"`
-	Protocol  string `json:"protocol"`
-	QType     string `json:"q_type"`
-	RCode     string `json:"r_code"`
-	TimeNSec  string `json:"timensec"`
-	TimeStamp string `json:"timestamp"`
+// number of batches waiting to be inserted, the logger is blocked while the queue is full
+const clickhouseBatchQueueSize = 4
+
+// ClickhouseIdentifier quotes the name of a database, a table or a column
+func ClickhouseIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+type ClickhouseBatch struct {
+	buffer *bytes.Buffer
+	rows   int
 }
 
 type ClickhouseClient struct {
 	*GenericWorker
+	columns    []pkgconfig.ConfigClickhouseColumn
+	httpClient *http.Client
 }
 
 func NewClickhouseClient(config *pkgconfig.Config, console *logger.Logger, name string) *ClickhouseClient {
@@ -38,9 +64,31 @@ func NewClickhouseClient(config *pkgconfig.Config, console *logger.Logger, name
 	}
 	w := &ClickhouseClient{GenericWorker: NewGenericWorker(config, console, name, "clickhouse", bufSize, pkgconfig.DefaultMonitor)}
 	w.ReadConfig()
+	w.httpClient = &http.Client{Timeout: 30 * time.Second}
 	return w
 }
 
+func (w *ClickhouseClient) ReadConfig() {
+	cfg := w.GetConfig().Loggers.ClickhouseClient
+
+	if cfg.BatchSize <= 0 {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] clickhouse - invalid batch size: ", cfg.BatchSize)
+	}
+
+	w.columns = ClickhouseDefaultColumns
+	if len(cfg.Columns) > 0 {
+		w.columns = cfg.Columns
+	}
+	for _, col := range w.columns {
+		if len(col.Name) == 0 || len(col.Field) == 0 {
+			w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] clickhouse - column without name or field: ", col)
+		}
+		if cfg.CreateTable && len(col.Type) == 0 {
+			w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] clickhouse - column type is required to create the table: ", col.Name)
+		}
+	}
+}
+
 func (w *ClickhouseClient) StartCollect() {
 	w.LogInfo("starting data collection")
 	defer w.CollectDone()
@@ -51,6 +99,7 @@ func (w *ClickhouseClient) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// goroutine to process transformed dns messages
 	go w.StartLogging()
@@ -59,8 +108,8 @@ func (w *ClickhouseClient) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			return
 
 			// new config provided?
@@ -78,21 +127,8 @@ func (w *ClickhouseClient) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-			w.GetOutputChannel() <- dm
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// then send to output channel and to next
+			subprocessors.Process(dm)
 
 		}
 	}
@@ -102,9 +138,39 @@ func (w *ClickhouseClient) StartLogging() {
 	w.LogInfo("logging has started")
 	defer w.LoggingDone()
 
+	// batches are inserted in background
+	batches := make(chan *ClickhouseBatch, clickhouseBatchQueueSize)
+	batchesDone := make(chan bool)
+	go func() {
+		defer close(batchesDone)
+		if w.GetConfig().Loggers.ClickhouseClient.CreateTable {
+			w.withRetry("create table", w.CreateTable)
+		}
+		for batch := range batches {
+			w.InsertBatch(batch)
+		}
+	}()
+
+	batch := &ClickhouseBatch{buffer: new(bytes.Buffer)}
+	encoder := json.NewEncoder(batch.buffer)
+	flush := func() {
+		if batch.rows > 0 {
+			batches <- batch
+		}
+		batch = &ClickhouseBatch{buffer: new(bytes.Buffer)}
+		encoder = json.NewEncoder(batch.buffer)
+	}
+
+	flushInterval := time.Duration(w.GetConfig().Loggers.ClickhouseClient.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
 	for {
 		select {
 		case <-w.OnLoggerStopped():
+			// insert the last batch and wait for the pending ones
+			flush()
+			close(batches)
+			<-batchesDone
 			return
 
 			// incoming dns message to process
@@ -113,38 +179,125 @@ func (w *ClickhouseClient) StartLogging() {
 				w.LogInfo("output channel closed!")
 				return
 			}
-			t, err := time.Parse(time.RFC3339, dm.DNSTap.TimestampRFC3339)
-			timensec := ""
-			if err == nil {
-				timensec = strconv.Itoa(int(t.UnixNano()))
+
+			// one json object per row, with the configured columns only
+			flat, err := dm.Flatten()
+			if err != nil {
+				w.LogError("flattening DNS message failed: %e", err)
+				continue
+			}
+			flat[ClickhouseFieldTimestampUnix] = strconv.Itoa(dm.DNSTap.TimeSec)
+			flat[ClickhouseFieldTimestampUnixNs] = ""
+			if t, err := time.Parse(time.RFC3339, dm.DNSTap.TimestampRFC3339); err == nil {
+				flat[ClickhouseFieldTimestampUnixNs] = strconv.FormatInt(t.UnixNano(), 10)
+			}
+
+			row := make(map[string]interface{}, len(w.columns))
+			for _, col := range w.columns {
+				if value, ok := flat[col.Field]; ok {
+					row[col.Name] = value
+				}
 			}
-			data := ClickhouseData{
-				Identity:  dm.DNSTap.Identity,
-				QueryIP:   dm.NetworkInfo.QueryIP,
-				QName:     dm.DNS.Qname,
-				Operation: dm.DNSTap.Operation,
-				Family:    dm.NetworkInfo.Family,
-				Protocol:  dm.NetworkInfo.Protocol,
-				QType:     dm.DNS.Qtype,
-				RCode:     dm.DNS.Rcode,
-				TimeNSec:  timensec,
-				TimeStamp: strconv.Itoa(int(int64(dm.DNSTap.TimeSec))),
+			if err := encoder.Encode(row); err != nil {
+				w.LogError("failed to encode row: %s", err)
+				continue
 			}
-			url := w.GetConfig().Loggers.ClickhouseClient.URL + "?query=INSERT%20INTO%20"
-			url += w.GetConfig().Loggers.ClickhouseClient.Database + "." + w.GetConfig().Loggers.ClickhouseClient.Table
-			url += "(identity,queryip,qname,operation,family,protocol,qtype,rcode,timensec,timestamp)%20VALUES%20('" + data.Identity + separator
-			url += data.QueryIP + separator + data.QName + separator + data.Operation + separator + data.Family + separator + data.Protocol
-			url += separator + data.QType + separator + data.RCode + separator + data.TimeNSec + separator + data.TimeStamp + "')"
-			req, _ := http.NewRequest("POST", url, nil)
-
-			req.Header.Add("Accept", "*/*")
-			req.Header.Add("X-ClickHouse-User", w.GetConfig().Loggers.ClickhouseClient.User)
-			req.Header.Add("X-ClickHouse-Key", w.GetConfig().Loggers.ClickhouseClient.Password)
-
-			_, errReq := http.DefaultClient.Do(req)
-			if errReq != nil {
-				w.LogError(errReq.Error())
+			batch.rows++
+
+			if batch.rows >= w.GetConfig().Loggers.ClickhouseClient.BatchSize {
+				flush()
 			}
+
+		// flush the batch every ?
+		case <-flushTimer.C:
+			flush()
+			flushTimer.Reset(flushInterval)
+		}
+	}
+}
+
+// CreateTable creates the database and the table with the configured columns, if they don't exist
+func (w *ClickhouseClient) CreateTable() error {
+	cfg := w.GetConfig().Loggers.ClickhouseClient
+
+	if err := w.query("CREATE DATABASE IF NOT EXISTS "+ClickhouseIdentifier(cfg.Database), nil); err != nil {
+		return err
+	}
+
+	columns := make([]string, 0, len(w.columns))
+	for _, col := range w.columns {
+		columns = append(columns, ClickhouseIdentifier(col.Name)+" "+col.Type)
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (%s) ENGINE = %s",
+		ClickhouseIdentifier(cfg.Database), ClickhouseIdentifier(cfg.Table), strings.Join(columns, ", "), cfg.TableEngine)
+	return w.query(query, nil)
+}
+
+// InsertBatch inserts the rows of the batch with the JSONEachRow format,
+// and retries with backoff on failure
+func (w *ClickhouseClient) InsertBatch(batch *ClickhouseBatch) {
+	cfg := w.GetConfig().Loggers.ClickhouseClient
+
+	columns := make([]string, 0, len(w.columns))
+	for _, col := range w.columns {
+		columns = append(columns, ClickhouseIdentifier(col.Name))
+	}
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s) FORMAT JSONEachRow",
+		ClickhouseIdentifier(cfg.Database), ClickhouseIdentifier(cfg.Table), strings.Join(columns, ", "))
+
+	data := batch.buffer.Bytes()
+	if !w.withRetry("insert", func() error { return w.query(query, data) }) {
+		w.LogError("batch dropped after %d retries, %d rows lost", cfg.MaxRetries, batch.rows)
+	}
+}
+
+// withRetry calls the function until it succeeds or the max retries is reached
+func (w *ClickhouseClient) withRetry(action string, fn func() error) bool {
+	retry := backoff.New(context.Background(), backoff.Config{
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		MaxRetries: w.GetConfig().Loggers.ClickhouseClient.MaxRetries + 1,
+	})
+	for retry.Ongoing() {
+		err := fn()
+		if err == nil {
+			return true
 		}
+		w.LogError("%s failed: %s", action, err)
+		retry.Wait()
+	}
+	return false
+}
+
+// query sends the query to the http interface, the data is appended to the query
+func (w *ClickhouseClient) query(query string, data []byte) error {
+	cfg := w.GetConfig().Loggers.ClickhouseClient
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("date_time_input_format", "best_effort")
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-ClickHouse-User", cfg.User)
+	req.Header.Set("X-ClickHouse-Key", cfg.Password)
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d, %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
+	return nil
 }
@@ -2,66 +2,204 @@ package workers
 
 import (
 	"bufio"
-	"net"
+	"bytes"
+	"encoding/json"
+	"io"
 	"net/http"
-	"regexp"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-logger"
 )
 
-func Test_ClickhouseClient(t *testing.T) {
-
-	testcases := []struct {
-		mode    string
-		pattern string
-	}{
-		{
-			mode:    pkgconfig.ModeJSON,
-			pattern: pkgconfig.ProgQname,
-		},
-	}
-	cfg := pkgconfig.GetDefaultConfig()
-	cfg.Loggers.ClickhouseClient.URL = "http://127.0.0.1:8123"
-	cfg.Loggers.ClickhouseClient.User = "default"
-	cfg.Loggers.ClickhouseClient.Password = "password"
-	cfg.Loggers.ClickhouseClient.Database = "database"
-	cfg.Loggers.ClickhouseClient.Table = "table"
-	fakeRcvr, err := net.Listen("tcp", "127.0.0.1:8123")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer fakeRcvr.Close()
-
-	for _, tc := range testcases {
-		t.Run(tc.mode, func(t *testing.T) {
-			g := NewClickhouseClient(cfg, logger.New(false), "test")
-
-			go g.StartCollect()
-
-			dm := dnsutils.GetFakeDNSMessage()
-			g.GetInputChannel() <- dm
-			// accept conn
-			conn, err := fakeRcvr.Accept()
-			if err != nil {
-				t.Fatal(err)
-			}
-			defer conn.Close()
-
-			// read and parse http request on server side
-			request, err := http.ReadRequest(bufio.NewReader(conn))
-			if err != nil {
-				t.Fatal(err)
-			}
-			query := request.URL.Query().Get("query")
-			conn.Write([]byte(pkgconfig.HTTPOK))
-
-			pattern := regexp.MustCompile(tc.pattern)
-			if !pattern.MatchString(query) {
-				t.Errorf("clickhouse test error want %s, got: %s", tc.pattern, query)
-			}
-		})
+func Test_ClickhouseClient_Batch(t *testing.T) {
+	var mu sync.Mutex
+	queries, inserts := []string{}, [][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-ClickHouse-User") != "default" || r.Header.Get("X-ClickHouse-Key") != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		queries = append(queries, r.URL.Query().Get("query"))
+		inserts = append(inserts, data)
+	}))
+	defer server.Close()
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.ClickhouseClient.URL = server.URL
+	config.Loggers.ClickhouseClient.BatchSize = 3
+	g := NewClickhouseClient(config, logger.New(false), "test")
+	go g.StartCollect()
+
+	// a quote in the qname must not break the insert
+	for i := 0; i < 4; i++ {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = "dns'collector"
+		g.GetInputChannel() <- dm
+	}
+
+	// the first batch is full, the second one is inserted on stop
+	time.Sleep(time.Second)
+	g.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != 2 {
+		t.Fatalf("want 2 inserts, got %d", len(queries))
+	}
+
+	rows := 0
+	scanner := bufio.NewScanner(bytes.NewReader(inserts[0]))
+	for ; scanner.Scan(); rows++ {
+		var row map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("invalid json row: %v", err)
+		}
+		if row["qname"] != "dns'collector" || len(row) != len(ClickhouseDefaultColumns) {
+			t.Errorf("invalid row: %v", row)
+		}
+	}
+	if rows != 3 || bytes.Count(inserts[1], []byte("\n")) != 1 {
+		t.Errorf("want batches of 3 and 1 rows, got %d and %d", rows, bytes.Count(inserts[1], []byte("\n")))
+	}
+}
+
+func Test_ClickhouseClient_DefaultColumns(t *testing.T) {
+	var mu sync.Mutex
+	queries, inserts := []string{}, [][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		data, _ := io.ReadAll(r.Body)
+		queries = append(queries, r.URL.Query().Get("query"))
+		inserts = append(inserts, data)
+	}))
+	defer server.Close()
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.ClickhouseClient.URL = server.URL
+	g := NewClickhouseClient(config, logger.New(false), "test")
+	go g.StartCollect()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNSTap.TimeSec = 1700000000
+	dm.DNSTap.TimestampRFC3339 = "2023-11-14T22:13:20.000000123Z"
+	g.GetInputChannel() <- dm
+	time.Sleep(time.Second)
+	g.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != 1 {
+		t.Fatalf("want 1 insert, got %d", len(queries))
+	}
+
+	// same columns and values as the legacy table
+	legacy := "(`identity`, `queryip`, `qname`, `operation`, `family`, `protocol`, `qtype`, `rcode`, `timensec`, `timestamp`)"
+	if queries[0] != "INSERT INTO `dnscollector`.`records` "+legacy+" FORMAT JSONEachRow" {
+		t.Errorf("invalid insert query: %s", queries[0])
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal(inserts[0], &row); err != nil {
+		t.Fatalf("invalid json row: %v", err)
+	}
+	if row["timestamp"] != "1700000000" || row["timensec"] != "1700000000000000123" {
+		t.Errorf("invalid timestamps: %v %v", row["timestamp"], row["timensec"])
+	}
+	if row["qname"] != dm.DNS.Qname || row["operation"] != dm.DNSTap.Operation {
+		t.Errorf("invalid row: %v", row)
+	}
+}
+
+func Test_ClickhouseClient_Columns(t *testing.T) {
+	var mu sync.Mutex
+	queries, inserts := []string{}, [][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		data, _ := io.ReadAll(r.Body)
+		queries = append(queries, r.URL.Query().Get("query"))
+		inserts = append(inserts, data)
+	}))
+	defer server.Close()
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.ClickhouseClient.URL = server.URL
+	config.Loggers.ClickhouseClient.Database = "database"
+	config.Loggers.ClickhouseClient.Table = "table"
+	config.Loggers.ClickhouseClient.CreateTable = true
+	config.Loggers.ClickhouseClient.Columns = []pkgconfig.ConfigClickhouseColumn{
+		{Name: "qname", Field: "dns.qname", Type: "String"},
+		{Name: "country", Field: "geoip.country-isocode", Type: "LowCardinality(String)"},
+	}
+	g := NewClickhouseClient(config, logger.New(false), "test")
+	go g.StartCollect()
+
+	g.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	time.Sleep(time.Second)
+	g.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != 3 {
+		t.Fatalf("want 3 queries, got %d", len(queries))
+	}
+	if queries[0] != "CREATE DATABASE IF NOT EXISTS `database`" {
+		t.Errorf("invalid create database query: %s", queries[0])
+	}
+	if queries[1] != "CREATE TABLE IF NOT EXISTS `database`.`table` (`qname` String, `country` LowCardinality(String)) ENGINE = MergeTree ORDER BY tuple()" {
+		t.Errorf("invalid create table query: %s", queries[1])
+	}
+
+	// fields missing from the message are omitted
+	if strings.TrimSpace(string(inserts[2])) != `{"qname":"dns.collector"}` {
+		t.Errorf("invalid row: %s", inserts[2])
+	}
+}
+
+func Test_ClickhouseClient_Retry(t *testing.T) {
+	// the server is unavailable for the first requests
+	var mu sync.Mutex
+	fail, inserted := 2, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		inserted++
+	}))
+	defer server.Close()
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.ClickhouseClient.URL = server.URL
+	config.Loggers.ClickhouseClient.MaxRetries = 2
+	g := NewClickhouseClient(config, logger.New(false), "test")
+
+	batch := &ClickhouseBatch{buffer: bytes.NewBufferString("{}\n"), rows: 1}
+	g.InsertBatch(batch)
+	mu.Lock()
+	if inserted != 1 {
+		t.Errorf("batch should be inserted after retries")
+	}
+
+	// dropped after max retries
+	fail = 3
+	mu.Unlock()
+	g.InsertBatch(batch)
+	mu.Lock()
+	defer mu.Unlock()
+	if inserted != 1 {
+		t.Errorf("batch should be dropped")
 	}
 }
@@ -0,0 +1,101 @@
+package workers
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+)
+
+// WorkerControl is the runtime control of a stanza, shared with its processors.
+// It is used by the admin api to pause the stanza and to list the active transforms.
+type WorkerControl struct {
+	sync.Mutex
+	paused     atomic.Bool
+	resumed    chan struct{}
+	transforms *transformers.Transforms
+}
+
+func NewWorkerControl() *WorkerControl {
+	return &WorkerControl{}
+}
+
+// Pause blocks the workers when they send messages, so the input channels are no more read
+// and the backpressure policy of the previous stanzas applies. False is returned if already paused.
+func (c *WorkerControl) Pause() bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.paused.Load() {
+		return false
+	}
+	c.resumed = make(chan struct{})
+	c.paused.Store(true)
+	return true
+}
+
+// Resume unblocks the workers, false is returned if not paused
+func (c *WorkerControl) Resume() bool {
+	c.Lock()
+	defer c.Unlock()
+	if !c.paused.Load() {
+		return false
+	}
+	c.paused.Store(false)
+	close(c.resumed)
+	return true
+}
+
+func (c *WorkerControl) IsPaused() bool { return c.paused.Load() }
+
+// Wait blocks while the stanza is paused or until the cancel channel is closed
+func (c *WorkerControl) Wait(cancel chan struct{}) {
+	if !c.paused.Load() {
+		return
+	}
+
+	c.Lock()
+	resumed, paused := c.resumed, c.paused.Load()
+	c.Unlock()
+	if !paused {
+		return
+	}
+
+	select {
+	case <-resumed:
+	case <-cancel:
+	}
+}
+
+// SetTransforms registers the transforms of the stanza
+func (c *WorkerControl) SetTransforms(transforms *transformers.Transforms) {
+	c.Lock()
+	defer c.Unlock()
+	c.transforms = transforms
+}
+
+// GetTransforms returns the names of the active transforms
+func (c *WorkerControl) GetTransforms() []string {
+	c.Lock()
+	defer c.Unlock()
+	if c.transforms == nil {
+		return []string{}
+	}
+	return c.transforms.GetNames()
+}
+
+// RouteState describes a route of a worker and the filling of its channel
+type RouteState struct {
+	Type     string `json:"type"`
+	Target   string `json:"target"`
+	Length   int    `json:"length"`
+	Capacity int    `json:"capacity"`
+}
+
+func newRouteStates(routeType string, routes []chan dnsutils.DNSMessage, names []string) []RouteState {
+	states := []RouteState{}
+	for i := range routes {
+		states = append(states, RouteState{Type: routeType, Target: names[i], Length: len(routes[i]), Capacity: cap(routes[i])})
+	}
+	return states
+}
@@ -43,7 +43,7 @@ func NewDNSMessage(next []Worker, config *pkgconfig.Config, logger *logger.Logge
 	return s
 }
 
-func (w *DNSMessage) ReadConfigMatching(value interface{}) {
+func (w *GenericWorker) ReadConfigMatching(value interface{}) {
 	reflectedValue := reflect.ValueOf(value)
 	if reflectedValue.Kind() == reflect.Map {
 		keys := reflectedValue.MapKeys()
@@ -51,10 +51,10 @@ func (w *DNSMessage) ReadConfigMatching(value interface{}) {
 		srcKind := dnsutils.MatchingKindString
 		for _, k := range keys {
 			v := reflectedValue.MapIndex(k)
-			if k.Interface().(string) == "match-source" {
+			if fmt.Sprint(k.Interface()) == "match-source" {
 				matchSrc = v.Interface().(string)
 			}
-			if k.Interface().(string) == "source-kind" {
+			if fmt.Sprint(k.Interface()) == "source-kind" {
 				srcKind = v.Interface().(string)
 			}
 		}
@@ -64,31 +64,54 @@ func (w *DNSMessage) ReadConfigMatching(value interface{}) {
 				w.LogFatal(err)
 			}
 			if len(sourceData.regexList) > 0 {
-				value.(map[interface{}]interface{})[srcKind] = sourceData.regexList
+				reflectedValue.SetMapIndex(reflect.ValueOf(srcKind), reflect.ValueOf(sourceData.regexList))
 			}
 			if len(sourceData.stringList) > 0 {
-				value.(map[interface{}]interface{})[srcKind] = sourceData.stringList
+				reflectedValue.SetMapIndex(reflect.ValueOf(srcKind), reflect.ValueOf(sourceData.stringList))
 			}
 		}
 	}
 }
 
 func (w *DNSMessage) ReadConfig() {
+	w.ReadMatching(w.GetConfig().Collectors.DNSMessage.Matching)
+}
+
+// ReadMatching loads the external files or urls of the include and exclude conditions
+func (w *GenericWorker) ReadMatching(matching pkgconfig.ConfigMatching) {
 	// load external file for include
-	if len(w.GetConfig().Collectors.DNSMessage.Matching.Include) > 0 {
-		for _, value := range w.GetConfig().Collectors.DNSMessage.Matching.Include {
-			w.ReadConfigMatching(value)
-		}
+	for _, value := range matching.Include {
+		w.ReadConfigMatching(value)
 	}
 	// load external file for exclude
-	if len(w.GetConfig().Collectors.DNSMessage.Matching.Exclude) > 0 {
-		for _, value := range w.GetConfig().Collectors.DNSMessage.Matching.Exclude {
-			w.ReadConfigMatching(value)
+	for _, value := range matching.Exclude {
+		w.ReadConfigMatching(value)
+	}
+}
+
+// MatchMessage returns true if the message matches the include conditions
+// and does not match the exclude ones
+func (w *GenericWorker) MatchMessage(dm *dnsutils.DNSMessage, matching pkgconfig.ConfigMatching) bool {
+	matched := true
+	if len(matching.Include) > 0 {
+		err, matchedInclude := dm.Matching(matching.Include)
+		if err != nil {
+			w.LogError(err.Error())
 		}
+		matched = matchedInclude
 	}
+
+	if matched && len(matching.Exclude) > 0 {
+		err, matchedExclude := dm.Matching(matching.Exclude)
+		if err != nil {
+			w.LogError(err.Error())
+		}
+		matched = !matchedExclude
+	}
+	return matched
 }
 
-func (w *DNSMessage) LoadData(matchSource string, srcKind string) (MatchSource, error) {
+func (w *GenericWorker) LoadData(matchSource string, srcKind string) (MatchSource, error) {
 	if isFileSource(matchSource) {
 		dataSource, err := w.LoadFromFile(matchSource, srcKind)
 		if err != nil {
@@ -105,7 +128,7 @@ func (w *DNSMessage) LoadData(matchSource string, srcKind string) (MatchSource,
 	return MatchSource{}, fmt.Errorf("match source not supported %s", matchSource)
 }
 
-func (w *DNSMessage) LoadFromURL(matchSource string, srcKind string) (MatchSource, error) {
+func (w *GenericWorker) LoadFromURL(matchSource string, srcKind string) (MatchSource, error) {
 	w.LogInfo("loading matching source from url=%s", matchSource)
 	resp, err := http.Get(matchSource)
 	if err != nil {
@@ -136,7 +159,7 @@ func (w *DNSMessage) LoadFromURL(matchSource string, srcKind string) (MatchSourc
 	return matchSources, nil
 }
 
-func (w *DNSMessage) LoadFromFile(filePath string, srcKind string) (MatchSource, error) {
+func (w *GenericWorker) LoadFromFile(filePath string, srcKind string) (MatchSource, error) {
 	localFile := strings.TrimPrefix(filePath, "file://")
 
 	w.LogInfo("loading matching source from file=%s", localFile)
@@ -168,14 +191,13 @@ func (w *DNSMessage) StartCollect() {
 	w.LogInfo("starting data collection")
 	defer w.CollectDone()
 
-	var err error
-
 	// prepare next channels
 	defaultRoutes, defaultNames := GetRoutes(w.GetDefaultRoutes())
 	droppedRoutes, droppedNames := GetRoutes(w.GetDroppedRoutes())
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().IngoingTransformers, w.GetLogger(), w.GetName(), defaultRoutes, 0)
+	w.StartTransforms(&subprocessors, w.IngoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// read incoming dns message
 	w.LogInfo("waiting dns message to process...")
@@ -199,58 +221,20 @@ func (w *DNSMessage) StartCollect() {
 			w.CountIngressTraffic()
 
 			// matching enabled, filtering DNS messages ?
-			matched := true
-			matchedInclude := false
-			matchedExclude := false
-
-			if len(w.GetConfig().Collectors.DNSMessage.Matching.Include) > 0 {
-				err, matchedInclude = dm.Matching(w.GetConfig().Collectors.DNSMessage.Matching.Include)
-				if err != nil {
-					w.LogError(err.Error())
-				}
-				if matched && matchedInclude {
-					matched = true
-				} else {
-					matched = false
-				}
-			}
-
-			if len(w.GetConfig().Collectors.DNSMessage.Matching.Exclude) > 0 {
-				err, matchedExclude = dm.Matching(w.GetConfig().Collectors.DNSMessage.Matching.Exclude)
-				if err != nil {
-					w.LogError(err.Error())
-				}
-				if matched && !matchedExclude {
-					matched = true
-				} else {
-					matched = false
-				}
-			}
+			matched := w.MatchMessage(&dm, w.GetConfig().Collectors.DNSMessage.Matching)
 
 			// count output packets
 			w.CountEgressTraffic()
 
-			// apply tranforms on matched packets only
-			// init dns message with additionnals parts if necessary
-			if matched {
-				transformResult, err := subprocessors.ProcessMessage(&dm)
-				if err != nil {
-					w.LogError(err.Error())
-				}
-				if transformResult == transformers.ReturnDrop {
-					w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-					continue
-				}
-			}
-
 			// drop packet ?
 			if !matched {
 				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
 
-			// send to next
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// apply tranforms on matched packets only
+			// init dns message with additionnals parts if necessary then send to next
+			subprocessors.Process(dm)
 		}
 	}
 }
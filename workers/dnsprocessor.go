@@ -28,6 +28,7 @@ func (w *DNSProcessor) StartCollect() {
 
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&w.GetConfig().IngoingTransformers, w.GetLogger(), w.GetName(), defaultRoutes, 0)
+	w.StartTransforms(&transforms, w.IngoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// read incoming dns message
 	for {
@@ -94,18 +95,8 @@ func (w *DNSProcessor) StartCollect() {
 			// count output packets
 			w.CountEgressTraffic()
 
-			// apply all enabled transformers
-			transformResult, err := transforms.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// dispatch dns message to all generators
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// apply all enabled transformers then dispatch to all generators
+			transforms.Process(dm)
 		}
 	}
 }
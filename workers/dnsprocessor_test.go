@@ -35,6 +35,46 @@ func Test_DnsProcessor(t *testing.T) {
 	}
 }
 
+func Test_DnsProcessor_TransformsWorkers(t *testing.T) {
+	logger := logger.New(true)
+	var o bytes.Buffer
+	logger.SetOutput(&o)
+
+	// transforms applied by several workers
+	config := pkgconfig.GetDefaultConfig()
+	config.IngoingTransformers.Workers = 4
+	config.IngoingTransformers.WorkersShardKey = "network.query-ip"
+	config.IngoingTransformers.Normalize.Enable = true
+	config.IngoingTransformers.Normalize.AddTld = true
+
+	// init and run the dns processor
+	fl := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+
+	consumer := NewDNSProcessor(config, logger, "test", 512)
+	consumer.AddDefaultRoute(fl)
+	consumer.AddDroppedRoute(fl)
+	go consumer.StartCollect()
+
+	for i := 0; i < 100; i++ {
+		dm := dnsutils.GetFakeDNSMessageWithPayload()
+		dm.NetworkInfo.QueryIP = fmt.Sprintf("10.0.0.%d", i%10)
+		consumer.GetInputChannel() <- dm
+	}
+
+	// all messages are transformed and forwarded
+	for i := 0; i < 100; i++ {
+		select {
+		case dmOut := <-fl.GetInputChannel():
+			if dmOut.PublicSuffix == nil || dmOut.PublicSuffix.QnamePublicSuffix != "dev" {
+				t.Fatalf("message not transformed: %v", dmOut.PublicSuffix)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d not forwarded", i)
+		}
+	}
+	consumer.Stop()
+}
+
 func Test_DnsProcessor_DecodeCounters(t *testing.T) {
 	logger := logger.New(true)
 	var o bytes.Buffer
@@ -66,6 +66,8 @@ func (w *DnstapServer) HandleConn(conn net.Conn, connID uint64, forceClose chan
 	dnstapProcessor.SetMetrics(w.metrics)
 	dnstapProcessor.SetDefaultRoutes(w.GetDefaultRoutes())
 	dnstapProcessor.SetDefaultDropped(w.GetDroppedRoutes())
+	dnstapProcessor.SetRouting(w.GetRouting())
+	dnstapProcessor.SetControl(w.GetControl())
 	go dnstapProcessor.StartCollect()
 
 	// init frame stream library
@@ -318,6 +320,7 @@ func (w *DNSTapProcessor) StartCollect() {
 
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&w.GetConfig().IngoingTransformers, w.GetLogger(), w.GetName(), defaultRoutes, w.ConnID)
+	w.StartTransforms(&transforms, w.IngoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// read incoming dns message
 	for {
@@ -527,18 +530,8 @@ func (w *DNSTapProcessor) StartCollect() {
 			// count output packets
 			w.CountEgressTraffic()
 
-			// apply all enabled transformers
-			transformResult, err := transforms.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// dispatch dns message to connected routes
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// apply all enabled transformers then dispatch to connected routes
+			transforms.Process(dm)
 		}
 	}
 }
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path"
 	"time"
 
+	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
@@ -30,6 +32,9 @@ func NewElasticSearchClient(config *pkgconfig.Config, console *logger.Logger, na
 	w := &ElasticSearchClient{GenericWorker: NewGenericWorker(config, console, name, "elasticsearch", bufSize, pkgconfig.DefaultMonitor)}
 	w.ReadConfig()
 	w.httpClient = &http.Client{Timeout: 5 * time.Second}
+	if config.Loggers.ElasticSearchClient.Spool.Enable {
+		w.EnableSpool(config.Loggers.ElasticSearchClient.Spool)
+	}
 	return w
 }
 
@@ -66,6 +71,7 @@ func (w *ElasticSearchClient) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// goroutine to process transformed dns messages
 	go w.StartLogging()
@@ -74,8 +80,8 @@ func (w *ElasticSearchClient) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			return
 
 		case cfg := <-w.NewConfig():
@@ -92,25 +98,18 @@ func (w *ElasticSearchClient) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-			w.GetOutputChannel() <- dm
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// then send to output channel and to next
+			subprocessors.Process(dm)
 		}
 	}
 }
 
+// esBulk is a bulk to send, with the messages kept for the spool
+type esBulk struct {
+	data  []byte
+	batch SpoolBatch
+}
+
 func (w *ElasticSearchClient) StartLogging() {
 	w.LogInfo("logging has started")
 	defer w.LoggingDone()
@@ -118,57 +117,97 @@ func (w *ElasticSearchClient) StartLogging() {
 	// create a new encoder that writes to the buffer
 	buffer := bytes.NewBuffer(make([]byte, 0, w.GetConfig().Loggers.ElasticSearchClient.BulkSize))
 	encoder := json.NewEncoder(buffer)
+	batch := SpoolBatch{}
 
 	flushInterval := time.Duration(w.GetConfig().Loggers.ElasticSearchClient.FlushInterval) * time.Second
 	flushTimer := time.NewTimer(flushInterval)
 
-	dataBuffer := make(chan []byte, w.GetConfig().Loggers.ElasticSearchClient.BulkChannelSize)
+	dataBuffer := make(chan esBulk, w.GetConfig().Loggers.ElasticSearchClient.BulkChannelSize)
+	bulkDone := make(chan bool)
 	go func() {
-		for data := range dataBuffer {
+		defer close(bulkDone)
+		for bulk := range dataBuffer {
 			var err error
 			if w.GetConfig().Loggers.ElasticSearchClient.Compression == pkgconfig.CompressGzip {
-				err = w.sendCompressedBulk(data)
+				err = w.sendCompressedBulk(bulk.data)
 			} else {
-				err = w.sendBulk(data)
+				err = w.sendBulk(bulk.data)
 			}
 			if err != nil {
 				w.LogError("error sending bulk data: %v", err)
 			}
+			w.SetRemoteAvailable(err == nil)
+			w.SpoolBatchDone(&bulk.batch, err)
 		}
 	}()
 
+	// append dns message to buffer
+	appendMessage := func(dm dnsutils.DNSMessage) {
+		flat, err := dm.Flatten()
+		if err != nil {
+			w.LogError("flattening DNS message failed: %e", err)
+		}
+		buffer.WriteString("{ \"create\" : {}}\n")
+		encoder.Encode(flat)
+	}
+
+	// send data and reset buffer
+	sendBuffer := func(warning string) {
+		bulk := esBulk{data: make([]byte, buffer.Len()), batch: batch}
+		buffer.Read(bulk.data)
+		buffer.Reset()
+		batch = SpoolBatch{}
+
+		select {
+		case dataBuffer <- bulk:
+		default:
+			w.LogWarning(warning)
+			// with the spool, the messages are written back to it
+			w.SpoolBatchDone(&bulk.batch, errors.New("send buffer is full"))
+		}
+	}
+
 	for {
+		// with the spool, the messages are not consumed while the remote is unavailable
+		// but the replayed ones are read to retry the connection
+		outputChannel := w.GetOutputChannel()
+		if !w.RemoteAvailable() {
+			outputChannel = nil
+		}
+
 		select {
 		case <-w.OnLoggerStopped():
+			// send the last bulk and wait for the pending ones
+			if buffer.Len() > 0 {
+				dataBuffer <- esBulk{data: buffer.Bytes(), batch: batch}
+			}
 			close(dataBuffer)
+			<-bulkDone
 			return
 
 			// incoming dns message to process
-		case dm, opened := <-w.GetOutputChannel():
+		case dm, opened := <-outputChannel:
 			if !opened {
 				w.LogInfo("output channel closed!")
 				return
 			}
 
-			// append dns message to buffer
-			flat, err := dm.Flatten()
-			if err != nil {
-				w.LogError("flattening DNS message failed: %e", err)
+			appendMessage(dm)
+			w.SpoolBatchAdd(&batch, dm)
+
+			// Send data and reset buffer
+			if buffer.Len() >= w.GetConfig().Loggers.ElasticSearchClient.BulkSize {
+				sendBuffer("Send buffer is full, bulk dropped")
 			}
-			buffer.WriteString("{ \"create\" : {}}\n")
-			encoder.Encode(flat)
+
+		// dns message replayed from the spool
+		case sm := <-w.GetSpoolChannel():
+			appendMessage(sm.DNSMessage)
+			batch.AddReplayed(sm)
 
 			// Send data and reset buffer
 			if buffer.Len() >= w.GetConfig().Loggers.ElasticSearchClient.BulkSize {
-				bufCopy := make([]byte, buffer.Len())
-				buffer.Read(bufCopy)
-				buffer.Reset()
-
-				select {
-				case dataBuffer <- bufCopy:
-				default:
-					w.LogWarning("Send buffer is full, bulk dropped")
-				}
+				sendBuffer("Send buffer is full, bulk dropped")
 			}
 
 		// flush the buffer every ?
@@ -176,15 +215,7 @@ func (w *ElasticSearchClient) StartLogging() {
 
 			// Send data and reset buffer
 			if buffer.Len() > 0 {
-				bufCopy := make([]byte, buffer.Len())
-				buffer.Read(bufCopy)
-				buffer.Reset()
-
-				select {
-				case dataBuffer <- bufCopy:
-				default:
-					w.LogWarning("automatic flush, send buffer is full, bulk dropped")
-				}
+				sendBuffer("automatic flush, send buffer is full, bulk dropped")
 			}
 
 			// restart timer
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -219,3 +220,43 @@ func TestElasticSearchClient_sendBulk_WithBasicAuth(t *testing.T) {
 	err := client.sendBulk([]byte("test payload"))
 	assert.NoError(t, err, "Unexpected error when sending request with Basic Auth")
 }
+
+func TestElasticSearchClient_Spool_BulkFailed(t *testing.T) {
+	// the first bulk is rejected then the documents are accepted
+	var requests, docs atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		payload, _ := io.ReadAll(r.Body)
+		docs.Add(int32(strings.Count(string(payload), "\n") / 2))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.ElasticSearchClient.Server = server.URL
+	config.Loggers.ElasticSearchClient.FlushInterval = 1
+	config.Loggers.ElasticSearchClient.Spool.Enable = true
+	config.Loggers.ElasticSearchClient.Spool.Directory = t.TempDir()
+	config.Loggers.ElasticSearchClient.Spool.RetryInterval = 1
+
+	g := NewElasticSearchClient(config, logger.New(false), "test")
+	go g.StartCollect()
+
+	for i := 0; i < 5; i++ {
+		g.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	}
+
+	// the failed bulk is written back to the spool then replayed
+	deadline := time.Now().Add(10 * time.Second)
+	for docs.Load() < 5 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	g.Stop()
+
+	assert.Equal(t, int32(5), docs.Load())
+	assert.GreaterOrEqual(t, requests.Load(), int32(2))
+	assert.Equal(t, int64(0), g.spool.Size())
+}
@@ -35,6 +35,7 @@ func (w *FalcoClient) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// goroutine to process transformed dns messages
 	go w.StartLogging()
@@ -42,8 +43,8 @@ func (w *FalcoClient) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			return
 
 		// new config provided?
@@ -61,21 +62,8 @@ func (w *FalcoClient) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-			w.GetOutputChannel() <- dm
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// then send to output channel and to next
+			subprocessors.Process(dm)
 		}
 	}
 }
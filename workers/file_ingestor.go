@@ -1,12 +1,17 @@
 package workers
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +24,9 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 )
 
 var waitFor = 10 * time.Second
@@ -27,7 +35,8 @@ func IsValidMode(mode string) bool {
 	switch mode {
 	case
 		pkgconfig.ModePCAP,
-		pkgconfig.ModeDNSTap:
+		pkgconfig.ModeDNSTap,
+		pkgconfig.ModeCDNS:
 		return true
 	}
 	return false
@@ -65,33 +74,272 @@ func (w *FileIngestor) CheckConfig() {
 }
 
 func (w *FileIngestor) ProcessFile(filePath string) {
+	// compressed files are detected with the extension before the compression one, e.g. .pcap.gz
+	fileExt := GetFileExtension(filePath)
+
 	switch w.GetConfig().Collectors.FileIngestor.WatchMode {
 	case pkgconfig.ModePCAP:
 		// process file with pcap extension only
-		if filepath.Ext(filePath) == ".pcap" || filepath.Ext(filePath) == ".pcap.gz" {
+		if fileExt == ".pcap" || fileExt == ".pcapng" {
 			w.LogInfo("file ready to process %s", filePath)
 			go w.ProcessPcap(filePath)
 		}
 	case pkgconfig.ModeDNSTap:
 		// process dnstap
-		if filepath.Ext(filePath) == ".fstrm" {
+		if fileExt == ".fstrm" {
 			w.LogInfo("file ready to process %s", filePath)
 			go w.ProcessDnstap(filePath)
 		}
+	case pkgconfig.ModeCDNS:
+		// process c-dns
+		if fileExt == ".cdns" {
+			w.LogInfo("file ready to process %s", filePath)
+			go w.ProcessCdns(filePath)
+		}
 	}
 }
 
-func (w *FileIngestor) ProcessPcap(filePath string) {
-	// open the file
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicLz4  = []byte{0x04, 0x22, 0x4d, 0x18}
+
+	compressionExtensions = []string{".gz", ".zst", ".lz4"}
+)
+
+// GetFileExtension returns the extension of the file, ignoring the compression one
+func GetFileExtension(filePath string) string {
+	ext := filepath.Ext(filePath)
+	for _, compressionExt := range compressionExtensions {
+		if ext == compressionExt {
+			return filepath.Ext(strings.TrimSuffix(filePath, ext))
+		}
+	}
+	return ext
+}
+
+// fileReader reads a file decompressed on the fly
+type fileReader struct {
+	io.Reader
+	file         *os.File
+	decompressor io.Closer
+}
+
+func (r *fileReader) Close() error {
+	if r.decompressor != nil {
+		r.decompressor.Close()
+	}
+	return r.file.Close()
+}
+
+// OpenFileReader opens the file and decompress it on the fly
+// when a gzip, zstd or lz4 magic number is detected
+func OpenFileReader(filePath string) (io.ReadCloser, error) {
 	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(f)
+	r := &fileReader{Reader: br, file: f}
+
+	// errors are ignored here, too small files are detected by the decoders
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		gzReader, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.Reader, r.decompressor = gzReader, gzReader
+
+	case bytes.HasPrefix(magic, magicZstd):
+		zstdReader, err := zstd.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.Reader, r.decompressor = zstdReader, zstdReader.IOReadCloser()
+
+	case bytes.HasPrefix(magic, magicLz4):
+		r.Reader = lz4.NewReader(br)
+	}
+	return r, nil
+}
+
+// LinkTypeLinuxSLL2 is the LINKTYPE_LINUX_SLL2 link type, not defined by gopacket.
+// Link types are read from the files without truncation, gopacket stores them on 8 bits.
+const LinkTypeLinuxSLL2 = 276
+
+// PcapReader is implemented by both pcap and pcapng readers
+type PcapReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	// PacketLinkType returns the link type of the interface of the packet
+	PacketLinkType(ci gopacket.CaptureInfo) uint32
+}
+
+type pcapReader struct {
+	*pcapgo.Reader
+	linkType uint32
+}
+
+func (r *pcapReader) PacketLinkType(ci gopacket.CaptureInfo) uint32 { return r.linkType }
+
+type pcapngReader struct {
+	*pcapgo.NgReader
+	blocks *pcapngBlockReader
+}
+
+func (r *pcapngReader) PacketLinkType(ci gopacket.CaptureInfo) uint32 {
+	if ci.InterfaceIndex >= 0 && ci.InterfaceIndex < len(r.blocks.linkTypes) {
+		return r.blocks.linkTypes[ci.InterfaceIndex]
+	}
+	return uint32(r.LinkType())
+}
+
+// pcapngBlockReader records the link types of the interfaces of the current section, each read stops at
+// the end of the current block so the interfaces are known up to the last packet read by the pcapng reader
+type pcapngBlockReader struct {
+	r         io.Reader
+	order     binary.ByteOrder
+	header    []byte
+	remaining int
+	linkTypes []uint32
+}
+
+func (b *pcapngBlockReader) Read(p []byte) (int, error) {
+	// block type, total length and the first 4 bytes of the body: byte-order magic or link type
+	if b.remaining == 0 {
+		n, err := b.r.Read(p[:min(len(p), 12-len(b.header))])
+		b.header = append(b.header, p[:n]...)
+		if len(b.header) == 12 {
+			if err := b.readHeader(); err != nil {
+				return n, err
+			}
+		}
+		return n, err
+	}
+
+	n, err := b.r.Read(p[:min(len(p), b.remaining)])
+	b.remaining -= n
+	return n, err
+}
+
+func (b *pcapngBlockReader) readHeader() error {
+	blockType := binary.BigEndian.Uint32(b.header[0:4])
+	switch {
+	case blockType == 0x0a0d0d0a:
+		// section header, with a new set of interfaces
+		b.order = binary.LittleEndian
+		if binary.BigEndian.Uint32(b.header[8:12]) == 0x1a2b3c4d {
+			b.order = binary.BigEndian
+		}
+		b.linkTypes = b.linkTypes[:0]
+	case b.order == nil:
+		return errors.New("pcapng section header expected")
+	case b.order.Uint32(b.header[0:4]) == 1:
+		// interface description
+		b.linkTypes = append(b.linkTypes, uint32(b.order.Uint16(b.header[8:10])))
+	}
+
+	length := b.order.Uint32(b.header[4:8])
+	if length < 12 || length > math.MaxInt32 {
+		return fmt.Errorf("invalid pcapng block length %d", length)
+	}
+	b.remaining = int(length) - 12
+	b.header = b.header[:0]
+	return nil
+}
+
+// NewPcapReader returns a pcap or pcapng reader according to the magic number of the file
+func NewPcapReader(r io.Reader) (PcapReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	// section header block of a pcapng file
+	if bytes.Equal(magic, []byte{0x0a, 0x0d, 0x0d, 0x0a}) {
+		blocks := &pcapngBlockReader{r: br}
+		ngReader, err := pcapgo.NewNgReader(blocks, pcapgo.NgReaderOptions{WantMixedLinkType: true, SkipUnknownVersion: true})
+		if err != nil {
+			return nil, err
+		}
+		return &pcapngReader{NgReader: ngReader, blocks: blocks}, nil
+	}
+
+	// link type of the file header, the upper bits hold the fcs information
+	header, err := br.Peek(24)
+	if err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic[0] == 0xa1 {
+		order = binary.BigEndian
+	}
+	reader, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &pcapReader{Reader: reader, linkType: order.Uint32(header[20:24]) & 0xffff}, nil
+}
+
+// GetLinkTypeDecoder returns the decoder of the supported link types
+func GetLinkTypeDecoder(linkType uint32) (gopacket.Decoder, error) {
+	if linkType == LinkTypeLinuxSLL2 {
+		return gopacket.DecodeFunc(decodeLinuxSLL2), nil
+	}
+	if linkType > math.MaxUint8 {
+		return nil, fmt.Errorf("unsupported link type %d", linkType)
+	}
+
+	switch lt := layers.LinkType(linkType); lt {
+	case layers.LinkTypeEthernet, layers.LinkTypeLinuxSLL, layers.LinkTypeRaw,
+		layers.LinkTypeNull, layers.LinkTypeLoop:
+		return lt, nil
+	case layers.LinkTypeIPv4:
+		return layers.LayerTypeIPv4, nil
+	case layers.LinkTypeIPv6:
+		return layers.LayerTypeIPv6, nil
+	}
+	return nil, fmt.Errorf("unsupported link type %d", linkType)
+}
+
+// decodeLinuxSLL2 decodes the Linux cooked capture v2 header, not supported by gopacket
+// https://www.tcpdump.org/linktypes/LINKTYPE_LINUX_SLL2.html
+func decodeLinuxSLL2(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < 20 {
+		return errors.New("linux SLL2 packet too small")
+	}
+
+	sll := &layers.LinuxSLL{
+		EthernetType: layers.EthernetType(binary.BigEndian.Uint16(data[0:2])),
+		AddrType:     binary.BigEndian.Uint16(data[8:10]),
+		PacketType:   layers.LinuxSLLPacketType(data[10]),
+		AddrLen:      uint16(data[11]),
+	}
+	sll.Addr = net.HardwareAddr(data[12 : 12+min(int(sll.AddrLen), 8)])
+	sll.Contents = data[:20]
+	sll.Payload = data[20:]
+
+	p.AddLayer(sll)
+	p.SetLinkLayer(sll)
+	return p.NextDecoder(sll.EthernetType)
+}
+
+func (w *FileIngestor) ProcessPcap(filePath string) {
+	// open the file, decompressed on the fly if needed
+	f, err := OpenFileReader(filePath)
 	if err != nil {
 		w.LogError("unable to read file: %s", err)
 		return
 	}
 	defer f.Close()
 
-	// it is a pcap file ?
-	pcapHandler, err := pcapgo.NewReader(f)
+	// it is a pcap or pcapng file ?
+	pcapHandler, err := NewPcapReader(f)
 	if err != nil {
 		w.LogError("unable to read pcap file: %s", err)
 		return
@@ -100,9 +348,15 @@ func (w *FileIngestor) ProcessPcap(filePath string) {
 	fileName := filepath.Base(filePath)
 	w.LogInfo("processing pcap file [%s]...", fileName)
 
-	if pcapHandler.LinkType() != layers.LinkTypeEthernet {
-		w.LogError("pcap file [%s] ignored: %s", filePath, pcapHandler.LinkType())
-		return
+	// with pcapng, each interface can have its own link type
+	decoders := make(map[uint32]gopacket.Decoder)
+	if reader, isPcap := pcapHandler.(*pcapReader); isPcap {
+		decoder, err := GetLinkTypeDecoder(reader.linkType)
+		if err != nil {
+			w.LogError("pcap file [%s] ignored: %s", filePath, err)
+			return
+		}
+		decoders[reader.linkType] = decoder
 	}
 
 	dnsChan := make(chan netutils.DNSPacket)
@@ -111,10 +365,6 @@ func (w *FileIngestor) ProcessPcap(filePath string) {
 	fragIP4Chan := make(chan gopacket.Packet)
 	fragIP6Chan := make(chan gopacket.Packet)
 
-	packetSource := gopacket.NewPacketSource(pcapHandler, pcapHandler.LinkType())
-	packetSource.DecodeOptions.Lazy = true
-	packetSource.NoCopy = true
-
 	// defrag ipv4
 	go netutils.IPDefragger(fragIP4Chan, udpChan, tcpChan, w.GetConfig().Collectors.FileIngestor.PcapDNSPort)
 	// defrag ipv6
@@ -173,7 +423,7 @@ func (w *FileIngestor) ProcessPcap(filePath string) {
 
 	nbPackets := 0
 	for {
-		packet, err := packetSource.NextPacket()
+		data, ci, err := pcapHandler.ReadPacketData()
 
 		if errors.Is(err, io.EOF) {
 			break
@@ -185,6 +435,25 @@ func (w *FileIngestor) ProcessPcap(filePath string) {
 
 		nbPackets++
 
+		// link type of the interface for pcapng files
+		linkType := pcapHandler.PacketLinkType(ci)
+
+		decoder, found := decoders[linkType]
+		if !found {
+			decoder, err = GetLinkTypeDecoder(linkType)
+			if err != nil {
+				w.LogError("pcap file [%s] packets ignored: %s", fileName, err)
+			}
+			decoders[linkType] = decoder
+		}
+		if decoder == nil {
+			continue
+		}
+
+		// decode the packet, data are never reused by the pcap readers
+		packet := gopacket.NewPacket(data, decoder, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		packet.Metadata().CaptureInfo = ci
+
 		// some security checks
 		if packet.NetworkLayer() == nil {
 			continue
@@ -240,8 +509,8 @@ func (w *FileIngestor) ProcessPcap(filePath string) {
 }
 
 func (w *FileIngestor) ProcessDnstap(filePath string) error {
-	// open the file
-	f, err := os.Open(filePath)
+	// open the file, decompressed on the fly if needed
+	f, err := OpenFileReader(filePath)
 	if err != nil {
 		return err
 	}
@@ -263,6 +532,10 @@ func (w *FileIngestor) ProcessDnstap(filePath string) error {
 		if errors.Is(err, io.EOF) {
 			break
 		}
+		if err != nil {
+			w.LogError("unable to read dnstap file [%s]: %s", fileName, err)
+			break
+		}
 
 		newbuf := make([]byte, len(buf))
 		copy(newbuf, buf)
@@ -283,6 +556,65 @@ func (w *FileIngestor) ProcessDnstap(filePath string) error {
 	return nil
 }
 
+func (w *FileIngestor) ProcessCdns(filePath string) {
+	// open the file, decompressed on the fly if needed
+	f, err := OpenFileReader(filePath)
+	if err != nil {
+		w.LogError("unable to read file: %s", err)
+		return
+	}
+	defer f.Close()
+
+	cdnsReader, err := dnsutils.NewCDNSReader(f)
+	if err != nil {
+		w.LogError("unable to read c-dns file: %s", err)
+		return
+	}
+
+	fileName := filepath.Base(filePath)
+	w.LogInfo("processing c-dns file [%s]", fileName)
+
+	nbMessages := 0
+	for {
+		// queries and responses of the next transaction
+		msgs, err := cdnsReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			w.LogError("unable to read c-dns file [%s]: %s", fileName, err)
+			break
+		}
+
+		for _, dm := range msgs {
+			if dm.DNSTap.Identity == "-" {
+				dm.DNSTap.Identity = w.GetConfig().GetServerIdentity()
+			}
+
+			// re-encode as dnstap to be decoded by the dnstap processor
+			// operation, identity and network informations are kept
+			data, err := dm.ToDNSTap(false)
+			if err != nil {
+				w.LogError("failed to encode to DNStap protobuf: %s", err)
+				continue
+			}
+			w.dnstapProcessor.GetDataChannel() <- data
+			nbMessages++
+		}
+	}
+
+	w.LogInfo("processing of [%s] terminated, %d DNS message(s) read", fileName, nbMessages)
+
+	// remove it ?
+	if w.GetConfig().Collectors.FileIngestor.DeleteAfter {
+		w.LogInfo("delete file [%s]", fileName)
+		os.Remove(filePath)
+	}
+
+	// remove event timer for this file
+	w.RemoveEvent(filePath)
+}
+
 func (w *FileIngestor) RegisterEvent(filePath string) {
 	// Get timer.
 	w.mu.Lock()
@@ -321,12 +653,16 @@ func (w *FileIngestor) StartCollect() {
 	dnsProcessor := NewDNSProcessor(w.GetConfig(), w.GetLogger(), w.GetName(), bufSize)
 	dnsProcessor.SetDefaultRoutes(w.GetDefaultRoutes())
 	dnsProcessor.SetDefaultDropped(w.GetDroppedRoutes())
+	dnsProcessor.SetRouting(w.GetRouting())
+	dnsProcessor.SetControl(w.GetControl())
 	go dnsProcessor.StartCollect()
 
 	// start dnstap subprocessor
 	dnstapProcessor := NewDNSTapProcessor(0, "", w.GetConfig(), w.GetLogger(), w.GetName(), bufSize)
 	dnstapProcessor.SetDefaultRoutes(w.GetDefaultRoutes())
 	dnstapProcessor.SetDefaultDropped(w.GetDroppedRoutes())
+	dnstapProcessor.SetRouting(w.GetRouting())
+	dnstapProcessor.SetControl(w.GetControl())
 	go dnstapProcessor.StartCollect()
 
 	w.dnstapProcessor = dnstapProcessor
@@ -347,18 +683,8 @@ func (w *FileIngestor) StartCollect() {
 		// prepare filepath
 		fn := filepath.Join(w.GetConfig().Collectors.FileIngestor.WatchDir, entry.Name())
 
-		switch w.GetConfig().Collectors.FileIngestor.WatchMode {
-		case pkgconfig.ModePCAP:
-			// process file with pcap extension
-			if filepath.Ext(fn) == ".pcap" || filepath.Ext(fn) == ".pcap.gz" {
-				go w.ProcessPcap(fn)
-			}
-		case pkgconfig.ModeDNSTap:
-			// process dnstap
-			if filepath.Ext(fn) == ".fstrm" {
-				go w.ProcessDnstap(fn)
-			}
-		}
+		// process the file according to the watch mode
+		w.ProcessFile(fn)
 	}
 
 	// then watch for new one
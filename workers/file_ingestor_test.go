@@ -1,11 +1,19 @@
 package workers
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-logger"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 )
 
 func Test_FileIngestor(t *testing.T) {
@@ -24,6 +32,26 @@ func Test_FileIngestor(t *testing.T) {
 			watchMode: "dnstap",
 			watchDir:  "./../tests/testsdata/dnstap/",
 		},
+		{
+			name:      "PcapLinkTypes",
+			watchMode: "pcap",
+			watchDir:  "./../tests/testsdata/pcap_linktypes/",
+		},
+		{
+			name:      "PcapCompressed",
+			watchMode: "pcap",
+			watchDir:  "./../tests/testsdata/compressed/",
+		},
+		{
+			name:      "DnstapCompressed",
+			watchMode: "dnstap",
+			watchDir:  "./../tests/testsdata/compressed/",
+		},
+		{
+			name:      "Cdns",
+			watchMode: "cdns",
+			watchDir:  "./../tests/testsdata/cdns/",
+		},
 	}
 
 	for _, tt := range tests {
@@ -52,3 +80,207 @@ func Test_FileIngestor(t *testing.T) {
 		})
 	}
 }
+
+func Test_FileIngestor_PcapLinkTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		filePath  string
+		linkTypes map[uint32]int
+	}{
+		{
+			name:     "Pcapng",
+			filePath: "./../tests/testsdata/pcap_linktypes/dnsdump_udp_multi_interfaces.pcapng",
+			linkTypes: map[uint32]int{
+				uint32(layers.LinkTypeEthernet): 2,
+				uint32(layers.LinkTypeLinuxSLL): 2,
+				uint32(layers.LinkTypeRaw):      2,
+				uint32(layers.LinkTypeNull):     2,
+				uint32(layers.LinkTypeLoop):     2,
+			},
+		},
+		{
+			name:      "LinuxSLL2",
+			filePath:  "./../tests/testsdata/pcap_linktypes/dnsdump_udp_linux_sll2.pcap",
+			linkTypes: map[uint32]int{LinkTypeLinuxSLL2: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			reader, err := NewPcapReader(f)
+			if err != nil {
+				t.Fatalf("unable to read pcap file: %s", err)
+			}
+
+			linkTypes := make(map[uint32]int)
+			for {
+				data, ci, err := reader.ReadPacketData()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unable to read packet: %s", err)
+				}
+
+				linkType := reader.PacketLinkType(ci)
+
+				decoder, err := GetLinkTypeDecoder(linkType)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				packet := gopacket.NewPacket(data, decoder, gopacket.Default)
+				if packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeUDP {
+					t.Errorf("udp packet expected with link type %d", linkType)
+				}
+				linkTypes[linkType]++
+			}
+
+			for linkType, count := range tt.linkTypes {
+				if linkTypes[linkType] != count {
+					t.Errorf("link type %d: %d packet(s) expected, got %d", linkType, count, linkTypes[linkType])
+				}
+			}
+		})
+	}
+}
+
+func Test_FileIngestor_UnsupportedLinkType(t *testing.T) {
+	if _, err := GetLinkTypeDecoder(uint32(layers.LinkTypeIEEE802_11)); err == nil {
+		t.Errorf("error expected with unsupported link type")
+	}
+
+	// link types above 255 are not truncated
+	for _, linkType := range []uint32{256, 257, 276 + 256} {
+		if _, err := GetLinkTypeDecoder(linkType); err == nil {
+			t.Errorf("error expected with unsupported link type %d", linkType)
+		}
+	}
+}
+
+func Test_FileIngestor_PcapRawLinkType(t *testing.T) {
+	// pcap file header with the link type 257, truncated to ethernet by gopacket
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[20:24], 257)
+
+	reader, err := NewPcapReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unable to read pcap file: %s", err)
+	}
+	if linkType := reader.PacketLinkType(gopacket.CaptureInfo{}); linkType != 257 {
+		t.Errorf("link type 257 expected, got %d", linkType)
+	}
+}
+
+func Test_FileIngestor_PcapngRawLinkType(t *testing.T) {
+	// read a linux sll2 packet
+	f, err := os.Open("./../tests/testsdata/pcap_linktypes/dnsdump_udp_linux_sll2.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader, err := NewPcapReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ci, err := reader.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// write it to a pcapng file, then set the link type of the interface to 276
+	var buf bytes.Buffer
+	w, err := pcapgo.NewNgWriterInterface(&buf, pcapgo.NgInterface{LinkType: layers.LinkType(LinkTypeLinuxSLL2 & 0xff), SnapLength: 65536}, pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci.InterfaceIndex = 0
+	if err := w.WritePacket(ci, data); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	pcapng := buf.Bytes()
+	shbLength := binary.LittleEndian.Uint32(pcapng[4:8])
+	binary.LittleEndian.PutUint16(pcapng[shbLength+8:], LinkTypeLinuxSLL2)
+
+	ngReader, err := NewPcapReader(bytes.NewReader(pcapng))
+	if err != nil {
+		t.Fatalf("unable to read pcapng file: %s", err)
+	}
+	data, ci, err = ngReader.ReadPacketData()
+	if err != nil {
+		t.Fatalf("unable to read packet: %s", err)
+	}
+	linkType := ngReader.PacketLinkType(ci)
+	if linkType != LinkTypeLinuxSLL2 {
+		t.Fatalf("link type %d expected, got %d", LinkTypeLinuxSLL2, linkType)
+	}
+	decoder, _ := GetLinkTypeDecoder(linkType)
+	packet := gopacket.NewPacket(data, decoder, gopacket.Default)
+	if packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeUDP {
+		t.Errorf("udp packet expected")
+	}
+}
+
+func Test_FileIngestor_GetFileExtension(t *testing.T) {
+	tests := map[string]string{
+		"dnsdump.pcap":         ".pcap",
+		"dnsdump.pcap.gz":      ".pcap",
+		"dnsdump.pcapng.zst":   ".pcapng",
+		"dnsdump.pcap.lz4":     ".pcap",
+		"/tmp/dnstap.fstrm.gz": ".fstrm",
+		"dnsdump.gz":           "",
+	}
+	for filePath, want := range tests {
+		if got := GetFileExtension(filePath); got != want {
+			t.Errorf("%s: want %q, got %q", filePath, want, got)
+		}
+	}
+}
+
+func Test_FileIngestor_OpenFileReader(t *testing.T) {
+	tests := []struct {
+		filePath string
+		original string
+	}{
+		{filePath: "./../tests/testsdata/pcap/dnsdump_udp.pcap", original: "./../tests/testsdata/pcap/dnsdump_udp.pcap"},
+		{filePath: "./../tests/testsdata/compressed/dnsdump_udp.pcap.gz", original: "./../tests/testsdata/pcap/dnsdump_udp.pcap"},
+		{filePath: "./../tests/testsdata/compressed/dnsdump_udp.pcap.zst", original: "./../tests/testsdata/pcap/dnsdump_udp.pcap"},
+		{filePath: "./../tests/testsdata/compressed/dnsdump_udp.pcap.lz4", original: "./../tests/testsdata/pcap/dnsdump_udp.pcap"},
+		{filePath: "./../tests/testsdata/compressed/dnstap.fstrm.gz", original: "./../tests/testsdata/dnstap/dnstap.fstrm"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			want, err := os.ReadFile(tt.original)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := OpenFileReader(tt.filePath)
+			if err != nil {
+				t.Fatalf("unable to open file: %s", err)
+			}
+			defer f.Close()
+
+			got, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("unable to read file: %s", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("decompressed content differs from the original file")
+			}
+		})
+	}
+}
@@ -56,6 +56,7 @@ func (w *Tail) StartCollect() {
 	defaultRoutes, defaultNames := GetRoutes(w.GetDefaultRoutes())
 	droppedRoutes, droppedNames := GetRoutes(w.GetDroppedRoutes())
 	subprocessors := transformers.NewTransforms(&w.GetConfig().IngoingTransformers, w.GetLogger(), w.GetName(), defaultRoutes, 0)
+	w.StartTransforms(&subprocessors, w.IngoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// init dns message
 	dm := dnsutils.DNSMessage{}
@@ -220,18 +221,8 @@ func (w *Tail) StartCollect() {
 			// count output packets
 			w.CountEgressTraffic()
 
-			// apply all enabled transformers
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// apply all enabled transformers then dispatch to connected routes
+			subprocessors.Process(dm)
 		}
 	}
 }
@@ -27,6 +27,7 @@ type KafkaProducer struct {
 	compressCodec              compress.Codec
 	kafkaConns                 map[int]*kafka.Conn // Map to store connections by partition
 	lastPartitionIndex         *int
+	spoolBatch                 SpoolBatch
 }
 
 func NewKafkaProducer(config *pkgconfig.Config, logger *logger.Logger, name string) *KafkaProducer {
@@ -41,6 +42,9 @@ func NewKafkaProducer(config *pkgconfig.Config, logger *logger.Logger, name stri
 		kafkaConns:     make(map[int]*kafka.Conn),
 	}
 	w.ReadConfig()
+	if config.Loggers.KafkaProducer.Spool.Enable {
+		w.EnableSpool(config.Loggers.KafkaProducer.Spool)
+	}
 	return w
 }
 
@@ -232,6 +236,7 @@ func (w *KafkaProducer) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 		if err != nil {
 			w.LogError("unable to write message", err.Error())
 			w.kafkaConnected = false
+			w.SetRemoteAvailable(false)
 			<-w.kafkaReconnect
 		}
 
@@ -247,10 +252,14 @@ func (w *KafkaProducer) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 		if err != nil {
 			w.LogError("unable to write message", err.Error())
 			w.kafkaConnected = false
+			w.SetRemoteAvailable(false)
 			<-w.kafkaReconnect
 		}
 	}
 
+	// with the spool, the failed messages are written back to it
+	w.SpoolBatchDone(&w.spoolBatch, err)
+
 	// reset buffer
 	*buf = nil
 }
@@ -265,6 +274,7 @@ func (w *KafkaProducer) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// goroutine to process transformed dns messages
 	go w.StartLogging()
@@ -273,8 +283,8 @@ func (w *KafkaProducer) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			return
 
 			// new config provided?
@@ -292,21 +302,8 @@ func (w *KafkaProducer) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-			w.GetOutputChannel() <- dm
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// then send to output channel and to next
+			subprocessors.Process(dm)
 		}
 	}
 }
@@ -330,9 +327,22 @@ func (w *KafkaProducer) StartLogging() {
 
 	go w.ConnectToKafka(ctx, readyTimer)
 
+	// messages are kept in the spool until the connection is ready
+	w.SetRemoteAvailable(false)
+
 	for {
+		// with the spool, the messages are not consumed while disconnected
+		outputChannel, spoolChannel := w.GetOutputChannel(), w.GetSpoolChannel()
+		if !w.kafkaConnected && w.SpoolEnabled() {
+			outputChannel, spoolChannel = nil, nil
+		}
+
 		select {
 		case <-w.OnLoggerStopped():
+			// send the pending messages
+			if w.kafkaConnected && len(bufferDm) > 0 {
+				w.FlushBuffer(&bufferDm)
+			}
 			// closing kafka connection if exist
 			w.Disconnect()
 			return
@@ -345,9 +355,10 @@ func (w *KafkaProducer) StartLogging() {
 			w.LogInfo("connected with success")
 			readyTimer.Stop()
 			w.kafkaConnected = true
+			w.SetRemoteAvailable(true)
 
 		// incoming dns message to process
-		case dm, opened := <-w.GetOutputChannel():
+		case dm, opened := <-outputChannel:
 			if !opened {
 				w.LogInfo("output channel closed!")
 				return
@@ -361,6 +372,17 @@ func (w *KafkaProducer) StartLogging() {
 
 			// append dns message to buffer
 			bufferDm = append(bufferDm, dm)
+			w.SpoolBatchAdd(&w.spoolBatch, dm)
+
+			// buffer is full ?
+			if len(bufferDm) >= w.GetConfig().Loggers.KafkaProducer.BufferSize {
+				w.FlushBuffer(&bufferDm)
+			}
+
+		// dns message replayed from the spool
+		case sm := <-spoolChannel:
+			bufferDm = append(bufferDm, sm.DNSMessage)
+			w.spoolBatch.AddReplayed(sm)
 
 			// buffer is full ?
 			if len(bufferDm) >= w.GetConfig().Loggers.KafkaProducer.BufferSize {
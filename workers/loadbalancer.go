@@ -0,0 +1,105 @@
+package workers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+)
+
+// number of virtual nodes per route on the hash ring
+const loadBalancerVirtualNodes = 100
+
+type ringNode struct {
+	hash  uint64
+	route int
+}
+
+// LoadBalancer selects one route among the forward routes for each message
+type LoadBalancer struct {
+	algo    string
+	hashKey string
+	routes  int
+	counter uint64
+	ring    []ringNode
+
+	// routing state used to build the load balancer
+	routing *Routing
+}
+
+func NewLoadBalancer(policy pkgconfig.PipelinesRouting, routesName []string) *LoadBalancer {
+	lb := &LoadBalancer{
+		algo:    policy.LoadBalance,
+		hashKey: policy.GetHashKey(),
+		routes:  len(routesName),
+	}
+
+	// build the hash ring, the position of a route depends only on its name
+	// so every worker of the stanza selects the same route for a given key
+	if lb.algo == pkgconfig.LoadBalanceConsistentHash {
+		for i, name := range routesName {
+			for v := 0; v < loadBalancerVirtualNodes; v++ {
+				lb.ring = append(lb.ring, ringNode{hash: hashString(name + "#" + strconv.Itoa(v)), route: i})
+			}
+		}
+		sort.Slice(lb.ring, func(i, j int) bool { return lb.ring[i].hash < lb.ring[j].hash })
+	}
+	return lb
+}
+
+// hashString returns the fnv-1a hash of the value, mixed with the
+// murmur3 finalizer to spread similar values on the whole ring
+func hashString(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Select returns the index of the preferred route for the message
+func (lb *LoadBalancer) Select(dm *dnsutils.DNSMessage, routes []chan dnsutils.DNSMessage) int {
+	switch lb.algo {
+	case pkgconfig.LoadBalanceLeastLoaded:
+		selected, minLoad := 0, 2.0
+		for i := range routes {
+			load := 1.0
+			if cap(routes[i]) > 0 {
+				load = float64(len(routes[i])) / float64(cap(routes[i]))
+			}
+			if load < minLoad {
+				selected, minLoad = i, load
+			}
+		}
+		return selected
+
+	case pkgconfig.LoadBalanceConsistentHash:
+		key := hashString(lb.GetKey(dm))
+		i := sort.Search(len(lb.ring), func(i int) bool { return lb.ring[i].hash >= key })
+		if i == len(lb.ring) {
+			i = 0
+		}
+		return lb.ring[i].route
+
+	default:
+		return int((atomic.AddUint64(&lb.counter, 1) - 1) % uint64(lb.routes))
+	}
+}
+
+// GetKey returns the value of the hash key field
+func (lb *LoadBalancer) GetKey(dm *dnsutils.DNSMessage) string {
+	value, found := dnsutils.GetFieldByJSONTag(reflect.ValueOf(dm).Elem(), lb.hashKey)
+	if !found {
+		return ""
+	}
+	return fmt.Sprint(value.Interface())
+}
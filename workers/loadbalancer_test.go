@@ -0,0 +1,97 @@
+package workers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+)
+
+func getRoutesForTest(n int, size int) ([]chan dnsutils.DNSMessage, []string) {
+	routes := []chan dnsutils.DNSMessage{}
+	names := []string{}
+	for i := 0; i < n; i++ {
+		routes = append(routes, make(chan dnsutils.DNSMessage, size))
+		names = append(names, "logger"+strconv.Itoa(i))
+	}
+	return routes, names
+}
+
+func TestLoadBalancer_RoundRobin(t *testing.T) {
+	routes, names := getRoutesForTest(3, 10)
+	lb := NewLoadBalancer(pkgconfig.PipelinesRouting{LoadBalance: pkgconfig.LoadBalanceRoundRobin}, names)
+
+	dm := dnsutils.GetFakeDNSMessage()
+	for i := 0; i < 6; i++ {
+		if selected := lb.Select(&dm, routes); selected != i%3 {
+			t.Errorf("message %d: want route %d, got %d", i, i%3, selected)
+		}
+	}
+}
+
+func TestLoadBalancer_LeastLoaded(t *testing.T) {
+	routes, names := getRoutesForTest(3, 10)
+	lb := NewLoadBalancer(pkgconfig.PipelinesRouting{LoadBalance: pkgconfig.LoadBalanceLeastLoaded}, names)
+
+	dm := dnsutils.GetFakeDNSMessage()
+	routes[0] <- dm
+	routes[0] <- dm
+	routes[1] <- dm
+
+	if selected := lb.Select(&dm, routes); selected != 2 {
+		t.Errorf("want route 2, got %d", selected)
+	}
+}
+
+func TestLoadBalancer_ConsistentHash(t *testing.T) {
+	routes, names := getRoutesForTest(3, 10)
+	lb := NewLoadBalancer(pkgconfig.PipelinesRouting{LoadBalance: pkgconfig.LoadBalanceConsistentHash}, names)
+
+	// the same client is always sent to the same route
+	selected := map[string]int{}
+	used := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.NetworkInfo.QueryIP = "192.168.1." + strconv.Itoa(i)
+		selected[dm.NetworkInfo.QueryIP] = lb.Select(&dm, routes)
+		used[selected[dm.NetworkInfo.QueryIP]] = true
+	}
+	if len(used) != 3 {
+		t.Errorf("clients should be distributed on all routes: %v", used)
+	}
+
+	// a new load balancer with the same routes gives the same result
+	lb = NewLoadBalancer(pkgconfig.PipelinesRouting{LoadBalance: pkgconfig.LoadBalanceConsistentHash}, names)
+	for queryIP, route := range selected {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.NetworkInfo.QueryIP = queryIP
+		if lb.Select(&dm, routes) != route {
+			t.Errorf("client %s moved to another route", queryIP)
+		}
+	}
+}
+
+func TestLoadBalancer_Failover(t *testing.T) {
+	w := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+	w.SetRoutingPolicy(pkgconfig.PipelinesRouting{LoadBalance: pkgconfig.LoadBalanceConsistentHash})
+
+	routes, names := getRoutesForTest(2, 1)
+	dm := dnsutils.GetFakeDNSMessage()
+
+	// the first message is sent to the selected route
+	w.SendForwardedTo(routes, names, dm)
+	selected := 0
+	if len(routes[1]) == 1 {
+		selected = 1
+	}
+	if len(routes[selected]) != 1 || len(routes[1-selected]) != 0 {
+		t.Fatalf("message should be sent to one route only")
+	}
+
+	// the selected route is full, the message is sent to the other one
+	w.SendForwardedTo(routes, names, dm)
+	if len(routes[1-selected]) != 1 {
+		t.Errorf("message should be sent to the other route")
+	}
+}
@@ -26,6 +26,9 @@ import (
 	"github.com/google/gopacket/pcapgo"
 
 	framestream "github.com/farsightsec/golang-framestream"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/prometheus/common/version"
 )
 
 const (
@@ -40,17 +43,34 @@ func IsValid(mode string) bool {
 		pkgconfig.ModeJSON,
 		pkgconfig.ModeFlatJSON,
 		pkgconfig.ModePCAP,
-		pkgconfig.ModeDNSTap:
+		pkgconfig.ModeDNSTap,
+		pkgconfig.ModeCDNS,
+		pkgconfig.ModeParquet:
 		return true
 	}
 	return false
 }
 
+// sizeWriter counts the bytes written to the underlying writer
+type sizeWriter struct {
+	w    io.Writer
+	size int64
+}
+
+func (s *sizeWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
 type LogFile struct {
 	*GenericWorker
 	writerPlain                            *bufio.Writer
 	writerPcap                             *pcapgo.Writer
 	writerDnstap                           *framestream.Encoder
+	writerCdns                             *dnsutils.CDNSWriter
+	writerParquet                          *parquet.GenericWriter[dnsutils.DNSMessageParquet]
+	writerParquetSize                      *sizeWriter
 	fileFd                                 *os.File
 	fileSize                               int64
 	fileDir, fileName, fileExt, filePrefix string
@@ -71,9 +91,6 @@ func NewLogFile(config *pkgconfig.Config, logger *logger.Logger, name string) *L
 		commandQueue:  make(chan string, 1),
 	}
 	w.ReadConfig()
-	if err := w.OpenCurrentFile(); err != nil {
-		w.LogFatal(pkgconfig.PrefixLogWorker+"["+name+"] file - unable to open output file:", err)
-	}
 
 	// start compressor
 	go w.startCompressor()
@@ -82,6 +99,12 @@ func NewLogFile(config *pkgconfig.Config, logger *logger.Logger, name string) *L
 	// start post command processor
 	go w.startCommandProcessor()
 
+	// after the compression queue initialization, the previous c-dns
+	// or parquet file can be rotated on open
+	if err := w.OpenCurrentFile(); err != nil {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+name+"] file - unable to open output file:", err)
+	}
+
 	return w
 }
 
@@ -106,9 +129,30 @@ func (w *LogFile) ReadConfig() {
 		w.jinjaFormat = w.GetConfig().Global.TextJinja
 	}
 
+	if w.GetConfig().Loggers.LogFile.Mode == pkgconfig.ModeParquet {
+		if _, err := GetParquetCodec(w.GetConfig().Loggers.LogFile.ParquetCompression); err != nil {
+			w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] file - ", err)
+		}
+	}
+
 	w.LogInfo("running in mode: %s", w.GetConfig().Loggers.LogFile.Mode)
 }
 
+// GetParquetCodec returns the compression codec of the parquet columns
+func GetParquetCodec(compression string) (compress.Codec, error) {
+	switch compression {
+	case pkgconfig.CompressSnappy:
+		return &parquet.Snappy, nil
+	case pkgconfig.ParquetCompressZstd:
+		return &parquet.Zstd, nil
+	case pkgconfig.CompressGzip:
+		return &parquet.Gzip, nil
+	case pkgconfig.CompressNone:
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("invalid parquet compression: %s", compression)
+}
+
 func (w *LogFile) RemoveOldFiles() error {
 	if w.GetConfig().Loggers.LogFile.MaxFiles == 0 {
 		return nil
@@ -178,9 +222,21 @@ func (w *LogFile) OpenCurrentFile() error {
 
 	w.fileSize = fileinfo.Size()
 
+	// c-dns and parquet files are terminated on close and can't be appended,
+	// so the previous one is kept aside with a rotated name
+	mode := w.GetConfig().Loggers.LogFile.Mode
+	if w.fileSize > 0 && (mode == pkgconfig.ModeCDNS || mode == pkgconfig.ModeParquet) {
+		fd.Close()
+		if err := w.ArchiveCurrentFile(); err != nil {
+			return err
+		}
+		w.LogInfo("previous %s file rotated", mode)
+		return w.OpenCurrentFile()
+	}
+
 	switch w.GetConfig().Loggers.LogFile.Mode {
 	case pkgconfig.ModeText, pkgconfig.ModeJSON, pkgconfig.ModeFlatJSON:
-		w.writerPlain = bufio.NewWriterSize(fd, w.config.Loggers.LogFile.MaxBatchSize)
+		w.writerPlain = bufio.NewWriterSize(fd, w.GetConfig().Loggers.LogFile.MaxBatchSize)
 
 	case pkgconfig.ModePCAP:
 		w.writerPcap = pcapgo.NewWriter(fd)
@@ -197,6 +253,25 @@ func (w *LogFile) OpenCurrentFile() error {
 			return err
 		}
 
+	case pkgconfig.ModeCDNS:
+		w.writerCdns = dnsutils.NewCDNSWriter(fd, w.GetConfig().Loggers.LogFile.CdnsMaxBlockItems, w.GetConfig().GetServerIdentity())
+		n, err := w.writerCdns.WriteHeader()
+		if err != nil {
+			return err
+		}
+		w.fileSize += int64(n)
+
+	case pkgconfig.ModeParquet:
+		codec, err := GetParquetCodec(w.GetConfig().Loggers.LogFile.ParquetCompression)
+		if err != nil {
+			return err
+		}
+		w.writerParquetSize = &sizeWriter{w: fd}
+		w.writerParquet = parquet.NewGenericWriter[dnsutils.DNSMessageParquet](w.writerParquetSize,
+			parquet.Compression(codec),
+			parquet.MaxRowsPerRowGroup(int64(w.GetConfig().Loggers.LogFile.ParquetRowGroupSize)),
+			parquet.CreatedBy("dnscollector", version.Version, version.Revision),
+		)
 	}
 
 	w.LogInfo("new log file created")
@@ -213,7 +288,7 @@ func (w *LogFile) compressFile(filename string) {
 	// prepare dest filename
 	baseName := filepath.Base(filename)
 	baseName = strings.TrimPrefix(baseName, "tocompress-")
-	if len(w.config.Loggers.LogFile.PostRotateCommand) > 0 {
+	if len(w.GetConfig().Loggers.LogFile.PostRotateCommand) > 0 {
 		baseName = "toprocess-" + baseName
 	}
 	tmpFile := filename + compressSuffix
@@ -277,7 +352,7 @@ func (w *LogFile) compressFile(filename string) {
 	}
 
 	// run post command on compressed file ?
-	if len(w.config.Loggers.LogFile.PostRotateCommand) > 0 {
+	if len(w.GetConfig().Loggers.LogFile.PostRotateCommand) > 0 {
 		go func() {
 			w.commandQueue <- dstFile
 		}()
@@ -312,6 +387,30 @@ func (w *LogFile) FlushWriters() {
 		w.writerPlain.Flush()
 	case pkgconfig.ModeDNSTap:
 		w.writerDnstap.Flush()
+	case pkgconfig.ModeCDNS:
+		// write the pending block, even if not full
+		n, err := w.writerCdns.Flush()
+		if err != nil {
+			w.LogError("failed to flush c-dns writer: %s", err)
+		}
+		w.fileSize += int64(n)
+	}
+}
+
+func (w *LogFile) CloseWriters() {
+	switch w.GetConfig().Loggers.LogFile.Mode {
+	case pkgconfig.ModeDNSTap:
+		w.writerDnstap.Close()
+	case pkgconfig.ModeCDNS:
+		// write the pending block and terminate the file
+		if _, err := w.writerCdns.Close(); err != nil {
+			w.LogError("failed to close c-dns writer: %s", err)
+		}
+	case pkgconfig.ModeParquet:
+		// write the pending row group and the footer
+		if err := w.writerParquet.Close(); err != nil {
+			w.LogError("failed to close parquet writer: %s", err)
+		}
 	}
 }
 
@@ -319,19 +418,33 @@ func (w *LogFile) RotateFile() error {
 	// close writer and existing file
 	w.FlushWriters()
 
-	if w.GetConfig().Loggers.LogFile.Mode == pkgconfig.ModeDNSTap {
-		w.writerDnstap.Close()
-	}
+	w.CloseWriters()
 
 	if err := w.fileFd.Close(); err != nil {
 		return err
 	}
 
+	if err := w.ArchiveCurrentFile(); err != nil {
+		return err
+	}
+
+	// re-create new one
+	if err := w.OpenCurrentFile(); err != nil {
+		w.LogError("unable to re-create file: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// ArchiveCurrentFile renames the closed log file, then compress it or run the postrotate command,
+// and removes the old files
+func (w *LogFile) ArchiveCurrentFile() error {
 	// Rename current log file
 	newFilename := fmt.Sprintf("%s-%d%s", w.filePrefix, time.Now().UnixNano(), w.fileExt)
-	if w.config.Loggers.LogFile.Compress {
+	if w.GetConfig().Loggers.LogFile.Compress {
 		newFilename = fmt.Sprintf("tocompress-%s", newFilename)
-	} else if len(w.config.Loggers.LogFile.PostRotateCommand) > 0 {
+	} else if len(w.GetConfig().Loggers.LogFile.PostRotateCommand) > 0 {
 		newFilename = fmt.Sprintf("toprocess-%s", newFilename)
 	}
 	bfpath := filepath.Join(w.fileDir, newFilename)
@@ -341,7 +454,7 @@ func (w *LogFile) RotateFile() error {
 	}
 
 	// post rotate command?
-	if w.config.Loggers.LogFile.Compress {
+	if w.GetConfig().Loggers.LogFile.Compress {
 		go func() {
 			w.compressQueue <- bfpath
 		}()
@@ -358,12 +471,6 @@ func (w *LogFile) RotateFile() error {
 		return err
 	}
 
-	// re-create new one
-	if err := w.OpenCurrentFile(); err != nil {
-		w.LogError("unable to re-create file: %s", err)
-		return err
-	}
-
 	return nil
 }
 
@@ -436,6 +543,43 @@ func (w *LogFile) WriteToDnstap(data []byte) {
 	w.fileSize += int64(n)
 }
 
+func (w *LogFile) WriteToCdns(dm dnsutils.DNSMessage) {
+	// rotate file ? blocks are written only when full
+	// so the file can exceed the max size by one block
+	if w.fileSize > w.GetMaxSize() {
+		if err := w.RotateFile(); err != nil {
+			w.LogError("failed to rotate file: %s", err)
+			return
+		}
+	}
+
+	n, err := w.writerCdns.Write(&dm)
+	if err != nil {
+		w.LogError("failed to encode to c-dns: %s", err)
+	}
+
+	// increase size file
+	w.fileSize += int64(n)
+}
+
+func (w *LogFile) WriteToParquet(dm dnsutils.DNSMessage) {
+	// rotate file ? row groups are written only when full
+	// so the file can exceed the max size by one row group
+	if w.fileSize > w.GetMaxSize() {
+		if err := w.RotateFile(); err != nil {
+			w.LogError("failed to rotate file: %s", err)
+			return
+		}
+	}
+
+	if _, err := w.writerParquet.Write([]dnsutils.DNSMessageParquet{dm.ToParquet()}); err != nil {
+		w.LogError("failed to encode to parquet: %s", err)
+	}
+
+	// size of the file with the row groups written
+	w.fileSize = w.writerParquetSize.size
+}
+
 func (w *LogFile) initializeCompressionQueue() {
 	// Get all files in the log directory
 	files, err := os.ReadDir(w.fileDir)
@@ -490,6 +634,7 @@ func (w *LogFile) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// goroutine to process transformed dns messages
 	go w.StartLogging()
@@ -498,8 +643,8 @@ func (w *LogFile) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			return
 
 			// new config provided?
@@ -518,22 +663,8 @@ func (w *LogFile) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-
-			w.GetOutputChannel() <- dm
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// then send to output channel and to next
+			subprocessors.Process(dm)
 		}
 	}
 }
@@ -552,7 +683,7 @@ func (w *LogFile) StartLogging() {
 
 	// Max size of a batch before forcing a write
 	batch := new(bytes.Buffer)
-	maxBatchSize := w.config.Loggers.LogFile.MaxBatchSize
+	maxBatchSize := w.GetConfig().Loggers.LogFile.MaxBatchSize
 	batchSize := 0 // Current batch size
 
 	for {
@@ -575,9 +706,7 @@ func (w *LogFile) StartLogging() {
 
 			// closing file
 			w.LogInfo("closing log file")
-			if w.GetConfig().Loggers.LogFile.Mode == pkgconfig.ModeDNSTap {
-				w.writerDnstap.Close()
-			}
+			w.CloseWriters()
 			w.fileFd.Close()
 
 			return
@@ -643,6 +772,14 @@ func (w *LogFile) StartLogging() {
 
 				// write the packet
 				w.WriteToPcap(dm, pkt)
+
+			// with c-dns mode
+			case pkgconfig.ModeCDNS:
+				w.WriteToCdns(dm)
+
+			// with parquet mode
+			case pkgconfig.ModeParquet:
+				w.WriteToParquet(dm)
 			}
 
 			// Update the batch size
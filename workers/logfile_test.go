@@ -5,6 +5,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -14,6 +15,8 @@ import (
 	"github.com/dmachard/go-logger"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"github.com/parquet-go/parquet-go"
 )
 
 func Test_LogFileText(t *testing.T) {
@@ -132,3 +135,131 @@ func Test_LogFileWrite_PcapMode(t *testing.T) {
 		t.Errorf("no data in pcap file")
 	}
 }
+
+func Test_LogFileWrite_CdnsMode(t *testing.T) {
+	// create a temp file
+	f, err := os.CreateTemp("", "temp_cdnsfile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(f.Name()) // clean up
+
+	// config
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.LogFile.FilePath = f.Name()
+	config.Loggers.LogFile.Mode = pkgconfig.ModeCDNS
+
+	// init generator in testing mode
+	g := NewLogFile(config, logger.New(false), "test")
+
+	// init fake dm with a valid payload
+	dm := dnsutils.GetFakeDNSMessage()
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("dns.collector.", dns.TypeA)
+	dm.DNS.Payload, _ = dnsmsg.Pack()
+	dm.DNS.Length = len(dm.DNS.Payload)
+
+	// write fake dns message and flush the pending block
+	g.WriteToCdns(dm)
+	g.CloseWriters()
+
+	// read temp file and check content
+	cr, err := dnsutils.NewCDNSReader(f)
+	if err != nil {
+		t.Fatalf("unable to read c-dns file: %v", err)
+	}
+	msgs, err := cr.Next()
+	if err != nil {
+		t.Fatalf("unable to read c-dns item: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].DNS.Type != dnsutils.DNSQuery {
+		t.Errorf("one query expected, got %v", msgs)
+	}
+}
+
+func Test_LogFileWrite_CdnsMode_FlushAndRotate(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "dns.cdns")
+
+	// a previous c-dns file exists
+	if err := os.WriteFile(filePath, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.LogFile.FilePath = filePath
+	config.Loggers.LogFile.Mode = pkgconfig.ModeCDNS
+	config.Loggers.LogFile.Compress = true
+	g := NewLogFile(config, logger.New(false), "test")
+
+	// the previous file is rotated with compression
+	var compressed []string
+	for i := 0; i < 20 && len(compressed) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		compressed, _ = filepath.Glob(filepath.Join(tmpDir, "dns-*.cdns.gz"))
+	}
+	if len(compressed) != 1 {
+		t.Errorf("previous file not compressed: %v", compressed)
+	}
+
+	// the partial block is written on flush
+	dm := dnsutils.GetFakeDNSMessage()
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("dns.collector.", dns.TypeA)
+	dm.DNS.Payload, _ = dnsmsg.Pack()
+	g.WriteToCdns(dm)
+
+	reply := new(dns.Msg)
+	reply.SetReply(dnsmsg)
+	dm.DNSTap.Operation = dnsutils.DNSTapClientResponse
+	dm.DNS.Payload, _ = reply.Pack()
+	g.WriteToCdns(dm)
+
+	sizeBefore := g.fileSize
+	g.FlushWriters()
+	if g.fileSize <= sizeBefore {
+		t.Errorf("partial block not written on flush")
+	}
+	g.CloseWriters()
+	g.fileFd.Close()
+}
+
+func Test_LogFileWrite_ParquetMode(t *testing.T) {
+	// config
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.LogFile.FilePath = filepath.Join(t.TempDir(), "dnstap.parquet")
+	config.Loggers.LogFile.Mode = pkgconfig.ModeParquet
+	config.Loggers.LogFile.ParquetRowGroupSize = 2
+
+	// init generator in testing mode
+	g := NewLogFile(config, logger.New(false), "test")
+
+	// write fake dns messages, the file is terminated on rotation
+	for i := 0; i < 5; i++ {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = fmt.Sprintf("%d.dns.collector", i)
+		g.WriteToParquet(dm)
+	}
+	if err := g.RotateFile(); err != nil {
+		t.Fatalf("unable to rotate file: %v", err)
+	}
+	g.WriteToParquet(dnsutils.GetFakeDNSMessage())
+	g.CloseWriters()
+
+	// read the rotated and the current files
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(config.Loggers.LogFile.FilePath), "*.parquet"))
+	if len(files) != 2 {
+		t.Fatalf("want 2 files, got %v", files)
+	}
+	rows, err := parquet.ReadFile[dnsutils.DNSMessageParquet](files[0])
+	if err != nil {
+		t.Fatalf("unable to read parquet file: %v", err)
+	}
+	if len(rows) != 5 || rows[4].DNS.Qname != "4.dns.collector" {
+		t.Errorf("invalid rows in rotated file: %v", rows)
+	}
+	rows, err = parquet.ReadFile[dnsutils.DNSMessageParquet](config.Loggers.LogFile.FilePath)
+	if err != nil || len(rows) != 1 {
+		t.Errorf("want one row in current file, got %d: %v", len(rows), err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
@@ -34,6 +35,7 @@ type LokiStream struct {
 	stream      *logproto.Stream
 	pushrequest *logproto.PushRequest
 	sizeentries int
+	batch       SpoolBatch
 }
 
 func (w *LokiStream) Init() {
@@ -79,6 +81,9 @@ func NewLokiClient(config *pkgconfig.Config, logger *logger.Logger, name string)
 	w := &LokiClient{GenericWorker: NewGenericWorker(config, logger, name, "loki", bufSize, pkgconfig.DefaultMonitor)}
 	w.streams = make(map[string]*LokiStream)
 	w.ReadConfig()
+	if config.Loggers.LokiClient.Spool.Enable {
+		w.EnableSpool(config.Loggers.LokiClient.Spool)
+	}
 	return w
 }
 
@@ -141,6 +146,7 @@ func (w *LokiClient) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// goroutine to process transformed dns messages
 	go w.StartLogging()
@@ -149,8 +155,8 @@ func (w *LokiClient) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			return
 
 			// new config provided?
@@ -168,21 +174,8 @@ func (w *LokiClient) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-			w.GetOutputChannel() <- dm
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// then send to output channel and to next
+			subprocessors.Process(dm)
 		}
 	}
 }
@@ -200,141 +193,166 @@ func (w *LokiClient) StartLogging() {
 	tflush := time.NewTimer(tflushInterval)
 
 	for {
+		// with the spool, the messages are not consumed while the remote is unavailable
+		// but the replayed ones are read to retry the connection
+		outputChannel := w.GetOutputChannel()
+		if !w.RemoteAvailable() {
+			outputChannel = nil
+		}
+
+		var dm dnsutils.DNSMessage
+		var replayed *SpoolMessage
 		select {
 		case <-w.OnLoggerStopped():
+			// send the pending entries
+			w.FlushStreams()
 			return
 
 		// incoming dns message to process
-		case dm, opened := <-w.GetOutputChannel():
+		case msg, opened := <-outputChannel:
 			if !opened {
 				w.LogInfo("output channel closed!")
 				return
 			}
+			dm = msg
+
+		// dns message replayed from the spool
+		case sm := <-w.GetSpoolChannel():
+			dm, replayed = sm.DNSMessage, &sm
+
+		case <-tflush.C:
+			w.FlushStreams()
 
-			lbls := labels.Labels{
-				labels.Label{Name: "identity", Value: dm.DNSTap.Identity},
-				labels.Label{Name: "job", Value: w.GetConfig().Loggers.LokiClient.JobName},
+			// restart timer
+			tflush.Reset(tflushInterval)
+			continue
+		}
+
+		lbls := labels.Labels{
+			labels.Label{Name: "identity", Value: dm.DNSTap.Identity},
+			labels.Label{Name: "job", Value: w.GetConfig().Loggers.LokiClient.JobName},
+		}
+		var err error
+		var flat map[string]interface{}
+		if len(w.GetConfig().Loggers.LokiClient.RelabelConfigs) > 0 {
+			// Save flattened JSON in case it's used when populating the message of the log entry.
+			// There is more room for improvement for reusing data though. Flatten() internally
+			// does a JSON encode of the DnsMessage, but it's not saved to use when the mode
+			// is JSON.
+			flat, err = dm.Flatten()
+			if err != nil {
+				w.LogError("flattening DNS message failed: %e", err)
 			}
-			var err error
-			var flat map[string]interface{}
-			if len(w.GetConfig().Loggers.LokiClient.RelabelConfigs) > 0 {
-				// Save flattened JSON in case it's used when populating the message of the log entry.
-				// There is more room for improvement for reusing data though. Flatten() internally
-				// does a JSON encode of the DnsMessage, but it's not saved to use when the mode
-				// is JSON.
-				flat, err = dm.Flatten()
-				if err != nil {
-					w.LogError("flattening DNS message failed: %e", err)
-				}
-				sb := labels.NewScratchBuilder(len(lbls) + len(flat))
-				sb.Assign(lbls)
-				for k, v := range flat {
-					sb.Add(fmt.Sprintf("__%s", strings.ReplaceAll(k, ".", "_")), fmt.Sprint(v))
-				}
-				sb.Sort()
-				lbls, _ = relabel.Process(sb.Labels(), w.GetConfig().Loggers.LokiClient.RelabelConfigs...)
-
-				// Drop all labels starting with __ from the map if a relabel config is used.
-				// These labels are just exposed to relabel for the user and should not be
-				// shipped to loki by default.
-				lb := labels.NewBuilder(lbls)
-				lbls.Range(func(l labels.Label) {
-					if l.Name[0:2] == "__" {
-						lb.Del(l.Name)
-					}
-				})
-				lbls = lb.Labels()
-
-				if len(lbls) == 0 {
-					w.LogInfo("dropping %v since it has no labels", dm)
-					continue
-				}
+			sb := labels.NewScratchBuilder(len(lbls) + len(flat))
+			sb.Assign(lbls)
+			for k, v := range flat {
+				sb.Add(fmt.Sprintf("__%s", strings.ReplaceAll(k, ".", "_")), fmt.Sprint(v))
 			}
-
-			// prepare entry
-			entry := logproto.Entry{}
-			entry.Timestamp = time.Unix(int64(dm.DNSTap.TimeSec), int64(dm.DNSTap.TimeNsec))
-
-			switch w.GetConfig().Loggers.LokiClient.Mode {
-			case pkgconfig.ModeText:
-				entry.Line = string(dm.Bytes(w.textFormat,
-					w.GetConfig().Global.TextFormatDelimiter,
-					w.GetConfig().Global.TextFormatBoundary))
-			case pkgconfig.ModeJSON:
-				json.NewEncoder(buffer).Encode(dm)
-				entry.Line = buffer.String()
-				buffer.Reset()
-			case pkgconfig.ModeFlatJSON:
-				if len(flat) == 0 {
-					flat, err = dm.Flatten()
-					if err != nil {
-						w.LogError("flattening DNS message failed: %e", err)
-					}
+			sb.Sort()
+			lbls, _ = relabel.Process(sb.Labels(), w.GetConfig().Loggers.LokiClient.RelabelConfigs...)
+
+			// Drop all labels starting with __ from the map if a relabel config is used.
+			// These labels are just exposed to relabel for the user and should not be
+			// shipped to loki by default.
+			lb := labels.NewBuilder(lbls)
+			lbls.Range(func(l labels.Label) {
+				if l.Name[0:2] == "__" {
+					lb.Del(l.Name)
 				}
-				json.NewEncoder(buffer).Encode(flat)
-				entry.Line = buffer.String()
-				buffer.Reset()
-			}
-			key := string(lbls.Bytes(byteBuffer))
-			ls, ok := w.streams[key]
-			if !ok {
-				ls = &LokiStream{config: w.GetConfig(), logger: w.GetLogger(), labels: lbls}
-				ls.Init()
-				w.streams[key] = ls
+			})
+			lbls = lb.Labels()
+
+			if len(lbls) == 0 {
+				w.LogInfo("dropping %v since it has no labels", dm)
+				// the replayed message is acknowledged to not replay it again
+				if replayed != nil {
+					w.SpoolBatchDone(&SpoolBatch{seqs: []uint64{replayed.seq}}, nil)
+				}
+				continue
 			}
-			ls.sizeentries += len(entry.Line)
-
-			// append entry to the stream
-			ls.stream.Entries = append(ls.stream.Entries, entry)
+		}
 
-			// flush ?
-			if ls.sizeentries >= w.GetConfig().Loggers.LokiClient.BatchSize {
-				// encode log entries
-				buf, err := ls.Encode2Proto()
+		// prepare entry
+		entry := logproto.Entry{}
+		entry.Timestamp = time.Unix(int64(dm.DNSTap.TimeSec), int64(dm.DNSTap.TimeNsec))
+
+		switch w.GetConfig().Loggers.LokiClient.Mode {
+		case pkgconfig.ModeText:
+			entry.Line = string(dm.Bytes(w.textFormat,
+				w.GetConfig().Global.TextFormatDelimiter,
+				w.GetConfig().Global.TextFormatBoundary))
+		case pkgconfig.ModeJSON:
+			json.NewEncoder(buffer).Encode(dm)
+			entry.Line = buffer.String()
+			buffer.Reset()
+		case pkgconfig.ModeFlatJSON:
+			if len(flat) == 0 {
+				flat, err = dm.Flatten()
 				if err != nil {
-					w.LogError("error encoding log entries - %v", err)
-					// reset push request and entries
-					ls.ResetEntries()
-					return
+					w.LogError("flattening DNS message failed: %e", err)
 				}
-
-				// send all entries
-				w.SendEntries(buf)
-
-				// reset entries and push request
-				ls.ResetEntries()
 			}
+			json.NewEncoder(buffer).Encode(flat)
+			entry.Line = buffer.String()
+			buffer.Reset()
+		}
+		key := string(lbls.Bytes(byteBuffer))
+		ls, ok := w.streams[key]
+		if !ok {
+			ls = &LokiStream{config: w.GetConfig(), logger: w.GetLogger(), labels: lbls}
+			ls.Init()
+			w.streams[key] = ls
+		}
+		ls.sizeentries += len(entry.Line)
+
+		// append entry to the stream
+		ls.stream.Entries = append(ls.stream.Entries, entry)
+		if replayed != nil {
+			ls.batch.AddReplayed(*replayed)
+		} else {
+			w.SpoolBatchAdd(&ls.batch, dm)
+		}
 
-		case <-tflush.C:
-			for _, s := range w.streams {
-				if len(s.stream.Entries) > 0 {
-					// timeout
-					// encode log entries
-					buf, err := s.Encode2Proto()
-					if err != nil {
-						w.LogError("error encoding log entries - %v", err)
-						// reset push request and entries
-						s.ResetEntries()
-						// restart timer
-						tflush.Reset(tflushInterval)
-						return
-					}
-
-					// send all entries
-					w.SendEntries(buf)
-
-					// reset entries and push request
-					s.ResetEntries()
-				}
-			}
+		// flush ?
+		if ls.sizeentries >= w.GetConfig().Loggers.LokiClient.BatchSize {
+			w.FlushStream(ls)
+		}
+	}
+}
 
-			// restart timer
-			tflush.Reset(tflushInterval)
+// FlushStreams sends the pending entries of all streams
+func (w *LokiClient) FlushStreams() {
+	for _, s := range w.streams {
+		if len(s.stream.Entries) == 0 {
+			continue
 		}
+		w.FlushStream(s)
+	}
+}
+
+// FlushStream sends the entries of the stream, with the spool the
+// entries not sent are written back to it
+func (w *LokiClient) FlushStream(s *LokiStream) {
+	// encode log entries
+	buf, err := s.Encode2Proto()
+	if err != nil {
+		w.LogError("error encoding log entries - %v", err)
+		// reset push request and entries, the replayed messages are not replayed again
+		s.ResetEntries()
+		w.SpoolBatchDone(&s.batch, nil)
+		return
 	}
+
+	// send all entries
+	err = w.SendEntries(buf)
+	w.SpoolBatchDone(&s.batch, err)
+
+	// reset entries and push request
+	s.ResetEntries()
 }
 
-func (w *LokiClient) SendEntries(buf []byte) {
+// SendEntries sends the encoded entries, an error is returned if the entries are not written
+func (w *LokiClient) SendEntries(buf []byte) error {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -354,7 +372,7 @@ func (w *LokiClient) SendEntries(buf []byte) {
 		post, err := http.NewRequest("POST", w.GetConfig().Loggers.LokiClient.ServerURL, bytes.NewReader(buf))
 		if err != nil {
 			w.LogError("new http error: %s", err)
-			return
+			return err
 		}
 		post = post.WithContext(ctx)
 		post.Header.Set("Content-Type", "application/x-protobuf")
@@ -372,30 +390,32 @@ func (w *LokiClient) SendEntries(buf []byte) {
 		resp, err := w.httpclient.Do(post)
 		if err != nil {
 			w.LogError("do http error: %s", err)
-			return
+			w.SetRemoteAvailable(false)
+			return err
 		}
 
 		// success ?
 		if resp.StatusCode > 0 && resp.StatusCode != 429 && resp.StatusCode/100 != 5 {
-			break
+			w.SetRemoteAvailable(true)
+			return nil
 		}
 
 		// something is wrong, retry ?
-		if resp.StatusCode/100 != 2 {
-			scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1024))
-			line := ""
-			if scanner.Scan() {
-				line = scanner.Text()
-			}
-			w.LogError("server returned HTTP status %s (%d): %s", resp.Status, resp.StatusCode, line)
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1024))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
 		}
+		err = fmt.Errorf("server returned HTTP status %s (%d): %s", resp.Status, resp.StatusCode, line)
+		w.LogError(err.Error())
 
 		// wait before retry
 		backoff.Wait()
 
 		// Make sure it sends at least once before checking for retry.
 		if !backoff.Ongoing() {
-			break
+			w.SetRemoteAvailable(false)
+			return err
 		}
 	}
 }
@@ -2,13 +2,21 @@ package workers
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/grafana/dskit/backoff"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc/credentials"
+
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
@@ -17,8 +25,18 @@ import (
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
+	"github.com/dmachard/go-netutils"
 )
 
+// number of exports waiting to be sent, the logger is blocked while the queue is full
+const otlpExportQueueSize = 4
+
+type otlpExport struct {
+	action string
+	items  int
+	send   func() error
+}
+
 type trackedSpan struct {
 	span      trace.Span
 	startTime time.Time
@@ -27,6 +45,10 @@ type trackedSpan struct {
 type OpenTelemetryClient struct {
 	*GenericWorker
 	tracerProviders map[string]*sdktrace.TracerProvider
+	exporter        *OTLPExporter
+	resource        *resourcepb.Resource
+	prom            *Prometheus
+	textFormat      []string
 }
 
 func NewOpenTelemetryClient(config *pkgconfig.Config, console *logger.Logger, name string) *OpenTelemetryClient {
@@ -39,14 +61,76 @@ func NewOpenTelemetryClient(config *pkgconfig.Config, console *logger.Logger, na
 		GenericWorker:   NewGenericWorker(config, console, name, "opentelemetry", bufSize, pkgconfig.DefaultMonitor),
 		tracerProviders: make(map[string]*sdktrace.TracerProvider),
 	}
+	w.ReadConfig()
+
+	cfg := config.Loggers.OpenTelemetryClient
+	if cfg.LogsEnabled || cfg.MetricsEnabled {
+		exporter, err := w.NewExporter()
+		if err != nil {
+			w.LogFatal(pkgconfig.PrefixLogWorker+"["+name+"] opentelemetry - unable to create the otlp exporter: ", err)
+		}
+		w.exporter = exporter
+		w.resource = OTLPResource(config.GetServerIdentity())
+	}
+
+	// the metrics are the counters of the prometheus logger
+	if cfg.MetricsEnabled {
+		w.prom = NewPrometheusCounters(w.GenericWorker, cfg.MetricsPrefix)
+	}
 	return w
 }
 
+func (w *OpenTelemetryClient) ReadConfig() {
+	cfg := w.GetConfig().Loggers.OpenTelemetryClient
+
+	if cfg.Protocol != OTLPProtocolGRPC && cfg.Protocol != OTLPProtocolHTTP {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] opentelemetry - invalid protocol: ", cfg.Protocol)
+	}
+	if _, ok := netutils.TLSVersion[cfg.TLSMinVersion]; !ok {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] opentelemetry - invalid tls min version: ", cfg.TLSMinVersion)
+	}
+	if cfg.BatchSize <= 0 {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] opentelemetry - invalid batch size: ", cfg.BatchSize)
+	}
+	if cfg.MetricsInterval <= 0 {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] opentelemetry - invalid metrics interval: ", cfg.MetricsInterval)
+	}
+
+	if len(cfg.TextFormat) > 0 {
+		w.textFormat = strings.Fields(cfg.TextFormat)
+	} else {
+		w.textFormat = strings.Fields(w.GetConfig().Global.TextFormat)
+	}
+}
+
+// tlsConfig returns the tls config of the exporters, nil when tls is disabled
+func (w *OpenTelemetryClient) tlsConfig() (*tls.Config, error) {
+	cfg := w.GetConfig().Loggers.OpenTelemetryClient
+	if !cfg.TLSSupport {
+		return nil, nil
+	}
+	return netutils.TLSClientConfig(netutils.TLSOptions{
+		InsecureSkipVerify: cfg.TLSInsecure,
+		MinVersion:         cfg.TLSMinVersion,
+		CAFile:             cfg.CAFile,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+	})
+}
+
+// NewExporter creates the otlp exporter of the logs and the metrics
+func (w *OpenTelemetryClient) NewExporter() (*OTLPExporter, error) {
+	cfg := w.GetConfig().Loggers.OpenTelemetryClient
+
+	tlsConfig, err := w.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewOTLPExporter(cfg.Protocol, cfg.OtelEndpoint, cfg.Headers, tlsConfig, time.Duration(cfg.ExportTimeout)*time.Second)
+}
+
 func (w *OpenTelemetryClient) initTracerProvider(serviceName string) *sdktrace.TracerProvider {
-	exporter, err := otlptrace.New(context.Background(), otlptracegrpc.NewClient(
-		otlptracegrpc.WithEndpoint(w.config.Loggers.OpenTelemetryClient.OtelEndpoint),
-		otlptracegrpc.WithInsecure(),
-	))
+	exporter, err := otlptrace.New(context.Background(), w.newTraceClient())
 	if err != nil {
 		log.Fatalf("failed to create OTLP exporter: %v", err)
 	}
@@ -60,6 +144,40 @@ func (w *OpenTelemetryClient) initTracerProvider(serviceName string) *sdktrace.T
 	return tracerProvider
 }
 
+// newTraceClient returns the client of the traces, with the protocol, the tls and the headers of the logs and the metrics
+func (w *OpenTelemetryClient) newTraceClient() otlptrace.Client {
+	cfg := w.GetConfig().Loggers.OpenTelemetryClient
+
+	tlsConfig, err := w.tlsConfig()
+	if err != nil {
+		log.Fatalf("failed to create TLS config: %v", err)
+	}
+
+	if cfg.Protocol == OTLPProtocolHTTP {
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(cfg.Headers)}
+		if endpoint, ok := strings.CutPrefix(cfg.OtelEndpoint, "https://"); ok {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+		} else {
+			opts = append(opts, otlptracehttp.WithEndpoint(strings.TrimPrefix(cfg.OtelEndpoint, "http://")))
+			if tlsConfig == nil {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+		}
+		if tlsConfig != nil {
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsConfig))
+		}
+		return otlptracehttp.NewClient(opts...)
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.OtelEndpoint), otlptracegrpc.WithHeaders(cfg.Headers)}
+	if tlsConfig != nil {
+		opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	return otlptracegrpc.NewClient(opts...)
+}
+
 func (w *OpenTelemetryClient) getTracer(serviceName string) trace.Tracer {
 	if tp, exists := w.tracerProviders[serviceName]; exists {
 		return tp.Tracer("")
@@ -79,6 +197,16 @@ func (w *OpenTelemetryClient) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, func(dm *dnsutils.DNSMessage, result int) {
+		if result == transformers.ReturnDrop {
+			w.SendDroppedTo(droppedRoutes, droppedNames, *dm)
+			return
+		}
+
+		// send to output channel, the messages are sent to next after the spans processing
+		w.CountEgressTraffic()
+		w.SendToOutput(*dm)
+	})
 
 	// goroutine to process transformed dns messages
 	go w.StartLogging()
@@ -87,8 +215,8 @@ func (w *OpenTelemetryClient) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			return
 
 			// new config provided?
@@ -107,18 +235,8 @@ func (w *OpenTelemetryClient) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-			w.GetOutputChannel() <- dm
+			// then send to output channel
+			subprocessors.Process(dm)
 		}
 	}
 }
@@ -127,6 +245,8 @@ func (w *OpenTelemetryClient) StartLogging() {
 	w.LogInfo("logging has started")
 	defer w.LoggingDone()
 
+	cfg := w.GetConfig().Loggers.OpenTelemetryClient
+
 	// prepare next channels
 	defaultRoutes, defaultNames := GetRoutes(w.GetDefaultRoutes())
 
@@ -135,11 +255,63 @@ func (w *OpenTelemetryClient) StartLogging() {
 	messageSpans := sync.Map{}
 	resolverSpans := sync.Map{}
 
-	go w.cleanupSpans(&requestorSpans, &messageSpans, &resolverSpans, time.Duration(w.config.Loggers.OpenTelemetryClient.MaxSpanTime)*time.Second)
+	if cfg.TracesEnabled {
+		go w.cleanupSpans(&requestorSpans, &messageSpans, &resolverSpans, time.Duration(cfg.MaxSpanTime)*time.Second)
+	}
+
+	// logs and metrics are exported in background
+	exports := make(chan otlpExport, otlpExportQueueSize)
+	exportsDone := make(chan bool)
+	go func() {
+		defer close(exportsDone)
+		for export := range exports {
+			if !w.withRetry(export.action, export.send) {
+				w.LogError("%s dropped after %d retries, %d items lost", export.action, cfg.MaxRetries, export.items)
+			}
+		}
+	}()
+
+	records := make([]*logspb.LogRecord, 0, cfg.BatchSize)
+	flushLogs := func() {
+		if len(records) > 0 {
+			req := OTLPLogsRequest(w.resource, records)
+			exports <- otlpExport{action: "logs export", items: len(records), send: func() error { return w.exporter.ExportLogs(req) }}
+		}
+		records = make([]*logspb.LogRecord, 0, cfg.BatchSize)
+	}
+
+	startTime := time.Now()
+	exportMetrics := func() {
+		if w.prom == nil {
+			return
+		}
+		families, err := w.prom.Gather()
+		if err != nil {
+			w.LogError("unable to gather metrics: %s", err)
+			return
+		}
+		req := OTLPMetricsRequest(w.resource, families, startTime, time.Now())
+		exports <- otlpExport{action: "metrics export", items: len(families), send: func() error { return w.exporter.ExportMetrics(req) }}
+	}
+
+	flushInterval := time.Duration(cfg.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+	metricsInterval := time.Duration(cfg.MetricsInterval) * time.Second
+	metricsTimer := time.NewTimer(metricsInterval)
+	epsInterval := 1 * time.Second
+	epsTimer := time.NewTimer(epsInterval)
 
 	for {
 		select {
 		case <-w.OnLoggerStopped():
+			// export the last logs and metrics and wait for the pending ones
+			flushLogs()
+			exportMetrics()
+			close(exports)
+			<-exportsDone
+			if w.exporter != nil {
+				w.exporter.Close()
+			}
 			return
 
 		// incoming dns message to process
@@ -149,31 +321,84 @@ func (w *OpenTelemetryClient) StartLogging() {
 				return
 			}
 
-			timestamp, err := time.Parse(time.RFC3339, dm.DNSTap.TimestampRFC3339)
-			if err != nil {
-				w.LogWarning("invalid timestamp: %v", err)
-				continue
-			}
-			tracer := w.getTracer(dm.DNSTap.Identity)
-
 			// ini opentelemetry with default values
 			dm.OpenTelemetry = &dnsutils.LoggerOpenTelemetry{}
 
-			switch dm.DNSTap.Operation {
-			case "CLIENT_QUERY":
-				w.handleClientQuery(&requestorSpans, &messageSpans, tracer, &dm, timestamp)
-			case "CLIENT_RESPONSE":
-				w.handleClientResponse(&requestorSpans, &messageSpans, &dm, timestamp)
-			case "RESOLVER_QUERY":
-				w.handleResolverQuery(&messageSpans, &resolverSpans, tracer, &dm, timestamp)
-			case "RESOLVER_RESPONSE":
-				w.handleResolverResponse(&resolverSpans, &dm, timestamp)
+			if cfg.TracesEnabled {
+				w.processSpans(&requestorSpans, &messageSpans, &resolverSpans, &dm)
+			}
+
+			if cfg.LogsEnabled {
+				body := dm.String(w.textFormat, w.GetConfig().Global.TextFormatDelimiter, w.GetConfig().Global.TextFormatBoundary)
+				records = append(records, OTLPLogRecord(&dm, body, time.Now()))
+				if len(records) >= cfg.BatchSize {
+					flushLogs()
+				}
+			}
+
+			if w.prom != nil {
+				w.prom.Record(dm)
 			}
 
 			// send to next ?
 			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+
+		// flush the logs every ?
+		case <-flushTimer.C:
+			flushLogs()
+			flushTimer.Reset(flushInterval)
+
+		// export the metrics every ?
+		case <-metricsTimer.C:
+			exportMetrics()
+			metricsTimer.Reset(metricsInterval)
+
+		case <-epsTimer.C:
+			// compute eps each second
+			if w.prom != nil {
+				w.prom.ComputeEventsPerSecond()
+			}
+			epsTimer.Reset(epsInterval)
+		}
+	}
+}
+
+func (w *OpenTelemetryClient) processSpans(requestorSpans, messageSpans, resolverSpans *sync.Map, dm *dnsutils.DNSMessage) {
+	timestamp, err := time.Parse(time.RFC3339, dm.DNSTap.TimestampRFC3339)
+	if err != nil {
+		w.LogWarning("invalid timestamp: %v", err)
+		return
+	}
+	tracer := w.getTracer(dm.DNSTap.Identity)
+
+	switch dm.DNSTap.Operation {
+	case "CLIENT_QUERY":
+		w.handleClientQuery(requestorSpans, messageSpans, tracer, dm, timestamp)
+	case "CLIENT_RESPONSE":
+		w.handleClientResponse(requestorSpans, messageSpans, dm, timestamp)
+	case "RESOLVER_QUERY":
+		w.handleResolverQuery(messageSpans, resolverSpans, tracer, dm, timestamp)
+	case "RESOLVER_RESPONSE":
+		w.handleResolverResponse(resolverSpans, dm, timestamp)
+	}
+}
+
+// withRetry calls the function until it succeeds or the max retries is reached
+func (w *OpenTelemetryClient) withRetry(action string, fn func() error) bool {
+	retry := backoff.New(context.Background(), backoff.Config{
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		MaxRetries: w.GetConfig().Loggers.OpenTelemetryClient.MaxRetries + 1,
+	})
+	for retry.Ongoing() {
+		err := fn()
+		if err == nil {
+			return true
 		}
+		w.LogError("%s failed: %s", action, err)
+		retry.Wait()
 	}
+	return false
 }
 
 func (w *OpenTelemetryClient) handleClientQuery(requestorSpans, messageSpans *sync.Map, tracer trace.Tracer, dm *dnsutils.DNSMessage, timestamp time.Time) {
@@ -260,7 +485,7 @@ func (w *OpenTelemetryClient) handleResolverResponse(resolverSpans *sync.Map, dm
 }
 
 func (w *OpenTelemetryClient) cleanupSpans(requestorSpans, messageSpans, resolverSpans *sync.Map, maxSpanDuration time.Duration) {
-	ticker := time.NewTicker(time.Duration(w.config.Loggers.OpenTelemetryClient.CleanupSpansInterval) * time.Second)
+	ticker := time.NewTicker(time.Duration(w.GetConfig().Loggers.OpenTelemetryClient.CleanupSpansInterval) * time.Second)
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -0,0 +1,340 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-netutils"
+	"github.com/prometheus/common/version"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+// OTLPExporter sends the logs and the metrics to an OTLP endpoint over gRPC or HTTP
+type OTLPExporter struct {
+	protocol      string
+	headers       map[string]string
+	timeout       time.Duration
+	grpcConn      *grpc.ClientConn
+	logsClient    collogspb.LogsServiceClient
+	metricsClient colmetricspb.MetricsServiceClient
+	httpClient    *http.Client
+	baseURL       string
+}
+
+// NewOTLPExporter creates the exporter, the endpoint is host:port or an url with the http protocol,
+// TLS is enabled when the tls config is provided
+func NewOTLPExporter(protocol, endpoint string, headers map[string]string, tlsConfig *tls.Config, timeout time.Duration) (*OTLPExporter, error) {
+	e := &OTLPExporter{protocol: protocol, headers: headers, timeout: timeout}
+
+	switch protocol {
+	case OTLPProtocolGRPC:
+		creds := insecure.NewCredentials()
+		if tlsConfig != nil {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		e.grpcConn = conn
+		e.logsClient = collogspb.NewLogsServiceClient(conn)
+		e.metricsClient = colmetricspb.NewMetricsServiceClient(conn)
+
+	case OTLPProtocolHTTP:
+		e.baseURL = strings.TrimSuffix(endpoint, "/")
+		if !strings.Contains(e.baseURL, "://") {
+			e.baseURL = "http://" + e.baseURL
+			if tlsConfig != nil {
+				e.baseURL = "https://" + strings.TrimPrefix(e.baseURL, "http://")
+			}
+		}
+		e.httpClient = &http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}
+
+	default:
+		return nil, fmt.Errorf("invalid protocol: %s", protocol)
+	}
+	return e, nil
+}
+
+func (e *OTLPExporter) ExportLogs(req *collogspb.ExportLogsServiceRequest) error {
+	if e.protocol == OTLPProtocolHTTP {
+		return e.post("/v1/logs", req)
+	}
+	ctx, cancel := e.grpcContext()
+	defer cancel()
+	_, err := e.logsClient.Export(ctx, req)
+	return err
+}
+
+func (e *OTLPExporter) ExportMetrics(req *colmetricspb.ExportMetricsServiceRequest) error {
+	if e.protocol == OTLPProtocolHTTP {
+		return e.post("/v1/metrics", req)
+	}
+	ctx, cancel := e.grpcContext()
+	defer cancel()
+	_, err := e.metricsClient.Export(ctx, req)
+	return err
+}
+
+func (e *OTLPExporter) Close() {
+	if e.grpcConn != nil {
+		e.grpcConn.Close()
+	}
+}
+
+// grpcContext returns the context of a request, with the timeout and the headers as metadata
+func (e *OTLPExporter) grpcContext() (context.Context, context.CancelFunc) {
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.New(e.headers))
+	return context.WithTimeout(ctx, e.timeout)
+}
+
+func (e *OTLPExporter) post(path string, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func otlpInt(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
+
+func otlpDouble(key string, value float64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}}}
+}
+
+func otlpBool(key string, value bool) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value}}}
+}
+
+// OTLPResource returns the resource describing the collector instance
+func OTLPResource(identity string) *resourcepb.Resource {
+	return &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+		otlpString("service.name", "dnscollector"),
+		otlpString("service.version", version.Version),
+		otlpString("service.instance.id", identity),
+	}}
+}
+
+func otlpScope() *commonpb.InstrumentationScope {
+	return &commonpb.InstrumentationScope{Name: "github.com/dmachard/go-dnscollector", Version: version.Version}
+}
+
+// otlpTransport converts the protocol to the network transport of the semantic conventions
+func otlpTransport(protocol string) string {
+	switch protocol {
+	case netutils.ProtoUDP:
+		return "udp"
+	case netutils.ProtoTCP, dnsutils.ProtoDoT, dnsutils.ProtoDoH:
+		return "tcp"
+	case "DOQ":
+		return "quic"
+	}
+	return ""
+}
+
+// OTLPLogRecord converts the dns message to a log record with the semantic attributes
+func OTLPLogRecord(dm *dnsutils.DNSMessage, body string, observed time.Time) *logspb.LogRecord {
+	record := &logspb.LogRecord{
+		TimeUnixNano:         uint64(dm.DNSTap.TimeSec)*1e9 + uint64(dm.DNSTap.TimeNsec),
+		ObservedTimeUnixNano: uint64(observed.UnixNano()),
+		SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		SeverityText:         "INFO",
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}},
+		Attributes: []*commonpb.KeyValue{
+			otlpString("dns.question.name", dm.DNS.Qname),
+			otlpString("dns.question.type", dm.DNS.Qtype),
+			otlpString("dns.question.class", dm.DNS.Qclass),
+			otlpString("dns.response_code", dm.DNS.Rcode),
+			otlpString("dns.message.type", dm.DNS.Type),
+			otlpInt("dns.id", int64(dm.DNS.ID)),
+			otlpInt("dns.opcode", int64(dm.DNS.Opcode)),
+			otlpInt("dns.length", int64(dm.DNS.Length)),
+			otlpInt("dns.answers.count", int64(dm.DNS.AnCount)),
+			otlpBool("dns.malformed", dm.DNS.MalformedPacket),
+			otlpString("network.protocol.name", "dns"),
+			otlpString("client.address", dm.NetworkInfo.QueryIP),
+			otlpString("server.address", dm.NetworkInfo.ResponseIP),
+			otlpString("dnstap.identity", dm.DNSTap.Identity),
+			otlpString("dnstap.operation", dm.DNSTap.Operation),
+			otlpDouble("dnstap.latency", dm.DNSTap.Latency),
+		},
+	}
+	if dm.NetworkInfo.Family == netutils.ProtoIPv4 || dm.NetworkInfo.Family == netutils.ProtoIPv6 {
+		record.Attributes = append(record.Attributes, otlpString("network.type", strings.ToLower(dm.NetworkInfo.Family)))
+	}
+	if transport := otlpTransport(dm.NetworkInfo.Protocol); len(transport) > 0 {
+		record.Attributes = append(record.Attributes, otlpString("network.transport", transport))
+	}
+	if port, err := strconv.Atoi(dm.NetworkInfo.QueryPort); err == nil {
+		record.Attributes = append(record.Attributes, otlpInt("client.port", int64(port)))
+	}
+	if port, err := strconv.Atoi(dm.NetworkInfo.ResponsePort); err == nil {
+		record.Attributes = append(record.Attributes, otlpInt("server.port", int64(port)))
+	}
+	for _, rr := range dm.DNS.DNSRRs.Answers {
+		record.Attributes = append(record.Attributes, otlpString("dns.answers."+strings.ToLower(rr.Rdatatype), rr.Rdata))
+	}
+
+	// correlation with the traces
+	if dm.OpenTelemetry != nil {
+		if traceID, err := hex.DecodeString(dm.OpenTelemetry.TraceID); err == nil && len(traceID) == 16 {
+			record.TraceId = traceID
+		}
+	}
+	return record
+}
+
+// OTLPLogsRequest returns the export request of the log records
+func OTLPLogsRequest(resource *resourcepb.Resource, records []*logspb.LogRecord) *collogspb.ExportLogsServiceRequest {
+	return &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource:  resource,
+		ScopeLogs: []*logspb.ScopeLogs{{Scope: otlpScope(), LogRecords: records}},
+	}}}
+}
+
+// OTLPMetricsRequest converts the gathered prometheus metrics to an export request,
+// counters are cumulative sums since the start time
+func OTLPMetricsRequest(resource *resourcepb.Resource, families []*dto.MetricFamily, start, now time.Time) *colmetricspb.ExportMetricsServiceRequest {
+	startNano, nowNano := uint64(start.UnixNano()), uint64(now.UnixNano())
+
+	metrics := make([]*metricspb.Metric, 0, len(families))
+	for _, family := range families {
+		metric := &metricspb.Metric{Name: family.GetName(), Description: family.GetHelp()}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			points := make([]*metricspb.NumberDataPoint, 0, len(family.GetMetric()))
+			for _, m := range family.GetMetric() {
+				points = append(points, &metricspb.NumberDataPoint{
+					Attributes: otlpLabels(m.GetLabel()), StartTimeUnixNano: startNano, TimeUnixNano: nowNano,
+					Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetCounter().GetValue()},
+				})
+			}
+			metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints: points, IsMonotonic: true,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}}
+
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			points := make([]*metricspb.NumberDataPoint, 0, len(family.GetMetric()))
+			for _, m := range family.GetMetric() {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				points = append(points, &metricspb.NumberDataPoint{
+					Attributes: otlpLabels(m.GetLabel()), TimeUnixNano: nowNano,
+					Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				})
+			}
+			metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}}
+
+		case dto.MetricType_HISTOGRAM:
+			points := make([]*metricspb.HistogramDataPoint, 0, len(family.GetMetric()))
+			for _, m := range family.GetMetric() {
+				h := m.GetHistogram()
+				sum := h.GetSampleSum()
+				point := &metricspb.HistogramDataPoint{
+					Attributes: otlpLabels(m.GetLabel()), StartTimeUnixNano: startNano, TimeUnixNano: nowNano,
+					Count: h.GetSampleCount(), Sum: &sum,
+				}
+				// prometheus buckets are cumulative
+				var previous uint64
+				for _, b := range h.GetBucket() {
+					point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+					point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-previous)
+					previous = b.GetCumulativeCount()
+				}
+				point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+				points = append(points, point)
+			}
+			metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				DataPoints:             points,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}}
+
+		case dto.MetricType_SUMMARY:
+			points := make([]*metricspb.SummaryDataPoint, 0, len(family.GetMetric()))
+			for _, m := range family.GetMetric() {
+				s := m.GetSummary()
+				point := &metricspb.SummaryDataPoint{
+					Attributes: otlpLabels(m.GetLabel()), StartTimeUnixNano: startNano, TimeUnixNano: nowNano,
+					Count: s.GetSampleCount(), Sum: s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+				points = append(points, point)
+			}
+			metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: points}}
+
+		default:
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     resource,
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Scope: otlpScope(), Metrics: metrics}},
+	}}}
+}
+
+func otlpLabels(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, l := range labels {
+		attrs = append(attrs, otlpString(l.GetName(), l.GetValue()))
+	}
+	return attrs
+}
@@ -1,11 +1,28 @@
 package workers
 
 import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-logger"
+	"github.com/dmachard/go-netutils"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestOpenTelemetry_InitTracerProvider(t *testing.T) {
@@ -22,3 +39,153 @@ func TestOpenTelemetry_InitTracerProvider(t *testing.T) {
 	// Assert tracer is not nil
 	assert.NotNil(t, tracer, "Tracer should not be nil")
 }
+
+func TestOpenTelemetry_LogRecord(t *testing.T) {
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNSTap.TimeSec = 1700000000
+	dm.DNSTap.TimeNsec = 123
+	dm.NetworkInfo.Protocol = netutils.ProtoUDP
+	dm.NetworkInfo.QueryPort = "53000"
+	dm.OpenTelemetry = &dnsutils.LoggerOpenTelemetry{TraceID: "0102030405060708090a0b0c0d0e0f10"}
+
+	record := OTLPLogRecord(&dm, "body", time.Now())
+	assert.Equal(t, uint64(1700000000000000123), record.GetTimeUnixNano())
+	assert.Equal(t, "body", record.GetBody().GetStringValue())
+	assert.Len(t, record.GetTraceId(), 16)
+
+	attrs := make(map[string]*commonpb.AnyValue)
+	for _, kv := range record.GetAttributes() {
+		attrs[kv.GetKey()] = kv.GetValue()
+	}
+	assert.Equal(t, dm.DNS.Qname, attrs["dns.question.name"].GetStringValue())
+	assert.Equal(t, dm.DNS.Qtype, attrs["dns.question.type"].GetStringValue())
+	assert.Equal(t, "udp", attrs["network.transport"].GetStringValue())
+	assert.Equal(t, int64(53000), attrs["client.port"].GetIntValue())
+	assert.Equal(t, dm.NetworkInfo.QueryIP, attrs["client.address"].GetStringValue())
+}
+
+func TestOpenTelemetry_MetricsRequest(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "test counter"}, []string{"stream_id"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_size", Help: "test histogram", Buckets: []float64{10, 100}})
+	registry.MustRegister(counter, histogram)
+	counter.WithLabelValues("dnsdist").Add(3)
+	for _, v := range []float64{5, 50, 500} {
+		histogram.Observe(v)
+	}
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+
+	req := OTLPMetricsRequest(OTLPResource("test"), families, time.Now(), time.Now())
+	metrics := req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()
+	assert.Len(t, metrics, 2)
+
+	// families are sorted by name
+	sum := metrics[1].GetSum()
+	assert.True(t, sum.GetIsMonotonic())
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, sum.GetAggregationTemporality())
+	assert.Equal(t, 3.0, sum.GetDataPoints()[0].GetAsDouble())
+	assert.Equal(t, "stream_id", sum.GetDataPoints()[0].GetAttributes()[0].GetKey())
+
+	point := metrics[0].GetHistogram().GetDataPoints()[0]
+	assert.Equal(t, uint64(3), point.GetCount())
+	assert.Equal(t, []float64{10, 100}, point.GetExplicitBounds())
+	assert.Equal(t, []uint64{1, 1, 1}, point.GetBucketCounts())
+}
+
+func TestOpenTelemetry_ExportHTTP(t *testing.T) {
+	logs := make(chan *collogspb.ExportLogsServiceRequest, 10)
+	metrics := make(chan *colmetricspb.ExportMetricsServiceRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/v1/logs":
+			req := &collogspb.ExportLogsServiceRequest{}
+			assert.NoError(t, proto.Unmarshal(data, req))
+			logs <- req
+		case "/v1/metrics":
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			assert.NoError(t, proto.Unmarshal(data, req))
+			metrics <- req
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := pkgconfig.GetDefaultConfig()
+	cfg.Global.ServerIdentity = "collector01"
+	cfg.Loggers.OpenTelemetryClient.OtelEndpoint = server.URL
+	cfg.Loggers.OpenTelemetryClient.Protocol = "http"
+	cfg.Loggers.OpenTelemetryClient.Headers = map[string]string{"Authorization": "Bearer secret"}
+	cfg.Loggers.OpenTelemetryClient.TracesEnabled = false
+	cfg.Loggers.OpenTelemetryClient.LogsEnabled = true
+	cfg.Loggers.OpenTelemetryClient.MetricsEnabled = true
+	cfg.Loggers.OpenTelemetryClient.BatchSize = 2
+
+	g := NewOpenTelemetryClient(cfg, logger.New(false), "test")
+	go g.StartCollect()
+	for i := 0; i < 3; i++ {
+		g.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	}
+	time.Sleep(time.Second)
+	g.Stop()
+
+	// one full batch, then the last record when stopped
+	records := 0
+	for len(logs) > 0 {
+		req := <-logs
+		resource := req.GetResourceLogs()[0].GetResource()
+		assert.Contains(t, resource.GetAttributes(), otlpString("service.instance.id", "collector01"))
+		records += len(req.GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords())
+	}
+	assert.Equal(t, 3, records)
+
+	// metrics are exported when stopped
+	assert.Len(t, metrics, 1)
+	found := false
+	for _, m := range (<-metrics).GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics() {
+		if m.GetName() == "dnscollector_dnsmessages_total" {
+			found = true
+		}
+	}
+	assert.True(t, found, "dnsmessages metric not exported")
+}
+
+type testLogsServer struct {
+	collogspb.UnimplementedLogsServiceServer
+	requests chan *collogspb.ExportLogsServiceRequest
+	tokens   chan []string
+}
+
+func (s *testLogsServer) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.tokens <- md.Get("authorization")
+	s.requests <- req
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func TestOpenTelemetry_ExportGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	logsServer := &testLogsServer{requests: make(chan *collogspb.ExportLogsServiceRequest, 1), tokens: make(chan []string, 1)}
+	collogspb.RegisterLogsServiceServer(server, logsServer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	exporter, err := NewOTLPExporter(OTLPProtocolGRPC, listener.Addr().String(), map[string]string{"authorization": "Bearer secret"}, nil, 5*time.Second)
+	assert.NoError(t, err)
+	defer exporter.Close()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	records := []*logspb.LogRecord{OTLPLogRecord(&dm, "body", time.Now())}
+	assert.NoError(t, exporter.ExportLogs(OTLPLogsRequest(OTLPResource("test"), records)))
+
+	assert.Equal(t, []string{"Bearer secret"}, <-logsServer.tokens)
+	assert.Equal(t, "body", (<-logsServer.requests).GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords()[0].GetBody().GetStringValue())
+}
@@ -0,0 +1,362 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/dmachard/go-netutils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// columns of the table when none are configured
+var PostgreSQLDefaultColumns = []pkgconfig.ConfigPostgreSQLColumn{
+	{Name: "timestamp", Field: "dnstap.timestamp-rfc3339ns", Type: "TIMESTAMPTZ NOT NULL"},
+	{Name: "identity", Field: "dnstap.identity", Type: "TEXT"},
+	{Name: "operation", Field: "dnstap.operation", Type: "TEXT"},
+	{Name: "query_ip", Field: "network.query-ip", Type: "TEXT"},
+	{Name: "family", Field: "network.family", Type: "TEXT"},
+	{Name: "protocol", Field: "network.protocol", Type: "TEXT"},
+	{Name: "qname", Field: "dns.qname", Type: "TEXT"},
+	{Name: "qtype", Field: "dns.qtype", Type: "TEXT"},
+	{Name: "rcode", Field: "dns.rcode", Type: "TEXT"},
+	{Name: "latency", Field: "dnstap.latency", Type: "DOUBLE PRECISION"},
+}
+
+// PostgreSQLTable returns the identifier of the table, the name can be qualified with the schema
+func PostgreSQLTable(name string) pgx.Identifier {
+	return pgx.Identifier(strings.Split(name, "."))
+}
+
+// PostgreSQLRow returns the values of the columns, the fields not mapped to a column
+// are added to the extra column if enabled
+func PostgreSQLRow(flat map[string]interface{}, columns []pkgconfig.ConfigPostgreSQLColumn, extraColumn string) []interface{} {
+	row := make([]interface{}, 0, len(columns)+1)
+	mapped := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		mapped[col.Field] = true
+		value, ok := flat[col.Field]
+		if !ok {
+			row = append(row, nil)
+			continue
+		}
+		// timestamps are encoded with the native type
+		if str, isString := value.(string); isString && strings.HasSuffix(col.Field, "timestamp-rfc3339ns") {
+			if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+				value = t
+			}
+		}
+		row = append(row, value)
+	}
+
+	if len(extraColumn) > 0 {
+		extra := make(map[string]interface{})
+		for field, value := range flat {
+			if !mapped[field] {
+				extra[field] = value
+			}
+		}
+		row = append(row, extra)
+	}
+	return row
+}
+
+type PostgreSQL struct {
+	*GenericWorker
+	columns                            []pkgconfig.ConfigPostgreSQLColumn
+	columnNames                        []string
+	pool                               *pgxpool.Pool
+	transportReady, transportReconnect chan bool
+	writerReady, schemaCreated         bool
+}
+
+func NewPostgreSQL(config *pkgconfig.Config, logger *logger.Logger, name string) *PostgreSQL {
+	bufSize := config.Global.Worker.ChannelBufferSize
+	if config.Loggers.PostgreSQL.ChannelBufferSize > 0 {
+		bufSize = config.Loggers.PostgreSQL.ChannelBufferSize
+	}
+	w := &PostgreSQL{GenericWorker: NewGenericWorker(config, logger, name, "postgresql", bufSize, pkgconfig.DefaultMonitor)}
+	w.transportReady = make(chan bool)
+	w.transportReconnect = make(chan bool)
+	w.ReadConfig()
+	return w
+}
+
+func (w *PostgreSQL) ReadConfig() {
+	cfg := w.GetConfig().Loggers.PostgreSQL
+
+	if _, err := pgxpool.ParseConfig(cfg.URL); err != nil {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] postgresql - invalid url: ", err)
+	}
+	if cfg.BufferSize <= 0 {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] postgresql - invalid buffer size: ", cfg.BufferSize)
+	}
+
+	w.columns = PostgreSQLDefaultColumns
+	if len(cfg.Columns) > 0 {
+		w.columns = cfg.Columns
+	}
+	w.columnNames = []string{}
+	for _, col := range w.columns {
+		if len(col.Name) == 0 || len(col.Field) == 0 {
+			w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] postgresql - column without name or field: ", col)
+		}
+		if cfg.CreateTable && len(col.Type) == 0 {
+			w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] postgresql - column type is required to create the table: ", col.Name)
+		}
+		w.columnNames = append(w.columnNames, col.Name)
+	}
+	if len(cfg.ExtraColumn) > 0 {
+		w.columnNames = append(w.columnNames, cfg.ExtraColumn)
+	}
+}
+
+// CreateTableQueries returns the queries to create the table, and to convert it
+// to an hypertable if enabled
+func (w *PostgreSQL) CreateTableQueries() []string {
+	cfg := w.GetConfig().Loggers.PostgreSQL
+
+	columns := make([]string, 0, len(w.columns)+1)
+	for _, col := range w.columns {
+		columns = append(columns, pgx.Identifier{col.Name}.Sanitize()+" "+col.Type)
+	}
+	if len(cfg.ExtraColumn) > 0 {
+		columns = append(columns, pgx.Identifier{cfg.ExtraColumn}.Sanitize()+" JSONB")
+	}
+
+	table := PostgreSQLTable(cfg.Table).Sanitize()
+	queries := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(columns, ", "))}
+	if len(cfg.HypertableColumn) > 0 {
+		queries = append(queries, fmt.Sprintf("SELECT create_hypertable('%s', '%s', if_not_exists => TRUE)",
+			strings.ReplaceAll(table, "'", "''"), strings.ReplaceAll(cfg.HypertableColumn, "'", "''")))
+	}
+	return queries
+}
+
+func (w *PostgreSQL) Connect() (*pgxpool.Pool, error) {
+	cfg := w.GetConfig().Loggers.PostgreSQL
+
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.User) > 0 {
+		poolConfig.ConnConfig.User = cfg.User
+	}
+	if len(cfg.Password) > 0 {
+		poolConfig.ConnConfig.Password = cfg.Password
+	}
+	poolConfig.ConnConfig.ConnectTimeout = time.Duration(cfg.ConnectTimeout) * time.Second
+	poolConfig.MaxConns = int32(cfg.MaxConns)
+
+	if cfg.TLSSupport {
+		tlsOptions := netutils.TLSOptions{
+			InsecureSkipVerify: cfg.TLSInsecure,
+			MinVersion:         cfg.TLSMinVersion,
+			CAFile:             cfg.CAFile,
+			CertFile:           cfg.CertFile,
+			KeyFile:            cfg.KeyFile,
+		}
+		tlsConfig, err := netutils.TLSClientConfig(tlsOptions)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = poolConfig.ConnConfig.Host
+		poolConfig.ConnConfig.TLSConfig = tlsConfig
+		poolConfig.ConnConfig.Fallbacks = nil
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), poolConfig.ConnConfig.ConnectTimeout)
+	defer cancel()
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	// create the schema on first run
+	if cfg.CreateTable && !w.schemaCreated {
+		for _, query := range w.CreateTableQueries() {
+			if _, err := pool.Exec(ctx, query); err != nil {
+				pool.Close()
+				return nil, err
+			}
+		}
+		w.schemaCreated = true
+		w.LogInfo("table %s created", cfg.Table)
+	}
+	return pool, nil
+}
+
+func (w *PostgreSQL) ConnectToRemote() {
+	for {
+		if w.pool != nil {
+			w.pool.Close()
+			w.pool = nil
+		}
+
+		w.LogInfo("connecting to %s", w.GetConfig().Loggers.PostgreSQL.URL)
+		pool, err := w.Connect()
+
+		// something is wrong during connection ?
+		if err != nil {
+			w.LogError("%s", err)
+			w.LogInfo("retry to connect in %d seconds", w.GetConfig().Loggers.PostgreSQL.RetryInterval)
+			time.Sleep(time.Duration(w.GetConfig().Loggers.PostgreSQL.RetryInterval) * time.Second)
+			continue
+		}
+
+		w.pool = pool
+
+		// block until the pool is ready
+		w.transportReady <- true
+
+		// block until an error occurred, need to reconnect
+		w.transportReconnect <- true
+	}
+}
+
+func (w *PostgreSQL) FlushBuffer(buf *[]dnsutils.DNSMessage) {
+	rows := make([][]interface{}, 0, len(*buf))
+	for _, dm := range *buf {
+		flat, err := dm.Flatten()
+		if err != nil {
+			w.LogError("flattening DNS message failed: %e", err)
+			continue
+		}
+		rows = append(rows, PostgreSQLRow(flat, w.columns, w.GetConfig().Loggers.PostgreSQL.ExtraColumn))
+	}
+
+	// insert the rows with the copy protocol
+	_, err := w.pool.CopyFrom(context.Background(), PostgreSQLTable(w.GetConfig().Loggers.PostgreSQL.Table), w.columnNames, pgx.CopyFromRows(rows))
+	if err != nil {
+		w.LogError("copy error, %d messages lost: %s", len(rows), err)
+		w.writerReady = false
+		<-w.transportReconnect
+	}
+
+	// reset buffer
+	*buf = nil
+}
+
+func (w *PostgreSQL) StartCollect() {
+	w.LogInfo("starting data collection")
+	defer w.CollectDone()
+
+	// prepare next channels
+	defaultRoutes, defaultNames := GetRoutes(w.GetDefaultRoutes())
+	droppedRoutes, droppedNames := GetRoutes(w.GetDroppedRoutes())
+
+	// prepare transforms
+	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
+
+	// goroutine to process transformed dns messages
+	go w.StartLogging()
+
+	// loop to process incoming messages
+	for {
+		select {
+		case <-w.OnStop():
+			subprocessors.Reset()
+			w.StopLogger()
+			return
+
+		case cfg := <-w.NewConfig():
+			w.SetConfig(cfg)
+			w.ReadConfig()
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-w.GetInputChannel():
+			if !opened {
+				w.LogInfo("input channel closed!")
+				return
+			}
+			// count global messages
+			w.CountIngressTraffic()
+
+			// apply tranforms, init dns message with additionnals parts if necessary
+			// then send to output channel and to next
+			subprocessors.Process(dm)
+		}
+	}
+}
+
+func (w *PostgreSQL) StartLogging() {
+	w.LogInfo("logging has started")
+	defer w.LoggingDone()
+
+	// init buffer
+	bufferDm := []dnsutils.DNSMessage{}
+
+	// init flush timer for buffer
+	flushInterval := time.Duration(w.GetConfig().Loggers.PostgreSQL.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+	// init remote conn
+	go w.ConnectToRemote()
+
+	w.LogInfo("ready to process")
+	for {
+		select {
+		case <-w.OnLoggerStopped():
+			// insert the pending messages and close the pool
+			if w.writerReady && len(bufferDm) > 0 {
+				w.FlushBuffer(&bufferDm)
+			}
+			if w.writerReady {
+				w.LogInfo("closing connections")
+				w.pool.Close()
+			}
+			return
+
+		case <-w.transportReady:
+			w.LogInfo("connected with success")
+			w.writerReady = true
+
+		// incoming dns message to process
+		case dm, opened := <-w.GetOutputChannel():
+			if !opened {
+				w.LogInfo("output channel closed!")
+				return
+			}
+
+			// drop dns message if the connection is not ready to avoid memory leak or
+			// to block the channel
+			if !w.writerReady {
+				continue
+			}
+
+			// append dns message to buffer
+			bufferDm = append(bufferDm, dm)
+
+			// buffer is full ?
+			if len(bufferDm) >= w.GetConfig().Loggers.PostgreSQL.BufferSize {
+				w.FlushBuffer(&bufferDm)
+			}
+
+		// flush the buffer
+		case <-flushTimer.C:
+			if !w.writerReady {
+				bufferDm = nil
+			}
+
+			if len(bufferDm) > 0 {
+				w.FlushBuffer(&bufferDm)
+			}
+
+			// restart timer
+			flushTimer.Reset(flushInterval)
+		}
+	}
+}
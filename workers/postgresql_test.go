@@ -0,0 +1,100 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func Test_PostgreSQL_Row(t *testing.T) {
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNSTap.TimestampRFC3339 = "2024-03-05T07:09:00.123456789Z"
+	flat, _ := dm.Flatten()
+
+	row := PostgreSQLRow(flat, PostgreSQLDefaultColumns, "extra")
+	if len(row) != len(PostgreSQLDefaultColumns)+1 {
+		t.Fatalf("want %d values, got %d", len(PostgreSQLDefaultColumns)+1, len(row))
+	}
+	if ts, ok := row[0].(time.Time); !ok || ts.Nanosecond() != 123456789 {
+		t.Errorf("invalid timestamp: %v", row[0])
+	}
+	if row[6] != dm.DNS.Qname {
+		t.Errorf("invalid qname: %v", row[6])
+	}
+
+	// the fields not mapped are in the extra column
+	extra := row[len(row)-1].(map[string]interface{})
+	if _, ok := extra["dns.qname"]; ok {
+		t.Errorf("mapped field in extra column")
+	}
+	if _, ok := extra["dns.id"]; !ok {
+		t.Errorf("missing field in extra column")
+	}
+
+	// values are encoded with the types of the default columns
+	oids := []uint32{pgtype.TimestamptzOID, pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.TextOID,
+		pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.Float8OID, pgtype.JSONBOID}
+	m := pgtype.NewMap()
+	for i, value := range row {
+		if _, err := m.Encode(oids[i], pgtype.BinaryFormatCode, value, nil); err != nil {
+			t.Errorf("unable to encode column %d: %v", i, err)
+		}
+	}
+
+	// missing fields are null, without extra column
+	columns := []pkgconfig.ConfigPostgreSQLColumn{{Name: "country", Field: "geoip.country-isocode"}}
+	if row = PostgreSQLRow(flat, columns, ""); len(row) != 1 || row[0] != nil {
+		t.Errorf("invalid row: %v", row)
+	}
+}
+
+func Test_PostgreSQL_CreateTable(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.PostgreSQL.Table = "dns.messages"
+	config.Loggers.PostgreSQL.Columns = []pkgconfig.ConfigPostgreSQLColumn{
+		{Name: "time", Field: "dnstap.timestamp-rfc3339ns", Type: "TIMESTAMPTZ NOT NULL"},
+		{Name: "qname", Field: "dns.qname", Type: "TEXT"},
+	}
+	config.Loggers.PostgreSQL.HypertableColumn = "time"
+	g := NewPostgreSQL(config, logger.New(false), "test")
+
+	queries := g.CreateTableQueries()
+	if len(queries) != 2 {
+		t.Fatalf("want 2 queries, got %d", len(queries))
+	}
+	if queries[0] != `CREATE TABLE IF NOT EXISTS "dns"."messages" ("time" TIMESTAMPTZ NOT NULL, "qname" TEXT, "extra" JSONB)` {
+		t.Errorf("invalid create table query: %s", queries[0])
+	}
+	if queries[1] != `SELECT create_hypertable('"dns"."messages"', 'time', if_not_exists => TRUE)` {
+		t.Errorf("invalid hypertable query: %s", queries[1])
+	}
+}
+
+func Test_PostgreSQL_Unavailable(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.PostgreSQL.URL = "postgres://127.0.0.1:1/dnscollector"
+	config.Loggers.PostgreSQL.RetryInterval = 1
+	g := NewPostgreSQL(config, logger.New(false), "test")
+	go g.StartCollect()
+
+	// messages are dropped while the database is unavailable
+	for i := 0; i < 10; i++ {
+		g.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	}
+	time.Sleep(time.Second)
+
+	done := make(chan bool)
+	go func() {
+		g.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("logger not stopped")
+	}
+}
@@ -66,6 +66,8 @@ func (w *PdnsServer) HandleConn(conn net.Conn, connID uint64, forceClose chan bo
 	pdnsProcessor.SetMetrics(w.metrics)
 	pdnsProcessor.SetDefaultRoutes(w.GetDefaultRoutes())
 	pdnsProcessor.SetDefaultDropped(w.GetDroppedRoutes())
+	pdnsProcessor.SetRouting(w.GetRouting())
+	pdnsProcessor.SetControl(w.GetControl())
 	go pdnsProcessor.StartCollect()
 
 	r := bufio.NewReader(conn)
@@ -231,6 +233,7 @@ func (w *PdnsProcessor) StartCollect() {
 
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&w.GetConfig().IngoingTransformers, w.GetLogger(), w.GetName(), defaultRoutes, w.ConnID)
+	w.StartTransforms(&transforms, w.IngoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// read incoming dns message
 	for {
@@ -460,18 +463,8 @@ func (w *PdnsProcessor) StartCollect() {
 			// count output packets
 			w.CountEgressTraffic()
 
-			// apply all enabled transformers
-			transformResult, err := transforms.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// dispatch dns messages to connected loggers
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// apply all enabled transformers then dispatch to connected routes
+			transforms.Process(dm)
 		}
 	}
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	// _ "net/http/pprof"
 )
 
@@ -38,6 +39,11 @@ var catalogueSelectors map[string]func(*dnsutils.DNSMessage) string = map[string
 	"stream_global": GetStreamGlobal,
 }
 
+// labels of the threat intelligence matches
+type ThreatIntelLabels struct {
+	Feed, Category, Trigger string
+}
+
 /*
 EpsCounters (Events Per Second) - is a set of metrics we calculate on per-second basis.
 For others we rely on averaging by collector
@@ -55,6 +61,8 @@ type EpsCounters struct {
 
 	TotalTC, TotalAA, TotalRA, TotalAD               float64
 	TotalMalformed, TotalFragmented, TotalReasembled float64
+
+	TotalThreatIntel map[ThreatIntelLabels]float64
 }
 
 type PrometheusCountersCatalogue interface {
@@ -168,6 +176,7 @@ type Prometheus struct {
 	counterIPProtocol, counterIPVersion                      *prometheus.Desc
 	counterDNSMessages, counterDNSQueries, counterDNSReplies *prometheus.Desc
 	counterOperations                                        *prometheus.Desc
+	counterThreatIntel                                       *prometheus.Desc
 
 	counterFlagsTC, counterFlagsAA                                         *prometheus.Desc
 	counterFlagsRA, counterFlagsAD                                         *prometheus.Desc
@@ -198,7 +207,7 @@ func newPrometheusCounterSet(w *Prometheus, labels prometheus.Labels) *Prometheu
 		epsCounters: EpsCounters{
 			TotalRcodes: make(map[string]float64), TotalQtypes: make(map[string]float64),
 			TotalIPVersion: make(map[string]float64), TotalIPProtocol: make(map[string]float64),
-			TotalOperations: make(map[string]float64),
+			TotalOperations: make(map[string]float64), TotalThreatIntel: make(map[ThreatIntelLabels]float64),
 		},
 
 		topRequesters:   topmap.NewTopMap(w.GetConfig().Loggers.Prometheus.TopN),
@@ -252,6 +261,7 @@ func (w *PrometheusCountersSet) Describe(ch chan<- *prometheus.Desc) {
 	ch <- w.prom.counterQtypes
 	ch <- w.prom.counterRcodes
 	ch <- w.prom.counterOperations
+	ch <- w.prom.counterThreatIntel
 	ch <- w.prom.counterIPProtocol
 	ch <- w.prom.counterIPVersion
 	ch <- w.prom.counterDNSMessages
@@ -386,6 +396,11 @@ func (w *PrometheusCountersSet) Record(dm dnsutils.DNSMessage) {
 		w.epsCounters.TotalOperations[dm.DNSTap.Operation]++
 	}
 
+	// threat intelligence matches
+	if dm.ThreatIntel != nil && dm.ThreatIntel.Matched {
+		w.epsCounters.TotalThreatIntel[ThreatIntelLabels{Feed: dm.ThreatIntel.Feed, Category: dm.ThreatIntel.Category, Trigger: dm.ThreatIntel.Trigger}]++
+	}
+
 	if dm.DNS.Type == dnsutils.DNSQuery {
 		w.epsCounters.TotalBytesReceived += dm.DNS.Length
 		w.epsCounters.TotalQueries++
@@ -537,6 +552,13 @@ func (w *PrometheusCountersSet) Collect(ch chan<- prometheus.Metric) {
 		)
 	}
 
+	// Update threat intelligence counter
+	for k, v := range w.epsCounters.TotalThreatIntel {
+		ch <- prometheus.MustNewConstMetric(w.prom.counterThreatIntel, prometheus.CounterValue,
+			v, k.Feed, k.Category, k.Trigger,
+		)
+	}
+
 	// Update IP protocol counter
 	for k, v := range w.epsCounters.TotalIPProtocol {
 		ch <- prometheus.MustNewConstMetric(w.prom.counterIPProtocol, prometheus.CounterValue,
@@ -709,15 +731,8 @@ func NewPrometheus(config *pkgconfig.Config, logger *logger.Logger, name string)
 	if config.Loggers.Prometheus.ChannelBufferSize > 0 {
 		bufSize = config.Loggers.Prometheus.ChannelBufferSize
 	}
-	w := &Prometheus{GenericWorker: NewGenericWorker(config, logger, name, "prometheus", bufSize, pkgconfig.DefaultMonitor)}
+	w := NewPrometheusCounters(NewGenericWorker(config, logger, name, "prometheus", bufSize, pkgconfig.DefaultMonitor), config.Loggers.Prometheus.PromPrefix)
 	w.doneAPI = make(chan bool)
-	w.promRegistry = prometheus.NewPedanticRegistry()
-
-	// This will create a catalogue of counters indexed by fileds requested by config
-	w.catalogueLabels, w.counters = CreateSystemCatalogue(w)
-
-	// init prometheus
-	w.InitProm()
 
 	// midleware to add basic authentication
 	authMiddleware := func(handler http.Handler) http.Handler {
@@ -750,9 +765,28 @@ func NewPrometheus(config *pkgconfig.Config, logger *logger.Logger, name string)
 	return w
 }
 
-func (w *Prometheus) InitProm() {
+// NewPrometheusCounters creates the registry and the counters of the dns messages, without the http server,
+// so they can be exported by other loggers
+func NewPrometheusCounters(worker *GenericWorker, promPrefix string) *Prometheus {
+	w := &Prometheus{GenericWorker: worker}
+	w.promRegistry = prometheus.NewPedanticRegistry()
+
+	// This will create a catalogue of counters indexed by fileds requested by config
+	w.catalogueLabels, w.counters = CreateSystemCatalogue(w)
+
+	// init prometheus
+	w.InitProm(promPrefix)
+	return w
+}
+
+// Gather returns the current value of all the metrics
+func (w *Prometheus) Gather() ([]*dto.MetricFamily, error) {
+	return w.promRegistry.Gather()
+}
+
+func (w *Prometheus) InitProm(promPrefix string) {
 
-	promPrefix := telemetry.SanitizeMetricName(w.GetConfig().Loggers.Prometheus.PromPrefix)
+	promPrefix = telemetry.SanitizeMetricName(promPrefix)
 
 	// register metric about current version information.
 	w.promRegistry.MustRegister(version.NewCollector(promPrefix))
@@ -905,6 +939,12 @@ func (w *Prometheus) InitProm() {
 		[]string{"operation"}, nil,
 	)
 
+	w.counterThreatIntel = prometheus.NewDesc(
+		fmt.Sprintf("%s_threatintel_total", promPrefix),
+		"Counter of dns messages matching a threat intelligence feed",
+		[]string{"feed", "category", "trigger"}, nil,
+	)
+
 	w.counterIPProtocol = prometheus.NewDesc(
 		fmt.Sprintf("%s_ipprotocol_total", promPrefix),
 		"Counter of packets per IP protocol",
@@ -1137,6 +1177,7 @@ func (w *Prometheus) StartCollect() {
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
 
 	// start http server
 	go w.ListenAndServe()
@@ -1148,8 +1189,8 @@ func (w *Prometheus) StartCollect() {
 	for {
 		select {
 		case <-w.OnStop():
-			w.StopLogger()
 			subprocessors.Reset()
+			w.StopLogger()
 			w.LogInfo("stopping http server...")
 			w.netListener.Close()
 			<-w.doneAPI
@@ -1170,21 +1211,8 @@ func (w *Prometheus) StartCollect() {
 			w.CountIngressTraffic()
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			transformResult, err := subprocessors.ProcessMessage(&dm)
-			if err != nil {
-				w.LogError(err.Error())
-			}
-			if transformResult == transformers.ReturnDrop {
-				w.SendDroppedTo(droppedRoutes, droppedNames, dm)
-				continue
-			}
-
-			// send to output channel
-			w.CountEgressTraffic()
-			w.GetOutputChannel() <- dm
-
-			// send to next ?
-			w.SendForwardedTo(defaultRoutes, defaultNames, dm)
+			// then send to output channel and to next
+			subprocessors.Process(dm)
 		}
 	}
 }
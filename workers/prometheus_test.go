@@ -362,3 +362,24 @@ func TestPrometheus_QnameInvalidChars(t *testing.T) {
 		t.Errorf("Cannot validate dnscollector_top_servfail_domains!")
 	}
 }
+
+func TestPrometheus_ThreatIntel(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig()
+	g := NewPrometheus(config, logger.New(false), "test")
+
+	// record one dns message matching a feed and one without match
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.ThreatIntel = &dnsutils.TransformThreatIntel{Matched: true, Feed: "rpz", Category: "malware", Trigger: "QNAME", Action: "NXDOMAIN", Value: "dns.collector"}
+	g.Record(dm)
+	g.Record(dm)
+
+	dmNoMatch := dnsutils.GetFakeDNSMessage()
+	dmNoMatch.ThreatIntel = &dnsutils.TransformThreatIntel{Feed: "-", Category: "-", Trigger: "-", Action: "-", Value: "-"}
+	g.Record(dmNoMatch)
+
+	mf := getMetrics(g, t)
+	labels := map[string]string{"stream_id": "collector", "feed": "rpz", "category": "malware", "trigger": "QNAME"}
+	if !ensureMetricValue(t, mf, "dnscollector_threatintel_total", labels, 2) {
+		t.Errorf("Cannot validate dnscollector_threatintel_total!")
+	}
+}
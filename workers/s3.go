@@ -0,0 +1,445 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/dmachard/go-netutils"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/uuid"
+	"github.com/grafana/dskit/backoff"
+	"github.com/klauspost/compress/gzip"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	framestream "github.com/farsightsec/golang-framestream"
+)
+
+// s3 requires parts of 5MiB minimum for the multipart upload
+const s3MinPartSize = 5
+
+func IsValidS3Mode(mode string) bool {
+	switch mode {
+	case
+		pkgconfig.ModeJSON,
+		pkgconfig.ModeFlatJSON,
+		pkgconfig.ModePCAP,
+		pkgconfig.ModeDNSTap:
+		return true
+	}
+	return false
+}
+
+// s3SizeWriter counts the bytes written before the compression
+type s3SizeWriter struct {
+	w    io.Writer
+	size int
+}
+
+func (s *s3SizeWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.size += n
+	return n, err
+}
+
+// S3Object is a batch of dns messages encoded and compressed in memory, uploaded as one object
+type S3Object struct {
+	mode, compression string
+	started           time.Time
+	messages          int
+	buffer            *bytes.Buffer
+	writerGzip        *gzip.Writer
+	writerSize        *s3SizeWriter
+	encoder           *json.Encoder
+	writerPcap        *pcapgo.Writer
+	writerDnstap      *framestream.Encoder
+}
+
+func NewS3Object(mode, compression string, started time.Time) (*S3Object, error) {
+	o := &S3Object{mode: mode, compression: compression, started: started, buffer: new(bytes.Buffer)}
+
+	var w io.Writer = o.buffer
+	if compression == pkgconfig.CompressGzip {
+		o.writerGzip = gzip.NewWriter(o.buffer)
+		w = o.writerGzip
+	}
+	o.writerSize = &s3SizeWriter{w: w}
+
+	switch mode {
+	case pkgconfig.ModeJSON, pkgconfig.ModeFlatJSON:
+		o.encoder = json.NewEncoder(o.writerSize)
+
+	case pkgconfig.ModePCAP:
+		o.writerPcap = pcapgo.NewWriter(o.writerSize)
+		if err := o.writerPcap.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+			return nil, err
+		}
+
+	case pkgconfig.ModeDNSTap:
+		fsOptions := &framestream.EncoderOptions{ContentType: []byte("protobuf:dnstap.Dnstap"), Bidirectional: false}
+		encoder, err := framestream.NewEncoder(o.writerSize, fsOptions)
+		if err != nil {
+			return nil, err
+		}
+		o.writerDnstap = encoder
+
+	default:
+		return nil, fmt.Errorf("invalid mode: %s", mode)
+	}
+	return o, nil
+}
+
+// Write encodes the dns message in the object
+func (o *S3Object) Write(dm dnsutils.DNSMessage, extendedSupport bool) error {
+	switch o.mode {
+	case pkgconfig.ModeJSON:
+		if err := o.encoder.Encode(dm); err != nil {
+			return err
+		}
+
+	case pkgconfig.ModeFlatJSON:
+		flat, err := dm.Flatten()
+		if err != nil {
+			return err
+		}
+		if err := o.encoder.Encode(flat); err != nil {
+			return err
+		}
+
+	case pkgconfig.ModeDNSTap:
+		data, err := dm.ToDNSTap(extendedSupport)
+		if err != nil {
+			return err
+		}
+		if _, err := o.writerDnstap.Write(data); err != nil {
+			return err
+		}
+
+	case pkgconfig.ModePCAP:
+		pkt, err := dm.ToPacketLayer()
+		if err != nil {
+			return err
+		}
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		for _, layer := range pkt {
+			layer.SerializeTo(buf, opts)
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(int64(dm.DNSTap.TimeSec), int64(dm.DNSTap.TimeNsec)),
+			CaptureLength: len(buf.Bytes()),
+			Length:        len(buf.Bytes()),
+		}
+		if err := o.writerPcap.WritePacket(ci, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	o.messages++
+	return nil
+}
+
+// Size returns the size of the encoded messages before compression
+func (o *S3Object) Size() int { return o.writerSize.size }
+
+func (o *S3Object) Messages() int { return o.messages }
+
+// Close terminates the encoding and returns the content of the object
+func (o *S3Object) Close() ([]byte, error) {
+	if o.writerDnstap != nil {
+		if err := o.writerDnstap.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if o.writerGzip != nil {
+		if err := o.writerGzip.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return o.buffer.Bytes(), nil
+}
+
+// Extension returns the file extension of the object according to the mode and the compression
+func (o *S3Object) Extension() string {
+	ext := ".json"
+	switch o.mode {
+	case pkgconfig.ModePCAP:
+		ext = ".pcap"
+	case pkgconfig.ModeDNSTap:
+		ext = ".fstrm"
+	}
+	if o.compression == pkgconfig.CompressGzip {
+		ext += ".gz"
+	}
+	return ext
+}
+
+func (o *S3Object) ContentType() string {
+	switch {
+	case o.compression == pkgconfig.CompressGzip:
+		return "application/gzip"
+	case o.mode == pkgconfig.ModePCAP:
+		return "application/vnd.tcpdump.pcap"
+	case o.mode == pkgconfig.ModeDNSTap:
+		return "application/octet-stream"
+	}
+	return "application/x-ndjson"
+}
+
+// S3ObjectKey builds the key of the object from the template,
+// the time placeholders are replaced with the start time of the batch
+func S3ObjectKey(template, identity, name string, started time.Time, id, ext string) string {
+	started = started.UTC()
+	return strings.NewReplacer(
+		"{identity}", identity,
+		"{name}", name,
+		"{yyyy}", started.Format("2006"),
+		"{mm}", started.Format("01"),
+		"{dd}", started.Format("02"),
+		"{hh}", started.Format("15"),
+		"{min}", started.Format("04"),
+		"{uuid}", id,
+		"{ext}", ext,
+	).Replace(template)
+}
+
+type S3Client struct {
+	*GenericWorker
+	client *minio.Client
+}
+
+func NewS3Client(config *pkgconfig.Config, logger *logger.Logger, name string) *S3Client {
+	bufSize := config.Global.Worker.ChannelBufferSize
+	if config.Loggers.S3Client.ChannelBufferSize > 0 {
+		bufSize = config.Loggers.S3Client.ChannelBufferSize
+	}
+	w := &S3Client{GenericWorker: NewGenericWorker(config, logger, name, "s3", bufSize, pkgconfig.DefaultMonitor)}
+	w.ReadConfig()
+	return w
+}
+
+func (w *S3Client) ReadConfig() {
+	cfg := w.GetConfig().Loggers.S3Client
+
+	if !IsValidS3Mode(cfg.Mode) {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] s3 - invalid mode: ", cfg.Mode)
+	}
+	if cfg.Compression != pkgconfig.CompressGzip && cfg.Compression != pkgconfig.CompressNone {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] s3 - invalid compress mode: ", cfg.Compression)
+	}
+	if cfg.PartSize < s3MinPartSize {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] s3 - part-size must be at least 5 MB: ", cfg.PartSize)
+	}
+
+	// tls client config
+	tlsOptions := netutils.TLSOptions{
+		InsecureSkipVerify: cfg.TLSInsecure,
+		MinVersion:         cfg.TLSMinVersion,
+		CAFile:             cfg.CAFile,
+	}
+	tlsConfig, err := netutils.TLSClientConfig(tlsOptions)
+	if err != nil {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] s3 - tls config failed:", err)
+	}
+
+	// static credentials or from the environment and the instance role
+	creds := credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, "")
+	if len(cfg.AccessKey) == 0 {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	bucketLookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		bucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       cfg.TLSSupport,
+		Region:       cfg.Region,
+		BucketLookup: bucketLookup,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			MaxIdleConns:    10,
+			IdleConnTimeout: 30 * time.Second,
+			TLSClientConfig: tlsConfig,
+		},
+	})
+	if err != nil {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.GetName()+"] s3 - invalid endpoint:", err)
+	}
+	w.client = client
+
+	w.LogInfo("running in mode: %s, bucket: %s", cfg.Mode, cfg.Bucket)
+}
+
+func (w *S3Client) StartCollect() {
+	w.LogInfo("starting data collection")
+	defer w.CollectDone()
+
+	// prepare next channels
+	defaultRoutes, defaultNames := GetRoutes(w.GetDefaultRoutes())
+	droppedRoutes, droppedNames := GetRoutes(w.GetDroppedRoutes())
+
+	// prepare transforms
+	subprocessors := transformers.NewTransforms(&w.GetConfig().OutgoingTransformers, w.GetLogger(), w.GetName(), w.GetOutputChannelAsList(), 0)
+	w.StartTransforms(&subprocessors, w.OutgoingTransformsHandler(defaultRoutes, defaultNames, droppedRoutes, droppedNames))
+
+	// goroutine to process transformed dns messages
+	go w.StartLogging()
+
+	// loop to process incoming messages
+	for {
+		select {
+		case <-w.OnStop():
+			subprocessors.Reset()
+			w.StopLogger()
+			return
+
+			// new config provided?
+		case cfg := <-w.NewConfig():
+			w.SetConfig(cfg)
+			w.ReadConfig()
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-w.GetInputChannel():
+			if !opened {
+				w.LogInfo("input channel closed!")
+				return
+			}
+
+			// count global messages
+			w.CountIngressTraffic()
+
+			// apply tranforms, init dns message with additionnals parts if necessary
+			// then send to output channel and to next
+			subprocessors.Process(dm)
+		}
+	}
+}
+
+// partition returns the key of the object without the unique id,
+// a new object is started when the time partition changes
+func (w *S3Client) partition(t time.Time) string {
+	return S3ObjectKey(w.GetConfig().Loggers.S3Client.KeyTemplate, w.GetConfig().GetServerIdentity(), w.GetName(), t, "", "")
+}
+
+func (w *S3Client) StartLogging() {
+	w.LogInfo("logging has started")
+	defer w.LoggingDone()
+
+	// objects are uploaded in background, the logger is blocked while the queue is full
+	uploads := make(chan *S3Object, w.GetConfig().Loggers.S3Client.UploadQueueSize)
+	uploadsDone := make(chan bool)
+	go func() {
+		defer close(uploadsDone)
+		for object := range uploads {
+			w.UploadObject(object)
+		}
+	}()
+
+	var object *S3Object
+	flush := func() {
+		if object != nil && object.Messages() > 0 {
+			uploads <- object
+		}
+		object = nil
+	}
+
+	// check every second the age and the time partition of the current object
+	checkTicker := time.NewTicker(time.Second)
+	defer checkTicker.Stop()
+
+	for {
+		select {
+		case <-w.OnLoggerStopped():
+			// upload the last object and wait for the pending ones
+			flush()
+			close(uploads)
+			<-uploadsDone
+			return
+
+		case dm, opened := <-w.GetOutputChannel():
+			if !opened {
+				w.LogInfo("output channel closed!")
+				return
+			}
+
+			cfg := w.GetConfig().Loggers.S3Client
+			if object == nil {
+				var err error
+				object, err = NewS3Object(cfg.Mode, cfg.Compression, time.Now())
+				if err != nil {
+					w.LogError("unable to create object: %s", err)
+					continue
+				}
+			}
+
+			if err := object.Write(dm, cfg.ExtendedSupport); err != nil {
+				w.LogError("failed to encode dns message: %s", err)
+				continue
+			}
+
+			// max size reached ?
+			if object.Size() >= cfg.MaxSize*1024*1024 {
+				flush()
+			}
+
+		case now := <-checkTicker.C:
+			if object == nil {
+				continue
+			}
+			flushInterval := time.Duration(w.GetConfig().Loggers.S3Client.FlushInterval) * time.Second
+			if now.Sub(object.started) >= flushInterval || w.partition(now) != w.partition(object.started) {
+				flush()
+			}
+		}
+	}
+}
+
+// UploadObject uploads the object to the bucket, with a multipart upload for the big ones,
+// and retries with backoff on failure
+func (w *S3Client) UploadObject(object *S3Object) {
+	cfg := w.GetConfig().Loggers.S3Client
+
+	data, err := object.Close()
+	if err != nil {
+		w.LogError("unable to close object: %s", err)
+		return
+	}
+	key := S3ObjectKey(cfg.KeyTemplate, w.GetConfig().GetServerIdentity(), w.GetName(), object.started, uuid.NewString(), object.Extension())
+
+	retry := backoff.New(context.Background(), backoff.Config{
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		MaxRetries: cfg.MaxRetries + 1,
+	})
+	for retry.Ongoing() {
+		_, err = w.client.PutObject(context.Background(), cfg.Bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+			ContentType: object.ContentType(),
+			PartSize:    uint64(cfg.PartSize) * 1024 * 1024,
+		})
+		if err == nil {
+			w.LogInfo("object %s uploaded with %d messages (%d bytes)", key, object.Messages(), len(data))
+			return
+		}
+		w.LogError("unable to upload object %s: %s", key, err)
+		retry.Wait()
+	}
+	w.LogError("object %s dropped after %d retries, %d messages lost", key, cfg.MaxRetries, object.Messages())
+}
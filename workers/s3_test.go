@@ -0,0 +1,283 @@
+package workers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/klauspost/compress/gzip"
+)
+
+// readS3Body returns the payload of the request, decoded from the aws-chunked encoding
+// used by the streaming signature
+func readS3Body(r *http.Request) []byte {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body, _ := io.ReadAll(r.Body)
+		return body
+	}
+
+	var body []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return body
+		}
+		size, err := strconv.ParseInt(strings.Split(strings.TrimSpace(header), ";")[0], 16, 64)
+		if err != nil || size == 0 {
+			return body
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return body
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
+
+func Test_S3Client_ObjectKey(t *testing.T) {
+	started := time.Date(2024, 3, 5, 7, 9, 0, 0, time.UTC)
+
+	key := S3ObjectKey("{identity}/{name}/{yyyy}/{mm}/{dd}/{hh}/{min}/{uuid}{ext}", "collector", "s3", started, "1234", ".json.gz")
+	if key != "collector/s3/2024/03/05/07/09/1234.json.gz" {
+		t.Errorf("invalid object key: %s", key)
+	}
+}
+
+func Test_S3Client_Object(t *testing.T) {
+	testcases := []struct {
+		mode, compression, ext string
+		magic                  []byte
+	}{
+		{mode: pkgconfig.ModeJSON, compression: pkgconfig.CompressGzip, ext: ".json.gz", magic: []byte("{")},
+		{mode: pkgconfig.ModeFlatJSON, compression: pkgconfig.CompressNone, ext: ".json", magic: []byte("{")},
+		{mode: pkgconfig.ModePCAP, compression: pkgconfig.CompressNone, ext: ".pcap", magic: []byte{0xd4, 0xc3, 0xb2, 0xa1}},
+		{mode: pkgconfig.ModeDNSTap, compression: pkgconfig.CompressGzip, ext: ".fstrm.gz", magic: []byte{0, 0, 0, 0}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.mode+"_"+tc.compression, func(t *testing.T) {
+			object, err := NewS3Object(tc.mode, tc.compression, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := object.Write(dnsutils.GetFakeDNSMessageWithPayload(), false); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if object.Messages() != 10 || object.Size() == 0 {
+				t.Errorf("invalid object: %d messages, %d bytes", object.Messages(), object.Size())
+			}
+			if object.Extension() != tc.ext {
+				t.Errorf("invalid extension: %s", object.Extension())
+			}
+
+			data, err := object.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.compression == pkgconfig.CompressGzip {
+				reader, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("invalid gzip content: %v", err)
+				}
+				data, _ = io.ReadAll(reader)
+			}
+			if !bytes.HasPrefix(data, tc.magic) {
+				t.Errorf("invalid content: %x", data[:4])
+			}
+		})
+	}
+}
+
+func Test_S3Client_Upload(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		objects[r.URL.Path] = readS3Body(r)
+		w.Header().Set("ETag", "\"object\"")
+	}))
+	defer server.Close()
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.S3Client.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	config.Loggers.S3Client.TLSSupport = false
+	config.Loggers.S3Client.PathStyle = true
+	config.Loggers.S3Client.AccessKey = "minioadmin"
+	config.Loggers.S3Client.SecretKey = "minioadmin"
+	config.Loggers.S3Client.KeyTemplate = "{identity}/{yyyy}/{uuid}{ext}"
+	g := NewS3Client(config, logger.New(false), "test")
+	go g.StartCollect()
+
+	for i := 0; i < 10; i++ {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = strconv.Itoa(i) + ".dns.collector"
+		g.GetInputChannel() <- dm
+	}
+
+	// the last object is uploaded on stop
+	time.Sleep(time.Second)
+	g.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(objects) != 1 {
+		t.Fatalf("want 1 object, got %d", len(objects))
+	}
+	for key, data := range objects {
+		prefix := "/dnscollector/" + config.GetServerIdentity() + "/" + time.Now().UTC().Format("2006") + "/"
+		if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, ".json.gz") {
+			t.Errorf("invalid object key: %s", key)
+		}
+
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("invalid gzip content: %v", err)
+		}
+		scanner := bufio.NewScanner(reader)
+		i := 0
+		for ; scanner.Scan(); i++ {
+			var dm dnsutils.DNSMessage
+			if err := json.Unmarshal(scanner.Bytes(), &dm); err != nil {
+				t.Fatalf("invalid json: %v", err)
+			}
+			if dm.DNS.Qname != strconv.Itoa(i)+".dns.collector" {
+				t.Errorf("unexpected message %d: %s", i, dm.DNS.Qname)
+			}
+		}
+		if i != 10 {
+			t.Errorf("want 10 messages, got %d", i)
+		}
+	}
+}
+
+func Test_S3Client_MultipartUpload(t *testing.T) {
+	var mu sync.Mutex
+	uploads := 0
+	parts := make(map[int][]byte)
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body := readS3Body(r)
+		query := r.URL.Query()
+		switch {
+		// initiate multipart upload
+		case r.Method == http.MethodPost && query.Has("uploads"):
+			uploads++
+			fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%d</UploadId></InitiateMultipartUploadResult>", uploads)
+
+		// upload part
+		case r.Method == http.MethodPut && query.Has("partNumber"):
+			part, _ := strconv.Atoi(query.Get("partNumber"))
+			parts[part] = body
+			w.Header().Set("ETag", "\"part"+query.Get("partNumber")+"\"")
+
+		// complete multipart upload
+		case r.Method == http.MethodPost && query.Has("uploadId"):
+			numbers := []int{}
+			for n := range parts {
+				numbers = append(numbers, n)
+			}
+			sort.Ints(numbers)
+			var data []byte
+			for _, n := range numbers {
+				data = append(data, parts[n]...)
+			}
+			objects[r.URL.Path] = data
+			fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>dnscollector</Bucket><Key>%s</Key><ETag>\"multipart\"</ETag></CompleteMultipartUploadResult>", r.URL.Path)
+
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	// objects bigger than a part
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.S3Client.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	config.Loggers.S3Client.TLSSupport = false
+	config.Loggers.S3Client.PathStyle = true
+	config.Loggers.S3Client.AccessKey = "minioadmin"
+	config.Loggers.S3Client.SecretKey = "minioadmin"
+	config.Loggers.S3Client.Compression = pkgconfig.CompressNone
+	config.Loggers.S3Client.PartSize = s3MinPartSize
+	config.Loggers.S3Client.MaxSize = 6
+	g := NewS3Client(config, logger.New(false), "test")
+
+	object, _ := NewS3Object(pkgconfig.ModeJSON, pkgconfig.CompressNone, time.Now())
+	for object.Size() < config.Loggers.S3Client.MaxSize*1024*1024 {
+		object.Write(dnsutils.GetFakeDNSMessage(), false)
+	}
+	size := object.Size()
+	g.UploadObject(object)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(objects) != 1 || uploads != 1 {
+		t.Fatalf("want 1 multipart object, got %d objects and %d multipart uploads", len(objects), uploads)
+	}
+	for _, data := range objects {
+		if len(data) != size {
+			t.Errorf("want %d bytes, got %d", size, len(data))
+		}
+	}
+}
+
+func Test_S3Client_UploadRetry(t *testing.T) {
+	// the server is unavailable for the first requests
+	var mu sync.Mutex
+	fail, uploaded := 12, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		readS3Body(r)
+		uploaded++
+		w.Header().Set("ETag", "\"object\"")
+	}))
+	defer server.Close()
+
+	config := pkgconfig.GetDefaultConfig()
+	config.Loggers.S3Client.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	config.Loggers.S3Client.TLSSupport = false
+	config.Loggers.S3Client.PathStyle = true
+	config.Loggers.S3Client.AccessKey = "minioadmin"
+	config.Loggers.S3Client.SecretKey = "minioadmin"
+	config.Loggers.S3Client.MaxRetries = 2
+	g := NewS3Client(config, logger.New(false), "test")
+
+	object, _ := NewS3Object(pkgconfig.ModeJSON, pkgconfig.CompressGzip, time.Now())
+	object.Write(dnsutils.GetFakeDNSMessage(), false)
+	g.UploadObject(object)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if uploaded != 1 {
+		t.Errorf("object should be uploaded after retries")
+	}
+}
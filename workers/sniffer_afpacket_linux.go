@@ -109,6 +109,8 @@ func (w *AfpacketSniffer) StartCollect() {
 	dnsProcessor := NewDNSProcessor(w.GetConfig(), w.GetLogger(), w.GetName(), bufSize)
 	dnsProcessor.SetDefaultRoutes(w.GetDefaultRoutes())
 	dnsProcessor.SetDefaultDropped(w.GetDroppedRoutes())
+	dnsProcessor.SetRouting(w.GetRouting())
+	dnsProcessor.SetControl(w.GetControl())
 	go dnsProcessor.StartCollect()
 
 	dnsChan := make(chan netutils.DNSPacket)
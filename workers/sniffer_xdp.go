@@ -47,6 +47,8 @@ func (w *XDPSniffer) StartCollect() {
 	dnsProcessor := NewDNSProcessor(w.GetConfig(), w.GetLogger(), w.GetName(), bufSize)
 	dnsProcessor.SetDefaultRoutes(w.GetDefaultRoutes())
 	dnsProcessor.SetDefaultDropped(w.GetDroppedRoutes())
+	dnsProcessor.SetRouting(w.GetRouting())
+	dnsProcessor.SetControl(w.GetControl())
 	go dnsProcessor.StartCollect()
 
 	// get network interface by name
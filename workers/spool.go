@@ -0,0 +1,606 @@
+package workers
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+)
+
+const (
+	spoolSegmentExt       = ".spool"
+	spoolCheckpointFile   = "checkpoint"
+	spoolCheckpointPeriod = 100
+)
+
+type spoolSegment struct {
+	path string
+	size int64
+	// number of messages, -1 until the segment is fully read
+	count int
+}
+
+// countingWriter counts the bytes written in the segment
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// SpoolMessage is a message replayed from the spool, it is removed from
+// the spool once acknowledged
+type SpoolMessage struct {
+	dnsutils.DNSMessage
+	seq uint64
+}
+
+// spoolInflight is a message replayed and not yet committed
+type spoolInflight struct {
+	seq   uint64
+	acked bool
+}
+
+// Spool is a disk-backed queue of dns messages, used by the loggers to persist
+// the messages while the remote destination is unavailable.
+// The messages are written in segment files, the last one is open for writing
+// and the others are read in order.
+// A replayed message is committed only when acknowledged, after the write
+// confirmed by the remote destination, a segment is removed once all its
+// messages are committed.
+type Spool struct {
+	sync.Mutex
+	config pkgconfig.ConfigSpool
+	dir    string
+
+	// closed segments, ordered from the oldest
+	segments  []spoolSegment
+	totalSize int64
+	nextSeq   uint64
+
+	// segment open for writing
+	writer     *os.File
+	counter    *countingWriter
+	encoder    *gob.Encoder
+	writePath  string
+	writeCount int
+
+	// segment open for reading, the previous ones are fully read
+	reader     *os.File
+	decoder    *gob.Decoder
+	readSeg    int
+	readOffset int
+	pending    *dnsutils.DNSMessage
+
+	// replayed messages waiting for the acknowledgement, in order
+	replay    chan SpoolMessage
+	replaySeq uint64
+	inflight  []spoolInflight
+
+	// incremented when the replay restarts from the committed position
+	generation uint64
+	rewound    chan bool
+
+	// messages committed in the oldest segment, saved in the checkpoint
+	committed int
+
+	// segments removed by the size or age limits
+	evicted int
+
+	// remote destination state
+	available bool
+	downSince time.Time
+
+	notify chan bool
+}
+
+// NewSpool opens the spool in the directory, the existing segments are kept to be replayed
+func NewSpool(config pkgconfig.ConfigSpool, dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		config:    config,
+		dir:       dir,
+		available: true,
+		replay:    make(chan SpoolMessage),
+		rewound:   make(chan bool, 1),
+		notify:    make(chan bool, 1),
+	}
+
+	// load existing segments
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), spoolSegmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		if info.Size() == 0 {
+			os.Remove(file)
+			continue
+		}
+		s.segments = append(s.segments, spoolSegment{path: file, size: info.Size(), count: -1})
+		s.totalSize += info.Size()
+		s.nextSeq = seq + 1
+	}
+
+	// load the position in the oldest segment
+	if data, err := os.ReadFile(filepath.Join(dir, spoolCheckpointFile)); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && len(s.segments) > 0 && fields[0] == filepath.Base(s.segments[0].path) {
+			s.committed, _ = strconv.Atoi(fields[1])
+		}
+	}
+	return s, nil
+}
+
+// IsEmpty returns true when there is no message to replay
+func (s *Spool) IsEmpty() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.isEmpty()
+}
+
+func (s *Spool) isEmpty() bool {
+	return s.pending == nil && s.reader == nil && s.readSeg >= len(s.segments) && s.writeCount == 0
+}
+
+// Size returns the size in bytes of the segments
+func (s *Spool) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.totalSize + s.writeSize()
+}
+
+func (s *Spool) writeSize() int64 {
+	if s.counter == nil {
+		return 0
+	}
+	return s.counter.n
+}
+
+// Output returns the channel of the replayed messages
+func (s *Spool) Output() chan SpoolMessage {
+	return s.replay
+}
+
+// SetAvailable updates the state of the remote destination
+func (s *Spool) SetAvailable(available bool) {
+	s.Lock()
+	defer s.Unlock()
+	if !available {
+		s.downSince = time.Now()
+	} else {
+		s.wakeup()
+	}
+	s.available = available
+}
+
+// IsAvailable returns the state of the remote destination
+func (s *Spool) IsAvailable() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.available
+}
+
+// replayAllowed returns true if the remote is available or if the retry interval is elapsed
+func (s *Spool) replayAllowed() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.available || time.Since(s.downSince) >= time.Duration(s.config.RetryInterval)*time.Second
+}
+
+func (s *Spool) wakeup() {
+	select {
+	case s.notify <- true:
+	default:
+	}
+}
+
+// Forward sends the message to the channel, the message is written to
+// the spool when the remote is unavailable, when the channel is full or
+// when older messages are waiting in the spool
+func (s *Spool) Forward(dm dnsutils.DNSMessage, out chan dnsutils.DNSMessage) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.available && s.isEmpty() {
+		select {
+		case out <- dm:
+			return nil
+		default:
+		}
+	}
+	return s.push(dm)
+}
+
+// Push writes the message at the end of the spool
+func (s *Spool) Push(dm dnsutils.DNSMessage) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.push(dm)
+}
+
+func (s *Spool) push(dm dnsutils.DNSMessage) error {
+	if s.writer == nil {
+		if err := s.openWriter(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.encoder.Encode(&dm); err != nil {
+		return err
+	}
+	s.writeCount++
+
+	// rotate the segment
+	if s.writeSize() >= int64(s.config.MaxSegmentSize) {
+		s.closeWriter()
+	}
+
+	// remove the oldest segments if the spool is too big
+	for s.totalSize+s.writeSize() > int64(s.config.MaxSize) && len(s.segments) > 0 {
+		s.dropOldestSegment()
+		s.evicted++
+	}
+
+	s.wakeup()
+	return nil
+}
+
+func (s *Spool) openWriter() error {
+	path := filepath.Join(s.dir, fmt.Sprintf("%016x%s", s.nextSeq, spoolSegmentExt))
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	s.nextSeq++
+	s.writer = fd
+	s.counter = &countingWriter{w: fd}
+	s.encoder = gob.NewEncoder(s.counter)
+	s.writePath = path
+	s.writeCount = 0
+	return nil
+}
+
+func (s *Spool) closeWriter() {
+	if s.writer == nil {
+		return
+	}
+	s.writer.Close()
+	if s.writeCount > 0 {
+		s.segments = append(s.segments, spoolSegment{path: s.writePath, size: s.counter.n, count: s.writeCount})
+		s.totalSize += s.counter.n
+	} else {
+		os.Remove(s.writePath)
+	}
+	s.writer, s.counter, s.encoder = nil, nil, nil
+	s.writeCount = 0
+}
+
+func (s *Spool) closeReader() {
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+		s.decoder = nil
+	}
+	s.pending = nil
+	s.readOffset = 0
+}
+
+// dropOldestSegment removes the oldest segment even if its messages are not committed
+func (s *Spool) dropOldestSegment() {
+	// number of messages of the segment already replayed
+	replayed := s.committed
+	switch {
+	case s.readSeg > 0:
+		replayed = s.segments[0].count
+		s.readSeg--
+	case s.reader != nil:
+		replayed = s.readOffset
+		s.closeReader()
+	}
+
+	// the acknowledgements of these messages are ignored
+	if n := replayed - s.committed; n > 0 {
+		s.inflight = s.inflight[min(n, len(s.inflight)):]
+	}
+	s.removeOldestSegment()
+}
+
+func (s *Spool) removeOldestSegment() {
+	os.Remove(s.segments[0].path)
+	s.totalSize -= s.segments[0].size
+	s.segments = s.segments[1:]
+	s.committed = 0
+	s.saveCheckpoint()
+}
+
+// endSegment closes the segment read, it is removed once all its messages are committed
+func (s *Spool) endSegment() {
+	s.segments[s.readSeg].count = s.readOffset
+	s.closeReader()
+	s.readSeg++
+	s.removeCommittedSegments()
+}
+
+// removeCommittedSegments removes the oldest segments fully read and committed
+func (s *Spool) removeCommittedSegments() {
+	for s.readSeg > 0 && s.committed >= s.segments[0].count {
+		s.readSeg--
+		s.removeOldestSegment()
+	}
+}
+
+// Peek returns the next message to replay without removing it from the spool
+func (s *Spool) Peek() (dnsutils.DNSMessage, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.pending != nil {
+		return *s.pending, true, nil
+	}
+
+	for {
+		if s.reader == nil {
+			if s.readSeg >= len(s.segments) {
+				if s.writeCount == 0 {
+					return dnsutils.DNSMessage{}, false, nil
+				}
+				// the segment open for writing is closed to be read
+				s.closeWriter()
+			}
+			if err := s.openReader(); err != nil {
+				s.endSegment()
+				return dnsutils.DNSMessage{}, false, err
+			}
+		}
+
+		var dm dnsutils.DNSMessage
+		err := s.decoder.Decode(&dm)
+		if err != nil {
+			// end of the segment, the next one will be read
+			s.endSegment()
+			if errors.Is(err, io.EOF) {
+				continue
+			}
+			return dnsutils.DNSMessage{}, false, fmt.Errorf("corrupted segment: %w", err)
+		}
+
+		s.pending = &dm
+		return dm, true, nil
+	}
+}
+
+func (s *Spool) openReader() error {
+	fd, err := os.Open(s.segments[s.readSeg].path)
+	if err != nil {
+		return err
+	}
+	s.reader = fd
+	s.decoder = gob.NewDecoder(bufio.NewReader(fd))
+	s.readOffset = 0
+
+	// skip the messages already committed, before a restart or a rewind
+	if s.readSeg == 0 {
+		for s.readOffset < s.committed {
+			var dm dnsutils.DNSMessage
+			if err := s.decoder.Decode(&dm); err != nil {
+				break
+			}
+			s.readOffset++
+		}
+	}
+	return nil
+}
+
+// deliver marks the message returned by Peek as replayed, the sequence
+// number used to acknowledge it is returned
+func (s *Spool) deliver() uint64 {
+	seq := s.replaySeq
+	s.replaySeq++
+
+	// the message is lost if its segment has been removed in the meantime
+	if s.pending != nil {
+		s.pending = nil
+		s.readOffset++
+		s.inflight = append(s.inflight, spoolInflight{seq: seq})
+	}
+	return seq
+}
+
+// Commit removes the message returned by Peek
+func (s *Spool) Commit() {
+	s.Lock()
+	defer s.Unlock()
+	if s.pending == nil {
+		return
+	}
+	s.ack(s.deliver())
+}
+
+// Ack commits the replayed message, to call once the remote destination has confirmed
+// the write. The messages are committed in order, so a message is replayed again after
+// a restart as long as an older one is not acknowledged.
+func (s *Spool) Ack(seq uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.ack(seq)
+}
+
+func (s *Spool) ack(seq uint64) {
+	i := sort.Search(len(s.inflight), func(i int) bool { return s.inflight[i].seq >= seq })
+	if i == len(s.inflight) || s.inflight[i].seq != seq {
+		return
+	}
+	s.inflight[i].acked = true
+
+	// commit the messages acknowledged in order
+	n := 0
+	for n < len(s.inflight) && s.inflight[n].acked {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	s.inflight = s.inflight[n:]
+	s.committed += n
+	if s.committed/spoolCheckpointPeriod != (s.committed-n)/spoolCheckpointPeriod {
+		s.saveCheckpoint()
+	}
+	s.removeCommittedSegments()
+}
+
+// Rewind restarts the replay from the last committed position, the messages
+// replayed and not yet acknowledged are replayed again in order
+func (s *Spool) Rewind() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closeReader()
+	s.readSeg = 0
+	s.inflight = nil
+	s.generation++
+	select {
+	case s.rewound <- true:
+	default:
+	}
+	s.wakeup()
+}
+
+// saveCheckpoint saves the position in the oldest segment
+func (s *Spool) saveCheckpoint() {
+	checkpoint := ""
+	if len(s.segments) > 0 {
+		checkpoint = filepath.Base(s.segments[0].path)
+	}
+	os.WriteFile(filepath.Join(s.dir, spoolCheckpointFile), []byte(fmt.Sprintf("%s %d\n", checkpoint, s.committed)), 0o640)
+}
+
+// Cleanup removes the segments older than the max age, the number of
+// segments removed by the size or age limits since the last call is returned
+func (s *Spool) Cleanup() int {
+	s.Lock()
+	defer s.Unlock()
+
+	for s.config.MaxAge > 0 && len(s.segments) > 0 {
+		info, err := os.Stat(s.segments[0].path)
+		if err == nil && time.Since(info.ModTime()) < time.Duration(s.config.MaxAge)*time.Second {
+			break
+		}
+		s.dropOldestSegment()
+		s.evicted++
+	}
+
+	evicted := s.evicted
+	s.evicted = 0
+	return evicted
+}
+
+// Close flushes the segment open for writing and saves the position,
+// the messages not acknowledged are replayed again after a restart
+func (s *Spool) Close() {
+	s.Lock()
+	defer s.Unlock()
+	s.closeWriter()
+	s.saveCheckpoint()
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+}
+
+// Replay sends the messages of the spool to the output channel in order,
+// the replay is paused while the remote destination is unavailable
+func (s *Spool) Replay(stop chan bool, logError func(msg string, v ...interface{})) {
+	cleanup := time.NewTicker(time.Second)
+	defer cleanup.Stop()
+
+	for {
+		if !s.replayAllowed() {
+			select {
+			case <-stop:
+				return
+			case <-s.notify:
+			case <-cleanup.C:
+				s.logEvicted(logError)
+			}
+			continue
+		}
+
+		s.Lock()
+		generation := s.generation
+		s.Unlock()
+
+		dm, ok, err := s.Peek()
+		if err != nil {
+			logError("spool - %v", err)
+			continue
+		}
+		if !ok {
+			select {
+			case <-stop:
+				return
+			case <-s.notify:
+			case <-cleanup.C:
+				s.logEvicted(logError)
+			}
+			continue
+		}
+
+		// the message is marked as replayed before to be sent, to accept its acknowledgement
+		// as soon as it is received, it is replayed again after a restart if not sent
+		s.Lock()
+		if s.generation != generation {
+			s.Unlock()
+			continue
+		}
+		sm := SpoolMessage{DNSMessage: dm, seq: s.deliver()}
+		s.Unlock()
+
+		for done := false; !done; {
+			select {
+			case <-stop:
+				return
+			case s.replay <- sm:
+				done = true
+			case <-s.rewound:
+				// the message is dropped if the replay restarts from the committed position
+				s.Lock()
+				done = s.generation != generation
+				s.Unlock()
+			case <-cleanup.C:
+				s.logEvicted(logError)
+			}
+		}
+	}
+}
+
+func (s *Spool) logEvicted(logError func(msg string, v ...interface{})) {
+	if n := s.Cleanup(); n > 0 {
+		logError("spool - %d segment(s) removed, max size or max age reached", n)
+	}
+}
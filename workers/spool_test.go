@@ -0,0 +1,355 @@
+package workers
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+)
+
+func getSpoolMessageForTest(i int) dnsutils.DNSMessage {
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Qname = strconv.Itoa(i) + ".dns.collector"
+	dm.DNSTap.TimeSec = 1700000000 + i
+	return dm
+}
+
+func TestSpool_PushPeekCommit(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	config.MaxSegmentSize = 4096
+	spool, err := NewSpool(config, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+
+	if !spool.IsEmpty() {
+		t.Fatalf("spool should be empty")
+	}
+
+	// push enough messages to rotate several segments
+	for i := 0; i < 100; i++ {
+		if err := spool.Push(getSpoolMessageForTest(i)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(spool.segments) < 2 {
+		t.Errorf("segments not rotated: %d", len(spool.segments))
+	}
+
+	// read messages in order
+	for i := 0; i < 100; i++ {
+		dm, ok, err := spool.Peek()
+		if err != nil || !ok {
+			t.Fatalf("message %d expected: %v", i, err)
+		}
+		if dm.DNS.Qname != strconv.Itoa(i)+".dns.collector" || dm.DNSTap.TimeSec != 1700000000+i {
+			t.Fatalf("unexpected message %d: %s %d", i, dm.DNS.Qname, dm.DNSTap.TimeSec)
+		}
+		spool.Commit()
+	}
+
+	if _, ok, _ := spool.Peek(); ok {
+		t.Errorf("no more message expected")
+	}
+	if !spool.IsEmpty() || spool.Size() != 0 {
+		t.Errorf("spool should be empty, size=%d", spool.Size())
+	}
+}
+
+func TestSpool_Restart(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	config.MaxSegmentSize = 4096
+	dir := t.TempDir()
+
+	spool, err := NewSpool(config, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 250; i++ {
+		spool.Push(getSpoolMessageForTest(i))
+	}
+	for i := 0; i < 150; i++ {
+		spool.Peek()
+		spool.Commit()
+	}
+	spool.Close()
+
+	// the messages not replayed are kept
+	spool, err = NewSpool(config, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+
+	for i := 150; i < 250; i++ {
+		dm, ok, err := spool.Peek()
+		if err != nil || !ok {
+			t.Fatalf("message %d expected: %v", i, err)
+		}
+		if dm.DNS.Qname != strconv.Itoa(i)+".dns.collector" {
+			t.Fatalf("unexpected message %d: %s", i, dm.DNS.Qname)
+		}
+		spool.Commit()
+	}
+	if _, ok, _ := spool.Peek(); ok {
+		t.Errorf("no more message expected")
+	}
+}
+
+func TestSpool_MaxSize(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	config.MaxSegmentSize = 4096
+	config.MaxSize = 16384
+
+	spool, err := NewSpool(config, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+
+	for i := 0; i < 1000; i++ {
+		spool.Push(getSpoolMessageForTest(i))
+	}
+	if spool.Size() > int64(config.MaxSize) {
+		t.Errorf("spool too big: %d", spool.Size())
+	}
+	if spool.Cleanup() == 0 {
+		t.Errorf("segments should be evicted")
+	}
+
+	// the oldest messages are removed
+	dm, ok, _ := spool.Peek()
+	if !ok || dm.DNS.Qname == "0.dns.collector" {
+		t.Errorf("oldest messages should be evicted")
+	}
+}
+
+func TestSpool_Relabeling(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	spool, err := NewSpool(config, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.Relabeling = &dnsutils.TransformRelabeling{
+		Rules: []dnsutils.RelabelingRule{{Regex: regexp.MustCompile("^dns.qname$"), Replacement: "qname", Action: "rename"}},
+	}
+	spool.Push(dm)
+
+	dmSpool, ok, err := spool.Peek()
+	if err != nil || !ok {
+		t.Fatalf("message expected: %v", err)
+	}
+	if dmSpool.Relabeling == nil || len(dmSpool.Relabeling.Rules) != 1 {
+		t.Fatalf("relabeling rules not restored")
+	}
+	rule := dmSpool.Relabeling.Rules[0]
+	if rule.Regex.String() != "^dns.qname$" || rule.Replacement != "qname" || rule.Action != "rename" {
+		t.Errorf("invalid relabeling rule: %v", rule)
+	}
+}
+
+func TestSpool_ForwardAndReplay(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	spool, err := NewSpool(config, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+
+	out := make(chan dnsutils.DNSMessage, 2)
+
+	// sent directly while the channel is not full
+	spool.Forward(getSpoolMessageForTest(0), out)
+	spool.Forward(getSpoolMessageForTest(1), out)
+	if !spool.IsEmpty() {
+		t.Fatalf("spool should be empty")
+	}
+
+	// written to the spool when the channel is full or the remote is unavailable
+	spool.Forward(getSpoolMessageForTest(2), out)
+	spool.SetAvailable(false)
+	spool.Forward(getSpoolMessageForTest(3), out)
+	if spool.IsEmpty() {
+		t.Fatalf("spool should not be empty")
+	}
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		spool.Replay(stop, t.Logf)
+		done <- true
+	}()
+
+	// the messages sent directly are read first
+	for i := 0; i < 2; i++ {
+		dm := <-out
+		if dm.DNS.Qname != strconv.Itoa(i)+".dns.collector" {
+			t.Errorf("unexpected message %d: %s", i, dm.DNS.Qname)
+		}
+	}
+
+	// the remote is available again, the spooled messages are replayed in order
+	spool.SetAvailable(true)
+	for i := 2; i < 4; i++ {
+		select {
+		case sm := <-spool.Output():
+			if sm.DNS.Qname != strconv.Itoa(i)+".dns.collector" {
+				t.Errorf("unexpected message %d: %s", i, sm.DNS.Qname)
+			}
+			spool.Ack(sm.seq)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d not replayed", i)
+		}
+	}
+
+	stop <- true
+	<-done
+
+	// the segments are removed once the messages are acknowledged
+	if _, ok, _ := spool.Peek(); ok || spool.Size() != 0 {
+		t.Errorf("spool should be empty, size=%d", spool.Size())
+	}
+}
+
+func TestSpool_ReplayWithoutAck(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	dir := t.TempDir()
+
+	spool, err := NewSpool(config, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		spool.Push(getSpoolMessageForTest(i))
+	}
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		spool.Replay(stop, t.Logf)
+		done <- true
+	}()
+
+	// only the first and the third messages are acknowledged
+	for i := 0; i < 5; i++ {
+		sm := <-spool.Output()
+		if i == 0 || i == 2 {
+			spool.Ack(sm.seq)
+		}
+	}
+	stop <- true
+	<-done
+	spool.Close()
+
+	// the messages are committed in order, so replayed again from the second one
+	spool, err = NewSpool(config, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+
+	for i := 1; i < 10; i++ {
+		dm, ok, err := spool.Peek()
+		if err != nil || !ok {
+			t.Fatalf("message %d expected: %v", i, err)
+		}
+		if dm.DNS.Qname != strconv.Itoa(i)+".dns.collector" {
+			t.Fatalf("unexpected message %d: %s", i, dm.DNS.Qname)
+		}
+		spool.Commit()
+	}
+}
+
+func TestSpool_BatchDone(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	config.Directory = t.TempDir()
+
+	w := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+	w.EnableSpool(config)
+	defer func() {
+		w.stopSpool <- true
+		<-w.doneSpool
+		w.spool.Close()
+	}()
+
+	// a replayed message and a message read from the output channel
+	w.spool.Push(getSpoolMessageForTest(0))
+	batch := SpoolBatch{}
+	batch.AddReplayed(<-w.GetSpoolChannel())
+	w.SpoolBatchAdd(&batch, getSpoolMessageForTest(1))
+
+	// the batch is not sent, the message read from the output channel is written to the spool
+	// and the replay restarts from the replayed message not acknowledged
+	w.SpoolBatchDone(&batch, errors.New("remote unavailable"))
+	if len(batch.messages) != 0 || len(batch.seqs) != 0 {
+		t.Errorf("batch should be reset")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case sm := <-w.GetSpoolChannel():
+			if sm.DNS.Qname != strconv.Itoa(i)+".dns.collector" {
+				t.Errorf("unexpected message %d: %s", i, sm.DNS.Qname)
+			}
+			batch.AddReplayed(sm)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d not replayed", i)
+		}
+	}
+	select {
+	case sm := <-w.GetSpoolChannel():
+		t.Errorf("message replayed twice: %s", sm.DNS.Qname)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the batch is sent, the replayed messages are committed
+	w.SpoolBatchDone(&batch, nil)
+	w.spool.Lock()
+	inflight := len(w.spool.inflight)
+	w.spool.Unlock()
+	if inflight != 0 {
+		t.Errorf("replayed messages not committed: %d", inflight)
+	}
+}
+
+func TestSpool_StopLogger(t *testing.T) {
+	config := pkgconfig.GetDefaultConfig().Loggers.LokiClient.Spool
+	config.Directory = t.TempDir()
+
+	w := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+	w.GetConfig().Global.Worker.DrainTimeout = 1
+	w.EnableSpool(config)
+
+	// the logger does not consume the messages, the remote is unavailable
+	w.SetRemoteAvailable(false)
+	go func() {
+		<-w.OnLoggerStopped()
+		w.LoggingDone()
+	}()
+	for i := 0; i < 3; i++ {
+		w.GetOutputChannel() <- getSpoolMessageForTest(i)
+	}
+	w.StopLogger()
+
+	// the messages of the output channel are kept in the spool
+	spool, err := NewSpool(config, filepath.Join(config.Directory, w.GetName()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer spool.Close()
+	for i := 0; i < 3; i++ {
+		dm, ok, _ := spool.Peek()
+		if !ok || dm.DNS.Qname != strconv.Itoa(i)+".dns.collector" {
+			t.Fatalf("message %d expected in the spool", i)
+		}
+		spool.Commit()
+	}
+}
@@ -89,6 +89,8 @@ func (w *TZSPSniffer) StartCollect() {
 	dnsProcessor := NewDNSProcessor(w.GetConfig(), w.GetLogger(), w.GetName(), w.GetConfig().Collectors.Tzsp.ChannelBufferSize)
 	dnsProcessor.SetDefaultRoutes(w.GetDefaultRoutes())
 	dnsProcessor.SetDefaultDropped(w.GetDroppedRoutes())
+	dnsProcessor.SetRouting(w.GetRouting())
+	dnsProcessor.SetControl(w.GetControl())
 	go dnsProcessor.StartCollect()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -1,11 +1,14 @@
 package workers
 
 import (
+	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/telemetry"
+	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
 )
 
@@ -13,6 +16,9 @@ type Worker interface {
 	SetMetrics(metrics *telemetry.PrometheusCollector)
 	AddDefaultRoute(wrk Worker)
 	AddDroppedRoute(wrk Worker)
+	SetRoutingPolicy(policy pkgconfig.PipelinesRouting)
+	AddMatchRoute(matching pkgconfig.ConfigMatching, wrks []Worker)
+	RewireRoutes(policy pkgconfig.PipelinesRouting, forward []Worker, dropped []Worker, matchTargets [][]Worker)
 	SetLoggers(loggers []Worker)
 	GetName() string
 	Stop()
@@ -20,17 +26,41 @@ type Worker interface {
 	CountIngressTraffic()
 	CountEgressTraffic()
 	GetInputChannel() chan dnsutils.DNSMessage
+	GetOutputChannel() chan dnsutils.DNSMessage
 	ReadConfig()
 	ReloadConfig(config *pkgconfig.Config)
+	GetControl() *WorkerControl
+	GetRoutesState() []RouteState
+}
+
+// MatchRoute is a conditional route, the messages matching the conditions are sent to the next workers
+type MatchRoute struct {
+	matching pkgconfig.ConfigMatching
+	routes   []chan dnsutils.DNSMessage
+	names    []string
+}
+
+// Routing is the routing state of a worker, shared with its processors.
+// It is replaced at runtime when the pipelines are rewired.
+type Routing struct {
+	Policy      pkgconfig.PipelinesRouting
+	MatchRoutes []MatchRoute
+
+	// routes used instead of the ones provided by the worker after a rewiring
+	rewired                    bool
+	forward, dropped           []chan dnsutils.DNSMessage
+	forwardNames, droppedNames []string
 }
 
 type GenericWorker struct {
 	doneRun, stopRun, stopProcess, doneProcess, doneMonitor, stopMonitor chan bool
-	config                                                               *pkgconfig.Config
+	config                                                               atomic.Pointer[pkgconfig.Config]
 	configChan                                                           chan *pkgconfig.Config
 	logger                                                               *logger.Logger
 	name, descr                                                          string
 	droppedRoutes, defaultRoutes                                         []Worker
+	routing                                                              *atomic.Pointer[Routing]
+	loadBalancer                                                         atomic.Pointer[LoadBalancer]
 	droppedWorker                                                        chan string
 	droppedWorkerCount                                                   map[string]int
 	dnsMessageIn, dnsMessageOut                                          chan dnsutils.DNSMessage
@@ -38,12 +68,18 @@ type GenericWorker struct {
 	metrics                                                                 *telemetry.PrometheusCollector
 	countIngress, countEgress, countForwarded, countDropped, countDiscarded chan int
 	totalIngress, totalEgress, totalForwarded, totalDropped, totalDiscarded int
+
+	spool                *Spool
+	stopSpool, doneSpool chan bool
+
+	// pause and transforms state, unblocked on stop
+	control   *WorkerControl
+	stopPause chan struct{}
 }
 
 func NewGenericWorker(config *pkgconfig.Config, logger *logger.Logger, name string, descr string, bufferSize int, monitor bool) *GenericWorker {
 	logger.Info(pkgconfig.PrefixLogWorker+"[%s] %s - enabled", name, descr)
 	w := &GenericWorker{
-		config:             config,
 		configChan:         make(chan *pkgconfig.Config),
 		logger:             logger,
 		name:               name,
@@ -63,7 +99,12 @@ func NewGenericWorker(config *pkgconfig.Config, logger *logger.Logger, name stri
 		countDiscarded:     make(chan int),
 		countForwarded:     make(chan int),
 		countDropped:       make(chan int),
+		routing:            &atomic.Pointer[Routing]{},
+		control:            NewWorkerControl(),
+		stopPause:          make(chan struct{}),
 	}
+	w.config.Store(config)
+	w.routing.Store(&Routing{})
 	if monitor {
 		go w.Monitor()
 	}
@@ -76,9 +117,9 @@ func (w *GenericWorker) SetMetrics(metrics *telemetry.PrometheusCollector) {
 
 func (w *GenericWorker) GetName() string { return w.name }
 
-func (w *GenericWorker) GetConfig() *pkgconfig.Config { return w.config }
+func (w *GenericWorker) GetConfig() *pkgconfig.Config { return w.config.Load() }
 
-func (w *GenericWorker) SetConfig(config *pkgconfig.Config) { w.config = config }
+func (w *GenericWorker) SetConfig(config *pkgconfig.Config) { w.config.Store(config) }
 
 func (w *GenericWorker) ReadConfig() {}
 
@@ -122,6 +163,75 @@ func (w *GenericWorker) SetDefaultDropped(workers []Worker) {
 	w.droppedRoutes = workers
 }
 
+// SetRoutingPolicy sets the behavior when the next workers are busy
+func (w *GenericWorker) SetRoutingPolicy(policy pkgconfig.PipelinesRouting) {
+	routing := *w.routing.Load()
+	routing.Policy = policy
+	w.routing.Store(&routing)
+}
+
+func (w *GenericWorker) GetRoutingPolicy() pkgconfig.PipelinesRouting { return w.routing.Load().Policy }
+
+// AddMatchRoute adds a conditional route to the next workers
+func (w *GenericWorker) AddMatchRoute(matching pkgconfig.ConfigMatching, wrks []Worker) {
+	routing := *w.routing.Load()
+	routing.MatchRoutes = append(append([]MatchRoute{}, routing.MatchRoutes...), w.NewMatchRoute(matching, wrks))
+	w.routing.Store(&routing)
+}
+
+func (w *GenericWorker) NewMatchRoute(matching pkgconfig.ConfigMatching, wrks []Worker) MatchRoute {
+	w.ReadMatching(matching)
+	routes, names := GetRoutes(wrks)
+	return MatchRoute{matching: matching, routes: routes, names: names}
+}
+
+// SetRouting shares the routing state of the parent worker, used by the processors
+func (w *GenericWorker) SetRouting(routing *atomic.Pointer[Routing]) { w.routing = routing }
+
+func (w *GenericWorker) GetRouting() *atomic.Pointer[Routing] { return w.routing }
+
+// RewireRoutes replaces the routes of the running worker and its processors
+func (w *GenericWorker) RewireRoutes(policy pkgconfig.PipelinesRouting, forward []Worker, dropped []Worker, matchTargets [][]Worker) {
+	routing := &Routing{Policy: policy, rewired: true}
+	routing.forward, routing.forwardNames = GetRoutes(forward)
+	routing.dropped, routing.droppedNames = GetRoutes(dropped)
+	for i := range matchTargets {
+		routing.MatchRoutes = append(routing.MatchRoutes, w.NewMatchRoute(policy.Routes[i].Matching, matchTargets[i]))
+	}
+	w.routing.Store(routing)
+	w.LogInfo("routes updated - forward=%v dropped=%v", routing.forwardNames, routing.droppedNames)
+}
+
+// GetRoutesState returns the routes of the worker with the filling of their channels
+func (w *GenericWorker) GetRoutesState() []RouteState {
+	routing := w.routing.Load()
+
+	forward, forwardNames := GetRoutes(w.defaultRoutes)
+	dropped, droppedNames := GetRoutes(w.droppedRoutes)
+	if routing.rewired {
+		forward, forwardNames = routing.forward, routing.forwardNames
+		dropped, droppedNames = routing.dropped, routing.droppedNames
+	}
+
+	states := newRouteStates("forward", forward, forwardNames)
+	states = append(states, newRouteStates("dropped", dropped, droppedNames)...)
+	for _, route := range routing.MatchRoutes {
+		states = append(states, newRouteStates("match", route.routes, route.names)...)
+	}
+	return states
+}
+
+// SetControl shares the control of the parent worker, used by the processors
+func (w *GenericWorker) SetControl(control *WorkerControl) { w.control = control }
+
+func (w *GenericWorker) GetControl() *WorkerControl { return w.control }
+
+// StartTransforms registers the transforms of the worker then starts them
+func (w *GenericWorker) StartTransforms(transforms *transformers.Transforms, handler func(dm *dnsutils.DNSMessage, result int)) {
+	w.control.SetTransforms(transforms)
+	transforms.Start(handler)
+}
+
 func (w *GenericWorker) SetLoggers(loggers []Worker) { w.defaultRoutes = loggers }
 
 func (w *GenericWorker) Loggers() ([]chan dnsutils.DNSMessage, []string) {
@@ -130,6 +240,19 @@ func (w *GenericWorker) Loggers() ([]chan dnsutils.DNSMessage, []string) {
 
 func (w *GenericWorker) ReloadConfig(config *pkgconfig.Config) {
 	w.LogInfo("reload configuration...")
+
+	// the paused worker can't read the new config, applied on resume
+	if w.control.IsPaused() {
+		w.LogInfo("worker paused, configuration applied on resume")
+		go func() {
+			w.control.Wait(w.stopPause)
+			select {
+			case w.configChan <- config:
+			case <-w.stopPause:
+			}
+		}()
+		return
+	}
 	w.configChan <- config
 }
 
@@ -158,8 +281,168 @@ func (w *GenericWorker) OnLoggerStopped() chan bool {
 }
 
 func (w *GenericWorker) StopLogger() {
+	if w.spool != nil {
+		w.stopSpool <- true
+		<-w.doneSpool
+	}
+
+	// let the logger process the buffered messages before to flush
+	w.WaitOutputDrained(time.Duration(w.GetConfig().Global.Worker.DrainTimeout) * time.Second)
+
 	w.stopProcess <- true
 	<-w.doneProcess
+
+	// the messages not processed are kept in the spool
+	if w.spool != nil {
+		for len(w.dnsMessageOut) > 0 {
+			if err := w.spool.Push(<-w.dnsMessageOut); err != nil {
+				w.LogError("spool - unable to write message: %v", err)
+				break
+			}
+		}
+		w.spool.Close()
+	}
+}
+
+// WaitOutputDrained waits until the output channel is empty or the timeout is reached
+func (w *GenericWorker) WaitOutputDrained(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for len(w.dnsMessageOut) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// EnableSpool opens the disk-backed spool of the worker and starts to replay the messages
+func (w *GenericWorker) EnableSpool(config pkgconfig.ConfigSpool) {
+	spool, err := NewSpool(config, filepath.Join(config.Directory, w.name))
+	if err != nil {
+		w.LogFatal(pkgconfig.PrefixLogWorker+"["+w.name+"] "+w.descr+" - unable to open spool:", err)
+	}
+	w.LogInfo("spool enabled in %s", filepath.Join(config.Directory, w.name))
+
+	w.spool = spool
+	w.stopSpool = make(chan bool)
+	w.doneSpool = make(chan bool)
+	go func() {
+		spool.Replay(w.stopSpool, w.LogError)
+		w.doneSpool <- true
+	}()
+}
+
+func (w *GenericWorker) SpoolEnabled() bool { return w.spool != nil }
+
+// GetSpoolChannel returns the channel of the messages replayed from the spool, nil without spool
+func (w *GenericWorker) GetSpoolChannel() chan SpoolMessage {
+	if w.spool == nil {
+		return nil
+	}
+	return w.spool.Output()
+}
+
+// SetRemoteAvailable pauses or resumes the replay of the spool
+func (w *GenericWorker) SetRemoteAvailable(available bool) {
+	if w.spool != nil {
+		w.spool.SetAvailable(available)
+	}
+}
+
+// RemoteAvailable returns false while the remote destination is unavailable, always true without spool
+func (w *GenericWorker) RemoteAvailable() bool {
+	if w.spool == nil {
+		return true
+	}
+	return w.spool.IsAvailable()
+}
+
+// SpoolBatch keeps the messages of a batch until the remote destination has confirmed the write
+type SpoolBatch struct {
+	messages []dnsutils.DNSMessage
+	seqs     []uint64
+}
+
+// AddReplayed keeps the sequence number of the message replayed from the spool, acknowledged with the batch
+func (b *SpoolBatch) AddReplayed(sm SpoolMessage) {
+	b.seqs = append(b.seqs, sm.seq)
+}
+
+// SpoolBatchAdd keeps the message in the batch, nothing is kept without spool
+func (w *GenericWorker) SpoolBatchAdd(batch *SpoolBatch, dm dnsutils.DNSMessage) {
+	if w.spool != nil {
+		batch.messages = append(batch.messages, dm)
+	}
+}
+
+// SpoolBatchDone is called once the batch is sent, the replayed messages are acknowledged.
+// When the batch failed, the other messages are written to the spool and the replay
+// restarts from the last committed position, to replay the messages in order.
+func (w *GenericWorker) SpoolBatchDone(batch *SpoolBatch, err error) {
+	defer func() { batch.messages, batch.seqs = nil, nil }()
+	if w.spool == nil {
+		return
+	}
+	if err == nil {
+		for _, seq := range batch.seqs {
+			w.spool.Ack(seq)
+		}
+		return
+	}
+
+	for _, dm := range batch.messages {
+		if err := w.spool.Push(dm); err != nil {
+			w.LogError("spool - unable to write message: %v", err)
+			break
+		}
+	}
+	if len(batch.seqs) > 0 {
+		w.spool.Rewind()
+	}
+}
+
+// SendToOutput sends the message to the output channel, through the spool if enabled
+func (w *GenericWorker) SendToOutput(dm dnsutils.DNSMessage) {
+	w.control.Wait(w.stopPause)
+	if w.spool == nil {
+		w.dnsMessageOut <- dm
+		return
+	}
+	if err := w.spool.Forward(dm, w.dnsMessageOut); err != nil {
+		w.LogError("spool - unable to write message: %v", err)
+		w.dnsMessageOut <- dm
+	}
+}
+
+// OutgoingTransformsHandler returns the function called with the messages transformed by the logger,
+// the kept messages are sent to the output channel and to the next workers
+func (w *GenericWorker) OutgoingTransformsHandler(defaultRoutes []chan dnsutils.DNSMessage, defaultNames []string,
+	droppedRoutes []chan dnsutils.DNSMessage, droppedNames []string) func(dm *dnsutils.DNSMessage, result int) {
+	return func(dm *dnsutils.DNSMessage, result int) {
+		if result == transformers.ReturnDrop {
+			w.SendDroppedTo(droppedRoutes, droppedNames, *dm)
+			return
+		}
+
+		// send to output channel
+		w.CountEgressTraffic()
+		w.SendToOutput(*dm)
+
+		// send to next ?
+		w.SendForwardedTo(defaultRoutes, defaultNames, *dm)
+	}
+}
+
+// IngoingTransformsHandler returns the function called with the messages transformed by the collector,
+// the kept messages are sent to the next workers
+func (w *GenericWorker) IngoingTransformsHandler(defaultRoutes []chan dnsutils.DNSMessage, defaultNames []string,
+	droppedRoutes []chan dnsutils.DNSMessage, droppedNames []string) func(dm *dnsutils.DNSMessage, result int) {
+	return func(dm *dnsutils.DNSMessage, result int) {
+		if result == transformers.ReturnDrop {
+			w.SendDroppedTo(droppedRoutes, droppedNames, *dm)
+			return
+		}
+
+		// dispatch dns message to all generators
+		w.SendForwardedTo(defaultRoutes, defaultNames, *dm)
+	}
 }
 
 func (w *GenericWorker) CollectDone() {
@@ -173,6 +456,8 @@ func (w *GenericWorker) LoggingDone() {
 }
 
 func (w *GenericWorker) Stop() {
+	// a paused worker is unblocked to be stopped
+	close(w.stopPause)
 
 	w.LogInfo("stopping collect...")
 	w.stopRun <- true
@@ -192,8 +477,8 @@ func (w *GenericWorker) Monitor() {
 		w.doneMonitor <- true
 	}()
 
-	w.LogInfo("starting monitoring - refresh every %ds", w.config.Global.Worker.InternalMonitor)
-	timerMonitor := time.NewTimer(time.Duration(w.config.Global.Worker.InternalMonitor) * time.Second)
+	w.LogInfo("starting monitoring - refresh every %ds", w.GetConfig().Global.Worker.InternalMonitor)
+	timerMonitor := time.NewTimer(time.Duration(w.GetConfig().Global.Worker.InternalMonitor) * time.Second)
 	for {
 		select {
 		case <-w.countDiscarded:
@@ -232,7 +517,7 @@ func (w *GenericWorker) Monitor() {
 			}
 
 			// // send to telemetry?
-			if w.config.Global.Telemetry.Enabled && w.metrics != nil {
+			if w.GetConfig().Global.Telemetry.Enabled && w.metrics != nil {
 				if w.totalIngress > 0 || w.totalEgress > 0 || w.totalForwarded > 0 || w.totalDropped > 0 {
 					w.metrics.Record <- telemetry.WorkerStats{
 						Name:                 w.GetName(),
@@ -250,7 +535,7 @@ func (w *GenericWorker) Monitor() {
 				}
 			}
 
-			timerMonitor.Reset(time.Duration(w.config.Global.Worker.InternalMonitor) * time.Second)
+			timerMonitor.Reset(time.Duration(w.GetConfig().Global.Worker.InternalMonitor) * time.Second)
 		}
 	}
 }
@@ -270,47 +555,128 @@ func (w *GenericWorker) StartLogging() {
 }
 
 func (w *GenericWorker) CountIngressTraffic() {
-	if w.config.Global.Telemetry.Enabled {
+	if w.GetConfig().Global.Telemetry.Enabled {
 		w.countIngress <- 1
 	}
 }
 
 func (w *GenericWorker) CountEgressTraffic() {
-	if w.config.Global.Telemetry.Enabled {
+	if w.GetConfig().Global.Telemetry.Enabled {
 		w.countEgress <- 1
 	}
 }
 
 func (w *GenericWorker) SendDroppedTo(routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage) {
+	w.control.Wait(w.stopPause)
+	routing := w.routing.Load()
+	if routing.rewired {
+		routes, routesName = routing.dropped, routing.droppedNames
+	}
 	for i := range routes {
+		w.sendTo(routing, routes[i], routesName[i], dm, w.countDropped)
+	}
+}
+
+func (w *GenericWorker) SendForwardedTo(routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage) {
+	w.control.Wait(w.stopPause)
+	routing := w.routing.Load()
+	if routing.rewired {
+		routes, routesName = routing.forward, routing.forwardNames
+	}
+
+	if len(routing.Policy.LoadBalance) > 0 && len(routes) > 1 {
+		w.sendBalanced(routing, routes, routesName, dm)
+	} else {
+		for i := range routes {
+			w.sendTo(routing, routes[i], routesName[i], dm, w.countForwarded)
+		}
+	}
+
+	// conditional routes, evaluated in order
+	for _, route := range routing.MatchRoutes {
+		if !w.MatchMessage(&dm, route.matching) {
+			continue
+		}
+		for i := range route.routes {
+			w.sendTo(routing, route.routes[i], route.names[i], dm, w.countForwarded)
+		}
+		if routing.Policy.GetMatchMode() == pkgconfig.MatchModeFirst {
+			break
+		}
+	}
+}
+
+// sendBalanced sends the message to one route only, selected by the load balancer.
+// When the selected route is busy, the message is sent to the next available one
+func (w *GenericWorker) sendBalanced(routing *Routing, routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage) {
+	lb := w.loadBalancer.Load()
+	if lb == nil || lb.routing != routing || lb.routes != len(routes) {
+		lb = NewLoadBalancer(routing.Policy, routesName)
+		lb.routing = routing
+		w.loadBalancer.Store(lb)
+	}
+
+	selected := lb.Select(&dm, routes)
+	for k := range routes {
+		i := (selected + k) % len(routes)
 		select {
 		case routes[i] <- dm:
-			if w.config.Global.Telemetry.Enabled {
-				w.countDropped <- 1
+			if w.GetConfig().Global.Telemetry.Enabled {
+				w.countForwarded <- 1
 			}
+			return
 		default:
-			if w.config.Global.Telemetry.Enabled {
-				w.countDiscarded <- 1
-			}
-			w.WorkerIsBusy(routesName[i])
 		}
 	}
+
+	// all routes are busy
+	w.sendTo(routing, routes[selected], routesName[selected], dm, w.countForwarded)
 }
 
-func (w *GenericWorker) SendForwardedTo(routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage) {
-	for i := range routes {
+// sendTo sends the message to the next worker, when this one is busy
+// the message is discarded or the sender waits according to the backpressure policy
+func (w *GenericWorker) sendTo(routing *Routing, route chan dnsutils.DNSMessage, routeName string, dm dnsutils.DNSMessage, counter chan int) {
+	select {
+	case route <- dm:
+		if w.GetConfig().Global.Telemetry.Enabled {
+			counter <- 1
+		}
+		return
+	default:
+	}
+
+	switch routing.Policy.GetBackpressure() {
+	case pkgconfig.BackpressureBlock:
 		select {
-		case routes[i] <- dm:
-			if w.config.Global.Telemetry.Enabled {
-				w.countForwarded <- 1
+		case route <- dm:
+			if w.GetConfig().Global.Telemetry.Enabled {
+				counter <- 1
 			}
-		default:
-			if w.config.Global.Telemetry.Enabled {
+		case <-w.stopPause:
+			// the worker is stopped, the message is discarded
+			if w.GetConfig().Global.Telemetry.Enabled {
 				w.countDiscarded <- 1
 			}
-			w.WorkerIsBusy(routesName[i])
 		}
+		return
+
+	case pkgconfig.BackpressureBlockWithTimeout:
+		timer := time.NewTimer(routing.Policy.GetBackpressureTimeout())
+		defer timer.Stop()
+		select {
+		case route <- dm:
+			if w.GetConfig().Global.Telemetry.Enabled {
+				counter <- 1
+			}
+			return
+		case <-timer.C:
+		}
+	}
+
+	if w.GetConfig().Global.Telemetry.Enabled {
+		w.countDiscarded <- 1
 	}
+	w.WorkerIsBusy(routeName)
 }
 
 func GetRoutes(routes []Worker) ([]chan dnsutils.DNSMessage, []string) {
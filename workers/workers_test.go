@@ -2,6 +2,9 @@ package workers
 
 import (
 	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
 
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-logger"
@@ -10,3 +13,182 @@ import (
 func TestGenericWorker(t *testing.T) {
 	NewGenericWorker(pkgconfig.GetDefaultConfig(), logger.New(false), "testonly", "", pkgconfig.DefaultBufferSize, pkgconfig.WorkerMonitorDisabled)
 }
+
+func TestGenericWorker_Backpressure(t *testing.T) {
+	testcases := []struct {
+		policy    string
+		delay     time.Duration
+		delivered bool
+	}{
+		{policy: pkgconfig.BackpressureDrop, delay: 200 * time.Millisecond, delivered: false},
+		{policy: pkgconfig.BackpressureBlock, delay: 200 * time.Millisecond, delivered: true},
+		{policy: pkgconfig.BackpressureBlockWithTimeout, delay: 200 * time.Millisecond, delivered: true},
+		{policy: pkgconfig.BackpressureBlockWithTimeout, delay: 2 * time.Second, delivered: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.policy, func(t *testing.T) {
+			w := NewGenericWorker(pkgconfig.GetDefaultConfig(), logger.New(false), "testonly", "", pkgconfig.DefaultBufferSize, pkgconfig.DefaultMonitor)
+			defer func() {
+				w.stopMonitor <- true
+				<-w.doneMonitor
+			}()
+			w.SetRoutingPolicy(pkgconfig.PipelinesRouting{Backpressure: tc.policy, BackpressureTimeout: 500})
+
+			// the next worker is busy
+			route := make(chan dnsutils.DNSMessage, 1)
+			route <- dnsutils.GetFakeDNSMessage()
+
+			// the next worker reads the first message after a delay
+			consumed := make(chan bool)
+			go func() {
+				time.Sleep(tc.delay)
+				<-route
+				consumed <- true
+			}()
+
+			dm := dnsutils.GetFakeDNSMessage()
+			dm.DNS.Qname = "backpressure.dns.collector"
+			w.SendForwardedTo([]chan dnsutils.DNSMessage{route}, []string{"next"}, dm)
+			<-consumed
+
+			select {
+			case dmNext := <-route:
+				if !tc.delivered {
+					t.Errorf("message should be discarded")
+				} else if dmNext.DNS.Qname != dm.DNS.Qname {
+					t.Errorf("unexpected message: %s", dmNext.DNS.Qname)
+				}
+			default:
+				if tc.delivered {
+					t.Errorf("message should be delivered")
+				}
+			}
+		})
+	}
+}
+
+func TestGenericWorker_BackpressureBlockStopped(t *testing.T) {
+	w := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+	w.SetRoutingPolicy(pkgconfig.PipelinesRouting{Backpressure: pkgconfig.BackpressureBlock})
+
+	// the next worker is busy and never reads its channel
+	route := make(chan dnsutils.DNSMessage, 1)
+	route <- dnsutils.GetFakeDNSMessage()
+
+	sent := make(chan bool)
+	go func() {
+		w.SendForwardedTo([]chan dnsutils.DNSMessage{route}, []string{"next"}, dnsutils.GetFakeDNSMessage())
+		sent <- true
+	}()
+
+	// the sender is released when the worker is stopped
+	close(w.stopPause)
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("sender still blocked after stop")
+	}
+	if len(route) != 1 {
+		t.Errorf("message should be discarded")
+	}
+}
+
+func TestGenericWorker_MatchRoutes(t *testing.T) {
+	testcases := []struct {
+		mode     string
+		rcode    string
+		expected []int
+	}{
+		{mode: pkgconfig.MatchModeFirst, rcode: "NXDOMAIN", expected: []int{1, 0, 0}},
+		{mode: pkgconfig.MatchModeFirst, rcode: "NOERROR", expected: []int{0, 1, 0}},
+		{mode: pkgconfig.MatchModeAll, rcode: "NXDOMAIN", expected: []int{1, 0, 1}},
+		{mode: pkgconfig.MatchModeAll, rcode: "NOERROR", expected: []int{0, 1, 1}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.mode+"_"+tc.rcode, func(t *testing.T) {
+			w := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+			w.SetRoutingPolicy(pkgconfig.PipelinesRouting{MatchMode: tc.mode})
+
+			// nxdomain, everything else and all messages
+			nxdomain := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+			others := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+			all := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+			w.AddMatchRoute(pkgconfig.ConfigMatching{Include: map[string]interface{}{"dns.rcode": "NXDOMAIN"}}, []Worker{nxdomain})
+			w.AddMatchRoute(pkgconfig.ConfigMatching{Exclude: map[string]interface{}{"dns.rcode": "NXDOMAIN"}}, []Worker{others})
+			w.AddMatchRoute(pkgconfig.ConfigMatching{}, []Worker{all})
+
+			dm := dnsutils.GetFakeDNSMessage()
+			dm.DNS.Rcode = tc.rcode
+			w.SendForwardedTo([]chan dnsutils.DNSMessage{}, []string{}, dm)
+
+			for i, next := range []*GenericWorker{nxdomain, others, all} {
+				if len(next.GetInputChannel()) != tc.expected[i] {
+					t.Errorf("route %d: want %d message(s), got %d", i, tc.expected[i], len(next.GetInputChannel()))
+				}
+			}
+		})
+	}
+}
+
+func TestGenericWorker_MatchRoutesSource(t *testing.T) {
+	w := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+	next := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+
+	// match source loaded from file
+	include := map[string]interface{}{
+		"dns.qname": map[string]interface{}{
+			"match-source": "file://../tests/testsdata/filtering_keep_domains_regex.txt",
+			"source-kind":  "regexp_list",
+		},
+	}
+	w.AddMatchRoute(pkgconfig.ConfigMatching{Include: include}, []Worker{next})
+
+	for _, qname := range []string{"mail.google.com", "www.dnscollector.dev"} {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = qname
+		w.SendForwardedTo([]chan dnsutils.DNSMessage{}, []string{}, dm)
+	}
+
+	if len(next.GetInputChannel()) != 1 {
+		t.Fatalf("want 1 message, got %d", len(next.GetInputChannel()))
+	}
+	if dm := <-next.GetInputChannel(); dm.DNS.Qname != "mail.google.com" {
+		t.Errorf("unexpected message: %s", dm.DNS.Qname)
+	}
+}
+
+func TestGenericWorker_Pause(t *testing.T) {
+	w := GetWorkerForTest(pkgconfig.DefaultBufferSize)
+	route := make(chan dnsutils.DNSMessage, 1)
+
+	if !w.GetControl().Pause() || w.GetControl().Pause() {
+		t.Fatalf("worker should be paused once")
+	}
+
+	// the message is sent on resume
+	sent := make(chan bool)
+	go func() {
+		w.SendForwardedTo([]chan dnsutils.DNSMessage{route}, []string{"next"}, dnsutils.GetFakeDNSMessage())
+		sent <- true
+	}()
+
+	select {
+	case <-sent:
+		t.Fatalf("message should not be sent while paused")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if !w.GetControl().Resume() || w.GetControl().Resume() {
+		t.Fatalf("worker should be resumed once")
+	}
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatalf("message should be sent after resume")
+	}
+	if len(route) != 1 {
+		t.Errorf("message not delivered")
+	}
+}